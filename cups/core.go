@@ -13,11 +13,14 @@ package cups
 */
 import "C"
 import (
+	"crypto/x509"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"runtime"
 	"strings"
+	"sync"
 	"time"
 	"unsafe"
 
@@ -34,6 +37,16 @@ const (
 	// This value should be large enough to be useful and small enough
 	// to work on any platform.
 	filePathMaxLength = 1024
+
+	// connectionMaxIdle is how long an idle connection is kept for reuse. It's
+	// a few seconds less than the default KeepAliveTimeout of CUPS, 30 seconds,
+	// so that connections are rarely reused just as CUPS closes them; a
+	// connection that CUPS closed sooner is reconnected by the CUPS API on the
+	// next request.
+	connectionMaxIdle = 25 * time.Second
+
+	// IPP status codes from 0x0500 to 0x05FF are server errors.
+	ippStatusServerError = 0x0500
 )
 
 // cupsCore handles CUPS API interaction and connection management.
@@ -44,19 +57,61 @@ type cupsCore struct {
 	connectTimeout C.int
 	// connectionSemaphore limits the quantity of open CUPS connections.
 	connectionSemaphore *lib.Semaphore
-	// connectionPool allows a connection to be reused instead of closed.
-	connectionPool chan *C.http_t
+	// connectionPool holds the idle connections to the CUPS server, to be
+	// reused instead of connecting again. It holds no more connections than
+	// connectionSemaphore allows to be open.
+	connectionPool chan idleConnection
 	hostIsLocal    bool
+	// caCertPool, when not nil, must verify the CUPS server certificate.
+	caCertPool *x509.CertPool
+
+	statsMutex sync.Mutex
+	created    uint
+	reused     uint
+	expired    uint
+}
+
+// idleConnection is a connection in cupsCore.connectionPool.
+type idleConnection struct {
+	http  *C.http_t
+	since time.Time
 }
 
-func newCUPSCore(maxConnections uint, connectTimeout time.Duration) (*cupsCore, error) {
+// ConnPoolStats are numbers reported to monitoring.
+type ConnPoolStats struct {
+	// Idle connections in the pool now.
+	Idle uint
+	// Connections opened, reused from the pool, and closed after being idle
+	// for too long, since the connector started.
+	Created uint
+	Reused  uint
+	Expired uint
+}
+
+// newCUPSCore connects to the CUPS server at serverHost:serverPort. An empty
+// serverHost or a zero serverPort falls back to the CUPS client default, as
+// does an empty encryption.
+//
+// When caCertFile is not empty, the CUPS server certificate must be signed by
+// one of the CA certificates in that PEM file.
+func newCUPSCore(maxConnections uint, connectTimeout time.Duration, serverHost string, serverPort uint16, encryption, caCertFile string) (*cupsCore, error) {
 	host := C.cupsServer()
+	if serverHost != "" {
+		// Never freed; the host is needed for the lifetime of cupsCore.
+		host = C.CString(serverHost)
+	}
 	port := C.ippPort()
-	encryption := C.cupsEncryption()
+	if serverPort != 0 {
+		port = C.int(serverPort)
+	}
+	enc, err := parseEncryption(encryption)
+	if err != nil {
+		return nil, err
+	}
 	timeout := C.int(connectTimeout / time.Millisecond)
 
 	var e string
-	switch encryption {
+	switch enc {
 	case C.HTTP_ENCRYPTION_ALWAYS:
 		e = "encrypting ALWAYS"
 	case C.HTTP_ENCRYPTION_IF_REQUESTED:
@@ -66,27 +121,50 @@ func newCUPSCore(maxConnections uint, connectTimeout time.Duration) (*cupsCore,
 	case C.HTTP_ENCRYPTION_REQUIRED:
 		e = "encryption REQUIRED"
 	default:
-		encryption = C.HTTP_ENCRYPTION_REQUIRED
+		enc = C.HTTP_ENCRYPTION_REQUIRED
 		e = "encrypting REQUIRED"
 	}
 
+	var caCertPool *x509.CertPool
+	if caCertFile != "" {
+		if caCertPool, err = loadCACertPool(caCertFile); err != nil {
+			return nil, err
+		}
+		if enc == C.HTTP_ENCRYPTION_NEVER {
+			return nil, errors.New("A CUPS CA certificate file requires CUPS encryption")
+		}
+	}
+
 	var hostIsLocal bool
 	if h := C.GoString(host); strings.HasPrefix(h, "/") || h == "localhost" {
 		hostIsLocal = true
 	}
 
-	cs := lib.NewSemaphore(maxConnections)
-	cp := make(chan *C.http_t)
-
-	cc := &cupsCore{host, port, encryption, timeout, cs, cp, hostIsLocal}
+	cc := &cupsCore{
+		host:                host,
+		port:                port,
+		encryption:          enc,
+		connectTimeout:      timeout,
+		connectionSemaphore: lib.NewSemaphore(maxConnections),
+		connectionPool:      make(chan idleConnection, maxConnections),
+		hostIsLocal:         hostIsLocal,
+		caCertPool:          caCertPool,
+	}
 
 	// This connection isn't used, just checks that a connection is possible
 	// before returning from the constructor.
 	http, err := cc.connect()
 	if err != nil {
+		if enc == C.HTTP_ENCRYPTION_ALWAYS || enc == C.HTTP_ENCRYPTION_REQUIRED {
+			return nil, fmt.Errorf("Failed to negotiate an encrypted connection to CUPS: %s", err)
+		}
 		return nil, err
 	}
+	encrypted := C.httpIsEncrypted(http) != 0
 	cc.disconnect(http)
+	if enc == C.HTTP_ENCRYPTION_ALWAYS && !encrypted {
+		return nil, fmt.Errorf("CUPS server %s:%d did not negotiate TLS", C.GoString(host), int(port))
+	}
 
 	log.Infof("connected to CUPS server %s:%d %s\n", C.GoString(host), int(port), e)
 
@@ -99,20 +177,44 @@ func newCUPSCore(maxConnections uint, connectTimeout time.Duration) (*cupsCore,
 func (cc *cupsCore) printFile(user, printername, filename, title *C.char, numOptions C.int, options *C.cups_option_t) (C.int, error) {
 	http, err := cc.connect()
 	if err != nil {
-		return 0, err
+		return 0, &printError{err: err}
 	}
 	defer cc.disconnect(http)
 
 	C.cupsSetUser(user)
 	jobID := C.cupsPrintFile2(http, printername, filename, title, numOptions, options)
 	if jobID == 0 {
-		return 0, fmt.Errorf("Failed to call cupsPrintFile2() for file %s: %d %s",
-			C.GoString(filename), int(C.cupsLastError()), C.GoString(C.cupsLastErrorString()))
+		status := int(C.cupsLastError())
+		return 0, &printError{status, fmt.Errorf("Failed to call cupsPrintFile2() for file %s: %d %s",
+			C.GoString(filename), status, C.GoString(C.cupsLastErrorString()))}
 	}
 
 	return jobID, nil
 }
 
+// printError is the failure of a job submission, with the IPP status of the
+// failure; 0 when CUPS couldn't be reached.
+type printError struct {
+	status int
+	err    error
+}
+
+func (e *printError) Error() string {
+	return e.err.Error()
+}
+
+// SubmitRetryable checks whether a job submission that failed with err may
+// succeed later: when CUPS couldn't be reached, or answered with an IPP
+// server error, like busy or not accepting jobs. Client errors, like an
+// unknown printer or an unsupported document format, are permanent.
+func SubmitRetryable(err error) bool {
+	e, ok := err.(*printError)
+	if !ok {
+		return false
+	}
+	return e.status == 0 || e.status >= ippStatusServerError && e.status < ippStatusServerError+0x100
+}
+
 // getPrinters gets the current list and state of printers by calling
 // C.doRequest (IPP_OP_CUPS_GET_PRINTERS).
 //
@@ -184,6 +286,11 @@ func (cc *cupsCore) getPPD(printername *C.char, modtime *C.time_t) (*C.char, err
 		// Cache miss.
 		return buffer, nil
 
+	case C.HTTP_STATUS_NOT_FOUND:
+		// Raw queues have no PPD.
+		C.free(unsafe.Pointer(buffer))
+		return nil, errNoPPD
+
 	default:
 		if len(C.GoString(buffer)) > 0 {
 			os.Remove(C.GoString(buffer))
@@ -204,7 +311,7 @@ func (cc *cupsCore) getPPD(printername *C.char, modtime *C.time_t) (*C.char, err
 //
 // The caller is responsible to C.ippDelete the returned *C.ipp_t response.
 func (cc *cupsCore) getJobAttributes(jobID C.int, attributes **C.char) (*C.ipp_t, error) {
-	uri, err := createJobURI(jobID)
+	uri, err := cc.createJobURI(jobID)
 	if err != nil {
 		return nil, err
 	}
@@ -228,7 +335,7 @@ func (cc *cupsCore) getJobAttributes(jobID C.int, attributes **C.char) (*C.ipp_t
 
 // createJobURI creates a uri string for the job-uri attribute, used to get the
 // state of a CUPS job.
-func createJobURI(jobID C.int) (*C.char, error) {
+func (cc *cupsCore) createJobURI(jobID C.int) (*C.char, error) {
 	length := C.size_t(urlMaxLength)
 	uri := (*C.char)(C.malloc(length))
 	if uri == nil {
@@ -238,7 +345,7 @@ func createJobURI(jobID C.int) (*C.char, error) {
 	resource := C.CString(fmt.Sprintf(jobURIFormat, uint32(jobID)))
 	defer C.free(unsafe.Pointer(resource))
 	C.httpAssembleURI(C.HTTP_URI_CODING_ALL,
-		uri, C.int(length), C.IPP, nil, C.cupsServer(), C.ippPort(), resource)
+		uri, C.int(length), C.IPP, nil, cc.host, cc.port, resource)
 
 	return uri, nil
 }
@@ -269,13 +376,14 @@ func (cc *cupsCore) doRequest(request *C.ipp_t, acceptableStatusCodes []C.ipp_st
 	return nil, fmt.Errorf("IPP status code %d", int(statusCode))
 }
 
-// connect calls C.httpConnect2 to create a new, open connection to
-// the CUPS server specified by environment variables, client.conf, etc.
+// connect reuses an idle connection from the pool, or calls C.httpConnect2
+// to create a new, open connection to the CUPS server specified by
+// environment variables, client.conf, etc.
 //
 // connect also acquires the connection semaphore and locks the OS
 // thread to allow the CUPS API to use thread-local storage cleanly.
 //
-// The caller is responsible to close the connection when finished
+// The caller is responsible to release the connection when finished
 // using cupsCore.disconnect.
 func (cc *cupsCore) connect() (*C.http_t, error) {
 	cc.connectionSemaphore.Acquire()
@@ -284,44 +392,146 @@ func (cc *cupsCore) connect() (*C.http_t, error) {
 	// cupsLastError() and cupsLastErrorString().
 	runtime.LockOSThread()
 
-	var http *C.http_t
+	if http := cc.reuseConnection(); http != nil {
+		return http, nil
+	}
 
-	select {
-	case h := <-cc.connectionPool:
-		// Reuse another connection.
-		http = h
-	default:
-		// No connection available for reuse; create a new one.
-		http = C.httpConnect2(cc.host, cc.port, nil, C.AF_UNSPEC, cc.encryption, 1, cc.connectTimeout, nil)
-		if http == nil {
-			defer cc.disconnect(http)
-			return nil, fmt.Errorf("Failed to connect to CUPS server %s:%d because %d %s",
-				C.GoString(cc.host), int(cc.port), int(C.cupsLastError()), C.GoString(C.cupsLastErrorString()))
+	// No connection available for reuse; create a new one.
+	http := C.httpConnect2(cc.host, cc.port, nil, C.AF_UNSPEC, cc.encryption, 1, cc.connectTimeout, nil)
+	if http == nil {
+		err := fmt.Errorf("Failed to connect to CUPS server %s:%d because %d %s",
+			C.GoString(cc.host), int(cc.port), int(C.cupsLastError()), C.GoString(C.cupsLastErrorString()))
+		runtime.UnlockOSThread()
+		cc.connectionSemaphore.Release()
+		return nil, err
+	}
+	if cc.caCertPool != nil {
+		if err := cc.verifyServerCertificate(http); err != nil {
+			C.httpClose(http)
+			runtime.UnlockOSThread()
+			cc.connectionSemaphore.Release()
+			return nil, err
 		}
 	}
 
+	cc.statsMutex.Lock()
+	cc.created++
+	cc.statsMutex.Unlock()
+
 	return http, nil
 }
 
-// disconnect calls C.httpClose to close an open CUPS connection, then
-// unlocks the OS thread and the connection semaphore.
-//
-// The http argument may be nil; the OS thread and semaphore are still
-// treated the same as described above.
-func (cc *cupsCore) disconnect(http *C.http_t) {
-	go func() {
+// reuseConnection takes a pooled connection that hasn't been idle for longer
+// than connectionMaxIdle, closing those that have. Returns nil when there is
+// none.
+func (cc *cupsCore) reuseConnection() *C.http_t {
+	for {
 		select {
-		case cc.connectionPool <- http:
-			// Hand this connection to the next guy who needs it.
-		case <-time.After(time.Second):
-			// Don't wait very long; stale connections are no fun.
-			C.httpClose(http)
+		case c := <-cc.connectionPool:
+			if time.Since(c.since) > connectionMaxIdle {
+				C.httpClose(c.http)
+				cc.statsMutex.Lock()
+				cc.expired++
+				cc.statsMutex.Unlock()
+				continue
+			}
+			cc.statsMutex.Lock()
+			cc.reused++
+			cc.statsMutex.Unlock()
+			return c.http
+
+		default:
+			return nil
 		}
-	}()
+	}
+}
+
+// disconnect returns an open CUPS connection to the pool, to be reused by
+// the next call to connect, then unlocks the OS thread and the connection
+// semaphore.
+func (cc *cupsCore) disconnect(http *C.http_t) {
+	select {
+	case cc.connectionPool <- idleConnection{http, time.Now()}:
+	default:
+		// The pool is full, which only happens when max connections is
+		// exceeded; should never happen.
+		C.httpClose(http)
+	}
 	runtime.UnlockOSThread()
 	cc.connectionSemaphore.Release()
 }
 
+// parseEncryption maps a cups_encryption config value to a CUPS encryption
+// setting.
+func parseEncryption(encryption string) (C.http_encryption_t, error) {
+	switch strings.ToLower(encryption) {
+	case "":
+		return C.cupsEncryption(), nil
+	case "never":
+		return C.HTTP_ENCRYPTION_NEVER, nil
+	case "ifrequested":
+		return C.HTTP_ENCRYPTION_IF_REQUESTED, nil
+	case "required":
+		return C.HTTP_ENCRYPTION_REQUIRED, nil
+	case "always":
+		return C.HTTP_ENCRYPTION_ALWAYS, nil
+	}
+	return C.HTTP_ENCRYPTION_REQUIRED, fmt.Errorf("Unknown CUPS encryption %q", encryption)
+}
+
+// loadCACertPool reads the PEM-encoded CA certificates in filename.
+func loadCACertPool(filename string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read CUPS CA certificate file: %s", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("No certificates found in CUPS CA certificate file %s", filename)
+	}
+	return pool, nil
+}
+
+// verifyServerCertificate checks the certificate chain presented by the CUPS
+// server on http against caCertPool.
+func (cc *cupsCore) verifyServerCertificate(http *C.http_t) error {
+	if C.httpIsEncrypted(http) == 0 {
+		return fmt.Errorf("CUPS server %s:%d did not negotiate TLS", C.GoString(cc.host), int(cc.port))
+	}
+
+	var credentials *C.cups_array_t
+	if C.httpCopyCredentials(http, &credentials) != 0 {
+		return errors.New("Failed to get the CUPS server certificate")
+	}
+	defer C.httpFreeCredentials(credentials)
+
+	var certs []*x509.Certificate
+	for c := C.cupsArrayFirst(credentials); c != nil; c = C.cupsArrayNext(credentials) {
+		credential := (*C.http_credential_t)(c)
+		cert, err := x509.ParseCertificate(C.GoBytes(credential.data, C.int(credential.datalen)))
+		if err != nil {
+			return fmt.Errorf("Failed to parse the CUPS server certificate: %s", err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return errors.New("The CUPS server presented no certificate")
+	}
+
+	opts := x509.VerifyOptions{
+		DNSName:       C.GoString(cc.host),
+		Roots:         cc.caCertPool,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range certs[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	if _, err := certs[0].Verify(opts); err != nil {
+		return fmt.Errorf("Failed to verify the CUPS server certificate: %s", err)
+	}
+	return nil
+}
+
 func (cc *cupsCore) connQtyOpen() uint {
 	return cc.connectionSemaphore.Count()
 }
@@ -329,3 +539,11 @@ func (cc *cupsCore) connQtyOpen() uint {
 func (cc *cupsCore) connQtyMax() uint {
 	return cc.connectionSemaphore.Size()
 }
+
+// connPoolStats returns a snapshot of the connection pool counters.
+func (cc *cupsCore) connPoolStats() ConnPoolStats {
+	cc.statsMutex.Lock()
+	defer cc.statsMutex.Unlock()
+
+	return ConnPoolStats{uint(len(cc.connectionPool)), cc.created, cc.reused, cc.expired}
+}
@@ -15,7 +15,9 @@ package cups
 import "C"
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"os"
 	"runtime"
@@ -23,6 +25,7 @@ import (
 	"strings"
 	"sync"
 	"syscall"
+	"text/template"
 	"time"
 	"unsafe"
 
@@ -52,6 +55,7 @@ const (
 	attrPrintColorModeDefault         = "print-color-mode-default"
 	attrPrintColorModeSupported       = "print-color-mode-supported"
 	attrPrinterInfo                   = "printer-info"
+	attrPrinterMakeAndModel           = "printer-make-and-model"
 	attrPrinterName                   = "printer-name"
 	attrPrinterState                  = "printer-state"
 	attrPrinterStateReasons           = "printer-state-reasons"
@@ -79,6 +83,8 @@ const (
 	attrJobState                = "job-state"
 )
 
+var errNoPPD = errors.New("Printer has no PPD")
+
 var (
 	requiredPrinterAttributes []string = []string{
 		attrCopiesDefault,
@@ -96,12 +102,20 @@ var (
 		attrPrintColorModeDefault,
 		attrPrintColorModeSupported,
 		attrPrinterInfo,
+		attrPrinterMakeAndModel,
 		attrPrinterName,
 		attrPrinterState,
 		attrPrinterStateReasons,
 		attrPrinterUUID,
 	}
 
+	// Printer tags that are present on every printer, if empty.
+	guaranteedTags []string = []string{
+		attrDeviceURI,
+		attrPrinterMakeAndModel,
+		attrPrinterStateReasons,
+	}
+
 	jobAttributes []string = []string{
 		attrJobState,
 		attrJobMediaSheetsCompleted,
@@ -126,29 +140,156 @@ var (
 	}
 )
 
+// prefixJobIDJobTitleTemplate is the job title template used when the
+// job ID should be prefixed to the job title, but no template is configured.
+const prefixJobIDJobTitleTemplate = "gcp:{{.ID}} {{.Title}}"
+
 // Interface between Go and the CUPS API.
 type CUPS struct {
-	cc                    *cupsCore
-	pc                    *ppdCache
-	infoToDisplayName     bool
-	prefixJobIDToJobTitle bool
-	displayNamePrefix     string
-	printerAttributes     []string
-	systemTags            map[string]string
+	cc                *cupsCore
+	pc                *ppdCache
+	infoToDisplayName bool
+	jobTitleTemplate  *template.Template
+	displayNamePrefix string
+	displayNameSuffix string
+	setupURL          *template.Template
+	supportURL        *template.Template
+	updateURL         *template.Template
+	printerAttributes []string
+	rawMakeAndModels  []string
+	missingPPDIsRaw   bool
+	systemTags        map[string]string
+
+	describeDriverless bool
+
+	stateReasonMessages map[string]string
+
+	sanitizeJobTitle  bool
+	jobTitleMaxLength uint
+
+	// Names of the driverless printers, which have no PPD, as of the last
+	// GetPrinters; see printerIsDriverless.
+	driverlessMutex sync.Mutex
+	driverless      map[string]struct{}
+
+	// The media size that is the default when a printer doesn't mark one.
+	defaultMediaSize cdd.MediaSizeName
+
+	// By name, the printers with defaultMediaSize as their default because
+	// they didn't mark one, as of the last GetPrinters, with the vendor ID of
+	// the size; and the printers that didn't have the size to default to.
+	mediaSizeMutex    sync.Mutex
+	mediaSizeDefaults map[string]string
+	mediaSizeMissing  map[string]struct{}
+
+	// Options to print with, by printer name; see applyDefaultOptions.
+	defaultOptions map[string]map[string]string
 }
 
-func NewCUPS(infoToDisplayName, prefixJobIDToJobTitle bool, displayNamePrefix string, printerAttributes []string, maxConnections uint, connectTimeout time.Duration) (*CUPS, error) {
-	if err := checkPrinterAttributes(printerAttributes); err != nil {
+// Options are the settings of a CUPS object.
+type Options struct {
+	InfoToDisplayName bool
+
+	// JobTitleTemplate is a text/template rendered with .ID and .Title to
+	// form the CUPS job title. When it is empty, PrefixJobIDToJobTitle
+	// selects between the plain title and the title prefixed with the job ID.
+	JobTitleTemplate      string
+	PrefixJobIDToJobTitle bool
+
+	// When SanitizeJobTitle is true, job titles are cleaned up with
+	// lib.SanitizeJobTitle after JobTitleTemplate is rendered.
+	SanitizeJobTitle  bool
+	JobTitleMaxLength uint
+
+	DisplayNamePrefix string
+	DisplayNameSuffix string
+
+	// SetupURL, SupportURL and UpdateURL are text/templates rendered with each
+	// lib.Printer.
+	SetupURL   string
+	SupportURL string
+	UpdateURL  string
+
+	PrinterAttributes []string
+
+	// Printers whose make-and-model contains one of RawMakeAndModels are raw,
+	// as are printers without a PPD when MissingPPDIsRaw is true. GetPrinters
+	// marks raw printers with lib.Printer.Raw. When DescribeDriverless is
+	// true, driverless printers without a PPD are described by their IPP
+	// attributes instead; see printerIsDriverless.
+	RawMakeAndModels   []string
+	MissingPPDIsRaw    bool
+	DescribeDriverless bool
+
+	MaxConnections uint
+	ConnectTimeout time.Duration
+	ServerHost     string
+	ServerPort     uint16
+	Encryption     string
+	CACertFile     string
+
+	TempDir              string
+	ConnectorDisplayName string
+
+	// StateReasonMessages, by printer-state-reasons keyword, override the
+	// built-in messages that describe printer states.
+	StateReasonMessages map[string]string
+
+	// DefaultMediaSize, a PPD PageSize keyword or CDD media size name, is the
+	// default media size of printers that don't mark one, and the size of
+	// their jobs that don't ask for one. When it is empty, or a printer
+	// doesn't have it, the printer's first size is the default.
+	DefaultMediaSize string
+
+	// DefaultOptions, by printer name, are CUPS options added to each job of
+	// the printer; see applyDefaultOptions. A PageSize among them wins over
+	// DefaultMediaSize.
+	DefaultOptions map[string]map[string]string
+}
+
+// NewCUPS creates a new CUPS object.
+func NewCUPS(options Options) (*CUPS, error) {
+	if err := checkPrinterAttributes(options.PrinterAttributes, options.DescribeDriverless); err != nil {
 		return nil, err
 	}
 
-	cc, err := newCUPSCore(maxConnections, connectTimeout)
+	var dms cdd.MediaSizeName
+	if options.DefaultMediaSize != "" {
+		var ok bool
+		if dms, ok = findMediaSizeName(options.DefaultMediaSize); !ok {
+			return nil, fmt.Errorf("Unknown default media size %s", options.DefaultMediaSize)
+		}
+	}
+
+	jobTitleTemplate := options.JobTitleTemplate
+	if jobTitleTemplate == "" && options.PrefixJobIDToJobTitle {
+		jobTitleTemplate = prefixJobIDJobTitleTemplate
+	}
+	var jtt *template.Template
+	if jobTitleTemplate != "" {
+		var err error
+		jtt, err = template.New("job-title").Parse(jobTitleTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse job title template: %s", err)
+		}
+	}
+
+	urlTemplates := make([]*template.Template, 3)
+	for i, u := range []string{options.SetupURL, options.SupportURL, options.UpdateURL} {
+		var err error
+		urlTemplates[i], err = template.New("printer-url").Parse(u)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse printer URL template %s: %s", u, err)
+		}
+	}
+
+	cc, err := newCUPSCore(options.MaxConnections, options.ConnectTimeout, options.ServerHost, options.ServerPort, options.Encryption, options.CACertFile)
 	if err != nil {
 		return nil, err
 	}
-	pc := newPPDCache(cc)
+	pc := newPPDCache(cc, options.TempDir)
 
-	systemTags, err := getSystemTags()
+	systemTags, err := getSystemTags(options.ConnectorDisplayName)
 	if err != nil {
 		return nil, err
 	}
@@ -156,10 +297,32 @@ func NewCUPS(infoToDisplayName, prefixJobIDToJobTitle bool, displayNamePrefix st
 	c := &CUPS{
 		cc:                cc,
 		pc:                pc,
-		infoToDisplayName: infoToDisplayName,
-		displayNamePrefix: displayNamePrefix,
-		printerAttributes: printerAttributes,
+		infoToDisplayName: options.InfoToDisplayName,
+		jobTitleTemplate:  jtt,
+		displayNamePrefix: options.DisplayNamePrefix,
+		displayNameSuffix: options.DisplayNameSuffix,
+		setupURL:          urlTemplates[0],
+		supportURL:        urlTemplates[1],
+		updateURL:         urlTemplates[2],
+		printerAttributes: options.PrinterAttributes,
+		rawMakeAndModels:  options.RawMakeAndModels,
+		missingPPDIsRaw:   options.MissingPPDIsRaw,
 		systemTags:        systemTags,
+
+		describeDriverless: options.DescribeDriverless,
+
+		stateReasonMessages: options.StateReasonMessages,
+
+		sanitizeJobTitle:  options.SanitizeJobTitle,
+		jobTitleMaxLength: options.JobTitleMaxLength,
+
+		driverless: make(map[string]struct{}),
+
+		defaultMediaSize:  dms,
+		mediaSizeDefaults: make(map[string]string),
+		mediaSizeMissing:  make(map[string]struct{}),
+
+		defaultOptions: options.DefaultOptions,
 	}
 
 	return c, nil
@@ -169,7 +332,7 @@ func (c *CUPS) Quit() {
 	c.pc.quit()
 }
 
-// ConnQtyOpen gets the current quantity of open CUPS connections.
+// ConnQtyOpen gets the current quantity of CUPS connections in use.
 func (c *CUPS) ConnQtyOpen() uint {
 	return c.cc.connQtyOpen()
 }
@@ -179,8 +342,20 @@ func (c *CUPS) ConnQtyMax() uint {
 	return c.cc.connQtyMax()
 }
 
-// GetPrinters gets all CUPS printers found on the CUPS server.
-func (c *CUPS) GetPrinters() ([]lib.Printer, error) {
+// ConnPoolStats gets the CUPS connection pool counters.
+func (c *CUPS) ConnPoolStats() ConnPoolStats {
+	return c.cc.connPoolStats()
+}
+
+// PPDCacheStats gets the PPD cache counters.
+func (c *CUPS) PPDCacheStats() PPDCacheStats {
+	return c.pc.stats()
+}
+
+// GetPrinters gets all CUPS printers found on the CUPS server. When ctx is
+// done, PPDs that haven't been fetched yet aren't fetched, and ctx.Err() is
+// returned.
+func (c *CUPS) GetPrinters(ctx context.Context) ([]lib.Printer, error) {
 	pa := C.newArrayOfStrings(C.int(len(c.printerAttributes)))
 	defer C.freeStringArrayAndStrings(pa, C.int(len(c.printerAttributes)))
 	for i, a := range c.printerAttributes {
@@ -201,9 +376,12 @@ func (c *CUPS) GetPrinters() ([]lib.Printer, error) {
 	}
 
 	printers := c.responseToPrinters(response)
-	printers = filterRawPrinters(printers)
-	printers = c.addPPDDescriptionToPrinters(printers)
+	printers = c.addPPDDescriptionToPrinters(ctx, printers)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	printers = addStaticDescriptionToPrinters(printers)
+	printers = c.addURLsToPrinters(printers)
 
 	return printers, nil
 }
@@ -222,11 +400,11 @@ func (c *CUPS) responseToPrinters(response *C.ipp_t) []lib.Printer {
 			attributes = append(attributes, a)
 		}
 		mAttributes := attributesToMap(attributes)
-		pds, pss, name, defaultDisplayName, uuid, tags := translateAttrs(mAttributes)
+		pds, pss, name, defaultDisplayName, uuid, tags := translateAttrs(mAttributes, c.stateReasonMessages)
 		if !c.infoToDisplayName || defaultDisplayName == "" {
 			defaultDisplayName = name
 		}
-		defaultDisplayName = c.displayNamePrefix + defaultDisplayName
+		defaultDisplayName = c.displayNamePrefix + defaultDisplayName + c.displayNameSuffix
 		for k, v := range c.systemTags {
 			tags[k] = v
 		}
@@ -238,6 +416,15 @@ func (c *CUPS) responseToPrinters(response *C.ipp_t) []lib.Printer {
 			Description:        pds,
 			Tags:               tags,
 		}
+		if deviceURI, exists := p.Tags[attrDeviceURI]; exists {
+			p.Tags[attrDeviceURI] = lib.RedactDeviceURI(deviceURI)
+		}
+		p.Raw = lib.PrinterIsRaw(p, c.rawMakeAndModels)
+		if scheme, ok := p.GetDeviceURIScheme(); ok {
+			p.Tags[lib.ConnectionTypeTag] = scheme
+		} else {
+			p.Tags[lib.ConnectionTypeTag] = lib.UnknownConnectionType
+		}
 
 		printers = append(printers, p)
 		if a == nil {
@@ -248,32 +435,60 @@ func (c *CUPS) responseToPrinters(response *C.ipp_t) []lib.Printer {
 	return printers
 }
 
-// filterRawPrinters removes raw printers from the slice.
-func filterRawPrinters(printers []lib.Printer) []lib.Printer {
-	result := make([]lib.Printer, 0, len(printers))
-	for i := range printers {
-		if !lib.PrinterIsRaw(printers[i]) {
-			result = append(result, printers[i])
-		}
-	}
-	return result
+// FilterRawPrinters splits a slice of printers into non-raw and raw, by
+// make-and-model or because GetPrinters found them raw.
+func (c *CUPS) FilterRawPrinters(printers []lib.Printer) ([]lib.Printer, []lib.Printer) {
+	return lib.FilterRawPrinters(printers, c.rawMakeAndModels)
 }
 
 // addPPDDescriptionToPrinters fetches description, PPD hash, manufacturer, model
 // for argument printers, concurrently. These are the fields derived from PPD.
-func (c *CUPS) addPPDDescriptionToPrinters(printers []lib.Printer) []lib.Printer {
+func (c *CUPS) addPPDDescriptionToPrinters(ctx context.Context, printers []lib.Printer) []lib.Printer {
 	var wg sync.WaitGroup
 	ch := make(chan *lib.Printer, len(printers))
+	driverless := make(chan string, len(printers))
+	mediaSizeDefaults := make(chan [2]string, len(printers))
+	mediaSizeMissing := make(chan string, len(printers))
+	setDefaultMediaSize := func(p *lib.Printer) {
+		ms, i, found := withDefaultMediaSize(p.Description.MediaSize, c.defaultMediaSize)
+		if i < 0 {
+			return
+		}
+		p.Description.MediaSize = ms
+		if found {
+			mediaSizeDefaults <- [2]string{p.Name, ms.Option[i].VendorID}
+		} else if c.defaultMediaSize != "" {
+			mediaSizeMissing <- p.Name
+		}
+	}
 
 	for i := range printers {
 		wg.Add(1)
 		go func(p *lib.Printer) {
-			if description, manufacturer, model, err := c.pc.getPPDCacheEntry(p.Name); err == nil {
+			if p.Raw {
+				// Raw printers are described by their attributes alone.
+				ch <- p
+			} else if description, manufacturer, model, err := c.pc.getPPDCacheEntry(ctx, p.Name); err == nil {
 				p.Description.Absorb(description)
+				setDefaultMediaSize(p)
 				p.Manufacturer = manufacturer
 				p.Model = model
 				ch <- p
-			} else {
+			} else if err == errNoPPD && c.describeDriverless && printerIsDriverless(p) {
+				// IPP Everywhere; the printer describes itself.
+				description, manufacturer, model := translateIPPAttrs(p.Tags)
+				p.Description.Absorb(description)
+				setDefaultMediaSize(p)
+				p.Manufacturer = manufacturer
+				p.Model = model
+				driverless <- p.Name
+				ch <- p
+			} else if err == errNoPPD && c.missingPPDIsRaw {
+				log.Debugf("Printer %s has no PPD, so it is raw", p.Name)
+				p.Raw = true
+				ch <- p
+			} else if ctx.Err() == nil {
+				// When ctx is done, GetPrinters returns ctx.Err() instead.
 				log.Error(err)
 			}
 			wg.Done()
@@ -282,6 +497,33 @@ func (c *CUPS) addPPDDescriptionToPrinters(printers []lib.Printer) []lib.Printer
 
 	wg.Wait()
 	close(ch)
+	close(driverless)
+	close(mediaSizeDefaults)
+	close(mediaSizeMissing)
+
+	names := make(map[string]struct{}, len(driverless))
+	for name := range driverless {
+		names[name] = struct{}{}
+	}
+	c.driverlessMutex.Lock()
+	c.driverless = names
+	c.driverlessMutex.Unlock()
+
+	defaults := make(map[string]string, len(mediaSizeDefaults))
+	for d := range mediaSizeDefaults {
+		defaults[d[0]] = d[1]
+	}
+	missing := make(map[string]struct{}, len(mediaSizeMissing))
+	c.mediaSizeMutex.Lock()
+	for name := range mediaSizeMissing {
+		missing[name] = struct{}{}
+		if _, exists := c.mediaSizeMissing[name]; !exists {
+			log.WarningPrinterf(name, "Printer doesn't have default media size %s, so its first size is the default", c.defaultMediaSize)
+		}
+	}
+	c.mediaSizeDefaults = defaults
+	c.mediaSizeMissing = missing
+	c.mediaSizeMutex.Unlock()
 
 	result := make([]lib.Printer, 0, len(ch))
 	for printer := range ch {
@@ -298,13 +540,34 @@ func addStaticDescriptionToPrinters(printers []lib.Printer) []lib.Printer {
 		printers[i].Description.Absorb(&cupsPDS)
 		printers[i].GCPVersion = lib.GCPAPIVersion
 		printers[i].ConnectorVersion = lib.ShortName
-		printers[i].SetupURL = lib.ConnectorHomeURL
-		printers[i].SupportURL = lib.ConnectorHomeURL
-		printers[i].UpdateURL = lib.ConnectorHomeURL
 	}
 	return printers
 }
 
+// addURLsToPrinters renders the setup, support and update URL templates for
+// each printer. A URL that fails to render, or renders empty, like when the
+// config file lacks it, falls back to the connector home page.
+func (c *CUPS) addURLsToPrinters(printers []lib.Printer) []lib.Printer {
+	for i := range printers {
+		printers[i].SetupURL = renderPrinterURL(c.setupURL, &printers[i])
+		printers[i].SupportURL = renderPrinterURL(c.supportURL, &printers[i])
+		printers[i].UpdateURL = renderPrinterURL(c.updateURL, &printers[i])
+	}
+	return printers
+}
+
+func renderPrinterURL(t *template.Template, printer *lib.Printer) string {
+	var b bytes.Buffer
+	if err := t.Execute(&b, printer); err != nil {
+		log.WarningPrinterf(printer.Name, "Failed to render printer URL: %s", err)
+		return lib.ConnectorHomeURL
+	}
+	if b.Len() == 0 {
+		return lib.ConnectorHomeURL
+	}
+	return b.String()
+}
+
 // uname returns strings similar to the Unix uname command:
 // sysname, nodename, release, version, machine
 func uname() (string, string, string, string, string, error) {
@@ -320,10 +583,13 @@ func uname() (string, string, string, string, string, error) {
 		C.GoString(&name.machine[0]), nil
 }
 
-func getSystemTags() (map[string]string, error) {
+func getSystemTags(connectorDisplayName string) (map[string]string, error) {
 	tags := make(map[string]string)
 
 	tags["connector-version"] = lib.BuildDate
+	if connectorDisplayName != "" {
+		tags["connector-display-name"] = connectorDisplayName
+	}
 	hostname, err := os.Hostname()
 	if err == nil {
 		tags["system-hostname"] = hostname
@@ -342,15 +608,48 @@ func getSystemTags() (map[string]string, error) {
 	tags["system-uname-version"] = version
 	tags["system-uname-machine"] = machine
 
-	tags["connector-cups-client-version"] = fmt.Sprintf("%d.%d.%d",
-		C.CUPS_VERSION_MAJOR, C.CUPS_VERSION_MINOR, C.CUPS_VERSION_PATCH)
+	tags["connector-cups-client-version"] = ClientVersion()
 
 	return tags, nil
 }
 
-// RemoveCachedPPD removes a printer's PPD from the cache.
-func (c *CUPS) RemoveCachedPPD(printername string) {
+// ClientVersion returns the version of the CUPS API that the connector was
+// built with.
+func ClientVersion() string {
+	return fmt.Sprintf("%d.%d.%d",
+		C.CUPS_VERSION_MAJOR, C.CUPS_VERSION_MINOR, C.CUPS_VERSION_PATCH)
+}
+
+// isDriverless checks whether GetPrinters found the named printer driverless.
+func (c *CUPS) isDriverless(printername string) bool {
+	c.driverlessMutex.Lock()
+	defer c.driverlessMutex.Unlock()
+
+	_, exists := c.driverless[printername]
+	return exists
+}
+
+// defaultMediaSizeVendorID gets the vendor ID of the named printer's default
+// media size, when GetPrinters set it from the configured default.
+func (c *CUPS) defaultMediaSizeVendorID(printername string) (string, bool) {
+	c.mediaSizeMutex.Lock()
+	defer c.mediaSizeMutex.Unlock()
+
+	vendorID, exists := c.mediaSizeDefaults[printername]
+	return vendorID, exists
+}
+
+// RemoveCachedPPD removes a printer's PPD from the cache, so that the next
+// poll fetches and translates it again. Returns true if it was cached.
+func (c *CUPS) RemoveCachedPPD(printername string) bool {
+	return c.pc.removePPD(printername)
+}
+
+// FetchPPD fetches and translates a printer's PPD, replacing any cached copy.
+func (c *CUPS) FetchPPD(ctx context.Context, printername string) (*cdd.PrinterDescriptionSection, error) {
 	c.pc.removePPD(printername)
+	description, _, _, err := c.pc.getPPDCacheEntry(ctx, printername)
+	return description, err
 }
 
 // GetJobState gets the current state of the job indicated by jobID.
@@ -370,12 +669,17 @@ func (c *CUPS) GetJobState(jobID uint32) (cdd.PrintJobStateDiff, error) {
 	defer C.ippDelete(response)
 
 	s := C.ippFindAttribute(response, C.JOB_STATE, C.IPP_TAG_ENUM)
-	state := int32(C.getAttributeIntegerValue(s, C.int(0)))
+	cupsState := int32(C.getAttributeIntegerValue(s, C.int(0)))
 
-	return convertJobState(state), nil
+	state := convertJobState(cupsState)
+	if state.State == nil {
+		return cdd.PrintJobStateDiff{}, fmt.Errorf("CUPS job %d has unrecognized state %d", jobID, cupsState)
+	}
+	return state, nil
 }
 
-// convertJobState converts CUPS job state to cdd.PrintJobStateDiff.
+// convertJobState converts CUPS job state to cdd.PrintJobStateDiff. The
+// State is nil when cupsState isn't recognized.
 func convertJobState(cupsState int32) cdd.PrintJobStateDiff {
 	var state cdd.PrintJobStateDiff
 
@@ -413,11 +717,19 @@ func (c *CUPS) Print(printername, filename, title, user, gcpJobID string, ticket
 	defer C.free(unsafe.Pointer(fn))
 	var t *C.char
 
-	if c.prefixJobIDToJobTitle {
-		title = fmt.Sprintf("gcp:%s %s", gcpJobID, title)
+	if c.jobTitleTemplate != nil {
+		var b bytes.Buffer
+		data := struct{ ID, Title string }{gcpJobID, title}
+		if err := c.jobTitleTemplate.Execute(&b, data); err != nil {
+			return 0, fmt.Errorf("Failed to render job title: %s", err)
+		}
+		title = b.String()
+	}
+	if c.sanitizeJobTitle {
+		title = lib.SanitizeJobTitle(title, c.jobTitleMaxLength)
 	}
-	if len(title) > 255 {
-		t = C.CString(title[:255])
+	if len(title) > lib.MaxJobTitleLength {
+		t = C.CString(title[:lib.MaxJobTitleLength])
 	} else {
 		t = C.CString(title)
 	}
@@ -427,6 +739,16 @@ func (c *CUPS) Print(printername, filename, title, user, gcpJobID string, ticket
 	if err != nil {
 		return 0, err
 	}
+	applyDefaultOptions(options, c.defaultOptions[printername])
+	if _, exists := options[ppdPageSize]; !exists {
+		if vendorID, exists := c.defaultMediaSizeVendorID(printername); exists {
+			// The printer didn't mark this default, so CUPS doesn't know it.
+			options[ppdPageSize] = vendorID
+		}
+	}
+	if c.isDriverless(printername) {
+		options = translateOptionsToIPP(options)
+	}
 	numOptions := C.int(0)
 	var o *C.cups_option_t = nil
 	for key, value := range options {
@@ -569,9 +891,19 @@ func findMissing(haystack, needles []string) []string {
 	return missing
 }
 
-func checkPrinterAttributes(printerAttributes []string) error {
+func checkPrinterAttributes(printerAttributes []string, describeDriverless bool) error {
+	for _, a := range printerAttributes {
+		if a == "" || strings.IndexAny(a, " \t\n,") >= 0 {
+			return fmt.Errorf("Invalid printer attribute in config file: %q", a)
+		}
+	}
+
 	if !contains(printerAttributes, "all") {
-		missing := findMissing(printerAttributes, requiredPrinterAttributes)
+		required := requiredPrinterAttributes
+		if describeDriverless {
+			required = append(required[:len(required):len(required)], driverlessPrinterAttributes...)
+		}
+		missing := findMissing(printerAttributes, required)
 		if len(missing) > 0 {
 			return fmt.Errorf("Printer attributes missing from config file: %s",
 				strings.Join(missing, ","))
@@ -14,6 +14,8 @@ package cups
 import "C"
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
 	"errors"
 	"fmt"
 	"io"
@@ -23,6 +25,7 @@ import (
 	"unsafe"
 
 	"github.com/google/cups-connector/cdd"
+	"github.com/google/cups-connector/log"
 )
 
 // This isn't really a cache, but an interface to CUPS' quirky PPD interface.
@@ -32,17 +35,42 @@ import (
 // So, this "cache":
 // (1) maintains temporary file copies of PPDs for each printer
 // (2) updates those PPD files as necessary
+//
+// Printers with byte-identical PPDs share one translation of the PPD.
 type ppdCache struct {
 	cc         *cupsCore
+	tempDir    string
 	cache      map[string]*ppdCacheEntry
 	cacheMutex sync.RWMutex
+
+	translations      map[string]*ppdTranslation
+	translationsMutex sync.Mutex
+
+	statsMutex      sync.Mutex
+	hits            uint
+	misses          uint
+	refreshFailures uint
+	evictions       uint
 }
 
-func newPPDCache(cc *cupsCore) *ppdCache {
+// PPDCacheStats are numbers reported to monitoring.
+type PPDCacheStats struct {
+	Entries         uint
+	Hits            uint
+	Misses          uint
+	RefreshFailures uint
+	Evictions       uint
+}
+
+// newPPDCache creates a new ppdCache. PPD files are stored in tempDir; when
+// tempDir is empty, the system temporary directory is used.
+func newPPDCache(cc *cupsCore, tempDir string) *ppdCache {
 	cache := make(map[string]*ppdCacheEntry)
 	pc := ppdCache{
-		cc:    cc,
-		cache: cache,
+		cc:           cc,
+		tempDir:      tempDir,
+		cache:        cache,
+		translations: make(map[string]*ppdTranslation),
 	}
 	return &pc
 }
@@ -52,34 +80,141 @@ func (pc *ppdCache) quit() {
 	defer pc.cacheMutex.Unlock()
 
 	for printername, pce := range pc.cache {
-		pce.free()
+		pce.free(pc)
 		delete(pc.cache, printername)
 	}
 }
 
-// removePPD removes a cache entry from the cache.
-func (pc *ppdCache) removePPD(printername string) {
+// removePPD removes a cache entry from the cache. Returns true if there was
+// an entry.
+func (pc *ppdCache) removePPD(printername string) bool {
 	pc.cacheMutex.Lock()
 	defer pc.cacheMutex.Unlock()
 
-	if pce, exists := pc.cache[printername]; exists {
-		pce.free()
+	pce, exists := pc.cache[printername]
+	if exists {
+		pce.free(pc)
 		delete(pc.cache, printername)
+		pc.countEviction()
+	}
+	return exists
+}
+
+// removePPDEntry removes pce from the cache, unless another entry has replaced
+// it concurrently; that entry is up-to-date, and pce was freed when replaced.
+func (pc *ppdCache) removePPDEntry(printername string, pce *ppdCacheEntry) {
+	pc.cacheMutex.Lock()
+	defer pc.cacheMutex.Unlock()
+
+	if pc.cache[printername] != pce {
+		return
+	}
+	pce.free(pc)
+	delete(pc.cache, printername)
+	pc.countEviction()
+}
+
+// stats returns a snapshot of the cache counters.
+func (pc *ppdCache) stats() PPDCacheStats {
+	pc.cacheMutex.RLock()
+	entries := uint(len(pc.cache))
+	pc.cacheMutex.RUnlock()
+
+	pc.statsMutex.Lock()
+	defer pc.statsMutex.Unlock()
+
+	return PPDCacheStats{entries, pc.hits, pc.misses, pc.refreshFailures, pc.evictions}
+}
+
+// countRefresh counts the outcome of one ppdCacheEntry.refresh() call.
+func (pc *ppdCache) countRefresh(hit bool, err error) {
+	pc.statsMutex.Lock()
+	defer pc.statsMutex.Unlock()
+
+	if err != nil {
+		pc.refreshFailures++
+	} else if hit {
+		pc.hits++
+	} else {
+		pc.misses++
+	}
+}
+
+func (pc *ppdCache) countEviction() {
+	pc.statsMutex.Lock()
+	defer pc.statsMutex.Unlock()
+
+	pc.evictions++
+}
+
+// acquireTranslation returns the shared translation of the PPD content with
+// hash, translating content if no printer shares it yet. The caller must
+// release the translation with releaseTranslation.
+func (pc *ppdCache) acquireTranslation(hash, content string) (*ppdTranslation, error) {
+	pc.translationsMutex.Lock()
+	if t, exists := pc.translations[hash]; exists {
+		t.refs++
+		pc.translationsMutex.Unlock()
+		return t, nil
+	}
+	pc.translationsMutex.Unlock()
+
+	// Translate without holding the lock; PPDs can be large.
+	description, manufacturer, model, warnings := translatePPD(content)
+	if description == nil {
+		return nil, errors.New("Failed to parse PPD")
+	}
+
+	pc.translationsMutex.Lock()
+	defer pc.translationsMutex.Unlock()
+
+	if t, exists := pc.translations[hash]; exists {
+		// Translated concurrently for another printer.
+		t.refs++
+		return t, nil
+	}
+	t := &ppdTranslation{hash, *description, manufacturer, model, warnings, 1}
+	pc.translations[hash] = t
+	return t, nil
+}
+
+// releaseTranslation drops one reference to t, forgetting t when no printers
+// use it anymore. t may be nil.
+func (pc *ppdCache) releaseTranslation(t *ppdTranslation) {
+	if t == nil {
+		return
+	}
+
+	pc.translationsMutex.Lock()
+	defer pc.translationsMutex.Unlock()
+
+	t.refs--
+	if t.refs == 0 {
+		delete(pc.translations, t.hash)
 	}
 }
 
-func (pc *ppdCache) getPPDCacheEntry(printername string) (*cdd.PrinterDescriptionSection, string, string, error) {
+// getPPDCacheEntry gets the PPD-derived fields of a printer, refreshing its
+// PPD. cupsGetPPD3() can't be interrupted, so ctx is checked before calling it,
+// and the cache entry is left alone when ctx is done.
+func (pc *ppdCache) getPPDCacheEntry(ctx context.Context, printername string) (*cdd.PrinterDescriptionSection, string, string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, "", "", err
+	}
+
 	pc.cacheMutex.RLock()
 	pce, exists := pc.cache[printername]
 	pc.cacheMutex.RUnlock()
 
 	if !exists {
-		pce, err := createPPDCacheEntry(printername)
+		pce, err := createPPDCacheEntry(printername, pc.tempDir)
 		if err != nil {
 			return nil, "", "", err
 		}
-		if err = pce.refresh(pc.cc); err != nil {
-			pce.free()
+		hit, err := pce.refresh(pc)
+		pc.countRefresh(hit, err)
+		if err != nil {
+			pce.free(pc)
 			return nil, "", "", err
 		}
 
@@ -89,16 +224,23 @@ func (pc *ppdCache) getPPDCacheEntry(printername string) (*cdd.PrinterDescriptio
 		if firstPCE, exists := pc.cache[printername]; exists {
 			// Two entries were created at the same time. Remove the older one.
 			delete(pc.cache, printername)
-			go firstPCE.free()
+			go firstPCE.free(pc)
+			pc.countEviction()
 		}
 		pc.cache[printername] = pce
 		description, manufacturer, model := pce.getFields()
 		return &description, manufacturer, model, nil
 
 	} else {
-		if err := pce.refresh(pc.cc); err != nil {
-			delete(pc.cache, printername)
-			pce.free()
+		hit, err := pce.refresh(pc)
+		pc.countRefresh(hit, err)
+		if _, ok := err.(*ppdTooSmallError); ok && pce.hasTranslation() {
+			// Keep the previous translation until CUPS returns a whole PPD.
+			description, manufacturer, model := pce.getFields()
+			return &description, manufacturer, model, nil
+		}
+		if err != nil {
+			pc.removePPDEntry(printername, pce)
 			return nil, "", "", err
 		}
 		description, manufacturer, model := pce.getFields()
@@ -106,22 +248,46 @@ func (pc *ppdCache) getPPDCacheEntry(printername string) (*cdd.PrinterDescriptio
 	}
 }
 
-// Holds persistent data needed for calling C.cupsGetPPD3.
-type ppdCacheEntry struct {
-	printername  *C.char
-	modtime      C.time_t
-	filename     string
+// Smaller PPDs, like empty or truncated files that CUPS sometimes returns, are
+// not translated. The *PPD-Adobe header and a few required keywords alone are
+// larger than this.
+const minPPDSize = 64
+
+// ppdTooSmallError means that CUPS returned a PPD smaller than minPPDSize,
+// which is likely a transient failure.
+type ppdTooSmallError struct {
+	size int
+}
+
+func (e *ppdTooSmallError) Error() string {
+	return fmt.Sprintf("PPD of %d bytes is too small to translate", e.size)
+}
+
+// ppdTranslation is the translated content of one PPD, shared by the cache
+// entries of all printers with that PPD.
+type ppdTranslation struct {
+	hash         string
 	description  cdd.PrinterDescriptionSection
 	manufacturer string
 	model        string
-	mutex        sync.Mutex
+	warnings     []string
+	refs         uint
+}
+
+// Holds persistent data needed for calling C.cupsGetPPD3.
+type ppdCacheEntry struct {
+	printername *C.char
+	modtime     C.time_t
+	filename    string
+	translation *ppdTranslation
+	mutex       sync.Mutex
 }
 
 // createPPDCacheEntry creates an instance of ppdCache with the name field set,
 // all else empty. The caller must free the name and buffer fields with
 // ppdCacheEntry.free()
-func createPPDCacheEntry(name string) (*ppdCacheEntry, error) {
-	file, err := ioutil.TempFile("", "cups-connector-ppd-")
+func createPPDCacheEntry(name, tempDir string) (*ppdCacheEntry, error) {
+	file, err := ioutil.TempFile(tempDir, "cups-connector-ppd-")
 	if err != nil {
 		return nil, fmt.Errorf("Failed to create PPD cache entry file: %s", err)
 	}
@@ -142,34 +308,45 @@ func createPPDCacheEntry(name string) (*ppdCacheEntry, error) {
 func (pce *ppdCacheEntry) getFields() (cdd.PrinterDescriptionSection, string, string) {
 	pce.mutex.Lock()
 	defer pce.mutex.Unlock()
-	return pce.description, pce.manufacturer, pce.model
+	t := pce.translation
+	return t.description, t.manufacturer, t.model
 }
 
-// free frees the memory that stores the name and buffer fields, and deletes
-// the file named by the buffer field. If the file doesn't exist, no error is
-// returned.
-func (pce *ppdCacheEntry) free() {
+// hasTranslation checks whether a PPD was ever translated for this entry.
+func (pce *ppdCacheEntry) hasTranslation() bool {
+	pce.mutex.Lock()
+	defer pce.mutex.Unlock()
+	return pce.translation != nil
+}
+
+// free frees the memory that stores the name and buffer fields, deletes
+// the file named by the buffer field, and releases the translation. If the
+// file doesn't exist, no error is returned.
+func (pce *ppdCacheEntry) free(pc *ppdCache) {
 	pce.mutex.Lock()
 	defer pce.mutex.Unlock()
 
 	C.free(unsafe.Pointer(pce.printername))
 	os.Remove(pce.filename)
+	pc.releaseTranslation(pce.translation)
+	pce.translation = nil
 }
 
 // refresh calls cupsGetPPD3() to refresh this PPD information, in
-// case CUPS has a new PPD for the printer.
-func (pce *ppdCacheEntry) refresh(cc *cupsCore) error {
+// case CUPS has a new PPD for the printer. Returns true when the PPD
+// hasn't changed.
+func (pce *ppdCacheEntry) refresh(pc *ppdCache) (bool, error) {
 	pce.mutex.Lock()
 	defer pce.mutex.Unlock()
 
-	ppdFilename, err := cc.getPPD(pce.printername, &pce.modtime)
+	ppdFilename, err := pc.cc.getPPD(pce.printername, &pce.modtime)
 	if err != nil {
-		return err
+		return false, err
 	}
 
 	if ppdFilename == nil {
 		// Cache hit.
-		return nil
+		return true, nil
 	}
 
 	// (else) Cache miss.
@@ -179,34 +356,40 @@ func (pce *ppdCacheEntry) refresh(cc *cupsCore) error {
 	// Read from CUPS temporary file.
 	r, err := os.Open(C.GoString(ppdFilename))
 	if err != nil {
-		return err
+		return false, err
 	}
 	defer r.Close()
 
-	// Write to this PPD cache file.
-	file, err := os.OpenFile(pce.filename, os.O_WRONLY|os.O_TRUNC, 0200)
-	if err != nil {
-		return fmt.Errorf("Failed to open already-created PPD cache file: %s", err)
+	var content bytes.Buffer
+	if _, err := io.Copy(&content, r); err != nil {
+		return false, err
+	}
+	if content.Len() < minPPDSize {
+		// Fetch the PPD again next time, instead of caching it.
+		pce.modtime = C.time_t(0)
+		log.WarningPrinterf(C.GoString(pce.printername),
+			"CUPS returned a PPD of %d bytes, which is too small to translate; will try again", content.Len())
+		return false, &ppdTooSmallError{content.Len()}
 	}
-	defer file.Close()
 
-	// Also write to a buffer for translation.
-	var content bytes.Buffer
+	// Write to this PPD cache file.
+	if err = ioutil.WriteFile(pce.filename, content.Bytes(), 0200); err != nil {
+		return false, fmt.Errorf("Failed to write PPD cache file: %s", err)
+	}
 
-	// Write to these simultaneously.
-	w := io.MultiWriter(&content, file)
-	if _, err := io.Copy(w, r); err != nil {
-		return err
+	hash := fmt.Sprintf("%x", sha256.Sum256(content.Bytes()))
+	translation, err := pc.acquireTranslation(hash, content.String())
+	if err != nil {
+		return false, err
 	}
 
-	description, manufacturer, model := translatePPD(content.String())
-	if description == nil || manufacturer == "" || model == "" {
-		return errors.New("Failed to parse PPD")
+	// A partial translation is better than none.
+	for _, warning := range translation.warnings {
+		log.WarningPrinter(C.GoString(pce.printername), warning)
 	}
 
-	pce.description = *description
-	pce.manufacturer = manufacturer
-	pce.model = model
+	pc.releaseTranslation(pce.translation)
+	pce.translation = translation
 
-	return nil
+	return false, nil
 }
@@ -0,0 +1,49 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package cups
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// An entry that failed to refresh must not remove the entry that replaced it
+// concurrently.
+func TestRemovePPDEntry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ppdcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pc := newPPDCache(nil, dir)
+	replaced, err := createPPDCacheEntry("lobby", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replaced.free(pc)
+	current, err := createPPDCacheEntry("lobby", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pc.cache["lobby"] = current
+
+	pc.removePPDEntry("lobby", replaced)
+	if pc.cache["lobby"] != current || pc.stats().Evictions != 0 {
+		t.Logf("expected the current entry to stay, got %v and %+v", pc.cache, pc.stats())
+		t.Fail()
+	}
+
+	pc.removePPDEntry("lobby", current)
+	if _, exists := pc.cache["lobby"]; exists || pc.stats().Evictions != 1 {
+		t.Logf("expected the current entry to be removed, got %v and %+v", pc.cache, pc.stats())
+		t.Fail()
+	}
+}
@@ -20,7 +20,8 @@ import (
 )
 
 // translateAttrs extracts a PrinterDescriptionSection, PrinterStateSection, name, default diplay name, UUID, and tags from maps of tags (CUPS attributes)
-func translateAttrs(printerTags map[string][]string) (*cdd.PrinterDescriptionSection, *cdd.PrinterStateSection, string, string, string, map[string]string) {
+// stateReasonMessages overrides the messages of printer-state-reasons keywords.
+func translateAttrs(printerTags map[string][]string, stateReasonMessages map[string]string) (*cdd.PrinterDescriptionSection, *cdd.PrinterStateSection, string, string, string, map[string]string) {
 	var name, info string
 	if n, ok := printerTags[attrPrinterName]; ok && len(n) > 0 {
 		name = n[0]
@@ -43,16 +44,51 @@ func translateAttrs(printerTags map[string][]string) (*cdd.PrinterDescriptionSec
 	}
 
 	state.State = getState(printerTags)
-	state.VendorState = getVendorState(printerTags)
+	state.VendorState = getVendorState(printerTags, stateReasonMessages)
 
-	tags := make(map[string]string, len(printerTags))
-	for k, v := range printerTags {
-		tags[k] = strings.Join(v, ",")
-	}
+	tags := attributesToTags(printerTags)
+	// Always keep printer-info, so that changes to it are noticed via the
+	// tags hash even when it isn't copied to the display name.
+	tags[attrPrinterInfo] = info
 
 	return &desc, &state, name, info, uuid, tags
 }
 
+// attributesToTags converts CUPS attributes to printer tags. Keys are
+// lowercased and trimmed. Multiple values are joined with commas, in the order
+// that CUPS reports them, so that values of related attributes like
+// marker-names and marker-levels still line up. The keys in guaranteedTags are
+// always present, if empty.
+func attributesToTags(printerTags map[string][]string) map[string]string {
+	// Sort keys so that the tags are the same when keys normalize to the same
+	// tag.
+	keys := make([]string, 0, len(printerTags))
+	for k := range printerTags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	tags := make(map[string]string, len(printerTags)+len(guaranteedTags))
+	for _, k := range guaranteedTags {
+		tags[k] = ""
+	}
+	for _, k := range keys {
+		tag := strings.ToLower(strings.TrimSpace(k))
+		if tag == "" {
+			continue
+		}
+		values := make([]string, 0, len(printerTags[k]))
+		for _, v := range printerTags[k] {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+		tags[tag] = strings.Join(values, ",")
+	}
+
+	return tags
+}
+
 func getUUID(printerTags map[string][]string) string {
 	var uuid string
 	if u, ok := printerTags[attrPrinterUUID]; ok {
@@ -82,7 +118,9 @@ func getState(printerTags map[string][]string) cdd.CloudDeviceStateType {
 	return cdd.CloudDeviceStateIdle
 }
 
-func getVendorState(printerTags map[string][]string) *cdd.VendorState {
+// getVendorState describes printer-state-reasons with messages, as returned by
+// stateReasonMessage. The keywords themselves are kept in the tags.
+func getVendorState(printerTags map[string][]string, messages map[string]string) *cdd.VendorState {
 	reasons, exists := printerTags[attrPrinterStateReasons]
 	if !exists || len(reasons) < 1 {
 		return nil
@@ -91,7 +129,7 @@ func getVendorState(printerTags map[string][]string) *cdd.VendorState {
 	sort.Strings(reasons)
 	vendorState := &cdd.VendorState{Item: make([]cdd.VendorStateItem, len(reasons))}
 	for i, reason := range reasons {
-		vs := cdd.VendorStateItem{DescriptionLocalized: cdd.NewLocalizedString(reason)}
+		vs := cdd.VendorStateItem{DescriptionLocalized: cdd.NewLocalizedString(stateReasonMessage(reason, messages))}
 		if strings.HasSuffix(reason, "-error") {
 			vs.State = cdd.VendorStateError
 		} else if strings.HasSuffix(reason, "-warning") {
@@ -89,6 +89,60 @@ func TestGetUUID(t *testing.T) {
 	}
 }
 
+func TestTranslateAttrsPrinterInfoTag(t *testing.T) {
+	pt := map[string][]string{
+		attrPrinterName: []string{"printer"},
+		attrPrinterInfo: []string{"Second floor"},
+	}
+	_, _, _, info, _, tags := translateAttrs(pt, nil)
+	if info != "Second floor" {
+		t.Logf("expected info %s, got %s", "Second floor", info)
+		t.Fail()
+	}
+	if tags[attrPrinterInfo] != "Second floor" {
+		t.Logf("expected %s tag %s, got %s", attrPrinterInfo, "Second floor", tags[attrPrinterInfo])
+		t.Fail()
+	}
+
+	pt = map[string][]string{attrPrinterName: []string{"printer"}}
+	_, _, _, _, _, tags = translateAttrs(pt, nil)
+	if v, ok := tags[attrPrinterInfo]; !ok || v != "" {
+		t.Logf("expected empty %s tag, got %q, %t", attrPrinterInfo, v, ok)
+		t.Fail()
+	}
+}
+
+func TestAttributesToTags(t *testing.T) {
+	pt := map[string][]string{
+		attrPrinterName:  []string{"printer"},
+		" Marker-Names ": []string{"black", " ", "cyan "},
+		attrMarkerLevels: []string{},
+	}
+	tags := attributesToTags(pt)
+
+	expected := map[string]string{
+		attrPrinterName:         "printer",
+		attrMarkerNames:         "black,cyan",
+		attrMarkerLevels:        "",
+		attrDeviceURI:           "",
+		attrPrinterMakeAndModel: "",
+		attrPrinterStateReasons: "",
+	}
+	if !reflect.DeepEqual(tags, expected) {
+		t.Logf("expected %+v, got %+v", expected, tags)
+		t.Fail()
+	}
+
+	pt = map[string][]string{
+		attrPrinterStateReasons: []string{"toner-low-warning", "media-low-warning"},
+	}
+	tags = attributesToTags(pt)
+	if tags[attrPrinterStateReasons] != "toner-low-warning,media-low-warning" {
+		t.Logf("expected values in CUPS order, got %s", tags[attrPrinterStateReasons])
+		t.Fail()
+	}
+}
+
 func TestGetState(t *testing.T) {
 	state := getState(nil)
 	if cdd.CloudDeviceStateIdle != state {
@@ -119,14 +173,14 @@ func TestGetState(t *testing.T) {
 }
 
 func TestGetVendorState(t *testing.T) {
-	vs := getVendorState(nil)
+	vs := getVendorState(nil, nil)
 	if nil != vs {
 		t.Logf("expected nil")
 		t.Fail()
 	}
 
 	pt := map[string][]string{}
-	vs = getVendorState(pt)
+	vs = getVendorState(pt, nil)
 	if nil != vs {
 		t.Logf("expected nil")
 		t.Fail()
@@ -147,13 +201,35 @@ func TestGetVendorState(t *testing.T) {
 			},
 		},
 	}
-	vs = getVendorState(pt)
+	vs = getVendorState(pt, nil)
 	if !reflect.DeepEqual(expected, vs) {
 		t.Logf("expected\n %+v\ngot\n %+v", expected, vs)
 		t.Fail()
 	}
 }
 
+func TestGetVendorStateMessages(t *testing.T) {
+	pt := map[string][]string{
+		attrPrinterStateReasons: []string{"media-jam-error", "toner-low-warning", "acme-tray-report"},
+	}
+	messages := map[string]string{
+		"toner-low":        "Le toner est bas",
+		"acme-tray-report": "Tray 3 is being serviced",
+	}
+	expected := []string{"Tray 3 is being serviced", "Paper jam", "Le toner est bas"}
+
+	vs := getVendorState(pt, messages)
+	if vs == nil || len(vs.Item) != len(expected) {
+		t.Fatalf("expected %d items, got %+v", len(expected), vs)
+	}
+	for i, item := range vs.Item {
+		if got := (*item.DescriptionLocalized)[0].Value; got != expected[i] {
+			t.Logf("expected %q, got %q", expected[i], got)
+			t.Fail()
+		}
+	}
+}
+
 func TestConvertSupportedContentType(t *testing.T) {
 	sct := convertSupportedContentType(nil)
 	if sct != nil {
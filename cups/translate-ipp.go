@@ -0,0 +1,243 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package cups
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/google/cups-connector/cdd"
+	"github.com/google/cups-connector/lib"
+)
+
+// Attributes of driverless printers that the PPD describes for others.
+const (
+	attrMediaDefault               = "media-default"
+	attrMediaSupported             = "media-supported"
+	attrSidesDefault               = "sides-default"
+	attrSidesSupported             = "sides-supported"
+	attrPrinterResolutionDefault   = "printer-resolution-default"
+	attrPrinterResolutionSupported = "printer-resolution-supported"
+
+	attrMedia             = "media"
+	attrSides             = "sides"
+	attrPrinterResolution = "printer-resolution"
+)
+
+// Attributes that describe a driverless printer, required when driverless
+// printers are described.
+var driverlessPrinterAttributes = []string{
+	attrMediaDefault,
+	attrMediaSupported,
+	attrSidesDefault,
+	attrSidesSupported,
+	attrPrinterResolutionDefault,
+	attrPrinterResolutionSupported,
+}
+
+var (
+	// PWG 5101.1 self-describing media names, like iso_a4_210x297mm.
+	rPWGMediaName = regexp.MustCompile(`^([a-z0-9]+)_([a-z0-9.-]+)_([0-9.]+)x([0-9.]+)(mm|in)$`)
+	// Resolutions, as attributesToMap formats them.
+	rIPPResolution = regexp.MustCompile(`^([0-9]+)x([0-9]+)ppi$`)
+	// Suffixes that CUPS adds to the make and model of driverless queues.
+	rDriverlessSuffix = regexp.MustCompile(`(?i)\s*(?:,|-)?\s*(?:ipp everywhere|driverless)(?:.*)$`)
+)
+
+// Device URI schemes of queues that CUPS drives with IPP, without a driver.
+var driverlessDeviceURISchemes = map[string]struct{}{
+	"ipp":           struct{}{},
+	"ipps":          struct{}{},
+	"implicitclass": struct{}{},
+}
+
+var sidesByCDD = map[cdd.DuplexType]string{
+	cdd.DuplexNoDuplex:  "one-sided",
+	cdd.DuplexLongEdge:  "two-sided-long-edge",
+	cdd.DuplexShortEdge: "two-sided-short-edge",
+}
+
+// printerIsDriverless checks whether a printer without a PPD is an IPP Everywhere
+// queue, which CUPS drives with the printer's own IPP attributes.
+func printerIsDriverless(p *lib.Printer) bool {
+	makeAndModel := strings.ToLower(p.Tags[attrPrinterMakeAndModel])
+	if strings.Contains(makeAndModel, "ipp everywhere") || strings.Contains(makeAndModel, "driverless") {
+		return true
+	}
+	scheme, ok := p.GetDeviceURIScheme()
+	if !ok {
+		return false
+	}
+	_, exists := driverlessDeviceURISchemes[scheme]
+	return exists
+}
+
+// translateIPPAttrs extracts the PPD-derived fields, a PrinterDescriptionSection,
+// manufacturer and model, from the printer tags of a driverless printer.
+func translateIPPAttrs(tags map[string]string) (*cdd.PrinterDescriptionSection, string, string) {
+	var pds cdd.PrinterDescriptionSection
+	pds.MediaSize = convertIPPMedia(splitTag(tags[attrMediaSupported]), tags[attrMediaDefault])
+	pds.Duplex = convertIPPSides(splitTag(tags[attrSidesSupported]), tags[attrSidesDefault])
+	pds.DPI = convertIPPResolution(splitTag(tags[attrPrinterResolutionSupported]), tags[attrPrinterResolutionDefault])
+
+	makeAndModel := rDriverlessSuffix.ReplaceAllString(tags[attrPrinterMakeAndModel], "")
+	manufacturer, model := makeAndModel, ""
+	if i := strings.IndexByte(makeAndModel, ' '); i > 0 {
+		manufacturer, model = makeAndModel[:i], strings.TrimSpace(makeAndModel[i+1:])
+	}
+
+	return &pds, manufacturer, model
+}
+
+// splitTag splits a tag of attribute values joined by attributesToTags.
+func splitTag(tag string) []string {
+	if tag == "" {
+		return nil
+	}
+	return strings.Split(tag, ",")
+}
+
+func convertIPPMedia(supported []string, def string) *cdd.MediaSize {
+	ms := cdd.MediaSize{}
+	for _, media := range supported {
+		found := rPWGMediaName.FindStringSubmatch(media)
+		if found == nil || found[1] == "custom" {
+			// Custom size ranges aren't sizes.
+			continue
+		}
+		width, err := strconv.ParseFloat(found[3], 32)
+		if err != nil {
+			continue
+		}
+		height, err := strconv.ParseFloat(found[4], 32)
+		if err != nil {
+			continue
+		}
+
+		o := cdd.MediaSizeOption{
+			Name:                       cdd.MediaSizeCustom,
+			VendorID:                   media,
+			CustomDisplayNameLocalized: cdd.NewLocalizedString(found[2]),
+		}
+		if name := cdd.MediaSizeName(strings.ToUpper(found[1] + "_" + found[2])); knownMediaSizeNames[name] {
+			o.Name = name
+		}
+		if found[5] == "mm" {
+			o.WidthMicrons, o.HeightMicrons = mmToMicrons(float32(width)), mmToMicrons(float32(height))
+		} else {
+			o.WidthMicrons, o.HeightMicrons = inchesToMicrons(float32(width)), inchesToMicrons(float32(height))
+		}
+		if media == def {
+			o.IsDefault = true
+		}
+		ms.Option = append(ms.Option, o)
+	}
+
+	if len(ms.Option) == 0 {
+		return nil
+	}
+	// Without media-default, CUPS.setDefaultMediaSize picks one.
+	return &ms
+}
+
+// knownMediaSizeNames are the standard CDD media size names, which are
+// uppercase PWG media names without dimensions.
+var knownMediaSizeNames = func() map[cdd.MediaSizeName]bool {
+	names := make(map[cdd.MediaSizeName]bool, len(ppdMediaSizes))
+	for _, o := range ppdMediaSizes {
+		if o.Name != cdd.MediaSizeCustom {
+			names[o.Name] = true
+		}
+	}
+	return names
+}()
+
+func convertIPPSides(supported []string, def string) *cdd.Duplex {
+	d := cdd.Duplex{}
+	for _, t := range []cdd.DuplexType{cdd.DuplexNoDuplex, cdd.DuplexLongEdge, cdd.DuplexShortEdge} {
+		if contains(supported, sidesByCDD[t]) {
+			d.Option = append(d.Option, cdd.DuplexOption{Type: t, IsDefault: sidesByCDD[t] == def})
+		}
+	}
+	if len(d.Option) < 2 {
+		// One-sided only is the same as no duplex capability.
+		return nil
+	}
+	for i := range d.Option {
+		if d.Option[i].IsDefault {
+			return &d
+		}
+	}
+	d.Option[0].IsDefault = true
+	return &d
+}
+
+func convertIPPResolution(supported []string, def string) *cdd.DPI {
+	d := cdd.DPI{}
+	for _, resolution := range supported {
+		found := rIPPResolution.FindStringSubmatch(resolution)
+		if found == nil {
+			continue
+		}
+		h, err := strconv.ParseInt(found[1], 10, 32)
+		if err != nil {
+			continue
+		}
+		v, err := strconv.ParseInt(found[2], 10, 32)
+		if err != nil {
+			continue
+		}
+		vendorID := found[1] + "x" + found[2] + "dpi"
+		d.Option = append(d.Option, cdd.DPIOption{
+			HorizontalDPI:              int32(h),
+			VerticalDPI:                int32(v),
+			IsDefault:                  resolution == def,
+			VendorID:                   vendorID,
+			CustomDisplayNameLocalized: cdd.NewLocalizedString(vendorID),
+		})
+	}
+
+	if len(d.Option) == 0 {
+		return nil
+	}
+	for i := range d.Option {
+		if d.Option[i].IsDefault {
+			return &d
+		}
+	}
+	d.Option[0].IsDefault = true
+	return &d
+}
+
+// translateOptionsToIPP replaces the PPD options of a translated ticket with
+// the IPP attributes that driverless printers understand instead.
+func translateOptionsToIPP(options map[string]string) map[string]string {
+	if value, exists := options[ppdDuplex]; exists {
+		delete(options, ppdDuplex)
+		for t, ppdValue := range duplexPPDByCDD {
+			if ppdValue == value {
+				options[attrSides] = sidesByCDD[t]
+			}
+		}
+	}
+	if value, exists := options[ppdPageSize]; exists {
+		delete(options, ppdPageSize)
+		// Driverless printers take the PWG names of their supported sizes,
+		// which are the vendor IDs of their media sizes, but not PPD custom sizes.
+		if !strings.HasPrefix(value, "Custom.") {
+			options[attrMedia] = value
+		}
+	}
+	if value, exists := options[ppdResolution]; exists {
+		delete(options, ppdResolution)
+		options[attrPrinterResolution] = value
+	}
+	return options
+}
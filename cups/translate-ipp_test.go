@@ -0,0 +1,111 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package cups
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/cups-connector/cdd"
+	"github.com/google/cups-connector/lib"
+)
+
+func TestPrinterIsDriverless(t *testing.T) {
+	for tags, expected := range map[[2]string]bool{
+		{"HP LaserJet M404 - IPP Everywhere", "dnssd://HP%20LaserJet._ipp._tcp.local/"}: true,
+		{"Brother HL-L2350DW series, driverless, cups-filters 1.28", "usb://Brother"}:   true,
+		{"Canon iR-ADV C5535", "ipps://canon.example.com/ipp/print"}:                    true,
+		{"Local Raw Printer", "socket://lobby.example.com"}:                             false,
+		{"", ""}: false,
+	} {
+		p := lib.Printer{Tags: map[string]string{attrPrinterMakeAndModel: tags[0], attrDeviceURI: tags[1]}}
+		if printerIsDriverless(&p) != expected {
+			t.Logf("expected %t for %q", expected, tags)
+			t.Fail()
+		}
+	}
+}
+
+func TestTranslateIPPAttrs(t *testing.T) {
+	tags := map[string]string{
+		attrPrinterMakeAndModel:        "HP LaserJet M404 - IPP Everywhere",
+		attrMediaSupported:             "na_letter_8.5x11in,iso_a4_210x297mm,custom_min_3x5in,oe_photo_4x6in",
+		attrMediaDefault:               "iso_a4_210x297mm",
+		attrSidesSupported:             "one-sided,two-sided-long-edge,two-sided-short-edge",
+		attrSidesDefault:               "one-sided",
+		attrPrinterResolutionSupported: "300x300ppi,600x600ppi",
+		attrPrinterResolutionDefault:   "600x600ppi",
+	}
+	pds, manufacturer, model := translateIPPAttrs(tags)
+
+	if manufacturer != "HP" || model != "LaserJet M404" {
+		t.Logf("expected HP and LaserJet M404, got %q and %q", manufacturer, model)
+		t.Fail()
+	}
+
+	expectedMediaSize := &cdd.MediaSize{Option: []cdd.MediaSizeOption{
+		{Name: cdd.MediaSizeNALetter, WidthMicrons: 215900, HeightMicrons: 279400, VendorID: "na_letter_8.5x11in", CustomDisplayNameLocalized: cdd.NewLocalizedString("letter")},
+		{Name: cdd.MediaSizeISOA4, WidthMicrons: 210000, HeightMicrons: 297000, IsDefault: true, VendorID: "iso_a4_210x297mm", CustomDisplayNameLocalized: cdd.NewLocalizedString("a4")},
+		{Name: cdd.MediaSizeCustom, WidthMicrons: 101600, HeightMicrons: 152400, VendorID: "oe_photo_4x6in", CustomDisplayNameLocalized: cdd.NewLocalizedString("photo")},
+	}}
+	if !reflect.DeepEqual(pds.MediaSize, expectedMediaSize) {
+		t.Logf("expected %+v, got %+v", expectedMediaSize, pds.MediaSize)
+		t.Fail()
+	}
+
+	expectedDuplex := &cdd.Duplex{Option: []cdd.DuplexOption{
+		{Type: cdd.DuplexNoDuplex, IsDefault: true},
+		{Type: cdd.DuplexLongEdge},
+		{Type: cdd.DuplexShortEdge},
+	}}
+	if !reflect.DeepEqual(pds.Duplex, expectedDuplex) {
+		t.Logf("expected %+v, got %+v", expectedDuplex, pds.Duplex)
+		t.Fail()
+	}
+
+	expectedDPI := &cdd.DPI{Option: []cdd.DPIOption{
+		{HorizontalDPI: 300, VerticalDPI: 300, VendorID: "300x300dpi", CustomDisplayNameLocalized: cdd.NewLocalizedString("300x300dpi")},
+		{HorizontalDPI: 600, VerticalDPI: 600, IsDefault: true, VendorID: "600x600dpi", CustomDisplayNameLocalized: cdd.NewLocalizedString("600x600dpi")},
+	}}
+	if !reflect.DeepEqual(pds.DPI, expectedDPI) {
+		t.Logf("expected %+v, got %+v", expectedDPI, pds.DPI)
+		t.Fail()
+	}
+
+	pds, _, _ = translateIPPAttrs(map[string]string{attrSidesSupported: "one-sided"})
+	if pds.MediaSize != nil || pds.Duplex != nil || pds.DPI != nil {
+		t.Logf("expected no capabilities, got %+v", pds)
+		t.Fail()
+	}
+}
+
+func TestTranslateOptionsToIPP(t *testing.T) {
+	options := map[string]string{
+		ppdDuplex:     ppdDuplexTumble,
+		ppdPageSize:   "iso_a4_210x297mm",
+		ppdResolution: "600x600dpi",
+		attrCopies:    "2",
+	}
+	expected := map[string]string{
+		attrSides:             "two-sided-short-edge",
+		attrMedia:             "iso_a4_210x297mm",
+		attrPrinterResolution: "600x600dpi",
+		attrCopies:            "2",
+	}
+	if o := translateOptionsToIPP(options); !reflect.DeepEqual(o, expected) {
+		t.Logf("expected %+v, got %+v", expected, o)
+		t.Fail()
+	}
+
+	options = map[string]string{ppdPageSize: "Custom.612x792"}
+	if o := translateOptionsToIPP(options); len(o) != 0 {
+		t.Logf("expected custom PPD sizes to be left out, got %+v", o)
+		t.Fail()
+	}
+}
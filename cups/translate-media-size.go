@@ -0,0 +1,59 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package cups
+
+import (
+	"strings"
+
+	"github.com/google/cups-connector/cdd"
+)
+
+// findMediaSizeName finds the CDD media size named by s, which is either a
+// PPD PageSize keyword, like A4 or Letter, or a CDD media size name, like
+// ISO_A4 or NA_LETTER. Case doesn't matter.
+func findMediaSizeName(s string) (cdd.MediaSizeName, bool) {
+	for keyword, option := range ppdMediaSizes {
+		if strings.EqualFold(keyword, s) || strings.EqualFold(string(option.Name), s) {
+			return option.Name, true
+		}
+	}
+	return "", false
+}
+
+// withDefaultMediaSize returns ms with a default, when ms has none. The
+// default is the size named name, if ms has it, else the first size. ms is
+// copied before it is changed, since translations are cached and shared.
+//
+// Returns the index of the default option that was set, or -1 when ms was
+// left as is, and whether that option is the size named name.
+func withDefaultMediaSize(ms *cdd.MediaSize, name cdd.MediaSizeName) (*cdd.MediaSize, int, bool) {
+	if ms == nil || len(ms.Option) == 0 {
+		return ms, -1, false
+	}
+	for _, option := range ms.Option {
+		if option.IsDefault {
+			return ms, -1, false
+		}
+	}
+
+	i, found := 0, false
+	if name != "" {
+		for j, option := range ms.Option {
+			if option.Name == name {
+				i, found = j, true
+				break
+			}
+		}
+	}
+
+	m := *ms
+	m.Option = append([]cdd.MediaSizeOption(nil), ms.Option...)
+	m.Option[i].IsDefault = true
+	return &m, i, found
+}
@@ -0,0 +1,67 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package cups
+
+import (
+	"testing"
+
+	"github.com/google/cups-connector/cdd"
+)
+
+func TestFindMediaSizeName(t *testing.T) {
+	for s, expected := range map[string]cdd.MediaSizeName{
+		"A4":        cdd.MediaSizeISOA4,
+		"letter":    cdd.MediaSizeNALetter,
+		"ISO_A4":    cdd.MediaSizeISOA4,
+		"na_letter": cdd.MediaSizeNALetter,
+	} {
+		if name, ok := findMediaSizeName(s); !ok || name != expected {
+			t.Logf("expected %s for %s, got %s", expected, s, name)
+			t.Fail()
+		}
+	}
+	if _, ok := findMediaSizeName("Napkin"); ok {
+		t.Log("expected no media size for Napkin")
+		t.Fail()
+	}
+}
+
+func TestWithDefaultMediaSize(t *testing.T) {
+	ms := &cdd.MediaSize{Option: []cdd.MediaSizeOption{
+		ppdMediaSizes["Letter"],
+		ppdMediaSizes["A4"],
+	}}
+
+	m, i, found := withDefaultMediaSize(ms, cdd.MediaSizeISOA4)
+	if i != 1 || !found || !m.Option[1].IsDefault || m.Option[0].IsDefault {
+		t.Logf("expected A4 to be the default, got %d %t %+v", i, found, m.Option)
+		t.Fail()
+	}
+	if ms.Option[1].IsDefault {
+		t.Log("expected the original media sizes to be left as they were")
+		t.Fail()
+	}
+
+	m, i, found = withDefaultMediaSize(ms, cdd.MediaSizeISOA3)
+	if i != 0 || found || !m.Option[0].IsDefault {
+		t.Logf("expected the first size to be the default, got %d %t %+v", i, found, m.Option)
+		t.Fail()
+	}
+
+	ms.Option[0].IsDefault = true
+	if m, i, _ = withDefaultMediaSize(ms, cdd.MediaSizeISOA4); i != -1 || m != ms {
+		t.Logf("expected the PPD default to be kept, got %d", i)
+		t.Fail()
+	}
+
+	if m, i, _ = withDefaultMediaSize(nil, cdd.MediaSizeISOA4); i != -1 || m != nil {
+		t.Log("expected nil media sizes to be left as they were")
+		t.Fail()
+	}
+}
@@ -15,7 +15,6 @@ import (
 	"strings"
 
 	"github.com/google/cups-connector/cdd"
-	"github.com/google/cups-connector/log"
 )
 
 const (
@@ -120,31 +119,52 @@ type entry struct {
 }
 
 // translatePPD extracts a PrinterDescriptionSection, manufacturer string, and model string
-// from a PPD string.
-func translatePPD(ppd string) (*cdd.PrinterDescriptionSection, string, string) {
+// from a PPD string. Parts of the PPD that can't be translated are left out of
+// the description, and described by the returned warnings. The description is
+// nil only when the PPD has no statements at all.
+func translatePPD(ppd string) (*cdd.PrinterDescriptionSection, string, string, []string) {
 	statements := ppdToStatements(ppd)
+	if len(statements) == 0 {
+		return nil, "", "", nil
+	}
 	openUIStatements, installables, uiConstraints, standAlones := groupStatements(statements)
 	openUIStatements = filterConstraints(openUIStatements, installables, uiConstraints)
-	entriesByMainKeyword, entriesByTranslation := openUIStatementsToEntries(openUIStatements)
+	entriesByMainKeyword, entriesByTranslation, warnings := openUIStatementsToEntries(openUIStatements)
+
+	failed := func(keyword string) {
+		warnings = append(warnings, fmt.Sprintf("Failed to translate PPD %s", keyword))
+	}
 
 	pds := cdd.PrinterDescriptionSection{
 		VendorCapability: &[]cdd.VendorCapability{},
 	}
 	if e, exists := entriesByMainKeyword[ppdPageSize]; exists {
-		pds.MediaSize = convertMediaSize(e)
+		if pds.MediaSize = convertMediaSize(e); pds.MediaSize == nil {
+			failed(ppdPageSize)
+		}
 	}
 	if e, exists := entriesByMainKeyword[ppdColorModel]; exists {
-		pds.Color = convertColorPPD(e)
+		if pds.Color = convertColorPPD(e); pds.Color == nil {
+			failed(ppdColorModel)
+		}
 	} else if e, exists := entriesByMainKeyword[ppdCMAndResolution]; exists {
-		pds.Color = convertColorPPD(e)
+		if pds.Color = convertColorPPD(e); pds.Color == nil {
+			failed(ppdCMAndResolution)
+		}
 	}
 	if e, exists := entriesByMainKeyword[ppdDuplex]; exists {
-		pds.Duplex = convertDuplex(e)
+		if pds.Duplex = convertDuplex(e); pds.Duplex == nil {
+			failed(ppdDuplex)
+		}
 	} else if e, exists := entriesByMainKeyword[ppdKMDuplex]; exists {
-		pds.Duplex = convertKMDuplex(e)
+		if pds.Duplex = convertKMDuplex(e); pds.Duplex == nil {
+			failed(ppdKMDuplex)
+		}
 	}
 	if e, exists := entriesByMainKeyword[ppdResolution]; exists {
-		pds.DPI = convertDPI(e)
+		if pds.DPI = convertDPI(e); pds.DPI == nil {
+			failed(ppdResolution)
+		}
 	}
 	if e, exists := entriesByMainKeyword[ppdOutputBin]; exists {
 		*pds.VendorCapability = append(*pds.VendorCapability, *convertVendorCapability(e))
@@ -173,14 +193,24 @@ func translatePPD(ppd string) (*cdd.PrinterDescriptionSection, string, string) {
 		case ppdNickName:
 			model = cleanupModel(s.value)
 		case ppdHWMargins:
-			pds.Margins = convertMargins(s.value)
+			if pds.Margins = convertMargins(s.value); pds.Margins == nil {
+				failed(ppdHWMargins)
+			}
 		case ppdThroughput:
-			pds.PrintingSpeed = convertPrintingSpeed(s.value, pds.Color)
+			if pds.PrintingSpeed = convertPrintingSpeed(s.value, pds.Color); pds.PrintingSpeed == nil {
+				failed(ppdThroughput)
+			}
 		}
 	}
 	model = strings.TrimLeft(strings.TrimPrefix(model, manufacturer), " ")
+	if manufacturer == "" {
+		warnings = append(warnings, "PPD has no manufacturer; set one in make_model_overrides")
+	}
+	if model == "" {
+		warnings = append(warnings, "PPD has no model; set one in make_model_overrides")
+	}
 
-	return &pds, manufacturer, model
+	return &pds, manufacturer, model, warnings
 }
 
 // ppdToStatements converts a PPD file to a slice of statements.
@@ -309,8 +339,9 @@ func filterConstraints(openUIs, installables [][]statement, uiConstraints []stat
 	return newOpenUIs
 }
 
-func openUIStatementsToEntries(statements [][]statement) (map[string]entry, map[string]entry) {
+func openUIStatementsToEntries(statements [][]statement) (map[string]entry, map[string]entry, []string) {
 	byMainKeyword, byTranslation := make(map[string]entry), make(map[string]entry)
+	var warnings []string
 
 	for _, openUI := range statements {
 		var e entry
@@ -325,7 +356,7 @@ func openUIStatementsToEntries(statements [][]statement) (map[string]entry, map[
 		case ppdBoolean:
 			e.entryType = entryTypeBoolean
 		case ppdPickMany:
-			log.Warning("This PPD file contains a PickMany OpenUI entry, which is not supported")
+			warnings = append(warnings, fmt.Sprintf("PPD PickMany entry %s is not supported", e.mainKeyword))
 			continue
 		default:
 			continue
@@ -351,7 +382,7 @@ func openUIStatementsToEntries(statements [][]statement) (map[string]entry, map[
 		byTranslation[e.translation] = e
 	}
 
-	return byMainKeyword, byTranslation
+	return byMainKeyword, byTranslation, warnings
 }
 
 func cleanupModel(model string) string {
@@ -724,7 +755,6 @@ func convertVendorCapability(e entry) *cdd.VendorCapability {
 }
 
 func convertMediaSize(e entry) *cdd.MediaSize {
-	foundDefault := false
 	ms := cdd.MediaSize{}
 	for _, option := range e.options {
 		if strings.HasSuffix(option.optionKeyword, ".FullBleed") {
@@ -744,7 +774,6 @@ func convertMediaSize(e entry) *cdd.MediaSize {
 
 		if e.defaultValue == option.optionKeyword {
 			o.IsDefault = true
-			foundDefault = true
 		}
 		ms.Option = append(ms.Option, o)
 	}
@@ -753,9 +782,7 @@ func convertMediaSize(e entry) *cdd.MediaSize {
 		return nil
 	}
 
-	if !foundDefault {
-		ms.Option[0].IsDefault = true
-	}
+	// Without a default in the PPD, CUPS.setDefaultMediaSize picks one.
 	return &ms
 }
 
@@ -17,7 +17,7 @@ import (
 )
 
 func translationTest(t *testing.T, ppd string, expected *cdd.PrinterDescriptionSection) {
-	description, _, _ := translatePPD(ppd)
+	description, _, _, _ := translatePPD(ppd)
 	if !reflect.DeepEqual(expected, description) {
 		e, _ := json.Marshal(expected)
 		d, _ := json.Marshal(description)
@@ -339,3 +339,32 @@ func TestCleanupModel(t *testing.T) {
 	easyModelTest(t, "LaserJet 4250 pcl3, hpcups 3.13.9", "LaserJet 4250")
 	easyModelTest(t, "DesignJet T790 pcl, 1.0", "DesignJet T790")
 }
+
+func TestTrPartialFailure(t *testing.T) {
+	ppd := `*PPD-Adobe: "4.3"
+*Manufacturer: "Acme"
+*NickName: "Acme Laser"
+*HWMargins: "eighteen"
+*Throughput: "30"`
+	description, manufacturer, model, warnings := translatePPD(ppd)
+	if description == nil || description.PrintingSpeed == nil {
+		t.Logf("expected the printing speed to be translated despite the bad margins, got %+v", description)
+		t.Fail()
+	} else if description.Margins != nil {
+		t.Logf("expected no margins, got %+v", description.Margins)
+		t.Fail()
+	}
+	if manufacturer != "Acme" || model != "Laser" {
+		t.Logf("expected Acme and Laser, got %q and %q", manufacturer, model)
+		t.Fail()
+	}
+	if !reflect.DeepEqual(warnings, []string{"Failed to translate PPD HWMargins"}) {
+		t.Logf("expected one warning about HWMargins, got %q", warnings)
+		t.Fail()
+	}
+
+	if description, _, _, _ = translatePPD(""); description != nil {
+		t.Logf("expected no description for a PPD without statements, got %+v", description)
+		t.Fail()
+	}
+}
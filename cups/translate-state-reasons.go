@@ -0,0 +1,79 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package cups
+
+import "strings"
+
+// Suffixes of printer-state-reasons keywords, which give their severity.
+var stateReasonSuffixes = []string{"-error", "-warning", "-report"}
+
+// Messages for common printer-state-reasons keywords, without their severity
+// suffixes. Keywords that aren't here are shown as they are.
+var defaultStateReasonMessages = map[string]string{
+	"connecting-to-device":             "Connecting to the printer",
+	"cover-open":                       "A cover is open",
+	"cups-insecure-filter":             "A print filter is insecure",
+	"cups-missing-filter":              "A print filter is missing",
+	"developer-empty":                  "Out of developer",
+	"developer-low":                    "Developer is low",
+	"door-open":                        "A door is open",
+	"fuser-over-temp":                  "The fuser is too hot",
+	"fuser-under-temp":                 "The fuser is warming up",
+	"input-tray-missing":               "A paper tray is missing",
+	"interlock-open":                   "An interlock is open",
+	"interpreter-resource-unavailable": "The printer is out of memory or fonts",
+	"marker-supply-empty":              "Out of ink or toner",
+	"marker-supply-low":                "Ink or toner is low",
+	"marker-waste-almost-full":         "The waste container is almost full",
+	"marker-waste-full":                "The waste container is full",
+	"media-empty":                      "Out of paper",
+	"media-jam":                        "Paper jam",
+	"media-low":                        "Paper is low",
+	"media-needed":                     "Load paper",
+	"moving-to-paused":                 "The printer is pausing",
+	"offline":                          "The printer is offline",
+	"opc-life-over":                    "The drum needs replacing",
+	"opc-near-eol":                     "The drum is nearly worn out",
+	"other":                            "The printer needs attention",
+	"output-area-almost-full":          "The output tray is almost full",
+	"output-area-full":                 "The output tray is full",
+	"output-tray-missing":              "The output tray is missing",
+	"paused":                           "The printer is paused",
+	"shutdown":                         "The printer is shut down",
+	"spool-area-full":                  "The print queue is full",
+	"stopped-partly":                   "The printer is partly stopped",
+	"stopping":                         "The printer is stopping",
+	"timed-out":                        "The printer isn't responding",
+	"toner-empty":                      "Out of toner",
+	"toner-low":                        "Toner is low",
+}
+
+// stateReasonMessage returns a message for a printer-state-reasons keyword,
+// like "Toner is low" for toner-low-warning. messages, which may be nil, win
+// over the built-in messages; they are looked up by the whole keyword, then by
+// the keyword without its severity suffix.
+func stateReasonMessage(reason string, messages map[string]string) string {
+	if message, exists := messages[reason]; exists {
+		return message
+	}
+	keyword := reason
+	for _, suffix := range stateReasonSuffixes {
+		if strings.HasSuffix(keyword, suffix) {
+			keyword = strings.TrimSuffix(keyword, suffix)
+			break
+		}
+	}
+	if message, exists := messages[keyword]; exists {
+		return message
+	}
+	if message, exists := defaultStateReasonMessages[keyword]; exists {
+		return message
+	}
+	return reason
+}
@@ -116,6 +116,54 @@ func translateTicket(ticket *cdd.CloudJobTicket) (map[string]string, error) {
 	return m, nil
 }
 
+// UntranslatableTicketOptions returns the names of the ticket items that
+// translateTicket can't convert to CUPS options, and so leaves out.
+func UntranslatableTicketOptions(ticket *cdd.CloudJobTicket) []string {
+	if ticket == nil {
+		return nil
+	}
+
+	var dropped []string
+	if ticket.Print.Color != nil {
+		parts := rVendorIDKeyValue.FindStringSubmatch(ticket.Print.Color.VendorID)
+		if parts == nil || parts[2] == "" {
+			dropped = append(dropped, "color")
+		}
+	}
+	if ticket.Print.Duplex != nil {
+		if _, exists := duplexPPDByCDD[ticket.Print.Duplex.Type]; !exists {
+			dropped = append(dropped, "duplex")
+		}
+	}
+	if ticket.Print.PageOrientation != nil {
+		if _, exists := orientationValueByType[ticket.Print.PageOrientation.Type]; !exists {
+			dropped = append(dropped, "page_orientation")
+		}
+	}
+	if ticket.Print.FitToPage != nil {
+		if t := ticket.Print.FitToPage.Type; t != cdd.FitToPageFitToPage && t != cdd.FitToPageNoFitting {
+			dropped = append(dropped, "fit_to_page")
+		}
+	}
+	return dropped
+}
+
+// A printer default option named with this prefix is forced.
+const forcedOptionPrefix = "!"
+
+// applyDefaultOptions adds a printer's default options to the options of a
+// job. A default option only fills in an option that the job didn't set; a
+// forced one, named with forcedOptionPrefix, replaces it.
+func applyDefaultOptions(options, defaults map[string]string) {
+	for name, value := range defaults {
+		if strings.HasPrefix(name, forcedOptionPrefix) {
+			options[strings.TrimPrefix(name, forcedOptionPrefix)] = value
+		} else if _, exists := options[name]; !exists {
+			options[name] = value
+		}
+	}
+}
+
 func micronsToPoints(microns int32) string {
 	return strconv.Itoa(int(float32(microns)*72/25400 + 0.5))
 }
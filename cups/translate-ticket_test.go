@@ -216,3 +216,54 @@ func TestTranslateTicket_RicohLockedPrint(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestUntranslatableTicketOptions(t *testing.T) {
+	if dropped := UntranslatableTicketOptions(nil); len(dropped) != 0 {
+		t.Logf("expected nothing dropped from nil ticket, got %v", dropped)
+		t.Fail()
+	}
+
+	ticket := cdd.CloudJobTicket{}
+	ticket.Print = cdd.PrintTicketSection{
+		Color:           &cdd.ColorTicketItem{VendorID: "ColorModel:Gray", Type: cdd.ColorTypeStandardMonochrome},
+		Duplex:          &cdd.DuplexTicketItem{Type: cdd.DuplexLongEdge},
+		PageOrientation: &cdd.PageOrientationTicketItem{Type: cdd.PageOrientationPortrait},
+	}
+	if dropped := UntranslatableTicketOptions(&ticket); len(dropped) != 0 {
+		t.Logf("expected nothing dropped, got %v", dropped)
+		t.Fail()
+	}
+
+	ticket.Print = cdd.PrintTicketSection{
+		Color:  &cdd.ColorTicketItem{VendorID: "Gray", Type: cdd.ColorTypeStandardMonochrome},
+		Duplex: &cdd.DuplexTicketItem{Type: "SIDEWAYS"},
+	}
+	expected := []string{"color", "duplex"}
+	if dropped := UntranslatableTicketOptions(&ticket); !reflect.DeepEqual(dropped, expected) {
+		t.Logf("expected %v, got %v", expected, dropped)
+		t.Fail()
+	}
+}
+
+func TestApplyDefaultOptions(t *testing.T) {
+	options := map[string]string{"Duplex": "None", "PageSize": "A4"}
+	applyDefaultOptions(options, map[string]string{
+		"Duplex":      "DuplexNoTumble",
+		"InputSlot":   "Tray2",
+		"!PageSize":   "Letter",
+		"ColorModel":  "Gray",
+		"!ColorModel": "RGB",
+	})
+	expected := map[string]string{"Duplex": "None", "PageSize": "Letter", "InputSlot": "Tray2", "ColorModel": "RGB"}
+	if !reflect.DeepEqual(options, expected) {
+		t.Logf("expected %+v, got %+v", expected, options)
+		t.Fail()
+	}
+
+	options = map[string]string{}
+	applyDefaultOptions(options, nil)
+	if len(options) != 0 {
+		t.Logf("expected no options, got %+v", options)
+		t.Fail()
+	}
+}
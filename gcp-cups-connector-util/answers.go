@@ -0,0 +1,99 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/codegangsta/cli"
+)
+
+// initAnswers holds the answers to every question that init would otherwise
+// ask, read from the --answers-file JSON document, like
+//
+//	{
+//	  "local_printing_enable": true,
+//	  "cloud_printing_enable": true,
+//	  "share_scope": "admins@example.com",
+//	  "proxy_name": "lobby"
+//	}
+//
+// An empty share_scope means that printers are not shared automatically.
+type initAnswers struct {
+	LocalPrintingEnable *bool   `json:"local_printing_enable"`
+	CloudPrintingEnable *bool   `json:"cloud_printing_enable"`
+	ShareScope          *string `json:"share_scope"`
+	ProxyName           string  `json:"proxy_name"`
+	GCPUserRefreshToken string  `json:"gcp_user_refresh_token"`
+}
+
+// Flags that answer the same questions as the answers file; setting both is
+// refused, so that it's clear which answer is used.
+var initAnswersFlags = []string{
+	"local-printing-enable", "cloud-printing-enable", "share-scope", "proxy-name",
+	"gcp-user-refresh-token", "prompt-gcp-user-refresh-token",
+}
+
+// loadInitAnswers reads the --answers-file flag's file. Returns nil when the
+// flag is not set.
+func loadInitAnswers(context *cli.Context) (*initAnswers, error) {
+	filename := context.String("answers-file")
+	if filename == "" {
+		return nil, nil
+	}
+	for _, flag := range initAnswersFlags {
+		if context.IsSet(flag) {
+			return nil, fmt.Errorf("--%s cannot be used with --answers-file", flag)
+		}
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open answers file: %s", err)
+	}
+	defer f.Close()
+
+	answers, err := parseInitAnswers(f)
+	if err != nil {
+		return nil, fmt.Errorf("Answers file %s: %s", filename, err)
+	}
+	return answers, nil
+}
+
+// parseInitAnswers decodes and validates an answers file, so that a missing
+// answer is found before any interactive step, like the OAuth flow.
+func parseInitAnswers(r io.Reader) (*initAnswers, error) {
+	var answers initAnswers
+	d := json.NewDecoder(r)
+	d.DisallowUnknownFields()
+	if err := d.Decode(&answers); err != nil {
+		return nil, fmt.Errorf("Failed to parse: %s", err)
+	}
+
+	if answers.LocalPrintingEnable == nil {
+		return nil, errors.New("local_printing_enable is required")
+	}
+	if answers.CloudPrintingEnable == nil {
+		return nil, errors.New("cloud_printing_enable is required")
+	}
+	if !*answers.CloudPrintingEnable {
+		return &answers, nil
+	}
+	if answers.ShareScope == nil {
+		return nil, errors.New("share_scope is required when cloud printing is enabled; use \"\" to not share")
+	}
+	if answers.ProxyName == "" {
+		return nil, errors.New("proxy_name is required when cloud printing is enabled")
+	}
+	return &answers, nil
+}
@@ -0,0 +1,49 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseInitAnswers(t *testing.T) {
+	answers, err := parseInitAnswers(strings.NewReader(`{
+		"local_printing_enable": false,
+		"cloud_printing_enable": true,
+		"share_scope": "",
+		"proxy_name": "lobby"
+	}`))
+	if err != nil {
+		t.Fatalf("expected valid answers, got %s", err)
+	}
+	if *answers.LocalPrintingEnable || !*answers.CloudPrintingEnable || *answers.ShareScope != "" || answers.ProxyName != "lobby" {
+		t.Logf("unexpected answers %+v", answers)
+		t.Fail()
+	}
+
+	if _, err = parseInitAnswers(strings.NewReader(`{"local_printing_enable": true, "cloud_printing_enable": false}`)); err != nil {
+		t.Logf("expected local-only answers without cloud answers to be valid, got %s", err)
+		t.Fail()
+	}
+
+	for _, invalid := range []string{
+		`{"cloud_printing_enable": false}`,
+		`{"local_printing_enable": true}`,
+		`{"local_printing_enable": true, "cloud_printing_enable": true, "proxy_name": "lobby"}`,
+		`{"local_printing_enable": true, "cloud_printing_enable": true, "share_scope": ""}`,
+		`{"local_printing_enable": true, "cloud_printing_enable": false, "proxy_nmae": "lobby"}`,
+		`local_printing_enable: true`,
+	} {
+		if _, err = parseInitAnswers(strings.NewReader(invalid)); err == nil {
+			t.Logf("expected error for %s", invalid)
+			t.Fail()
+		}
+	}
+}
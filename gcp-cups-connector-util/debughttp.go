@@ -0,0 +1,122 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/codegangsta/cli"
+	"github.com/google/cups-connector/gcp"
+	"github.com/google/cups-connector/lib"
+)
+
+// Query parameters whose values are never logged.
+var debugHTTPSecretParams = []string{
+	"access_token", "client_secret", "code", "refresh_token",
+}
+
+var debugHTTPFlag = cli.BoolFlag{
+	Name:  "debug-http",
+	Usage: "Log every HTTP request made, with secrets redacted, for troubleshooting",
+}
+
+// debugTransport is an http.RoundTripper that logs the method, URL, status
+// and duration of each request.
+type debugTransport struct {
+	base http.RoundTripper
+}
+
+func (t *debugTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	response, err := t.base.RoundTrip(req)
+	elapsed := time.Since(start)
+
+	u := redactURL(req.URL)
+	if err != nil {
+		log.Printf("HTTP %s %s failed after %s: %s", req.Method, u, elapsed, err)
+	} else {
+		log.Printf("HTTP %s %s: %s in %s", req.Method, u, response.Status, elapsed)
+	}
+	return response, err
+}
+
+// redactURL returns u as a string, without the values of secret query
+// parameters.
+func redactURL(u *url.URL) string {
+	redacted := *u
+	q := redacted.Query()
+	for _, p := range debugHTTPSecretParams {
+		if _, exists := q[p]; exists {
+			q.Set(p, "REDACTED")
+		}
+	}
+	redacted.RawQuery = q.Encode()
+	return redacted.String()
+}
+
+// trustCACertFile adds the CA certificates in filename, if any, to those
+// trusted by the OAuth and GCP clients. It must be called before
+// setUserAgent and setupDebugHTTP.
+func trustCACertFile(filename string) {
+	if filename == "" {
+		return
+	}
+	if err := lib.TrustCACertFile(filename); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// setMinTLSVersion refuses servers that can't speak at least TLS version,
+// like the connector. It must be called before setUserAgent and
+// setupDebugHTTP.
+func setMinTLSVersion(version string) {
+	v, err := lib.ParseTLSVersion(version)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if err = lib.SetMinTLSVersion(v); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// setUserAgent sends the User-Agent of config with every request made through
+// http.DefaultTransport.
+func setUserAgent(config *lib.Config) {
+	lib.SetUserAgent(config.HTTPUserAgent())
+}
+
+// setupDebugHTTP logs all requests made through http.DefaultTransport, which
+// includes the OAuth clients, when the debug-http flag is set.
+func setupDebugHTTP(context *cli.Context) {
+	if context.Bool("debug-http") {
+		http.DefaultTransport = &debugTransport{http.DefaultTransport}
+	}
+}
+
+// newGCPHTTPClient creates a client with OAuth credentials, which waits no
+// more than the gcp-api-timeout flag for each response, token refreshes
+// included, like the connector's GCP client.
+func newGCPHTTPClient(context *cli.Context, config *lib.Config, refreshToken string, scopes ...string) *http.Client {
+	oauthConfig := gcp.NewOAuthConfig(config.GCPOAuthClientID, config.GCPOAuthClientSecret,
+		config.GCPOAuthAuthURL, config.GCPOAuthTokenURL, scopes...)
+	return gcp.NewOAuthClient(oauthConfig, refreshToken, context.Duration("gcp-api-timeout"))
+}
+
+// gcpAPITimeout returns the GCP API timeout of config, or the default when
+// config predates it.
+func gcpAPITimeout(config *lib.Config) time.Duration {
+	timeout, err := time.ParseDuration(config.GCPAPITimeout)
+	if err != nil {
+		timeout, _ = time.ParseDuration(lib.DefaultConfig.GCPAPITimeout)
+	}
+	return timeout
+}
@@ -9,10 +9,12 @@ https://developers.google.com/open-source/licenses/bsd
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"math/rand"
 	"os"
 	"sync"
 	"time"
@@ -26,6 +28,7 @@ import (
 func main() {
 	// Suppress date/time prefix.
 	log.SetFlags(0)
+	rand.Seed(time.Now().UnixNano())
 
 	app := cli.NewApp()
 	app.Name = "gcp-cups-connector-util"
@@ -41,11 +44,50 @@ func main() {
 			Action:    initConfigFile,
 			Flags:     initFlags,
 		},
+		cli.Command{
+			Name:   "reauth",
+			Usage:  "Replace the OAuth credentials in an existing config file",
+			Action: reauthConfigFile,
+			Flags:  reauthFlags,
+		},
 		cli.Command{
 			Name:      "monitor",
 			ShortName: "m",
 			Usage:     "Read stats from a running connector",
 			Action:    monitorConnector,
+			Flags: []cli.Flag{
+				cli.DurationFlag{
+					Name:  "monitor-timeout",
+					Usage: "wait for a monitor response no more than this long",
+					Value: 10 * time.Second,
+				},
+				cli.DurationFlag{
+					Name:  "max-sync-age",
+					Usage: "warn if the last printer sync is older than this (0 to disable)",
+					Value: 15 * time.Minute,
+				},
+				cli.BoolFlag{
+					Name:  "json",
+					Usage: "print the stats and supply levels as JSON",
+				},
+			},
+		},
+		cli.Command{
+			Name:   "inventory",
+			Usage:  "Print the printers known to a running connector, as JSON",
+			Action: inventoryConnector,
+			Flags: []cli.Flag{
+				cli.DurationFlag{
+					Name:  "monitor-timeout",
+					Usage: "wait for a monitor response no more than this long",
+					Value: 10 * time.Second,
+				},
+			},
+		},
+		cli.Command{
+			Name:   "refresh-ppd",
+			Usage:  "Make a running connector fetch and translate a printer's PPD again, like after a driver update",
+			Action: refreshPPD,
 			Flags: []cli.Flag{
 				cli.DurationFlag{
 					Name:  "monitor-timeout",
@@ -57,7 +99,19 @@ func main() {
 		cli.Command{
 			Name:   "delete-all-gcp-printers",
 			Usage:  "Delete all printers associated with this connector",
-			Action: deleteAllGCPPrinters,
+			Action: withGCPContext(deleteAllGCPPrinters),
+		},
+		cli.Command{
+			Name:   "share",
+			Usage:  "Share the printers associated with this connector with a scope",
+			Action: withGCPContext(sharePrinters),
+			Flags:  shareFlags,
+		},
+		cli.Command{
+			Name:   "unshare",
+			Usage:  "Stop sharing the printers associated with this connector with a scope",
+			Action: withGCPContext(unsharePrinters),
+			Flags:  shareFlags,
 		},
 		cli.Command{
 			Name:   "update-config-file",
@@ -67,7 +121,7 @@ func main() {
 		cli.Command{
 			Name:   "delete-gcp-job",
 			Usage:  "Deletes one GCP job",
-			Action: deleteGCPJob,
+			Action: withGCPContext(deleteGCPJob),
 			Flags: []cli.Flag{
 				cli.StringFlag{
 					Name: "job-id",
@@ -77,7 +131,7 @@ func main() {
 		cli.Command{
 			Name:   "cancel-gcp-job",
 			Usage:  "Cancels one GCP job",
-			Action: cancelGCPJob,
+			Action: withGCPContext(cancelGCPJob),
 			Flags: []cli.Flag{
 				cli.StringFlag{
 					Name: "job-id",
@@ -87,7 +141,7 @@ func main() {
 		cli.Command{
 			Name:   "delete-all-gcp-printer-jobs",
 			Usage:  "Delete all queued jobs associated with a printer",
-			Action: deleteAllGCPPrinterJobs,
+			Action: withGCPContext(deleteAllGCPPrinterJobs),
 			Flags: []cli.Flag{
 				cli.StringFlag{
 					Name: "printer-id",
@@ -97,7 +151,7 @@ func main() {
 		cli.Command{
 			Name:   "cancel-all-gcp-printer-jobs",
 			Usage:  "Cancels all queued jobs associated with a printer",
-			Action: cancelAllGCPPrinterJobs,
+			Action: withGCPContext(cancelAllGCPPrinterJobs),
 			Flags: []cli.Flag{
 				cli.StringFlag{
 					Name: "printer-id",
@@ -107,7 +161,7 @@ func main() {
 		cli.Command{
 			Name:   "show-gcp-printer-status",
 			Usage:  "Shows the current status of a printer and it's jobs",
-			Action: showGCPPrinterStatus,
+			Action: withGCPContext(showGCPPrinterStatus),
 			Flags: []cli.Flag{
 				cli.StringFlag{
 					Name: "printer-id",
@@ -128,12 +182,17 @@ func getConfig(context *cli.Context) *lib.Config {
 	return config
 }
 
-// getGCP returns a GoogleCloudPrint object
-func getGCP(config *lib.Config) *gcp.GoogleCloudPrint {
+// getGCP returns a GoogleCloudPrint object, with the refresh tokens in the
+// token store.
+func getGCP(context *cli.Context, config *lib.Config) *gcp.GoogleCloudPrint {
+	trustCACertFile(config.CACertFile)
+	setMinTLSVersion(config.MinTLSVersion)
+	setUserAgent(config)
+	loadTokens(context, config)
 	gcp, err := gcp.NewGoogleCloudPrint(config.GCPBaseURL, config.RobotRefreshToken,
 		config.UserRefreshToken, config.ProxyName, config.GCPOAuthClientID,
 		config.GCPOAuthClientSecret, config.GCPOAuthAuthURL, config.GCPOAuthTokenURL,
-		0, nil)
+		gcpAPITimeout(config), 0, 0, "", nil, nil)
 	if err != nil {
 		log.Fatalln(err)
 	}
@@ -187,6 +246,11 @@ func updateConfigFile(context *cli.Context) {
 		fmt.Println("Added cups_job_queue_size")
 		config.CUPSJobQueueSize = lib.DefaultConfig.CUPSJobQueueSize
 	}
+	if _, exists := configMap["cups_job_retries"]; !exists {
+		dirty = true
+		fmt.Println("Added cups_job_retries")
+		config.CUPSJobRetries = lib.DefaultConfig.CUPSJobRetries
+	}
 	if _, exists := configMap["cups_printer_poll_interval"]; !exists {
 		dirty = true
 		fmt.Println("Added cups_printer_poll_interval")
@@ -197,17 +261,12 @@ func updateConfigFile(context *cli.Context) {
 		fmt.Println("Added cups_printer_attributes")
 		config.CUPSPrinterAttributes = lib.DefaultConfig.CUPSPrinterAttributes
 	} else {
-		// Make sure all required attributes are present.
-		s := make(map[string]struct{}, len(config.CUPSPrinterAttributes))
-		for _, a := range config.CUPSPrinterAttributes {
-			s[a] = struct{}{}
-		}
-		for _, a := range lib.DefaultConfig.CUPSPrinterAttributes {
-			if _, exists := s[a]; !exists {
-				dirty = true
-				fmt.Printf("Added %s to cups_printer_attributes\n", a)
-				config.CUPSPrinterAttributes = append(config.CUPSPrinterAttributes, a)
-			}
+		// Make sure all required attributes are present, like those of
+		// driverless printers in configs older than cups_driverless_printers.
+		for _, a := range missingPrinterAttributes(config.CUPSPrinterAttributes) {
+			dirty = true
+			fmt.Printf("Added %s to cups_printer_attributes\n", a)
+			config.CUPSPrinterAttributes = append(config.CUPSPrinterAttributes, a)
 		}
 	}
 	if _, exists := configMap["cups_job_full_username"]; !exists {
@@ -215,16 +274,28 @@ func updateConfigFile(context *cli.Context) {
 		fmt.Println("Added cups_job_full_username")
 		config.CUPSJobFullUsername = lib.DefaultConfig.CUPSJobFullUsername
 	}
-	if _, exists := configMap["cups_ignore_raw_printers"]; !exists {
+	if _, exists := configMap["cups_job_username_template"]; !exists {
+		dirty = true
+		fmt.Println("Added cups_job_username_template")
+		config.CUPSJobUsernameTemplate = lib.DefaultConfig.CUPSJobUsernameTemplate
+	}
+	if _, exists := configMap["cups_raw_printer_policy"]; !exists {
 		dirty = true
-		fmt.Println("Added cups_ignore_raw_printers")
-		config.CUPSIgnoreRawPrinters = lib.DefaultConfig.CUPSIgnoreRawPrinters
+		fmt.Println("Added cups_raw_printer_policy")
+		// Replaces cups_ignore_raw_printers.
+		config.CUPSRawPrinterPolicy = config.RawPrinterPolicy()
+		config.CUPSIgnoreRawPrinters = nil
 	}
 	if _, exists := configMap["copy_printer_info_to_display_name"]; !exists {
 		dirty = true
 		fmt.Println("Added copy_printer_info_to_display_name")
 		config.CopyPrinterInfoToDisplayName = lib.DefaultConfig.CopyPrinterInfoToDisplayName
 	}
+	if _, exists := configMap["job_title_template"]; !exists {
+		dirty = true
+		fmt.Println("Added job_title_template")
+		config.JobTitleTemplate = lib.DefaultConfig.JobTitleTemplate
+	}
 	if _, exists := configMap["display_name_prefix"]; !exists {
 		dirty = true
 		fmt.Println("Added display_name_prefix")
@@ -235,6 +306,11 @@ func updateConfigFile(context *cli.Context) {
 		fmt.Println("Added monitor_socket_filename")
 		config.MonitorSocketFilename = lib.DefaultConfig.MonitorSocketFilename
 	}
+	if _, exists := configMap["temp_dir"]; !exists {
+		dirty = true
+		fmt.Println("Added temp_dir")
+		config.TempDir = lib.DefaultConfig.TempDir
+	}
 	if _, exists := configMap["gcp_base_url"]; !exists {
 		dirty = true
 		fmt.Println("Added gcp_base_url")
@@ -330,6 +406,286 @@ func updateConfigFile(context *cli.Context) {
 		fmt.Println("Added log_level")
 		config.LogLevel = lib.DefaultConfig.LogLevel
 	}
+	if _, exists := configMap["state_change_webhook_url"]; !exists {
+		dirty = true
+		fmt.Println("Added state_change_webhook_url")
+		config.StateChangeWebhookURL = lib.DefaultConfig.StateChangeWebhookURL
+	}
+	if _, exists := configMap["cups_server_host"]; !exists {
+		dirty = true
+		fmt.Println("Added cups_server_host")
+		config.CUPSServerHost = lib.DefaultConfig.CUPSServerHost
+	}
+	if _, exists := configMap["cups_server_port"]; !exists {
+		dirty = true
+		fmt.Println("Added cups_server_port")
+		config.CUPSServerPort = lib.DefaultConfig.CUPSServerPort
+	}
+	if _, exists := configMap["cups_encryption"]; !exists {
+		dirty = true
+		fmt.Println("Added cups_encryption")
+		config.CUPSEncryption = lib.DefaultConfig.CUPSEncryption
+	}
+	if _, exists := configMap["cups_ca_cert_file"]; !exists {
+		dirty = true
+		fmt.Println("Added cups_ca_cert_file")
+		config.CUPSCACertFile = lib.DefaultConfig.CUPSCACertFile
+	}
+	if _, exists := configMap["printer_poll_interval_overrides"]; !exists {
+		dirty = true
+		fmt.Println("Added printer_poll_interval_overrides")
+		config.PrinterPollIntervalOverrides = lib.DefaultConfig.PrinterPollIntervalOverrides
+	}
+	if _, exists := configMap["min_update_interval"]; !exists {
+		dirty = true
+		fmt.Println("Added min_update_interval")
+		config.MinUpdateInterval = lib.DefaultConfig.MinUpdateInterval
+	}
+	if _, exists := configMap["printer_allowlist_file"]; !exists {
+		dirty = true
+		fmt.Println("Added printer_allowlist_file")
+		config.PrinterAllowlistFile = lib.DefaultConfig.PrinterAllowlistFile
+	}
+	if _, exists := configMap["printer_allowlist_interval"]; !exists {
+		dirty = true
+		fmt.Println("Added printer_allowlist_interval")
+		config.PrinterAllowlistInterval = lib.DefaultConfig.PrinterAllowlistInterval
+	}
+	if _, exists := configMap["strict_names"]; !exists {
+		dirty = true
+		fmt.Println("Added strict_names")
+		config.StrictNames = lib.DefaultConfig.StrictNames
+	}
+	if _, exists := configMap["cups_raw_printer_make_models"]; !exists {
+		dirty = true
+		fmt.Println("Added cups_raw_printer_make_models")
+		config.CUPSRawPrinterMakeModels = lib.DefaultConfig.CUPSRawPrinterMakeModels
+	}
+	if _, exists := configMap["cups_missing_ppd_is_raw"]; !exists {
+		dirty = true
+		fmt.Println("Added cups_missing_ppd_is_raw")
+		config.CUPSMissingPPDIsRaw = lib.DefaultConfig.CUPSMissingPPDIsRaw
+	}
+	if _, exists := configMap["printer_setup_url"]; !exists {
+		dirty = true
+		fmt.Println("Added printer_setup_url")
+		config.PrinterSetupURL = lib.DefaultConfig.PrinterSetupURL
+	}
+	if _, exists := configMap["printer_support_url"]; !exists {
+		dirty = true
+		fmt.Println("Added printer_support_url")
+		config.PrinterSupportURL = lib.DefaultConfig.PrinterSupportURL
+	}
+	if _, exists := configMap["printer_update_url"]; !exists {
+		dirty = true
+		fmt.Println("Added printer_update_url")
+		config.PrinterUpdateURL = lib.DefaultConfig.PrinterUpdateURL
+	}
+	if _, exists := configMap["log_compress"]; !exists {
+		dirty = true
+		fmt.Println("Added log_compress")
+		config.LogCompress = lib.DefaultConfig.LogCompress
+	}
+	if _, exists := configMap["capability_overrides"]; !exists {
+		dirty = true
+		fmt.Println("Added capability_overrides")
+		config.CapabilityOverrides = lib.DefaultConfig.CapabilityOverrides
+	}
+	if _, exists := configMap["ca_cert_file"]; !exists {
+		dirty = true
+		fmt.Println("Added ca_cert_file")
+		config.CACertFile = lib.DefaultConfig.CACertFile
+	}
+	if _, exists := configMap["token_store"]; !exists {
+		dirty = true
+		fmt.Println("Added token_store")
+		config.TokenStore = lib.DefaultConfig.TokenStore
+	}
+	if _, exists := configMap["token_store_command"]; !exists {
+		dirty = true
+		fmt.Println("Added token_store_command")
+		config.TokenStoreCommand = lib.DefaultConfig.TokenStoreCommand
+	}
+	if _, exists := configMap["strict_job_options"]; !exists {
+		dirty = true
+		fmt.Println("Added strict_job_options")
+		config.StrictJobOptions = lib.DefaultConfig.StrictJobOptions
+	}
+	if _, exists := configMap["gcp_printer_list_refresh_interval"]; !exists {
+		dirty = true
+		fmt.Println("Added gcp_printer_list_refresh_interval")
+		config.GCPPrinterListRefreshInterval = lib.DefaultConfig.GCPPrinterListRefreshInterval
+	}
+	if _, exists := configMap["printer_tags"]; !exists {
+		dirty = true
+		fmt.Println("Added printer_tags")
+		config.PrinterTags = lib.DefaultConfig.PrinterTags
+	}
+	if _, exists := configMap["gcp_api_timeout"]; !exists {
+		dirty = true
+		fmt.Println("Added gcp_api_timeout")
+		config.GCPAPITimeout = lib.DefaultConfig.GCPAPITimeout
+	}
+	if _, exists := configMap["allow_empty_cups_sync"]; !exists {
+		dirty = true
+		fmt.Println("Added allow_empty_cups_sync")
+		config.AllowEmptyCUPSSync = lib.DefaultConfig.AllowEmptyCUPSSync
+	}
+	if _, exists := configMap["user_agent"]; !exists {
+		dirty = true
+		fmt.Println("Added user_agent")
+		config.UserAgent = lib.DefaultConfig.UserAgent
+	}
+	if _, exists := configMap["disabled_printers"]; !exists {
+		dirty = true
+		fmt.Println("Added disabled_printers")
+		config.DisabledPrinters = lib.DefaultConfig.DisabledPrinters
+	}
+	if _, exists := configMap["gcp_download_timeout"]; !exists {
+		dirty = true
+		fmt.Println("Added gcp_download_timeout")
+		config.GCPDownloadTimeout = lib.DefaultConfig.GCPDownloadTimeout
+	}
+	if _, exists := configMap["make_model_overrides"]; !exists {
+		dirty = true
+		fmt.Println("Added make_model_overrides")
+		config.MakeModelOverrides = lib.DefaultConfig.MakeModelOverrides
+	}
+	if _, exists := configMap["shadow_mode"]; !exists {
+		dirty = true
+		fmt.Println("Added shadow_mode")
+		config.ShadowMode = lib.DefaultConfig.ShadowMode
+	}
+	if _, exists := configMap["printer_state_reason_messages"]; !exists {
+		dirty = true
+		fmt.Println("Added printer_state_reason_messages")
+		config.PrinterStateReasonMessages = lib.DefaultConfig.PrinterStateReasonMessages
+	}
+	if _, exists := configMap["display_name_suffix"]; !exists {
+		dirty = true
+		fmt.Println("Added display_name_suffix")
+		config.DisplayNameSuffix = lib.DefaultConfig.DisplayNameSuffix
+	}
+	if _, exists := configMap["force_reregister"]; !exists {
+		dirty = true
+		fmt.Println("Added force_reregister")
+		config.ForceReregister = lib.DefaultConfig.ForceReregister
+	}
+	if _, exists := configMap["debug_pprof_address"]; !exists {
+		dirty = true
+		fmt.Println("Added debug_pprof_address")
+		config.DebugPprofAddress = lib.DefaultConfig.DebugPprofAddress
+	}
+	if _, exists := configMap["xmpp_server_fallbacks"]; !exists {
+		dirty = true
+		fmt.Println("Added xmpp_server_fallbacks")
+		config.XMPPServerFallbacks = lib.DefaultConfig.XMPPServerFallbacks
+	}
+	if _, exists := configMap["job_title_sanitize"]; !exists {
+		dirty = true
+		fmt.Println("Added job_title_sanitize")
+		config.JobTitleSanitize = lib.DefaultConfig.JobTitleSanitize
+	}
+	if _, exists := configMap["job_title_max_length"]; !exists {
+		dirty = true
+		fmt.Println("Added job_title_max_length")
+		config.JobTitleMaxLength = lib.DefaultConfig.JobTitleMaxLength
+	}
+	if _, exists := configMap["log_job_titles"]; !exists {
+		dirty = true
+		fmt.Println("Added log_job_titles")
+		config.LogJobTitles = lib.DefaultConfig.LogJobTitles
+	}
+	if _, exists := configMap["snmp_extra_oids"]; !exists {
+		dirty = true
+		fmt.Println("Added snmp_extra_oids")
+		config.SNMPExtraOIDs = lib.DefaultConfig.SNMPExtraOIDs
+	}
+	if _, exists := configMap["sync_max_retries"]; !exists {
+		dirty = true
+		fmt.Println("Added sync_max_retries")
+		config.SyncMaxRetries = lib.DefaultConfig.SyncMaxRetries
+	}
+	if _, exists := configMap["sync_retry_backoff"]; !exists {
+		dirty = true
+		fmt.Println("Added sync_retry_backoff")
+		config.SyncRetryBackoff = lib.DefaultConfig.SyncRetryBackoff
+	}
+	if _, exists := configMap["skip_device_uri_schemes"]; !exists {
+		dirty = true
+		fmt.Println("Added skip_device_uri_schemes")
+		config.SkipDeviceURISchemes = lib.DefaultConfig.SkipDeviceURISchemes
+	}
+	if _, exists := configMap["default_media_size"]; !exists {
+		dirty = true
+		fmt.Println("Added default_media_size")
+		config.DefaultMediaSize = lib.DefaultConfig.DefaultMediaSize
+	}
+	if _, exists := configMap["min_tls_version"]; !exists {
+		dirty = true
+		fmt.Println("Added min_tls_version")
+		config.MinTLSVersion = lib.DefaultConfig.MinTLSVersion
+	}
+	if _, exists := configMap["keep_job_files"]; !exists {
+		dirty = true
+		fmt.Println("Added keep_job_files")
+		config.KeepJobFiles = lib.DefaultConfig.KeepJobFiles
+	}
+	if _, exists := configMap["keep_job_files_count"]; !exists {
+		dirty = true
+		fmt.Println("Added keep_job_files_count")
+		config.KeepJobFilesCount = lib.DefaultConfig.KeepJobFilesCount
+	}
+	if _, exists := configMap["keep_job_files_max_age"]; !exists {
+		dirty = true
+		fmt.Println("Added keep_job_files_max_age")
+		config.KeepJobFilesMaxAge = lib.DefaultConfig.KeepJobFilesMaxAge
+	}
+	if _, exists := configMap["cloud_job_poll_interval"]; !exists {
+		dirty = true
+		fmt.Println("Added cloud_job_poll_interval")
+		config.CloudJobPollInterval = lib.DefaultConfig.CloudJobPollInterval
+	}
+	if _, exists := configMap["reregister_on_uuid_change"]; !exists {
+		dirty = true
+		fmt.Println("Added reregister_on_uuid_change")
+		config.ReregisterOnUUIDChange = lib.DefaultConfig.ReregisterOnUUIDChange
+	}
+	if _, exists := configMap["accepted_job_formats"]; !exists {
+		dirty = true
+		fmt.Println("Added accepted_job_formats")
+		config.AcceptedJobFormats = lib.DefaultConfig.AcceptedJobFormats
+	}
+	if _, exists := configMap["self_heal_interval"]; !exists {
+		dirty = true
+		fmt.Println("Added self_heal_interval")
+		config.SelfHealInterval = lib.DefaultConfig.SelfHealInterval
+	}
+	if _, exists := configMap["max_registered_printers"]; !exists {
+		dirty = true
+		fmt.Println("Added max_registered_printers")
+		config.MaxRegisteredPrinters = lib.DefaultConfig.MaxRegisteredPrinters
+	}
+	if _, exists := configMap["job_dedup_ttl"]; !exists {
+		dirty = true
+		fmt.Println("Added job_dedup_ttl")
+		config.JobDedupTTL = lib.DefaultConfig.JobDedupTTL
+	}
+	if _, exists := configMap["printer_default_options"]; !exists {
+		dirty = true
+		fmt.Println("Added printer_default_options")
+		config.PrinterDefaultOptions = lib.DefaultConfig.PrinterDefaultOptions
+	}
+	if _, exists := configMap["cups_job_max_stopped"]; !exists {
+		dirty = true
+		fmt.Println("Added cups_job_max_stopped")
+		config.CUPSJobMaxStopped = lib.DefaultConfig.CUPSJobMaxStopped
+	}
+	if _, exists := configMap["cups_driverless_printers"]; !exists {
+		dirty = true
+		fmt.Println("Added cups_driverless_printers")
+		config.CUPSDriverlessPrinters = lib.DefaultConfig.CUPSDriverlessPrinters
+	}
 
 	if dirty {
 		config.ToFile(context)
@@ -339,13 +695,37 @@ func updateConfigFile(context *cli.Context) {
 	}
 }
 
+// withGCPContext adapts a command that makes GCP calls to a cli action. The
+// util's commands are short-lived, so the calls are never cancelled.
+func withGCPContext(action func(ctx context.Context, context *cli.Context)) func(*cli.Context) {
+	return func(c *cli.Context) {
+		action(context.Background(), c)
+	}
+}
+
+// missingPrinterAttributes returns the default CUPS printer attributes that
+// attributes lacks, in the default order.
+func missingPrinterAttributes(attributes []string) []string {
+	s := make(map[string]struct{}, len(attributes))
+	for _, a := range attributes {
+		s[a] = struct{}{}
+	}
+	var missing []string
+	for _, a := range lib.DefaultConfig.CUPSPrinterAttributes {
+		if _, exists := s[a]; !exists {
+			missing = append(missing, a)
+		}
+	}
+	return missing
+}
+
 // deleteAllGCPPrinters finds all GCP printers associated with this
 // connector, deletes them from GCP.
-func deleteAllGCPPrinters(context *cli.Context) {
+func deleteAllGCPPrinters(ctx context.Context, context *cli.Context) {
 	config := getConfig(context)
-	gcp := getGCP(config)
+	gcp := getGCP(context, config)
 
-	printers, err := gcp.List()
+	printers, err := gcp.List(ctx)
 	if err != nil {
 		log.Fatalln(err)
 	}
@@ -354,7 +734,7 @@ func deleteAllGCPPrinters(context *cli.Context) {
 	for gcpID, name := range printers {
 		wg.Add(1)
 		go func(gcpID, name string) {
-			err := gcp.Delete(gcpID)
+			err := gcp.Delete(ctx, gcpID)
 			if err != nil {
 				fmt.Printf("Failed to delete %s \"%s\": %s\n", gcpID, name, err)
 			} else {
@@ -367,11 +747,11 @@ func deleteAllGCPPrinters(context *cli.Context) {
 }
 
 // deleteGCPJob deletes one GCP job
-func deleteGCPJob(context *cli.Context) {
+func deleteGCPJob(ctx context.Context, context *cli.Context) {
 	config := getConfig(context)
-	gcp := getGCP(config)
+	gcp := getGCP(context, config)
 
-	err := gcp.DeleteJob(context.String("job-id"))
+	err := gcp.DeleteJob(ctx, context.String("job-id"))
 	if err != nil {
 		fmt.Printf("Failed to delete GCP job %s: %s\n", context.String("job-id"), err)
 	} else {
@@ -380,9 +760,9 @@ func deleteGCPJob(context *cli.Context) {
 }
 
 // cancelGCPJob cancels one GCP job
-func cancelGCPJob(context *cli.Context) {
+func cancelGCPJob(ctx context.Context, context *cli.Context) {
 	config := getConfig(context)
-	gcp := getGCP(config)
+	gcp := getGCP(context, config)
 
 	cancelState := cdd.PrintJobStateDiff{
 		State: &cdd.JobState{
@@ -391,7 +771,7 @@ func cancelGCPJob(context *cli.Context) {
 		},
 	}
 
-	err := gcp.Control(context.String("job-id"), cancelState)
+	err := gcp.Control(ctx, context.String("job-id"), cancelState)
 	if err != nil {
 		fmt.Printf("Failed to cancel GCP job %s: %s\n", context.String("job-id"), err)
 	} else {
@@ -401,11 +781,11 @@ func cancelGCPJob(context *cli.Context) {
 
 // deleteAllGCPPrinterJobs finds all GCP printer jobs associated with a
 // a given printer id and deletes them.
-func deleteAllGCPPrinterJobs(context *cli.Context) {
+func deleteAllGCPPrinterJobs(ctx context.Context, context *cli.Context) {
 	config := getConfig(context)
-	gcp := getGCP(config)
+	gcp := getGCP(context, config)
 
-	jobs, err := gcp.Fetch(context.String("printer-id"))
+	jobs, err := gcp.Fetch(ctx, context.String("printer-id"))
 	if err != nil {
 		log.Fatalln(err)
 	}
@@ -417,7 +797,7 @@ func deleteAllGCPPrinterJobs(context *cli.Context) {
 	ch := make(chan bool)
 	for _, job := range jobs {
 		go func(gcpJobID string) {
-			err := gcp.DeleteJob(gcpJobID)
+			err := gcp.DeleteJob(ctx, gcpJobID)
 			if err != nil {
 				fmt.Printf("Failed to delete GCP job %s: %s\n", gcpJobID, err)
 			} else {
@@ -434,11 +814,11 @@ func deleteAllGCPPrinterJobs(context *cli.Context) {
 
 // cancelAllGCPPrinterJobs finds all GCP printer jobs associated with a
 // a given printer id and cancels them.
-func cancelAllGCPPrinterJobs(context *cli.Context) {
+func cancelAllGCPPrinterJobs(ctx context.Context, context *cli.Context) {
 	config := getConfig(context)
-	gcp := getGCP(config)
+	gcp := getGCP(context, config)
 
-	jobs, err := gcp.Fetch(context.String("printer-id"))
+	jobs, err := gcp.Fetch(ctx, context.String("printer-id"))
 	if err != nil {
 		log.Fatalln(err)
 	}
@@ -457,7 +837,7 @@ func cancelAllGCPPrinterJobs(context *cli.Context) {
 	ch := make(chan bool)
 	for _, job := range jobs {
 		go func(gcpJobID string) {
-			err := gcp.Control(gcpJobID, cancelState)
+			err := gcp.Control(ctx, gcpJobID, cancelState)
 			if err != nil {
 				fmt.Printf("Failed to cancel GCP job %s: %s\n", gcpJobID, err)
 			} else {
@@ -473,11 +853,11 @@ func cancelAllGCPPrinterJobs(context *cli.Context) {
 }
 
 // showGCPPrinterStatus shows the current status of a GCP printer and it's jobs
-func showGCPPrinterStatus(context *cli.Context) {
+func showGCPPrinterStatus(ctx context.Context, context *cli.Context) {
 	config := getConfig(context)
-	gcp := getGCP(config)
+	gcp := getGCP(context, config)
 
-	printer, _, err := gcp.Printer(context.String("printer-id"))
+	printer, _, err := gcp.Printer(ctx, context.String("printer-id"))
 	if err != nil {
 		log.Fatalln(err)
 	}
@@ -485,7 +865,7 @@ func showGCPPrinterStatus(context *cli.Context) {
 	fmt.Println("Name:", printer.DefaultDisplayName)
 	fmt.Println("State:", printer.State.State)
 
-	jobs, err := gcp.Jobs(context.String("printer-id"))
+	jobs, err := gcp.Jobs(ctx, context.String("printer-id"))
 	if err != nil {
 		log.Fatalln(err)
 	}
@@ -0,0 +1,39 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/cups-connector/lib"
+)
+
+func TestMissingPrinterAttributes(t *testing.T) {
+	if missing := missingPrinterAttributes(lib.DefaultConfig.CUPSPrinterAttributes); len(missing) != 0 {
+		t.Logf("expected no missing default attributes, got %v", missing)
+		t.Fail()
+	}
+
+	var old []string
+	for _, a := range lib.DefaultConfig.CUPSPrinterAttributes {
+		switch a {
+		case "media-default", "media-supported", "sides-default", "sides-supported",
+			"printer-resolution-default", "printer-resolution-supported":
+		default:
+			old = append(old, a)
+		}
+	}
+	expected := []string{"media-default", "media-supported", "sides-default", "sides-supported",
+		"printer-resolution-default", "printer-resolution-supported"}
+	if missing := missingPrinterAttributes(old); !reflect.DeepEqual(missing, expected) {
+		t.Logf("expected the driverless attributes %v, got %v", expected, missing)
+		t.Fail()
+	}
+}
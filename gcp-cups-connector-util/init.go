@@ -9,13 +9,19 @@ https://developers.google.com/open-source/licenses/bsd
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"math"
+	"math/rand"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -23,34 +29,86 @@ import (
 	"github.com/google/cups-connector/gcp"
 	"github.com/google/cups-connector/lib"
 
-	"golang.org/x/oauth2"
+	"golang.org/x/term"
 )
 
 const (
 	gcpOAuthDeviceCodeURL   = "https://accounts.google.com/o/oauth2/device/code"
 	gcpOAuthTokenPollURL    = "https://www.googleapis.com/oauth2/v3/token"
 	gcpOAuthGrantTypeDevice = "http://oauth.net/grant_type/device/1.0"
+
+	// Bounds for the OAuth confirmation polling interval, and the maximum
+	// random jitter added to each poll.
+	gcpOAuthPollMinInterval = 1 * time.Second
+	gcpOAuthPollMaxInterval = 60 * time.Second
+	gcpOAuthPollMaxJitter   = 1 * time.Second
+
+	// Consecutive authorization_pending responses after which a slow_down
+	// backoff is halved back toward the original interval.
+	gcpOAuthPollResetAfter = 3
+
+	// Attempts to create the robot account when GCP is rate limiting or
+	// unavailable, the first wait between attempts when GCP doesn't say how
+	// long to wait, and the longest wait.
+	gcpCreateRobotMaxAttempts = 6
+	gcpCreateRobotMinBackoff  = 2 * time.Second
+	gcpCreateRobotMaxBackoff  = 2 * time.Minute
 )
 
+// IPP attribute names are lowercase keywords.
+var printerAttributeRegexp = regexp.MustCompile(`^[a-z][a-z0-9._-]*$`)
+
 var initFlags = []cli.Flag{
+	lib.ConfigFilenameFlag,
+	cli.BoolFlag{
+		Name:  "overwrite",
+		Usage: "Replace the config file if it already exists",
+	},
+	cli.BoolFlag{
+		Name:  "resume",
+		Usage: "Resume an init that failed after creating a robot account, reusing that account",
+	},
+	cli.BoolFlag{
+		Name:  "restart",
+		Usage: "Discard the state of an init that failed, and create a new robot account",
+	},
+	cli.StringFlag{
+		Name:  "answers-file",
+		Usage: "JSON file with the answers to every question, instead of prompts: local_printing_enable, cloud_printing_enable, share_scope, proxy_name and, optionally, gcp_user_refresh_token",
+	},
 	cli.StringFlag{
 		Name:  "gcp-user-refresh-token",
 		Usage: "GCP user refresh token, useful when managing many connectors",
 	},
+	cli.BoolFlag{
+		Name:  "prompt-gcp-user-refresh-token",
+		Usage: "Prompt for a GCP user refresh token, without echoing it, instead of the OAuth device flow",
+	},
+	debugHTTPFlag,
+	qrFlag,
 	cli.DurationFlag{
 		Name:  "gcp-api-timeout",
-		Usage: "GCP API timeout, for debugging",
+		Usage: "Time to wait for each GCP and OAuth response (0s means no limit)",
 		Value: 30 * time.Second,
 	},
+	cli.DurationFlag{
+		Name:  "gcp-download-timeout",
+		Usage: "Time to download each print job before it fails (0s means no limit)",
+		Value: 5 * time.Minute,
+	},
 
 	cli.StringFlag{
 		Name:  "share-scope",
-		Usage: "Scope (user or group email address) to automatically share printers with",
+		Usage: "Scope (user or group email address) to automatically share printers with; wildcards, like print-*@example.com, match groups",
 	},
 	cli.StringFlag{
 		Name:  "proxy-name",
 		Usage: "Name for this connector instance. Should be unique per Google user account",
 	},
+	cli.StringFlag{
+		Name:  "connector-display-name",
+		Usage: "Friendly name for this connector, for tags and monitoring (default the proxy name)",
+	},
 	cli.IntFlag{
 		Name:  "xmpp-port",
 		Usage: "Max connections to CUPS server",
@@ -71,6 +129,49 @@ var initFlags = []cli.Flag{
 		Usage: "Maximum quantity of PDFs to download concurrently from GCP cloud service",
 		Value: int(lib.DefaultConfig.GCPMaxConcurrentDownloads),
 	},
+	cli.StringFlag{
+		Name:  "gcp-printer-list-refresh-interval",
+		Usage: "Interval between refreshes of the GCP printer list (0s disables)",
+		Value: lib.DefaultConfig.GCPPrinterListRefreshInterval,
+	},
+	cli.StringFlag{
+		Name:  "cloud-job-poll-interval",
+		Usage: "Interval between polls for GCP jobs whose XMPP notification was missed (0s disables)",
+		Value: lib.DefaultConfig.CloudJobPollInterval,
+	},
+	cli.StringFlag{
+		Name:  "self-heal-interval",
+		Usage: "Interval between checks for registered printers deleted from GCP, which are registered again (0s disables)",
+		Value: lib.DefaultConfig.SelfHealInterval,
+	},
+	cli.IntFlag{
+		Name:  "sync-max-retries",
+		Usage: "Quantity of times to retry each failed printer sync operation",
+		Value: int(lib.DefaultConfig.SyncMaxRetries),
+	},
+	cli.StringFlag{
+		Name:  "sync-retry-backoff",
+		Usage: "Wait before the first retry of a failed printer sync operation; doubles with each retry",
+		Value: lib.DefaultConfig.SyncRetryBackoff,
+	},
+	cli.StringFlag{
+		Name:  "ca-cert-file",
+		Usage: "PEM file of extra CA certificates to trust for HTTPS connections to GCP and OAuth",
+	},
+	cli.StringFlag{
+		Name:  "min-tls-version",
+		Usage: "Oldest TLS version to accept from servers: 1.0, 1.1, 1.2 or 1.3",
+		Value: lib.DefaultConfig.MinTLSVersion,
+	},
+	cli.StringFlag{
+		Name:  "token-store",
+		Usage: "Where to keep the OAuth refresh tokens: file, env, or command",
+		Value: lib.DefaultConfig.TokenStore,
+	},
+	cli.StringFlag{
+		Name:  "token-store-command",
+		Usage: "Command that prints a refresh token, given its name, when token-store is command",
+	},
 	cli.IntFlag{
 		Name:  "cups-max-connections",
 		Usage: "Max connections to CUPS server",
@@ -81,23 +182,94 @@ var initFlags = []cli.Flag{
 		Usage: "CUPS timeout for opening a new connection",
 		Value: lib.DefaultConfig.CUPSConnectTimeout,
 	},
+	cli.StringFlag{
+		Name:  "cups-server-host",
+		Usage: "CUPS server hostname or socket path (default from CUPS client configuration)",
+	},
+	cli.IntFlag{
+		Name:  "cups-server-port",
+		Usage: "CUPS server port (default from CUPS client configuration)",
+		Value: int(lib.DefaultConfig.CUPSServerPort),
+	},
+	cli.StringFlag{
+		Name:  "cups-encryption",
+		Usage: "CUPS connection encryption: never, ifrequested, required, or always (default from CUPS client configuration)",
+	},
+	cli.StringFlag{
+		Name:  "cups-ca-cert-file",
+		Usage: "PEM file of CA certificates to verify the CUPS server certificate with",
+	},
 	cli.IntFlag{
 		Name:  "cups-job-queue-size",
 		Usage: "CUPS job queue size",
 		Value: int(lib.DefaultConfig.CUPSJobQueueSize),
 	},
+	cli.StringFlag{
+		Name:  "cups-printer-attributes",
+		Usage: "Comma-separated CUPS printer attributes to poll, in addition to the defaults",
+	},
+	cli.StringFlag{
+		Name:  "default-media-size",
+		Usage: "Default media size of printers whose PPD doesn't mark one, like A4 or Letter",
+	},
+	cli.IntFlag{
+		Name:  "cups-job-retries",
+		Usage: "Quantity of times to retry a failed CUPS job submission",
+		Value: int(lib.DefaultConfig.CUPSJobRetries),
+	},
+	cli.StringFlag{
+		Name:  "cups-job-max-stopped",
+		Usage: "Time that a CUPS job may stay stopped before it fails (0s means no limit)",
+		Value: lib.DefaultConfig.CUPSJobMaxStopped,
+	},
 	cli.StringFlag{
 		Name:  "cups-printer-poll-interval",
 		Usage: "Interval, in seconds, between CUPS printer state polls",
 		Value: lib.DefaultConfig.CUPSPrinterPollInterval,
 	},
+	cli.StringFlag{
+		Name:  "min-update-interval",
+		Usage: "Minimum interval between GCP updates of one printer",
+		Value: lib.DefaultConfig.MinUpdateInterval,
+	},
+	cli.StringFlag{
+		Name:  "printer-allowlist-file",
+		Usage: "File listing the CUPS printer names or patterns to share (default all printers)",
+	},
+	cli.StringFlag{
+		Name:  "printer-allowlist-interval",
+		Usage: "Interval between reads of the printer allowlist file",
+		Value: lib.DefaultConfig.PrinterAllowlistInterval,
+	},
 	cli.BoolFlag{
 		Name:  "cups-job-full-username",
 		Usage: "Whether to use the full username (joe@example.com) in CUPS jobs",
 	},
-	cli.BoolTFlag{
-		Name:  "cups-ignore-raw-printers",
-		Usage: "Whether to ignore CUPS raw printers",
+	cli.StringFlag{
+		Name:  "cups-job-username-template",
+		Usage: "Template for the CUPS job username, with {{.Local}}, {{.Domain}}, and {{.Full}}; overrides cups-job-full-username",
+		Value: lib.DefaultConfig.CUPSJobUsernameTemplate,
+	},
+	cli.StringFlag{
+		Name:  "cups-raw-printer-policy",
+		Usage: "What to do with CUPS raw printers: ignore, register, or delete-from-gcp",
+		Value: lib.DefaultConfig.CUPSRawPrinterPolicy,
+	},
+	cli.BoolFlag{
+		Name:  "strict-names",
+		Usage: "Fail printer syncs when CUPS printers share a name",
+	},
+	cli.BoolFlag{
+		Name:  "strict-job-options",
+		Usage: "Fail print jobs that ask for options the printer can't honor",
+	},
+	cli.BoolFlag{
+		Name:  "allow-empty-cups-sync",
+		Usage: "Sync even when CUPS has no printers, deleting all GCP printers",
+	},
+	cli.BoolFlag{
+		Name:  "shadow-mode",
+		Usage: "Log the changes to GCP printers that would be made, without making them",
 	},
 	cli.BoolTFlag{
 		Name:  "copy-printer-info-to-display-name",
@@ -107,16 +279,71 @@ var initFlags = []cli.Flag{
 		Name:  "prefix-job-id-to-job-title",
 		Usage: "Whether to add the job ID to the beginning of the job title",
 	},
+	cli.StringFlag{
+		Name:  "job-title-template",
+		Usage: "Template for the CUPS job title, with {{.ID}} and {{.Title}}; overrides prefix-job-id-to-job-title",
+		Value: lib.DefaultConfig.JobTitleTemplate,
+	},
+	cli.BoolFlag{
+		Name:  "job-title-sanitize",
+		Usage: "Whether to replace control characters in job titles and shorten them to job-title-max-length",
+	},
+	cli.IntFlag{
+		Name:  "job-title-max-length",
+		Usage: "Maximum length in bytes of sanitized job titles; 0 means 255",
+		Value: int(lib.DefaultConfig.JobTitleMaxLength),
+	},
+	cli.BoolFlag{
+		Name:  "log-job-titles",
+		Usage: "Whether to log job titles",
+	},
 	cli.StringFlag{
 		Name:  "display-name-prefix",
 		Usage: "Prefix to add to GCP printer's display name",
 		Value: lib.DefaultConfig.DisplayNamePrefix,
 	},
+	cli.StringFlag{
+		Name:  "display-name-suffix",
+		Usage: "Suffix to add to GCP printer's display name",
+		Value: lib.DefaultConfig.DisplayNameSuffix,
+	},
+	cli.StringFlag{
+		Name:  "printer-setup-url",
+		Usage: "Template for the setup URL of each printer, e.g. with {{.Name}}",
+		Value: lib.DefaultConfig.PrinterSetupURL,
+	},
+	cli.StringFlag{
+		Name:  "printer-support-url",
+		Usage: "Template for the support URL of each printer, e.g. with {{.Name}}",
+		Value: lib.DefaultConfig.PrinterSupportURL,
+	},
+	cli.StringFlag{
+		Name:  "printer-update-url",
+		Usage: "Template for the update URL of each printer, e.g. with {{.Name}}",
+		Value: lib.DefaultConfig.PrinterUpdateURL,
+	},
 	cli.StringFlag{
 		Name:  "monitor-socket-filename",
 		Usage: "Filename of unix socket for connector-check to talk to connector",
 		Value: lib.DefaultConfig.MonitorSocketFilename,
 	},
+	cli.BoolFlag{
+		Name:  "create-socket-dir",
+		Usage: "Create the monitor socket directory now, if it doesn't exist",
+	},
+	cli.StringFlag{
+		Name:  "temp-dir",
+		Usage: "Directory for downloaded job files and cached PPDs (default system temporary directory)",
+		Value: lib.DefaultConfig.TempDir,
+	},
+	cli.StringFlag{
+		Name:  "state-change-webhook-url",
+		Usage: "URL to POST printer state changes to",
+	},
+	cli.StringFlag{
+		Name:  "user-agent",
+		Usage: "User-Agent header of outbound HTTP requests (default the connector version and proxy name)",
+	},
 	cli.BoolFlag{
 		Name:  "snmp-enable",
 		Usage: "SNMP enable",
@@ -154,6 +381,10 @@ var initFlags = []cli.Flag{
 		Usage: "Maximum log file quantity before rollover",
 		Value: int(lib.DefaultConfig.LogMaxFiles),
 	},
+	cli.BoolFlag{
+		Name:  "log-compress",
+		Usage: "Whether to gzip rolled log files",
+	},
 	cli.StringFlag{
 		Name:  "log-level",
 		Usage: "Minimum event severity to log: PANIC, ERROR, WARN, INFO, DEBUG, VERBOSE",
@@ -161,14 +392,24 @@ var initFlags = []cli.Flag{
 	},
 }
 
+// userScopes is the OAuth scopes to ask the user for. Resolving a share scope
+// pattern needs to list the groups of the domain.
+func userScopes(shareScope string) []string {
+	if gcp.ShareScopeIsPattern(shareScope) {
+		return []string{gcp.ScopeCloudPrint, gcp.ScopeDirectoryGroups}
+	}
+	return []string{gcp.ScopeCloudPrint}
+}
+
 // getUserClientFromUser follows the token acquisition steps outlined here:
 // https://developers.google.com/identity/protocols/OAuth2ForDevices
-func getUserClientFromUser(context *cli.Context) (*http.Client, string) {
+func getUserClientFromUser(context *cli.Context, shareScope string) (*http.Client, string) {
 	form := url.Values{
 		"client_id": {lib.DefaultConfig.GCPOAuthClientID},
-		"scope":     {gcp.ScopeCloudPrint},
+		"scope":     {strings.Join(userScopes(shareScope), " ")},
 	}
-	response, err := http.PostForm(gcpOAuthDeviceCodeURL, form)
+	hc := gcp.NewHTTPClient(context.Duration("gcp-api-timeout"))
+	response, err := hc.PostForm(gcpOAuthDeviceCodeURL, form)
 	if err != nil {
 		log.Fatalln(err)
 	}
@@ -184,24 +425,19 @@ func getUserClientFromUser(context *cli.Context) (*http.Client, string) {
 
 	fmt.Printf("Visit %s, and enter this code. I'll wait for you.\n%s\n",
 		r.VerificationURL, r.UserCode)
+	if context.Bool("qr") {
+		printQRCode(r.VerificationURL)
+	}
 
 	return pollOAuthConfirmation(context, r.DeviceCode, r.Interval)
 }
 
 func pollOAuthConfirmation(context *cli.Context, deviceCode string, interval int) (*http.Client, string) {
-	config := oauth2.Config{
-		ClientID:     lib.DefaultConfig.GCPOAuthClientID,
-		ClientSecret: lib.DefaultConfig.GCPOAuthClientSecret,
-		Endpoint: oauth2.Endpoint{
-			AuthURL:  lib.DefaultConfig.GCPOAuthAuthURL,
-			TokenURL: lib.DefaultConfig.GCPOAuthTokenURL,
-		},
-		RedirectURL: gcp.RedirectURL,
-		Scopes:      []string{gcp.ScopeCloudPrint},
-	}
+	hc := gcp.NewHTTPClient(context.Duration("gcp-api-timeout"))
+	backoff := newOAuthPollBackoff(time.Duration(interval) * time.Second)
 
 	for {
-		time.Sleep(time.Duration(interval) * time.Second)
+		time.Sleep(backoff.interval + time.Duration(rand.Int63n(int64(gcpOAuthPollMaxJitter))))
 
 		form := url.Values{
 			"client_id":     {lib.DefaultConfig.GCPOAuthClientID},
@@ -209,7 +445,7 @@ func pollOAuthConfirmation(context *cli.Context, deviceCode string, interval int
 			"code":          {deviceCode},
 			"grant_type":    {gcpOAuthGrantTypeDevice},
 		}
-		response, err := http.PostForm(gcpOAuthTokenPollURL, form)
+		response, err := hc.PostForm(gcpOAuthTokenPollURL, form)
 		if err != nil {
 			log.Fatalln(err)
 		}
@@ -224,13 +460,11 @@ func pollOAuthConfirmation(context *cli.Context, deviceCode string, interval int
 
 		switch r.Error {
 		case "":
-			token := &oauth2.Token{RefreshToken: r.RefreshToken}
-			client := config.Client(oauth2.NoContext, token)
-			client.Timeout = context.Duration("gcp-api-timeout")
-			return client, r.RefreshToken
+			return newGCPHTTPClient(context, &lib.DefaultConfig, r.RefreshToken, gcp.ScopeCloudPrint), r.RefreshToken
 		case "authorization_pending":
+			backoff.pending()
 		case "slow_down":
-			interval *= 2
+			backoff.slowDown()
 		default:
 			log.Fatalln(err)
 		}
@@ -239,24 +473,53 @@ func pollOAuthConfirmation(context *cli.Context, deviceCode string, interval int
 	panic("unreachable")
 }
 
+// oauthPollBackoff is the OAuth confirmation polling interval, which backs off
+// when asked to slow down and recovers after a few normal polls.
+type oauthPollBackoff struct {
+	base     time.Duration
+	interval time.Duration
+	polls    int
+}
+
+func newOAuthPollBackoff(interval time.Duration) *oauthPollBackoff {
+	if interval < gcpOAuthPollMinInterval {
+		interval = gcpOAuthPollMinInterval
+	} else if interval > gcpOAuthPollMaxInterval {
+		interval = gcpOAuthPollMaxInterval
+	}
+	return &oauthPollBackoff{base: interval, interval: interval}
+}
+
+// slowDown doubles the interval, up to gcpOAuthPollMaxInterval.
+func (b *oauthPollBackoff) slowDown() {
+	b.polls = 0
+	if b.interval > gcpOAuthPollMaxInterval/2 {
+		b.interval = gcpOAuthPollMaxInterval
+	} else {
+		b.interval *= 2
+	}
+}
+
+// pending halves the interval, down to the original interval, after every
+// gcpOAuthPollResetAfter consecutive authorization_pending responses.
+func (b *oauthPollBackoff) pending() {
+	if b.interval <= b.base {
+		return
+	}
+	b.polls++
+	if b.polls < gcpOAuthPollResetAfter {
+		return
+	}
+	b.polls = 0
+	b.interval /= 2
+	if b.interval < b.base {
+		b.interval = b.base
+	}
+}
+
 // getUserClientFromToken creates a user client with just a refresh token.
-func getUserClientFromToken(context *cli.Context) *http.Client {
-	config := &oauth2.Config{
-		ClientID:     lib.DefaultConfig.GCPOAuthClientID,
-		ClientSecret: lib.DefaultConfig.GCPOAuthClientSecret,
-		Endpoint: oauth2.Endpoint{
-			AuthURL:  lib.DefaultConfig.GCPOAuthAuthURL,
-			TokenURL: lib.DefaultConfig.GCPOAuthTokenURL,
-		},
-		RedirectURL: gcp.RedirectURL,
-		Scopes:      []string{gcp.ScopeCloudPrint},
-	}
-
-	token := &oauth2.Token{RefreshToken: context.String("gcp-user-refresh-token")}
-	client := config.Client(oauth2.NoContext, token)
-	client.Timeout = context.Duration("gcp-api-timeout")
-
-	return client
+func getUserClientFromToken(context *cli.Context, refreshToken string) *http.Client {
+	return newGCPHTTPClient(context, &lib.DefaultConfig, refreshToken, gcp.ScopeCloudPrint)
 }
 
 // initRobotAccount creates a GCP robot account for this connector.
@@ -265,13 +528,34 @@ func initRobotAccount(context *cli.Context, userClient *http.Client) (string, st
 	params.Set("oauth_client_id", lib.DefaultConfig.GCPOAuthClientID)
 
 	url := fmt.Sprintf("%s%s?%s", lib.DefaultConfig.GCPBaseURL, "createrobot", params.Encode())
-	response, err := userClient.Get(url)
-	if err != nil {
-		log.Fatalln(err)
-	}
-	if response.StatusCode != http.StatusOK {
-		log.Fatalf("Failed to initialize robot account: %s\n", response.Status)
+	var response *http.Response
+	backoff := gcpCreateRobotMinBackoff
+	for attempt := 1; ; attempt++ {
+		var err error
+		response, err = userClient.Get(url)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if response.StatusCode == http.StatusOK {
+			break
+		}
+		response.Body.Close()
+		if !createRobotRetryable(response.StatusCode) || attempt == gcpCreateRobotMaxAttempts {
+			log.Fatalf("Failed to initialize robot account: %s\n", response.Status)
+		}
+
+		wait := retryAfter(response.Header.Get("Retry-After"), backoff, time.Now())
+		if wait > gcpCreateRobotMaxBackoff {
+			wait = gcpCreateRobotMaxBackoff
+		}
+		fmt.Printf("GCP responded %s; retrying in %s\n", response.Status, wait)
+		time.Sleep(wait)
+
+		if backoff *= 2; backoff > gcpCreateRobotMaxBackoff {
+			backoff = gcpCreateRobotMaxBackoff
+		}
 	}
+	defer response.Body.Close()
 
 	var robotInit struct {
 		Success  bool   `json:"success"`
@@ -280,81 +564,242 @@ func initRobotAccount(context *cli.Context, userClient *http.Client) (string, st
 		AuthCode string `json:"authorization_code"`
 	}
 
-	if err = json.NewDecoder(response.Body).Decode(&robotInit); err != nil {
-		log.Fatalln(err)
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		log.Fatalf("Failed to read robot account response: %s\n", err)
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		log.Fatalln("Failed to initialize robot account: GCP returned an empty response")
+	}
+	if err = json.Unmarshal(body, &robotInit); err != nil {
+		log.Fatalf("Failed to initialize robot account: GCP returned a malformed response: %s\n", err)
 	}
 	if !robotInit.Success {
 		log.Fatalf("Failed to initialize robot account: %s\n", robotInit.Message)
 	}
+	if robotInit.Message != "" {
+		fmt.Printf("GCP says: %s\n", robotInit.Message)
+	}
 
 	return robotInit.XMPPJID, robotInit.AuthCode
 }
 
-func verifyRobotAccount(authCode string) string {
-	config := &oauth2.Config{
-		ClientID:     lib.DefaultConfig.GCPOAuthClientID,
-		ClientSecret: lib.DefaultConfig.GCPOAuthClientSecret,
-		Endpoint: oauth2.Endpoint{
-			AuthURL:  lib.DefaultConfig.GCPOAuthAuthURL,
-			TokenURL: lib.DefaultConfig.GCPOAuthTokenURL,
-		},
-		RedirectURL: gcp.RedirectURL,
-		Scopes:      []string{gcp.ScopeCloudPrint, gcp.ScopeGoogleTalk},
+// createRobotRetryable checks whether a createrobot call that failed with
+// statusCode may succeed later. Rate limits and server errors are transient;
+// other client errors are permanent.
+func createRobotRetryable(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode >= 500
+}
+
+// retryAfter parses a Retry-After header, which is either a number of seconds
+// or an HTTP date, returning fallback when the header is missing or invalid.
+func retryAfter(header string, fallback time.Duration, now time.Time) time.Duration {
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return fallback
+	}
+	if seconds, err := strconv.ParseInt(header, 10, 64); err == nil {
+		if seconds < 0 {
+			return fallback
+		}
+		if seconds > int64(math.MaxInt64/time.Second) {
+			return math.MaxInt64
+		}
+		return time.Duration(seconds) * time.Second
 	}
+	if date, err := http.ParseTime(header); err == nil {
+		if wait := date.Sub(now); wait > 0 {
+			return wait
+		}
+		return 0
+	}
+	return fallback
+}
+
+// warnClockSkew warns when the system clock is off from GCP's, before the
+// OAuth steps that would fail because of it.
+func warnClockSkew(context *cli.Context) {
+	skew, err := gcp.ClockSkew(lib.DefaultConfig.GCPBaseURL, context.Duration("gcp-api-timeout"))
+	if err != nil {
+		fmt.Printf("Failed to check the system clock against GCP: %s\n", err)
+		return
+	}
+	if gcp.ClockSkewTooLarge(skew) {
+		fmt.Printf("The system clock is off from GCP's by %s, so OAuth is likely to fail; sync it, eg with NTP, then try again\n", skew)
+		fmt.Println("")
+	}
+}
 
-	token, err := config.Exchange(oauth2.NoContext, authCode)
+func verifyRobotAccount(context *cli.Context, authCode string) string {
+	config := gcp.NewOAuthConfig(lib.DefaultConfig.GCPOAuthClientID, lib.DefaultConfig.GCPOAuthClientSecret,
+		lib.DefaultConfig.GCPOAuthAuthURL, lib.DefaultConfig.GCPOAuthTokenURL,
+		gcp.ScopeCloudPrint, gcp.ScopeGoogleTalk)
+
+	token, err := config.Exchange(gcp.OAuthContext(context.Duration("gcp-api-timeout")), authCode)
 	if err != nil {
 		log.Fatalln(err)
 	}
 
+	fmt.Printf("Requested OAuth scopes for robot account: %s\n", strings.Join(config.Scopes, " "))
+	if granted, ok := gcp.TokenScopes(token); !ok {
+		fmt.Println("The OAuth server didn't say which scopes it granted")
+	} else {
+		fmt.Printf("Granted OAuth scopes for robot account: %s\n", strings.Join(granted, " "))
+		if missing := gcp.MissingScopes(config.Scopes, granted); len(missing) > 0 {
+			fmt.Printf("The robot account wasn't granted these scopes, so GCP or XMPP calls may fail: %s\n",
+				strings.Join(missing, " "))
+		}
+	}
+
 	return token.RefreshToken
 }
 
-func createRobotAccount(context *cli.Context, userClient *http.Client) (string, string) {
-	xmppJID, authCode := initRobotAccount(context, userClient)
-	token := verifyRobotAccount(authCode)
+// cupsPrinterAttributes merges the cups-printer-attributes flag with the
+// default CUPS printer attributes.
+func cupsPrinterAttributes(context *cli.Context) []string {
+	attributes, err := mergePrinterAttributes(lib.DefaultConfig.CUPSPrinterAttributes, context.String("cups-printer-attributes"))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	return attributes
+}
+
+// mergePrinterAttributes appends the comma-separated attributes in extra to
+// defaults, skipping duplicates.
+func mergePrinterAttributes(defaults []string, extra string) ([]string, error) {
+	attributes := append([]string{}, defaults...)
+	seen := make(map[string]struct{}, len(defaults))
+	for _, a := range defaults {
+		seen[a] = struct{}{}
+	}
+
+	for _, a := range strings.Split(extra, ",") {
+		a = strings.TrimSpace(a)
+		if a == "" {
+			continue
+		}
+		if !printerAttributeRegexp.MatchString(a) {
+			return nil, fmt.Errorf("Invalid CUPS printer attribute %q", a)
+		}
+		if _, exists := seen[a]; !exists {
+			seen[a] = struct{}{}
+			attributes = append(attributes, a)
+		}
+	}
+
+	return attributes, nil
+}
 
-	return xmppJID, token
+// checkConfigFlags checks the flags that become config values.
+func checkConfigFlags(context *cli.Context, cloudEnable, localEnable bool) error {
+	if !cloudEnable {
+		return createLocalConfig(context).Validate()
+	}
+	if port := context.Int("xmpp-port"); port < 1 || port > math.MaxUint16 {
+		return fmt.Errorf("xmpp-port %d is not between 1 and %d", port, math.MaxUint16)
+	}
+	return createCloudConfig(context, "", "", "", "", "", localEnable).Validate()
 }
 
 // createCloudConfig creates a config object that supports cloud and (optionally) local mode.
 func createCloudConfig(context *cli.Context, xmppJID, robotRefreshToken, userRefreshToken, shareScope, proxyName string, localEnable bool) *lib.Config {
 	return &lib.Config{
-		XMPPJID:                   xmppJID,
-		RobotRefreshToken:         robotRefreshToken,
-		UserRefreshToken:          userRefreshToken,
-		ShareScope:                shareScope,
-		ProxyName:                 proxyName,
-		XMPPServer:                lib.DefaultConfig.XMPPServer,
-		XMPPPort:                  uint16(context.Int("xmpp-port")),
-		XMPPPingTimeout:           context.String("gcp-xmpp-ping-timeout"),
-		XMPPPingInterval:          context.String("gcp-xmpp-ping-interval-default"),
-		GCPBaseURL:                lib.DefaultConfig.GCPBaseURL,
-		GCPOAuthClientID:          lib.DefaultConfig.GCPOAuthClientID,
-		GCPOAuthClientSecret:      lib.DefaultConfig.GCPOAuthClientSecret,
-		GCPOAuthAuthURL:           lib.DefaultConfig.GCPOAuthAuthURL,
-		GCPOAuthTokenURL:          lib.DefaultConfig.GCPOAuthTokenURL,
-		GCPMaxConcurrentDownloads: uint(context.Int("gcp-max-concurrent-downloads")),
+		XMPPJID:                       xmppJID,
+		RobotRefreshToken:             robotRefreshToken,
+		UserRefreshToken:              userRefreshToken,
+		ShareScope:                    shareScope,
+		ProxyName:                     proxyName,
+		ConnectorDisplayName:          context.String("connector-display-name"),
+		XMPPServer:                    lib.DefaultConfig.XMPPServer,
+		XMPPServerFallbacks:           lib.DefaultConfig.XMPPServerFallbacks,
+		XMPPPort:                      uint16(context.Int("xmpp-port")),
+		XMPPPingTimeout:               context.String("gcp-xmpp-ping-timeout"),
+		XMPPPingInterval:              context.String("gcp-xmpp-ping-interval-default"),
+		GCPBaseURL:                    lib.DefaultConfig.GCPBaseURL,
+		GCPOAuthClientID:              lib.DefaultConfig.GCPOAuthClientID,
+		GCPOAuthClientSecret:          lib.DefaultConfig.GCPOAuthClientSecret,
+		GCPOAuthAuthURL:               lib.DefaultConfig.GCPOAuthAuthURL,
+		GCPOAuthTokenURL:              lib.DefaultConfig.GCPOAuthTokenURL,
+		GCPMaxConcurrentDownloads:     uint(context.Int("gcp-max-concurrent-downloads")),
+		GCPAPITimeout:                 context.Duration("gcp-api-timeout").String(),
+		GCPDownloadTimeout:            context.Duration("gcp-download-timeout").String(),
+		AcceptedJobFormats:            lib.DefaultConfig.AcceptedJobFormats,
+		GCPPrinterListRefreshInterval: context.String("gcp-printer-list-refresh-interval"),
+		CloudJobPollInterval:          context.String("cloud-job-poll-interval"),
+		SelfHealInterval:              context.String("self-heal-interval"),
+		MaxRegisteredPrinters:         lib.DefaultConfig.MaxRegisteredPrinters,
+		SyncMaxRetries:                uint(context.Int("sync-max-retries")),
+		SyncRetryBackoff:              context.String("sync-retry-backoff"),
+		CACertFile:                    context.String("ca-cert-file"),
+		MinTLSVersion:                 context.String("min-tls-version"),
+		TokenStore:                    context.String("token-store"),
+		TokenStoreCommand:             context.String("token-store-command"),
 
 		CUPSMaxConnections:           uint(context.Int("cups-max-connections")),
 		CUPSConnectTimeout:           context.String("cups-connect-timeout"),
+		CUPSServerHost:               context.String("cups-server-host"),
+		CUPSServerPort:               uint16(context.Int("cups-server-port")),
+		CUPSEncryption:               context.String("cups-encryption"),
+		CUPSCACertFile:               context.String("cups-ca-cert-file"),
 		CUPSJobQueueSize:             uint(context.Int("cups-job-queue-size")),
+		CUPSJobRetries:               uint(context.Int("cups-job-retries")),
+		CUPSJobMaxStopped:            context.String("cups-job-max-stopped"),
 		CUPSPrinterPollInterval:      context.String("cups-printer-poll-interval"),
-		CUPSPrinterAttributes:        lib.DefaultConfig.CUPSPrinterAttributes,
+		PrinterPollIntervalOverrides: lib.DefaultConfig.PrinterPollIntervalOverrides,
+		MinUpdateInterval:            context.String("min-update-interval"),
+		PrinterAllowlistFile:         context.String("printer-allowlist-file"),
+		PrinterAllowlistInterval:     context.String("printer-allowlist-interval"),
+		DisabledPrinters:             lib.DefaultConfig.DisabledPrinters,
+		ForceReregister:              lib.DefaultConfig.ForceReregister,
+		ReregisterOnUUIDChange:       lib.DefaultConfig.ReregisterOnUUIDChange,
+		CUPSPrinterAttributes:        cupsPrinterAttributes(context),
 		CUPSJobFullUsername:          context.Bool("cups-job-full-username"),
-		CUPSIgnoreRawPrinters:        context.Bool("cups-ignore-raw-printers"),
+		CUPSJobUsernameTemplate:      context.String("cups-job-username-template"),
+		CUPSRawPrinterPolicy:         context.String("cups-raw-printer-policy"),
+		SkipDeviceURISchemes:         lib.DefaultConfig.SkipDeviceURISchemes,
+		CUPSRawPrinterMakeModels:     lib.DefaultConfig.CUPSRawPrinterMakeModels,
+		CUPSMissingPPDIsRaw:          lib.DefaultConfig.CUPSMissingPPDIsRaw,
+		CUPSDriverlessPrinters:       lib.DefaultConfig.CUPSDriverlessPrinters,
+		DefaultMediaSize:             context.String("default-media-size"),
+		StrictNames:                  context.Bool("strict-names"),
+		StrictJobOptions:             context.Bool("strict-job-options"),
+		AllowEmptyCUPSSync:           context.Bool("allow-empty-cups-sync"),
+		ShadowMode:                   context.Bool("shadow-mode"),
+		CapabilityOverrides:          lib.DefaultConfig.CapabilityOverrides,
+		MakeModelOverrides:           lib.DefaultConfig.MakeModelOverrides,
+		PrinterTags:                  lib.DefaultConfig.PrinterTags,
+		PrinterDefaultOptions:        lib.DefaultConfig.PrinterDefaultOptions,
+		PrinterStateReasonMessages:   lib.DefaultConfig.PrinterStateReasonMessages,
 		CopyPrinterInfoToDisplayName: context.Bool("copy-printer-info-to-display-name"),
 		PrefixJobIDToJobTitle:        context.Bool("prefix-job-id-to-job-title"),
+		JobTitleTemplate:             context.String("job-title-template"),
+		JobTitleSanitize:             context.Bool("job-title-sanitize"),
+		JobTitleMaxLength:            uint(context.Int("job-title-max-length")),
+		LogJobTitles:                 context.Bool("log-job-titles"),
 		DisplayNamePrefix:            context.String("display-name-prefix"),
+		DisplayNameSuffix:            context.String("display-name-suffix"),
+		PrinterSetupURL:              context.String("printer-setup-url"),
+		PrinterSupportURL:            context.String("printer-support-url"),
+		PrinterUpdateURL:             context.String("printer-update-url"),
 		MonitorSocketFilename:        context.String("monitor-socket-filename"),
+		DebugPprofAddress:            lib.DefaultConfig.DebugPprofAddress,
+		TempDir:                      context.String("temp-dir"),
+		KeepJobFiles:                 lib.DefaultConfig.KeepJobFiles,
+		KeepJobFilesCount:            lib.DefaultConfig.KeepJobFilesCount,
+		KeepJobFilesMaxAge:           lib.DefaultConfig.KeepJobFilesMaxAge,
+		JobDedupTTL:                  lib.DefaultConfig.JobDedupTTL,
+		StateChangeWebhookURL:        context.String("state-change-webhook-url"),
+		UserAgent:                    context.String("user-agent"),
 		SNMPEnable:                   context.Bool("snmp-enable"),
 		SNMPCommunity:                context.String("snmp-community"),
 		SNMPMaxConnections:           uint(context.Int("snmp-max-connections")),
+		SNMPExtraOIDs:                lib.DefaultConfig.SNMPExtraOIDs,
 		LocalPrintingEnable:          localEnable,
 		CloudPrintingEnable:          true,
 		LogFileName:                  context.String("log-file-name"),
 		LogFileMaxMegabytes:          uint(context.Int("log-file-max-megabytes")),
 		LogMaxFiles:                  uint(context.Int("log-max-files")),
+		LogCompress:                  context.Bool("log-compress"),
 		LogLevel:                     context.String("log-level"),
 	}
 }
@@ -362,25 +807,69 @@ func createCloudConfig(context *cli.Context, xmppJID, robotRefreshToken, userRef
 // createLocalConfig creates a config object that supports local mode.
 func createLocalConfig(context *cli.Context) *lib.Config {
 	return &lib.Config{
+		MinTLSVersion:                context.String("min-tls-version"),
 		CUPSMaxConnections:           uint(context.Int("cups-max-connections")),
 		CUPSConnectTimeout:           context.String("cups-connect-timeout"),
+		CUPSServerHost:               context.String("cups-server-host"),
+		CUPSServerPort:               uint16(context.Int("cups-server-port")),
+		CUPSEncryption:               context.String("cups-encryption"),
+		CUPSCACertFile:               context.String("cups-ca-cert-file"),
 		CUPSJobQueueSize:             uint(context.Int("cups-job-queue-size")),
+		CUPSJobRetries:               uint(context.Int("cups-job-retries")),
+		CUPSJobMaxStopped:            context.String("cups-job-max-stopped"),
 		CUPSPrinterPollInterval:      context.String("cups-printer-poll-interval"),
-		CUPSPrinterAttributes:        lib.DefaultConfig.CUPSPrinterAttributes,
+		PrinterPollIntervalOverrides: lib.DefaultConfig.PrinterPollIntervalOverrides,
+		MinUpdateInterval:            context.String("min-update-interval"),
+		PrinterAllowlistFile:         context.String("printer-allowlist-file"),
+		PrinterAllowlistInterval:     context.String("printer-allowlist-interval"),
+		DisabledPrinters:             lib.DefaultConfig.DisabledPrinters,
+		CUPSPrinterAttributes:        cupsPrinterAttributes(context),
 		CUPSJobFullUsername:          context.Bool("cups-job-full-username"),
-		CUPSIgnoreRawPrinters:        context.Bool("cups-ignore-raw-printers"),
+		CUPSJobUsernameTemplate:      context.String("cups-job-username-template"),
+		CUPSRawPrinterPolicy:         context.String("cups-raw-printer-policy"),
+		SkipDeviceURISchemes:         lib.DefaultConfig.SkipDeviceURISchemes,
+		CUPSRawPrinterMakeModels:     lib.DefaultConfig.CUPSRawPrinterMakeModels,
+		CUPSMissingPPDIsRaw:          lib.DefaultConfig.CUPSMissingPPDIsRaw,
+		CUPSDriverlessPrinters:       lib.DefaultConfig.CUPSDriverlessPrinters,
+		DefaultMediaSize:             context.String("default-media-size"),
+		StrictNames:                  context.Bool("strict-names"),
+		StrictJobOptions:             context.Bool("strict-job-options"),
+		AllowEmptyCUPSSync:           context.Bool("allow-empty-cups-sync"),
+		CapabilityOverrides:          lib.DefaultConfig.CapabilityOverrides,
+		MakeModelOverrides:           lib.DefaultConfig.MakeModelOverrides,
+		PrinterTags:                  lib.DefaultConfig.PrinterTags,
+		PrinterDefaultOptions:        lib.DefaultConfig.PrinterDefaultOptions,
+		PrinterStateReasonMessages:   lib.DefaultConfig.PrinterStateReasonMessages,
 		CopyPrinterInfoToDisplayName: context.Bool("copy-printer-info-to-display-name"),
 		PrefixJobIDToJobTitle:        context.Bool("prefix-job-id-to-job-title"),
+		JobTitleTemplate:             context.String("job-title-template"),
+		JobTitleSanitize:             context.Bool("job-title-sanitize"),
+		JobTitleMaxLength:            uint(context.Int("job-title-max-length")),
+		LogJobTitles:                 context.Bool("log-job-titles"),
 		DisplayNamePrefix:            context.String("display-name-prefix"),
+		DisplayNameSuffix:            context.String("display-name-suffix"),
+		PrinterSetupURL:              context.String("printer-setup-url"),
+		PrinterSupportURL:            context.String("printer-support-url"),
+		PrinterUpdateURL:             context.String("printer-update-url"),
 		MonitorSocketFilename:        context.String("monitor-socket-filename"),
+		DebugPprofAddress:            lib.DefaultConfig.DebugPprofAddress,
+		TempDir:                      context.String("temp-dir"),
+		KeepJobFiles:                 lib.DefaultConfig.KeepJobFiles,
+		KeepJobFilesCount:            lib.DefaultConfig.KeepJobFilesCount,
+		KeepJobFilesMaxAge:           lib.DefaultConfig.KeepJobFilesMaxAge,
+		JobDedupTTL:                  lib.DefaultConfig.JobDedupTTL,
+		StateChangeWebhookURL:        context.String("state-change-webhook-url"),
+		UserAgent:                    context.String("user-agent"),
 		SNMPEnable:                   context.Bool("snmp-enable"),
 		SNMPCommunity:                context.String("snmp-community"),
 		SNMPMaxConnections:           uint(context.Int("snmp-max-connections")),
+		SNMPExtraOIDs:                lib.DefaultConfig.SNMPExtraOIDs,
 		LocalPrintingEnable:          true,
 		CloudPrintingEnable:          false,
 		LogFileName:                  context.String("log-file-name"),
 		LogFileMaxMegabytes:          uint(context.Int("log-file-max-megabytes")),
 		LogMaxFiles:                  uint(context.Int("log-max-files")),
+		LogCompress:                  context.Bool("log-compress"),
 		LogLevel:                     context.String("log-level"),
 	}
 }
@@ -408,6 +897,28 @@ func scanNonEmptyString(prompt string) string {
 	panic("unreachable")
 }
 
+// scanSecretString is like scanNonEmptyString, but doesn't echo the answer
+// when reading from a terminal.
+func scanSecretString(prompt string) string {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return scanNonEmptyString(prompt)
+	}
+
+	for {
+		fmt.Println(prompt)
+		answer, err := term.ReadPassword(fd)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		fmt.Println("")
+		if a := strings.TrimSpace(string(answer)); len(a) > 0 {
+			fmt.Println("")
+			return a
+		}
+	}
+}
+
 func scanYesOrNo(question string) bool {
 	for {
 		var answer string
@@ -424,23 +935,43 @@ func scanYesOrNo(question string) bool {
 
 // The first return value is true if a boolean value could be parsed.
 // The second return value is the parsed boolean value if the first return value is true.
+//
+// Only whole answers are recognized (y, yes, t, true, 1 and n, no, f, false, 0),
+// so that something like "maybe" is not mistaken for yes.
 func stringToBool(val string) (bool, bool) {
-	if len(val) > 0 {
-		switch strings.ToLower(val[0:1]) {
-		case "y", "t", "1":
-			return true, true
-		case "n", "f", "0":
-			return true, false
-		default:
-			return false, true
-		}
+	switch strings.ToLower(strings.TrimSpace(val)) {
+	case "y", "yes", "t", "true", "1":
+		return true, true
+	case "n", "no", "f", "false", "0":
+		return true, false
+	default:
+		return false, false
 	}
-	return false, false
 }
 
 func initConfigFile(context *cli.Context) {
+	answers, err := loadInitAnswers(context)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	proxyNameAnswer := context.String("proxy-name")
+	if answers != nil {
+		proxyNameAnswer = answers.ProxyName
+	}
+
+	trustCACertFile(context.String("ca-cert-file"))
+	setMinTLSVersion(context.String("min-tls-version"))
+	setUserAgent(&lib.Config{UserAgent: context.String("user-agent"), ProxyName: proxyNameAnswer})
+	setupDebugHTTP(context)
+
+	if configFilename, exists := lib.GetConfigFilename(context); exists && !context.Bool("overwrite") {
+		log.Fatalf("The config file %s already exists; use --overwrite to replace it\n", configFilename)
+	}
+
 	var localEnable bool
-	if context.IsSet("local-printing-enable") {
+	if answers != nil {
+		localEnable = *answers.LocalPrintingEnable
+	} else if context.IsSet("local-printing-enable") {
 		localEnable = context.Bool("local-printing-enable")
 	} else {
 		fmt.Println("\"Local printing\" means that clients print directly to the connector via local subnet,")
@@ -449,7 +980,9 @@ func initConfigFile(context *cli.Context) {
 	}
 
 	var cloudEnable bool
-	if context.IsSet("cloud-printing-enable") {
+	if answers != nil {
+		cloudEnable = *answers.CloudPrintingEnable
+	} else if context.IsSet("cloud-printing-enable") {
 		cloudEnable = context.Bool("cloud-printing-enable")
 	} else {
 		fmt.Println("\"Cloud printing\" means that clients can print from anywhere on the Internet,")
@@ -460,38 +993,103 @@ func initConfigFile(context *cli.Context) {
 	if !localEnable && !cloudEnable {
 		log.Fatalln("Try again. Either local or cloud (or both) must be enabled for the connector to do something.")
 	}
+	// Check the flags before the interactive steps, so that a typo doesn't
+	// waste an OAuth flow.
+	if err := checkConfigFlags(context, cloudEnable, localEnable); err != nil {
+		log.Fatalln(err)
+	}
+
+	if cloudEnable {
+		warnClockSkew(context)
+	}
 
 	var config *lib.Config
 
+	var state *initState
 	var xmppJID, robotRefreshToken, userRefreshToken, shareScope, proxyName string
 	if cloudEnable {
-		if context.IsSet("share-scope") {
+		if state, err = getInitState(context, answers); err != nil {
+			log.Fatalln(err)
+		}
+
+		if answers != nil {
+			shareScope = *answers.ShareScope
+		} else if context.IsSet("share-scope") {
 			shareScope = context.String("share-scope")
 		} else if scanYesOrNo("Retain the user OAuth token to enable automatic sharing?") {
 			shareScope = scanNonEmptyString("User or group email address to share with:")
 		}
 
-		if context.IsSet("proxy-name") {
+		if answers != nil {
+			proxyName = answers.ProxyName
+		} else if context.IsSet("proxy-name") {
 			proxyName = context.String("proxy-name")
 		} else {
 			proxyName = scanNonEmptyString("Proxy name for this GCP CUPS Connector:")
 		}
 
-		var userClient *http.Client
-		if context.IsSet("gcp-user-refresh-token") {
-			userClient = getUserClientFromToken(context)
+		store := newInitTokenStore(context)
+		var storedUserRefreshToken string
+		if store != nil {
+			var err error
+			if storedUserRefreshToken, err = store.Load(lib.UserRefreshToken); err != nil {
+				log.Fatalln(err)
+			}
+		}
+
+		if state.started() {
+			if state.ShareScope != shareScope {
+				log.Fatalf("The unfinished init shares with %q, not %q; use --restart to start over\n", state.ShareScope, shareScope)
+			}
+			userRefreshToken = state.UserRefreshToken
+
 		} else {
-			var urt string
-			userClient, urt = getUserClientFromUser(context)
-			if shareScope != "" {
-				userRefreshToken = urt
+			var userClient *http.Client
+			if answers != nil && answers.GCPUserRefreshToken != "" {
+				userClient = getUserClientFromToken(context, answers.GCPUserRefreshToken)
+			} else if context.IsSet("gcp-user-refresh-token") {
+				userClient = getUserClientFromToken(context, context.String("gcp-user-refresh-token"))
+			} else if context.Bool("prompt-gcp-user-refresh-token") {
+				userClient = getUserClientFromToken(context, scanSecretString("GCP user refresh token:"))
+			} else if storedUserRefreshToken != "" {
+				// Already in the token store, so not saved again.
+				userClient = getUserClientFromToken(context, storedUserRefreshToken)
+			} else {
+				var urt string
+				userClient, urt = getUserClientFromUser(context, shareScope)
+				if shareScope != "" {
+					userRefreshToken = urt
+				}
+			}
+
+			state.ShareScope, state.UserRefreshToken = shareScope, userRefreshToken
+			state.XMPPJID, state.AuthCode = initRobotAccount(context, userClient)
+			if err := state.save(); err != nil {
+				fmt.Println(err)
 			}
 		}
 
-		xmppJID, robotRefreshToken = createRobotAccount(context, userClient)
+		// The authorization code can only be exchanged once, so the robot
+		// refresh token is saved as soon as it's known.
+		if state.RobotRefreshToken == "" {
+			state.RobotRefreshToken = verifyRobotAccount(context, state.AuthCode)
+			state.AuthCode = ""
+			if err := state.save(); err != nil {
+				fmt.Println(err)
+			}
+		}
+		xmppJID, robotRefreshToken = state.XMPPJID, state.RobotRefreshToken
 
 		fmt.Println("Acquired OAuth credentials for robot account")
 		fmt.Println("")
+		if store != nil {
+			if saveToken(store, lib.RobotRefreshToken, robotRefreshToken) {
+				robotRefreshToken = ""
+			}
+			if userRefreshToken != "" && saveToken(store, lib.UserRefreshToken, userRefreshToken) {
+				userRefreshToken = ""
+			}
+		}
 		config = createCloudConfig(context, xmppJID, robotRefreshToken, userRefreshToken, shareScope, proxyName, localEnable)
 
 	} else {
@@ -499,6 +1097,9 @@ func initConfigFile(context *cli.Context) {
 	}
 
 	configFilename := writeConfigFile(context, config)
+	if state != nil {
+		state.remove()
+	}
 	fmt.Printf("The config file %s is ready to rock.\n", configFilename)
 	if cloudEnable {
 		fmt.Println("Keep it somewhere safe, as it contains an OAuth refresh token.")
@@ -507,6 +1108,12 @@ func initConfigFile(context *cli.Context) {
 	socketDirectory := filepath.Dir(context.String("monitor-socket-filename"))
 	if _, err := os.Stat(socketDirectory); os.IsNotExist(err) {
 		fmt.Println("")
-		fmt.Printf("When the connector runs, be sure the socket directory %s exists.\n", socketDirectory)
+		if !context.Bool("create-socket-dir") {
+			fmt.Printf("The connector will create the socket directory %s when it runs.\n", socketDirectory)
+		} else if err = os.MkdirAll(socketDirectory, 0755); err != nil {
+			fmt.Printf("Failed to create the socket directory %s: %s\n", socketDirectory, err)
+		} else {
+			fmt.Printf("Created the socket directory %s\n", socketDirectory)
+		}
 	}
 }
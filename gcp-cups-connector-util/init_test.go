@@ -0,0 +1,154 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStringToBool(t *testing.T) {
+	cases := []struct {
+		input  string
+		parsed bool
+		value  bool
+	}{
+		{"y", true, true},
+		{"Y", true, true},
+		{"yes", true, true},
+		{"YES", true, true},
+		{"t", true, true},
+		{"true", true, true},
+		{"1", true, true},
+		{" yes ", true, true},
+		{"n", true, false},
+		{"No", true, false},
+		{"f", true, false},
+		{"false", true, false},
+		{"0", true, false},
+		{"", false, false},
+		{"maybe", false, false},
+		{"yep", false, false},
+		{"nope", false, false},
+		{"10", false, false},
+		{"truthy", false, false},
+	}
+
+	for _, c := range cases {
+		parsed, value := stringToBool(c.input)
+		if parsed != c.parsed || (parsed && value != c.value) {
+			t.Logf("input %q: expected %t, %t, got %t, %t", c.input, c.parsed, c.value, parsed, value)
+			t.Fail()
+		}
+	}
+}
+
+func TestMergePrinterAttributes(t *testing.T) {
+	defaults := []string{"printer-name", "printer-info"}
+
+	attributes, err := mergePrinterAttributes(defaults, "printer-location, printer-name,,com.example-key")
+	if err != nil {
+		t.Logf("unexpected error: %s", err)
+		t.Fail()
+	}
+	expected := []string{"printer-name", "printer-info", "printer-location", "com.example-key"}
+	if strings.Join(attributes, ",") != strings.Join(expected, ",") {
+		t.Logf("expected %v, got %v", expected, attributes)
+		t.Fail()
+	}
+	if len(defaults) != 2 {
+		t.Logf("defaults were modified: %v", defaults)
+		t.Fail()
+	}
+
+	for _, extra := range []string{"printer name", "Printer-Name", "-printer", "printer;info"} {
+		if _, err := mergePrinterAttributes(defaults, extra); err == nil {
+			t.Logf("expected error for %q", extra)
+			t.Fail()
+		}
+	}
+}
+
+func TestOAuthPollBackoff(t *testing.T) {
+	b := newOAuthPollBackoff(5 * time.Second)
+
+	b.slowDown()
+	b.slowDown()
+	if b.interval != 20*time.Second {
+		t.Logf("expected 20s after two slow_down, got %s", b.interval)
+		t.Fail()
+	}
+
+	for i := 0; i < 100; i++ {
+		b.slowDown()
+	}
+	if b.interval != gcpOAuthPollMaxInterval {
+		t.Logf("expected %s after many slow_down, got %s", gcpOAuthPollMaxInterval, b.interval)
+		t.Fail()
+	}
+
+	for i := 0; i < 100; i++ {
+		b.pending()
+	}
+	if b.interval != 5*time.Second {
+		t.Logf("expected 5s after many authorization_pending, got %s", b.interval)
+		t.Fail()
+	}
+
+	b = newOAuthPollBackoff(0)
+	if b.interval != gcpOAuthPollMinInterval {
+		t.Logf("expected %s for interval 0, got %s", gcpOAuthPollMinInterval, b.interval)
+		t.Fail()
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2015, 10, 21, 7, 28, 0, 0, time.UTC)
+	fallback := 2 * time.Second
+
+	testCases := []struct {
+		header   string
+		expected time.Duration
+	}{
+		{"", fallback},
+		{"30", 30 * time.Second},
+		{" 0 ", 0},
+		{"-5", fallback},
+		{"soon", fallback},
+		{"Wed, 21 Oct 2015 07:29:00 GMT", time.Minute},
+		{"Wed, 21 Oct 2015 07:27:00 GMT", 0},
+	}
+	for _, tc := range testCases {
+		if wait := retryAfter(tc.header, fallback, now); wait != tc.expected {
+			t.Logf("expected %s for %q, got %s", tc.expected, tc.header, wait)
+			t.Fail()
+		}
+	}
+
+	if wait := retryAfter("99999999999999999", fallback, now); wait <= 0 {
+		t.Logf("expected a long wait for a huge header, got %s", wait)
+		t.Fail()
+	}
+}
+
+func TestCreateRobotRetryable(t *testing.T) {
+	for _, statusCode := range []int{429, 500, 503} {
+		if !createRobotRetryable(statusCode) {
+			t.Logf("expected %d to be retryable", statusCode)
+			t.Fail()
+		}
+	}
+	for _, statusCode := range []int{400, 401, 403, 404} {
+		if createRobotRetryable(statusCode) {
+			t.Logf("expected %d not to be retryable", statusCode)
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,145 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/codegangsta/cli"
+	"github.com/google/cups-connector/lib"
+)
+
+// The state of an unfinished init is kept next to the config file that the
+// init writes, in a file named after it with this suffix, so that inits of
+// different config files don't resume each other.
+const initStateSuffix = ".init-state"
+
+// initState holds what init got from GCP so far, so that an init that fails
+// after creating the robot account, like when the authorization code can't be
+// exchanged or the config file can't be written, can be resumed with the same
+// robot account instead of leaving it orphaned. It holds OAuth credentials, so
+// the file is only readable by its owner, and a file owned by another user is
+// refused.
+type initState struct {
+	filename string
+
+	ShareScope        string `json:"share_scope"`
+	UserRefreshToken  string `json:"user_refresh_token,omitempty"`
+	XMPPJID           string `json:"xmpp_jid"`
+	AuthCode          string `json:"authorization_code,omitempty"`
+	RobotRefreshToken string `json:"robot_refresh_token,omitempty"`
+}
+
+// loadInitState reads the state saved in filename. A missing file is an
+// empty state.
+func loadInitState(filename string) (*initState, error) {
+	state := initState{filename: filename}
+	info, err := os.Lstat(filename)
+	if os.IsNotExist(err) {
+		return &state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read init state: %s", err)
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("Init state %s is not a regular file; remove it to start over", filename)
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); !ok || int(stat.Uid) != os.Getuid() {
+		return nil, fmt.Errorf("Init state %s is not owned by the current user; remove it to start over", filename)
+	}
+
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read init state: %s", err)
+	}
+	if err = json.Unmarshal(b, &state); err != nil {
+		return nil, fmt.Errorf("Failed to parse init state %s: %s", filename, err)
+	}
+	return &state, nil
+}
+
+// started reports whether a robot account was created.
+func (s *initState) started() bool {
+	return s.XMPPJID != ""
+}
+
+// save writes the state to a new file, created exclusively, then renames it
+// over the saved state, so that neither an existing file nor a symlink is
+// written through.
+func (s *initState) save() error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(s.filename), filepath.Base(s.filename))
+	if err != nil {
+		return fmt.Errorf("Failed to save init state: %s", err)
+	}
+	_, err = f.Write(b)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), s.filename)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("Failed to save init state: %s", err)
+	}
+	return nil
+}
+
+// remove deletes the saved state, once init is done with it.
+func (s *initState) remove() {
+	if err := os.Remove(s.filename); err != nil && !os.IsNotExist(err) {
+		fmt.Printf("Failed to remove init state %s: %s\n", s.filename, err)
+	}
+}
+
+// getInitState loads the state of an unfinished init, then decides, by the
+// resume and restart flags or by asking, whether to resume it. Returns an
+// empty state when not resuming.
+func getInitState(context *cli.Context, answers *initAnswers) (*initState, error) {
+	if context.Bool("resume") && context.Bool("restart") {
+		return nil, errors.New("--resume cannot be used with --restart")
+	}
+
+	configFilename, _ := lib.GetConfigFilename(context)
+	filename := configFilename + initStateSuffix
+	if context.Bool("restart") {
+		return &initState{filename: filename}, nil
+	}
+	state, err := loadInitState(filename)
+	if err != nil {
+		return nil, err
+	}
+	if !state.started() {
+		if context.Bool("resume") {
+			fmt.Println("There is no unfinished init to resume; starting a new one")
+		}
+		return state, nil
+	}
+
+	// Without prompts, resume, so that retries don't orphan robot accounts.
+	if !context.Bool("resume") && answers == nil {
+		fmt.Printf("An earlier init created the robot account %s, but didn't finish.\n", state.XMPPJID)
+		if !scanYesOrNo("Resume it, reusing that robot account?") {
+			return &initState{filename: filename}, nil
+		}
+	}
+	fmt.Printf("Resuming the init of robot account %s\n", state.XMPPJID)
+	return state, nil
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInitState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "initstate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "gcp-cups-connector.config.json"+initStateSuffix)
+
+	state, err := loadInitState(filename)
+	if err != nil {
+		t.Fatalf("expected a missing state file to be an empty state, got %s", err)
+	}
+	if state.started() {
+		t.Logf("expected an empty state to not be started, got %+v", state)
+		t.Fail()
+	}
+
+	state.ShareScope = "admins@example.com"
+	state.XMPPJID = "robot@cloudprint.example.com"
+	state.AuthCode = "code"
+	if err = state.save(); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(filename); err != nil || info.Mode().Perm() != 0600 {
+		t.Logf("expected the state file to be readable only by its owner, got %v, %v", info, err)
+		t.Fail()
+	}
+
+	loaded, err := loadInitState(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *loaded != *state {
+		t.Logf("expected %+v, got %+v", state, loaded)
+		t.Fail()
+	}
+
+	if err = os.Chmod(filename, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = loaded.save(); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(filename); err != nil || info.Mode().Perm() != 0600 {
+		t.Logf("expected the saved state to replace the file, got %v, %v", info, err)
+		t.Fail()
+	}
+
+	loaded.remove()
+	if _, err = os.Stat(filename); !os.IsNotExist(err) {
+		t.Logf("expected the state file to be removed, got %v", err)
+		t.Fail()
+	}
+}
+
+func TestInitStateSymlink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "initstate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "gcp-cups-connector.config.json"+initStateSuffix)
+	target := filepath.Join(dir, "target")
+	if err = ioutil.WriteFile(target, []byte(`{"xmpp_jid": "robot@cloudprint.example.com"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Symlink(target, filename); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = loadInitState(filename); err == nil {
+		t.Log("expected a symlinked state file to be refused")
+		t.Fail()
+	}
+
+	state := &initState{filename: filename, XMPPJID: "other@cloudprint.example.com"}
+	if err = state.save(); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadFile(target); err != nil || string(b) != `{"xmpp_jid": "robot@cloudprint.example.com"}` {
+		t.Logf("expected the symlink target to be left alone, got %q, %v", b, err)
+		t.Fail()
+	}
+	if info, err := os.Lstat(filename); err != nil || !info.Mode().IsRegular() {
+		t.Logf("expected the symlink to be replaced by the state file, got %v, %v", info, err)
+		t.Fail()
+	}
+}
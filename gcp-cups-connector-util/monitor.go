@@ -9,11 +9,14 @@ https://developers.google.com/open-source/licenses/bsd
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/codegangsta/cli"
@@ -21,12 +24,104 @@ import (
 )
 
 func monitorConnector(context *cli.Context) {
+	buf := requestMonitor(context, lib.MonitorRequestStats)
+
+	warnings := os.Stdout
+	if context.Bool("json") {
+		printMonitorJSON(buf, requestMonitor(context, lib.MonitorRequestSupplies))
+		warnings = os.Stderr
+	} else {
+		fmt.Printf(string(buf))
+	}
+
+	if maxAge := context.Duration("max-sync-age"); maxAge > 0 {
+		if age, ok := lastSyncAge(string(buf)); ok && age > maxAge {
+			fmt.Fprintf(warnings, "WARNING: the last printer sync was %s ago, longer than %s\n", age, maxAge)
+		}
+	}
+}
+
+// printMonitorJSON prints monitor stats, the connector's labels, and supplies
+// when the connector sent them as JSON, as one JSON object.
+func printMonitorJSON(stats, supplies []byte) {
+	output := struct {
+		Stats    map[string]string `json:"stats"`
+		Labels   map[string]string `json:"labels,omitempty"`
+		Supplies json.RawMessage   `json:"supplies,omitempty"`
+	}{Stats: parseStats(string(stats))}
+	if labels, exists := output.Stats["connector-labels"]; exists {
+		json.Unmarshal([]byte(labels), &output.Labels)
+	}
+	if json.Valid(supplies) {
+		output.Supplies = supplies
+	}
+
+	b, err := json.MarshalIndent(output, "", "  ")
+	if err != nil {
+		log.Fatalln(err)
+	}
+	fmt.Println(string(b))
+}
+
+// parseStats parses key=value monitor stats lines.
+func parseStats(stats string) map[string]string {
+	values := make(map[string]string)
+	for _, line := range strings.Split(stats, "\n") {
+		eq := strings.Index(line, "=")
+		if eq <= 0 {
+			continue
+		}
+		if brace := strings.Index(line, "{"); brace >= 0 && brace < eq {
+			// Labels, like job-count{printer="a=b"}, may contain =, and
+			// labeled stats have numeric values, so the last }= ends them.
+			if end := strings.LastIndex(line, "}="); end > brace {
+				eq = end + 1
+			}
+		}
+		values[line[:eq]] = line[eq+1:]
+	}
+	return values
+}
+
+// inventoryConnector prints the printers known to a running connector, as
+// JSON.
+func inventoryConnector(context *cli.Context) {
+	buf := requestMonitor(context, lib.MonitorRequestInventory)
+	if !json.Valid(buf) {
+		log.Fatalf("The connector did not send an inventory; it may be too old: %s\n", buf)
+	}
+	os.Stdout.Write(buf)
+}
+
+// refreshPPD asks a running connector to drop its cached PPD of a printer,
+// so that the next poll fetches and translates it again.
+func refreshPPD(context *cli.Context) {
+	if len(context.Args()) != 1 {
+		log.Fatalln("Usage: refresh-ppd PRINTER")
+	}
+	printerName := context.Args()[0]
+
+	buf := requestMonitor(context, lib.MonitorRequestRefreshPPD+" "+printerName)
+	removed, ok := parseStats(string(buf))["ppd-removed"]
+	if !ok {
+		log.Fatalf("The connector did not refresh the PPD; it may be too old: %s\n", buf)
+	}
+	if removed == "true" {
+		fmt.Printf("Removed the cached PPD of %s; the next poll fetches and translates it again\n", printerName)
+	} else {
+		fmt.Printf("The PPD of %s was not cached; the next poll fetches and translates it\n", printerName)
+	}
+}
+
+// requestMonitor sends request to the monitor socket of a running connector,
+// and returns the response.
+func requestMonitor(context *cli.Context, request string) []byte {
 	config, filename, err := lib.GetConfig(context)
 	if err != nil {
 		log.Fatalf("Failed to read config file: %s\n", err)
 	}
 	if filename == "" {
-		fmt.Println("No config file was found, so using defaults")
+		fmt.Fprintln(os.Stderr, "No config file was found, so using defaults")
 	}
 
 	if _, err := os.Stat(config.MonitorSocketFilename); err != nil {
@@ -50,6 +145,12 @@ func monitorConnector(context *cli.Context) {
 	}
 	defer conn.Close()
 
+	// Connectors that predate requests ignore this, and send their stats.
+	if _, err = fmt.Fprintln(conn, request); err != nil {
+		log.Fatalln(err)
+	}
+	conn.(*net.UnixConn).CloseWrite()
+
 	buf, err := ioutil.ReadAll(conn)
 	if err != nil {
 		log.Fatalln(err)
@@ -57,5 +158,21 @@ func monitorConnector(context *cli.Context) {
 
 	timer.Stop()
 
-	fmt.Printf(string(buf))
+	return buf
+}
+
+// lastSyncAge finds the last-sync-age-seconds value in monitor stats. There is
+// none, like "never", before the connector's first sync.
+func lastSyncAge(stats string) (time.Duration, bool) {
+	for _, line := range strings.Split(stats, "\n") {
+		if !strings.HasPrefix(line, "last-sync-age-seconds=") {
+			continue
+		}
+		seconds, err := strconv.ParseInt(strings.TrimPrefix(line, "last-sync-age-seconds="), 10, 64)
+		if err != nil {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	return 0, false
 }
@@ -0,0 +1,52 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLastSyncAge(t *testing.T) {
+	for stats, expected := range map[string]time.Duration{
+		"jobs-done=1\nlast-sync-age-seconds=42\n":                        42 * time.Second,
+		"connector-labels={\"site\":\"nyc\"}\nlast-sync-age-seconds=7\n": 7 * time.Second,
+	} {
+		if age, ok := lastSyncAge(stats); !ok || age != expected {
+			t.Logf("expected %s from %q, got %s, %t", expected, stats, age, ok)
+			t.Fail()
+		}
+	}
+
+	if age, ok := lastSyncAge("jobs-done=1\n"); ok {
+		t.Logf("expected no age without last-sync-age-seconds, got %s", age)
+		t.Fail()
+	}
+	if age, ok := lastSyncAge("last-sync=never\nlast-sync-age-seconds=never\n"); ok {
+		t.Logf("expected no age before the first sync, got %s", age)
+		t.Fail()
+	}
+}
+
+func TestParseStats(t *testing.T) {
+	stats := "connector-version=1\n" +
+		"connector-labels={\"site\":\"a}=b\",\"env\":\"x=y\"}\n" +
+		"job-count{printer=\"a}=b\",state=\"done\"}=3\n" +
+		"no-value\n"
+	expected := map[string]string{
+		"connector-version":                          "1",
+		"connector-labels":                           "{\"site\":\"a}=b\",\"env\":\"x=y\"}",
+		"job-count{printer=\"a}=b\",state=\"done\"}": "3",
+	}
+	if values := parseStats(stats); !reflect.DeepEqual(values, expected) {
+		t.Logf("expected %v, got %v", expected, values)
+		t.Fail()
+	}
+}
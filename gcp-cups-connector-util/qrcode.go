@@ -0,0 +1,525 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/codegangsta/cli"
+	"golang.org/x/term"
+)
+
+// A minimal QR code encoder, for the OAuth verification URL: byte mode, low
+// error correction, versions 1 to 10, which hold up to 271 bytes.
+
+var qrFlag = cli.BoolFlag{
+	Name:  "qr",
+	Usage: "Also print the OAuth verification URL as a QR code, to scan with a phone",
+}
+
+// printQRCode prints content as a QR code, unless stdout is a terminal too
+// narrow to show it whole.
+func printQRCode(content string) {
+	q, err := newQRCode(content)
+	if err != nil {
+		log.Printf("Failed to create QR code: %s", err)
+		return
+	}
+	fd := int(os.Stdout.Fd())
+	if term.IsTerminal(fd) {
+		if width, _, err := term.GetSize(fd); err == nil && width < q.width() {
+			fmt.Printf("The terminal is too narrow for the QR code, which needs %d columns\n", q.width())
+			return
+		}
+	}
+	fmt.Print(q)
+}
+
+// qrVersion describes the error correction blocks of a version, at low error
+// correction. Blocks of group 2, if any, have one more data codeword.
+type qrVersion struct {
+	ecPerBlock int
+	blocks1    int
+	dataBlock1 int
+	blocks2    int
+	alignments []int
+}
+
+var qrVersions = []qrVersion{
+	1:  {7, 1, 19, 0, nil},
+	2:  {10, 1, 34, 0, []int{6, 18}},
+	3:  {15, 1, 55, 0, []int{6, 22}},
+	4:  {20, 1, 80, 0, []int{6, 26}},
+	5:  {26, 1, 108, 0, []int{6, 30}},
+	6:  {18, 2, 68, 0, []int{6, 34}},
+	7:  {20, 2, 78, 0, []int{6, 22, 38}},
+	8:  {24, 2, 97, 0, []int{6, 24, 42}},
+	9:  {30, 2, 116, 0, []int{6, 26, 46}},
+	10: {18, 2, 68, 2, []int{6, 28, 50}},
+}
+
+func (v qrVersion) dataCodewords() int {
+	return v.blocks1*v.dataBlock1 + v.blocks2*(v.dataBlock1+1)
+}
+
+// qrCode is a QR code matrix; true modules are dark.
+type qrCode struct {
+	size       int
+	modules    [][]bool
+	isFunction [][]bool
+}
+
+// newQRCode encodes content in the smallest version that holds it, with the
+// mask that makes it easiest to scan.
+func newQRCode(content string) (*qrCode, error) {
+	version := 0
+	for v := 1; v < len(qrVersions); v++ {
+		countBits := 8
+		if v >= 10 {
+			countBits = 16
+		}
+		if 4+countBits+8*len(content) <= 8*qrVersions[v].dataCodewords() {
+			version = v
+			break
+		}
+	}
+	if version == 0 {
+		return nil, errors.New("Too long for a QR code")
+	}
+
+	codewords := qrCodewords(qrEncodeData(content, version), qrVersions[version])
+
+	var best *qrCode
+	bestPenalty := -1
+	for mask := 0; mask < 8; mask++ {
+		q := newQRFunctionPatterns(version, mask)
+		q.placeCodewords(codewords)
+		q.applyMask(mask)
+		if penalty := q.penalty(); bestPenalty < 0 || penalty < bestPenalty {
+			best, bestPenalty = q, penalty
+		}
+	}
+	return best, nil
+}
+
+// qrEncodeData encodes content in byte mode, padded to the data capacity of
+// version.
+func qrEncodeData(content string, version int) []byte {
+	var bits []bool
+	appendBits := func(value uint, n int) {
+		for i := n - 1; i >= 0; i-- {
+			bits = append(bits, value>>uint(i)&1 == 1)
+		}
+	}
+
+	appendBits(4, 4)
+	if version >= 10 {
+		appendBits(uint(len(content)), 16)
+	} else {
+		appendBits(uint(len(content)), 8)
+	}
+	for i := 0; i < len(content); i++ {
+		appendBits(uint(content[i]), 8)
+	}
+
+	capacity := 8 * qrVersions[version].dataCodewords()
+	for i := 0; i < 4 && len(bits) < capacity; i++ {
+		bits = append(bits, false)
+	}
+	for len(bits)%8 != 0 {
+		bits = append(bits, false)
+	}
+	for pad := uint(0xEC); len(bits) < capacity; pad ^= 0xEC ^ 0x11 {
+		appendBits(pad, 8)
+	}
+
+	data := make([]byte, len(bits)/8)
+	for i, bit := range bits {
+		if bit {
+			data[i/8] |= 0x80 >> uint(i%8)
+		}
+	}
+	return data
+}
+
+// qrCodewords splits data into blocks, adds error correction to each, then
+// interleaves them.
+func qrCodewords(data []byte, v qrVersion) []byte {
+	var blocks, ecBlocks [][]byte
+	for i, offset := 0, 0; i < v.blocks1+v.blocks2; i++ {
+		n := v.dataBlock1
+		if i >= v.blocks1 {
+			n++
+		}
+		blocks = append(blocks, data[offset:offset+n])
+		ecBlocks = append(ecBlocks, qrErrorCorrection(data[offset:offset+n], v.ecPerBlock))
+		offset += n
+	}
+
+	var codewords []byte
+	for i := 0; i <= v.dataBlock1; i++ {
+		for _, block := range blocks {
+			if i < len(block) {
+				codewords = append(codewords, block[i])
+			}
+		}
+	}
+	for i := 0; i < v.ecPerBlock; i++ {
+		for _, ec := range ecBlocks {
+			codewords = append(codewords, ec[i])
+		}
+	}
+	return codewords
+}
+
+// qrMultiply multiplies in GF(256), modulo x^8 + x^4 + x^3 + x^2 + 1.
+func qrMultiply(x, y byte) byte {
+	var z byte
+	for i := 7; i >= 0; i-- {
+		carry := z & 0x80
+		z <<= 1
+		if carry != 0 {
+			z ^= 0x1D
+		}
+		if y>>uint(i)&1 == 1 {
+			z ^= x
+		}
+	}
+	return z
+}
+
+// qrErrorCorrection computes the n Reed-Solomon error correction codewords
+// of data.
+func qrErrorCorrection(data []byte, n int) []byte {
+	// The generator polynomial, (x - 2^0)(x - 2^1)...(x - 2^(n-1)), without
+	// its leading 1.
+	generator := make([]byte, n)
+	generator[n-1] = 1
+	root := byte(1)
+	for i := 0; i < n; i++ {
+		for j := 0; j < n; j++ {
+			generator[j] = qrMultiply(generator[j], root)
+			if j+1 < n {
+				generator[j] ^= generator[j+1]
+			}
+		}
+		root = qrMultiply(root, 2)
+	}
+
+	remainder := make([]byte, n)
+	for _, b := range data {
+		factor := b ^ remainder[0]
+		copy(remainder, remainder[1:])
+		remainder[n-1] = 0
+		for j := range remainder {
+			remainder[j] ^= qrMultiply(generator[j], factor)
+		}
+	}
+	return remainder
+}
+
+// newQRFunctionPatterns draws the finder, timing and alignment patterns, and
+// the format and version information, of version with mask.
+func newQRFunctionPatterns(version, mask int) *qrCode {
+	size := 4*version + 17
+	q := qrCode{size: size, modules: make([][]bool, size), isFunction: make([][]bool, size)}
+	for y := range q.modules {
+		q.modules[y] = make([]bool, size)
+		q.isFunction[y] = make([]bool, size)
+	}
+
+	for i := 0; i < size; i++ {
+		q.setFunction(6, i, i%2 == 0)
+		q.setFunction(i, 6, i%2 == 0)
+	}
+
+	for _, center := range [][2]int{{3, 3}, {size - 4, 3}, {3, size - 4}} {
+		for dy := -4; dy <= 4; dy++ {
+			for dx := -4; dx <= 4; dx++ {
+				x, y := center[0]+dx, center[1]+dy
+				if x < 0 || x >= size || y < 0 || y >= size {
+					continue
+				}
+				d := qrMax(qrAbs(dx), qrAbs(dy))
+				q.setFunction(x, y, d != 2 && d != 4)
+			}
+		}
+	}
+
+	alignments := qrVersions[version].alignments
+	last := len(alignments) - 1
+	for i, cx := range alignments {
+		for j, cy := range alignments {
+			if i == 0 && j == 0 || i == 0 && j == last || i == last && j == 0 {
+				// Overlaps a finder pattern.
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					q.setFunction(cx+dx, cy+dy, qrMax(qrAbs(dx), qrAbs(dy)) != 1)
+				}
+			}
+		}
+	}
+
+	format := qrFormatBits(mask)
+	bit := func(i int) bool { return format>>uint(i)&1 == 1 }
+	for i := 0; i < 6; i++ {
+		q.setFunction(8, i, bit(i))
+	}
+	q.setFunction(8, 7, bit(6))
+	q.setFunction(8, 8, bit(7))
+	q.setFunction(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		q.setFunction(14-i, 8, bit(i))
+	}
+	for i := 0; i < 8; i++ {
+		q.setFunction(size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		q.setFunction(8, size-15+i, bit(i))
+	}
+	q.setFunction(8, size-8, true)
+
+	if version >= 7 {
+		info := qrVersionBits(version)
+		for i := 0; i < 18; i++ {
+			dark := info>>uint(i)&1 == 1
+			a, b := size-11+i%3, i/3
+			q.setFunction(a, b, dark)
+			q.setFunction(b, a, dark)
+		}
+	}
+
+	return &q
+}
+
+// qrFormatBits computes the format information of low error correction with
+// mask.
+func qrFormatBits(mask int) uint {
+	data := uint(1<<3 | mask)
+	remainder := data
+	for i := 0; i < 10; i++ {
+		remainder = remainder<<1 ^ (remainder>>9)*0x537
+	}
+	return (data<<10 | remainder) ^ 0x5412
+}
+
+// qrVersionBits computes the version information of version.
+func qrVersionBits(version int) uint {
+	remainder := uint(version)
+	for i := 0; i < 12; i++ {
+		remainder = remainder<<1 ^ (remainder>>11)*0x1F25
+	}
+	return uint(version)<<12 | remainder
+}
+
+func (q *qrCode) setFunction(x, y int, dark bool) {
+	q.modules[y][x] = dark
+	q.isFunction[y][x] = true
+}
+
+// placeCodewords fills the modules that aren't function patterns with
+// codewords, in the zigzag order of the QR code specification. Modules left
+// over are the remainder bits, which are light.
+func (q *qrCode) placeCodewords(codewords []byte) {
+	i := 0
+	for right := q.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			// Skip the vertical timing pattern.
+			right = 5
+		}
+		upward := (right+1)&2 == 0
+		for vert := 0; vert < q.size; vert++ {
+			y := vert
+			if upward {
+				y = q.size - 1 - vert
+			}
+			for j := 0; j < 2; j++ {
+				x := right - j
+				if q.isFunction[y][x] || i >= 8*len(codewords) {
+					continue
+				}
+				q.modules[y][x] = codewords[i/8]>>uint(7-i%8)&1 == 1
+				i++
+			}
+		}
+	}
+}
+
+func (q *qrCode) applyMask(mask int) {
+	for y := 0; y < q.size; y++ {
+		for x := 0; x < q.size; x++ {
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if invert && !q.isFunction[y][x] {
+				q.modules[y][x] = !q.modules[y][x]
+			}
+		}
+	}
+}
+
+// penalty scores how hard the code is to scan, by the rules of the QR code
+// specification; the lower the better.
+func (q *qrCode) penalty() int {
+	at := func(x, y int, vertical bool) bool {
+		if vertical {
+			return q.modules[x][y]
+		}
+		return q.modules[y][x]
+	}
+	finderLike := []bool{true, false, true, true, true, false, true}
+
+	penalty := 0
+	for _, vertical := range []bool{false, true} {
+		for y := 0; y < q.size; y++ {
+			// Runs of five or more modules of the same color.
+			run := 1
+			for x := 1; x < q.size; x++ {
+				if at(x, y, vertical) == at(x-1, y, vertical) {
+					run++
+					continue
+				}
+				if run >= 5 {
+					penalty += run - 2
+				}
+				run = 1
+			}
+			if run >= 5 {
+				penalty += run - 2
+			}
+
+			// Patterns like the finder patterns, with four light modules
+			// on either side.
+			for x := 0; x+7 <= q.size; x++ {
+				matches := true
+				for i, dark := range finderLike {
+					if at(x+i, y, vertical) != dark {
+						matches = false
+						break
+					}
+				}
+				if !matches {
+					continue
+				}
+				if q.lightRun(x-4, x, y, vertical) || q.lightRun(x+7, x+11, y, vertical) {
+					penalty += 40
+				}
+			}
+		}
+	}
+
+	// 2x2 blocks of the same color.
+	dark := 0
+	for y := 0; y < q.size; y++ {
+		for x := 0; x < q.size; x++ {
+			if q.modules[y][x] {
+				dark++
+			}
+			if x > 0 && y > 0 {
+				c := q.modules[y][x]
+				if c == q.modules[y-1][x] && c == q.modules[y][x-1] && c == q.modules[y-1][x-1] {
+					penalty += 3
+				}
+			}
+		}
+	}
+
+	// Proportion of dark modules away from half.
+	percent := dark * 100 / (q.size * q.size)
+	penalty += qrAbs(percent-50) / 5 * 10
+
+	return penalty
+}
+
+// lightRun checks whether the modules from start to end, excluded, of a row or
+// column are light. Modules outside the code, in the quiet zone, are light.
+func (q *qrCode) lightRun(start, end, y int, vertical bool) bool {
+	for x := start; x < end; x++ {
+		if x < 0 || x >= q.size {
+			continue
+		}
+		if vertical && q.modules[x][y] || !vertical && q.modules[y][x] {
+			return false
+		}
+	}
+	return true
+}
+
+// qrQuietZone is the width of the light border around a QR code, in modules.
+const qrQuietZone = 4
+
+// width is the width of the rendered code, in terminal columns.
+func (q *qrCode) width() int {
+	return q.size + 2*qrQuietZone
+}
+
+// String renders the code with Unicode half blocks, two modules per line, for
+// terminals with a dark background: light modules are drawn and dark ones
+// are blank.
+func (q *qrCode) String() string {
+	light := func(x, y int) bool {
+		x, y = x-qrQuietZone, y-qrQuietZone
+		return x < 0 || y < 0 || x >= q.size || y >= q.size || !q.modules[y][x]
+	}
+
+	var s bytes.Buffer
+	for y := 0; y < q.width(); y += 2 {
+		for x := 0; x < q.width(); x++ {
+			top, bottom := light(x, y), y+1 < q.width() && light(x, y+1)
+			switch {
+			case top && bottom:
+				s.WriteString("█")
+			case top:
+				s.WriteString("▀")
+			case bottom:
+				s.WriteString("▄")
+			default:
+				s.WriteString(" ")
+			}
+		}
+		s.WriteString("\n")
+	}
+	return s.String()
+}
+
+func qrAbs(i int) int {
+	if i < 0 {
+		return -i
+	}
+	return i
+}
+
+func qrMax(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// The version 1-M example of the QR code specification, annex I.
+func TestQRErrorCorrection(t *testing.T) {
+	data := []byte{0x10, 0x20, 0x0c, 0x56, 0x61, 0x80, 0xec, 0x11, 0xec, 0x11, 0xec, 0x11, 0xec, 0x11, 0xec, 0x11}
+	expected := []byte{0xa5, 0x24, 0xd4, 0xc1, 0xed, 0x36, 0xc7, 0x87, 0x2c, 0x55}
+	if ec := qrErrorCorrection(data, 10); !bytes.Equal(ec, expected) {
+		t.Logf("expected % x, got % x", expected, ec)
+		t.Fail()
+	}
+}
+
+func TestQRFormatAndVersionBits(t *testing.T) {
+	for mask, expected := range []uint{0x77c4, 0x72f3, 0x7daa, 0x789d, 0x662f, 0x6318, 0x6c41, 0x6976} {
+		if bits := qrFormatBits(mask); bits != expected {
+			t.Logf("expected format bits %x for mask %d, got %x", expected, mask, bits)
+			t.Fail()
+		}
+	}
+	for version, expected := range map[int]uint{7: 0x07c94, 8: 0x085bc, 10: 0x0a4d3} {
+		if bits := qrVersionBits(version); bits != expected {
+			t.Logf("expected version bits %x for version %d, got %x", expected, version, bits)
+			t.Fail()
+		}
+	}
+}
+
+func TestNewQRCode(t *testing.T) {
+	for content, expectedSize := range map[string]int{
+		"https://www.google.com/device": 25,
+		strings.Repeat("a", 17):         21,
+		strings.Repeat("a", 18):         25,
+		strings.Repeat("a", 271):        57,
+	} {
+		q, err := newQRCode(content)
+		if err != nil {
+			t.Logf("unexpected error for %d bytes: %s", len(content), err)
+			t.Fail()
+			continue
+		}
+		if q.size != expectedSize {
+			t.Logf("expected size %d for %d bytes, got %d", expectedSize, len(content), q.size)
+			t.Fail()
+		}
+	}
+
+	if _, err := newQRCode(strings.Repeat("a", 272)); err == nil {
+		t.Log("expected an error for content too long")
+		t.Fail()
+	}
+}
+
+func TestQRCodeString(t *testing.T) {
+	q, err := newQRCode("https://www.google.com/device")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(q.String(), "\n"), "\n")
+	if len(lines) != (q.width()+1)/2 {
+		t.Logf("expected %d lines, got %d", (q.width()+1)/2, len(lines))
+		t.Fail()
+	}
+	for _, line := range lines {
+		if n := len([]rune(line)); n != q.width() {
+			t.Logf("expected lines of %d columns, got %d", q.width(), n)
+			t.Fail()
+			break
+		}
+	}
+	// The quiet zone is light, and so drawn.
+	if lines[0] != strings.Repeat("█", q.width()) {
+		t.Logf("expected the first line to be the quiet zone, got %q", lines[0])
+		t.Fail()
+	}
+}
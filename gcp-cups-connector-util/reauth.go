@@ -0,0 +1,111 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/codegangsta/cli"
+	"github.com/google/cups-connector/gcp"
+	"github.com/google/cups-connector/lib"
+
+	"golang.org/x/oauth2"
+)
+
+var reauthFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "gcp-user-refresh-token",
+		Usage: "GCP user refresh token to store, instead of the OAuth device flow",
+	},
+	cli.BoolFlag{
+		Name:  "prompt-gcp-user-refresh-token",
+		Usage: "Prompt for a GCP user refresh token, without echoing it, instead of the OAuth device flow",
+	},
+	debugHTTPFlag,
+	qrFlag,
+	cli.DurationFlag{
+		Name:  "gcp-api-timeout",
+		Usage: "Time to wait for each GCP and OAuth response (0s means no limit)",
+		Value: 30 * time.Second,
+	},
+}
+
+// verifyRefreshToken checks that refreshToken can still be exchanged for an
+// access token.
+func verifyRefreshToken(context *cli.Context, config *lib.Config, refreshToken string, scopes ...string) error {
+	oauthConfig := gcp.NewOAuthConfig(config.GCPOAuthClientID, config.GCPOAuthClientSecret,
+		config.GCPOAuthAuthURL, config.GCPOAuthTokenURL, scopes...)
+
+	token := &oauth2.Token{RefreshToken: refreshToken}
+	_, err := oauthConfig.TokenSource(gcp.OAuthContext(context.Duration("gcp-api-timeout")), token).Token()
+	return err
+}
+
+// reauthConfigFile obtains a fresh user refresh token for an existing config
+// file, keeping the proxy name and robot account, so that printers don't
+// have to be registered again.
+func reauthConfigFile(context *cli.Context) {
+	config, configFilename, err := lib.GetConfig(context)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	trustCACertFile(config.CACertFile)
+	setMinTLSVersion(config.MinTLSVersion)
+	setUserAgent(config)
+	setupDebugHTTP(context)
+	store := loadTokens(context, config)
+
+	if configFilename == "" {
+		log.Fatalln("Could not find a config file to reauthorize; run init instead")
+	}
+	if !config.CloudPrintingEnable || config.ProxyName == "" {
+		log.Fatalln("Cloud printing is not configured in this config file; run init instead")
+	}
+
+	if err = verifyRefreshToken(context, config, config.RobotRefreshToken, gcp.ScopeCloudPrint, gcp.ScopeGoogleTalk); err != nil {
+		log.Fatalf("The robot account refresh token no longer works (%s); run init to create a new robot account\n", err)
+	}
+
+	if config.ShareScope == "" {
+		fmt.Println("The robot account credentials are valid, and no user credentials are retained; nothing to update")
+		return
+	}
+
+	var userRefreshToken string
+	if context.IsSet("gcp-user-refresh-token") {
+		userRefreshToken = context.String("gcp-user-refresh-token")
+	} else if context.Bool("prompt-gcp-user-refresh-token") {
+		userRefreshToken = scanSecretString("GCP user refresh token:")
+	} else {
+		_, userRefreshToken = getUserClientFromUser(context, config.ShareScope)
+	}
+
+	if err = verifyRefreshToken(context, config, userRefreshToken, userScopes(config.ShareScope)...); err != nil {
+		log.Fatalf("The new user refresh token doesn't work, so %s was not changed: %s\n", configFilename, err)
+	}
+
+	if config.TokenStore != lib.TokenStoreFile && config.TokenStore != "" {
+		if saveToken(store, lib.UserRefreshToken, userRefreshToken) {
+			fmt.Println("Updated the user refresh token in the token store")
+			return
+		}
+		// Read the config file again, without the tokens from the token store.
+		if config, _, err = lib.GetConfig(context); err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	config.UserRefreshToken = userRefreshToken
+	if _, err = config.ToFile(context); err != nil {
+		log.Fatalln(err)
+	}
+	fmt.Printf("Updated the user refresh token in %s\n", configFilename)
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+
+	"github.com/codegangsta/cli"
+)
+
+var shareFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "scope",
+		Usage: "Email address of a user or group, or a domain, to share with; wildcards, like print-*@example.com, match groups",
+	},
+	cli.StringFlag{
+		Name:  "printer-filter",
+		Usage: "Regular expression that printer names must match; all printers by default",
+	},
+}
+
+// sharePrinters shares the GCP printers associated with this connector.
+func sharePrinters(ctx context.Context, context *cli.Context) {
+	changeSharing(ctx, context, true)
+}
+
+// unsharePrinters stops sharing the GCP printers associated with this connector.
+func unsharePrinters(ctx context.Context, context *cli.Context) {
+	changeSharing(ctx, context, false)
+}
+
+// changeSharing shares or unshares every GCP printer whose name matches the
+// printer filter, then exits non-zero if any of them failed.
+func changeSharing(ctx context.Context, context *cli.Context, share bool) {
+	scope := context.String("scope")
+	if scope == "" {
+		log.Fatalln("--scope is required")
+	}
+	var filter *regexp.Regexp
+	if pattern := context.String("printer-filter"); pattern != "" {
+		var err error
+		if filter, err = regexp.Compile("^(?:" + pattern + ")$"); err != nil {
+			log.Fatalf("Invalid printer filter: %s", err)
+		}
+	}
+
+	config := getConfig(context)
+	gcp := getGCP(context, config)
+	if !gcp.CanShare() {
+		log.Fatalln("Cannot change sharing because user OAuth credentials are not in the config file")
+	}
+
+	scopes, err := gcp.ResolveShareScope(ctx, scope)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	printers, err := gcp.List(ctx)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	// Sort by name, so that the output is easy to read.
+	gcpIDs := make([]string, 0, len(printers))
+	for gcpID, name := range printers {
+		if filter == nil || filter.MatchString(name) {
+			gcpIDs = append(gcpIDs, gcpID)
+		}
+	}
+	sort.Slice(gcpIDs, func(i, j int) bool { return printers[gcpIDs[i]] < printers[gcpIDs[j]] })
+
+	if len(gcpIDs) == 0 {
+		fmt.Println("No matching printers")
+		return
+	}
+
+	var failures int
+	for _, gcpID := range gcpIDs {
+		name := printers[gcpID]
+		for _, scope := range scopes {
+			if share {
+				err = gcp.Share(ctx, gcpID, scope)
+			} else {
+				err = gcp.Unshare(ctx, gcpID, scope)
+			}
+			switch {
+			case err != nil:
+				failures++
+				fmt.Printf("Failed to change sharing of %s \"%s\" with %s: %s\n", gcpID, name, scope, err)
+			case share:
+				fmt.Printf("Shared %s \"%s\" with %s\n", gcpID, name, scope)
+			default:
+				fmt.Printf("Unshared %s \"%s\" from %s\n", gcpID, name, scope)
+			}
+		}
+	}
+
+	if failures > 0 {
+		fmt.Printf("%d of %d shares failed\n", failures, len(gcpIDs)*len(scopes))
+		os.Exit(1)
+	}
+}
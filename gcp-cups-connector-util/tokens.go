@@ -0,0 +1,62 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/codegangsta/cli"
+	"github.com/google/cups-connector/lib"
+)
+
+// loadTokens replaces the refresh tokens in config with those in the token
+// store that config names.
+func loadTokens(context *cli.Context, config *lib.Config) lib.TokenStore {
+	store, err := lib.NewTokenStore(context, config)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if err = lib.LoadTokens(store, config); err != nil {
+		log.Fatalln(err)
+	}
+	return store
+}
+
+// newInitTokenStore returns the token store named by the init flags, or nil
+// when tokens are kept in the config file that init writes.
+func newInitTokenStore(context *cli.Context) lib.TokenStore {
+	config := lib.Config{
+		TokenStore:        context.String("token-store"),
+		TokenStoreCommand: context.String("token-store-command"),
+	}
+	if config.TokenStore == lib.TokenStoreFile {
+		return nil
+	}
+	store, err := lib.NewTokenStore(context, &config)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	return store
+}
+
+// saveToken saves token in store, returning true, or returns false when
+// store is read-only, so that the token has to be kept in the config file.
+func saveToken(store lib.TokenStore, name lib.TokenName, token string) bool {
+	err := store.Save(name, token)
+	if err == lib.ErrTokenStoreReadOnly {
+		fmt.Printf("The token store can't save the %s, so it is kept in the config file;\n", name)
+		fmt.Println("move it to where the token store reads it, then remove it from the config file.")
+		return false
+	}
+	if err != nil {
+		log.Fatalf("Failed to save the %s: %s\n", name, err)
+	}
+	return true
+}
@@ -11,8 +11,11 @@ package main
 import (
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"os/signal"
+	"runtime"
+	"runtime/debug"
 	"syscall"
 	"time"
 
@@ -32,19 +35,79 @@ func main() {
 	app := cli.NewApp()
 	app.Name = "gcp-cups-connector"
 	app.Usage = "Google Cloud Print CUPS Connector"
+	app.Version = lib.BuildDate
+	cli.VersionPrinter = printVersion
 	app.Flags = []cli.Flag{
 		lib.ConfigFilenameFlag,
 		cli.BoolFlag{
 			Name:  "log-to-console",
 			Usage: "Log to STDERR, in addition to log file",
 		},
+		cli.BoolFlag{
+			Name:  "require-monitor-socket",
+			Usage: "Exit when the monitor socket can't be created, instead of running without it",
+		},
 	}
 	app.Action = func(context *cli.Context) {
 		os.Exit(connector(context))
 	}
+	app.Commands = []cli.Command{
+		cli.Command{
+			Name:  "sync-once",
+			Usage: "Sync CUPS printers to Google Cloud Print once, then exit",
+			Action: func(context *cli.Context) {
+				os.Exit(syncOnce(context))
+			},
+		},
+		cli.Command{
+			Name:  "selftest",
+			Usage: "Check that CUPS, GCP, XMPP and the monitor socket work, then exit",
+			Action: func(context *cli.Context) {
+				os.Exit(selfTest(context))
+			},
+		},
+		cli.Command{
+			Name:  "testprint",
+			Usage: "Print a test page to a CUPS printer the way cloud jobs are printed, then exit",
+			Action: func(context *cli.Context) {
+				os.Exit(testPrint(context))
+			},
+		},
+		cli.Command{
+			Name:   "version",
+			Usage:  "Print build and library versions, then exit",
+			Action: printVersion,
+		},
+	}
 	app.RunAndExitOnError()
 }
 
+// printVersion prints the connector version, the commit it was built from,
+// and the versions of the libraries that matter most when reporting bugs.
+// It doesn't read the config file.
+func printVersion(context *cli.Context) {
+	fmt.Println(lib.FullName)
+	fmt.Printf("connector-version=%s\n", lib.BuildVersion)
+	fmt.Printf("build-date=%s\n", lib.BuildDate)
+	fmt.Printf("git-commit=%s\n", lib.GitCommit)
+	fmt.Printf("go-version=%s\n", runtime.Version())
+	fmt.Printf("cups-client-version=%s\n", cups.ClientVersion())
+	fmt.Printf("oauth2-version=%s\n", moduleVersion("golang.org/x/oauth2"))
+}
+
+// moduleVersion returns the version of the module at path that the connector
+// was built with, or "unknown" when the build didn't record it.
+func moduleVersion(path string) string {
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, dep := range info.Deps {
+			if dep.Path == path {
+				return dep.Version
+			}
+		}
+	}
+	return "unknown"
+}
+
 func connector(context *cli.Context) int {
 	config, configFilename, err := lib.GetConfig(context)
 	if err != nil {
@@ -52,24 +115,10 @@ func connector(context *cli.Context) int {
 		return 1
 	}
 
-	logFileMaxBytes := config.LogFileMaxMegabytes * 1024 * 1024
-	var logWriter io.Writer
-	logWriter, err = log.NewLogRoller(config.LogFileName, logFileMaxBytes, config.LogMaxFiles)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to start log roller: %s", err)
-		return 1
-	}
-
-	if context.Bool("log-to-console") {
-		logWriter = io.MultiWriter(logWriter, os.Stderr)
-	}
-	logLevel, ok := log.LevelFromString(config.LogLevel)
-	if !ok {
-		fmt.Fprintf(os.Stderr, "Log level %s is not recognized", config.LogLevel)
+	if err = startLogging(context, config); err != nil {
+		fmt.Fprintln(os.Stderr, err)
 		return 1
 	}
-	log.SetLevel(logLevel)
-	log.SetWriter(logWriter)
 
 	if configFilename == "" {
 		log.Info("No config file was found, so using defaults")
@@ -82,6 +131,14 @@ func connector(context *cli.Context) int {
 		log.Error("Cannot run connector with both local_printing_enable and cloud_printing_enable set to false")
 		return 1
 	}
+	if err := config.Validate(); err != nil {
+		log.Errorf("Invalid config file: %s", err)
+		return 1
+	}
+	if err := setupHTTP(config); err != nil {
+		log.Error(err)
+		return 1
+	}
 
 	if _, err := os.Stat(config.MonitorSocketFilename); !os.IsNotExist(err) {
 		if err != nil {
@@ -94,6 +151,28 @@ func connector(context *cli.Context) int {
 		return 1
 	}
 
+	if err := monitor.CreateSocketDir(config.MonitorSocketFilename); err != nil {
+		if context.Bool("require-monitor-socket") {
+			log.Errorf("Failed to create monitor socket directory: %s", err)
+			return 1
+		}
+		log.Warningf("Failed to create monitor socket directory: %s", err)
+	}
+
+	if config.TempDir != "" {
+		if err := prepareTempDir(config.TempDir); err != nil {
+			log.Fatalf("Temp directory %s is not usable: %s", config.TempDir, err)
+			return 1
+		}
+	}
+
+	if config.DebugPprofAddress != "" {
+		if err := startPprof(config.DebugPprofAddress); err != nil {
+			log.Error(err)
+			return 1
+		}
+	}
+
 	jobs := make(chan *lib.Job, 10)
 	xmppNotifications := make(chan xmpp.PrinterNotification, 5)
 
@@ -111,16 +190,20 @@ func connector(context *cli.Context) int {
 			return 1
 		}
 
-		g, err = gcp.NewGoogleCloudPrint(config.GCPBaseURL, config.RobotRefreshToken,
-			config.UserRefreshToken, config.ProxyName, config.GCPOAuthClientID,
-			config.GCPOAuthClientSecret, config.GCPOAuthAuthURL, config.GCPOAuthTokenURL,
-			config.GCPMaxConcurrentDownloads, jobs)
+		warnClockSkew(config)
+
+		g, err = newGoogleCloudPrint(context, config, jobs)
 		if err != nil {
 			log.Error(err)
 			return 1
 		}
 
-		x, err = xmpp.NewXMPP(config.XMPPJID, config.ProxyName, config.XMPPServer, config.XMPPPort,
+		minTLSVersion, err := lib.ParseTLSVersion(config.MinTLSVersion)
+		if err != nil {
+			log.Error(err)
+			return 1
+		}
+		x, err = xmpp.NewXMPP(config.XMPPJID, config.ProxyName, config.XMPPServers(), config.XMPPPort, minTLSVersion,
 			xmppPingTimeout, xmppPingInterval, g.GetRobotAccessToken, xmppNotifications)
 		if err != nil {
 			log.Error(err)
@@ -129,24 +212,24 @@ func connector(context *cli.Context) int {
 		defer x.Quit()
 	}
 
-	cupsConnectTimeout, err := time.ParseDuration(config.CUPSConnectTimeout)
+	c, err := newCUPS(config)
 	if err != nil {
-		log.Fatalf("Failed to parse CUPS connect timeout: %s", err)
+		log.Fatal(err)
 		return 1
 	}
-	c, err := cups.NewCUPS(config.CopyPrinterInfoToDisplayName, config.PrefixJobIDToJobTitle,
-		config.DisplayNamePrefix, config.CUPSPrinterAttributes, config.CUPSMaxConnections,
-		cupsConnectTimeout)
+	defer c.Quit()
+
+	options, err := managerOptions(config)
 	if err != nil {
 		log.Fatal(err)
 		return 1
 	}
-	defer c.Quit()
 
 	var s *snmp.SNMPManager
 	if config.SNMPEnable {
 		log.Info("SNMP enabled")
-		s, err = snmp.NewSNMPManager(config.SNMPCommunity, config.SNMPMaxConnections)
+		s, err = snmp.NewSNMPManager(config.SNMPCommunity, config.SNMPMaxConnections,
+			options.PrinterPollInterval, config.SNMPExtraOIDs)
 		if err != nil {
 			log.Error(err)
 			return 1
@@ -157,9 +240,9 @@ func connector(context *cli.Context) int {
 	var priv *privet.Privet
 	if config.LocalPrintingEnable {
 		if g == nil {
-			priv, err = privet.NewPrivet(jobs, config.GCPBaseURL, nil)
+			priv, err = privet.NewPrivet(jobs, config.GCPBaseURL, config.TempDir, nil)
 		} else {
-			priv, err = privet.NewPrivet(jobs, config.GCPBaseURL, g.ProximityToken)
+			priv, err = privet.NewPrivet(jobs, config.GCPBaseURL, config.TempDir, g.ProximityToken)
 		}
 		if err != nil {
 			log.Error(err)
@@ -168,21 +251,15 @@ func connector(context *cli.Context) int {
 		defer priv.Quit()
 	}
 
-	cupsPrinterPollInterval, err := time.ParseDuration(config.CUPSPrinterPollInterval)
-	if err != nil {
-		log.Fatalf("Failed to parse CUPS printer poll interval: %s", err)
-		return 1
-	}
-	pm, err := manager.NewPrinterManager(c, g, priv, s, cupsPrinterPollInterval,
-		config.CUPSJobQueueSize, config.CUPSJobFullUsername, config.CUPSIgnoreRawPrinters,
-		config.ShareScope, jobs, xmppNotifications)
+	pm, err := manager.NewPrinterManager(c, g, priv, s, options, jobs, xmppNotifications)
 	if err != nil {
 		log.Error(err)
 		return 1
 	}
 	defer pm.Quit()
 
-	m, err := monitor.NewMonitor(c, g, priv, pm, config.MonitorSocketFilename)
+	m, err := monitor.NewMonitor(c, g, x, priv, pm, config.DisplayName(), config.Labels, config.MonitorSocketFilename,
+		context.Bool("require-monitor-socket"))
 	if err != nil {
 		log.Error(err)
 		return 1
@@ -211,6 +288,299 @@ func connector(context *cli.Context) int {
 	return 0
 }
 
+// syncOnce syncs CUPS printers to GCP one time, for connectors that are run
+// on a schedule instead of as a daemon.
+func syncOnce(context *cli.Context) int {
+	config, _, err := lib.GetConfig(context)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read config file: %s", err)
+		return 1
+	}
+
+	if err = startLogging(context, config); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if !config.CloudPrintingEnable {
+		log.Error("Cannot sync printers once with cloud_printing_enable set to false")
+		return 1
+	}
+
+	if config.TempDir != "" {
+		if err := prepareTempDir(config.TempDir); err != nil {
+			log.Errorf("Temp directory %s is not usable: %s", config.TempDir, err)
+			return 1
+		}
+	}
+
+	if err = setupHTTP(config); err != nil {
+		log.Error(err)
+		return 1
+	}
+
+	g, err := newGoogleCloudPrint(context, config, nil)
+	if err != nil {
+		log.Error(err)
+		return 1
+	}
+
+	c, err := newCUPS(config)
+	if err != nil {
+		log.Error(err)
+		return 1
+	}
+	defer c.Quit()
+
+	var s *snmp.SNMPManager
+	if config.SNMPEnable {
+		s, err = snmp.NewSNMPManager(config.SNMPCommunity, config.SNMPMaxConnections, 0,
+			config.SNMPExtraOIDs)
+		if err != nil {
+			log.Error(err)
+			return 1
+		}
+		defer s.Quit()
+	}
+
+	options, err := managerOptions(config)
+	if err != nil {
+		log.Error(err)
+		return 1
+	}
+	err = manager.SyncPrinters(c, g, s, options)
+	if err != nil {
+		log.Error(err)
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	fmt.Println("Printers are in sync")
+	return 0
+}
+
+// managerOptions parses the printer manager settings of config. Durations
+// missing from older config files get their defaults.
+func managerOptions(config *lib.Config) (manager.Options, error) {
+	options := manager.Options{
+		PrinterPollIntervalOverrides: config.PrinterPollIntervalOverrides,
+		SyncMaxRetries:               config.SyncMaxRetries,
+		MaxRegisteredPrinters:        config.MaxRegisteredPrinters,
+		AllowlistFile:                config.PrinterAllowlistFile,
+		DisabledPrinters:             config.DisabledPrinters,
+		ForceReregister:              config.ForceReregister,
+		SkipDeviceURISchemes:         config.SkipDeviceURISchemes,
+		ReregisterOnUUIDChange:       config.ReregisterOnUUIDChange,
+		CUPSQueueSize:                config.CUPSJobQueueSize,
+		CUPSJobRetries:               config.CUPSJobRetries,
+		JobFullUsername:              config.CUPSJobFullUsername,
+		JobUsernameTemplate:          config.CUPSJobUsernameTemplate,
+		RawPrinterPolicy:             config.RawPrinterPolicy(),
+		StrictNames:                  config.StrictNames,
+		StrictJobOptions:             config.StrictJobOptions,
+		AllowEmptyCUPSSync:           config.AllowEmptyCUPSSync,
+		ShadowMode:                   config.ShadowMode,
+		LogJobTitles:                 config.LogJobTitles,
+		KeepJobFiles:                 config.KeepJobFiles,
+		KeepJobFilesCount:            config.KeepJobFilesCount,
+		TempDir:                      config.TempDir,
+		CapabilityOverrides:          config.CapabilityOverrides,
+		MakeModelOverrides:           config.MakeModelOverrides,
+		PrinterTags:                  config.PrinterTags,
+		ShareScope:                   config.ShareScope,
+		StateChangeWebhookURL:        config.StateChangeWebhookURL,
+	}
+
+	var err error
+	if options.PrinterPollInterval, err = time.ParseDuration(config.CUPSPrinterPollInterval); err != nil {
+		return options, fmt.Errorf("Failed to parse CUPS printer poll interval: %s", err)
+	}
+	if options.CUPSJobMaxStopped, err = lib.ParseConfigDuration(config.CUPSJobMaxStopped, lib.DefaultConfig.CUPSJobMaxStopped); err != nil {
+		return options, fmt.Errorf("Failed to parse CUPS job max stopped: %s", err)
+	}
+	if options.MinUpdateInterval, err = lib.ParseConfigDuration(config.MinUpdateInterval, lib.DefaultConfig.MinUpdateInterval); err != nil {
+		return options, fmt.Errorf("Failed to parse min update interval: %s", err)
+	}
+	if config.CloudPrintingEnable {
+		if options.GCPPrinterListRefreshInterval, err = lib.ParseConfigDuration(config.GCPPrinterListRefreshInterval, lib.DefaultConfig.GCPPrinterListRefreshInterval); err != nil {
+			return options, fmt.Errorf("Failed to parse GCP printer list refresh interval: %s", err)
+		}
+		if options.CloudJobPollInterval, err = lib.ParseConfigDuration(config.CloudJobPollInterval, lib.DefaultConfig.CloudJobPollInterval); err != nil {
+			return options, fmt.Errorf("Failed to parse cloud job poll interval: %s", err)
+		}
+		if config.SelfHealInterval != "" {
+			if options.SelfHealInterval, err = time.ParseDuration(config.SelfHealInterval); err != nil {
+				return options, fmt.Errorf("Failed to parse self heal interval: %s", err)
+			}
+		}
+		if options.SyncRetryBackoff, err = lib.ParseConfigDuration(config.SyncRetryBackoff, lib.DefaultConfig.SyncRetryBackoff); err != nil {
+			return options, fmt.Errorf("Failed to parse sync retry backoff: %s", err)
+		}
+	}
+	if options.AllowlistInterval, err = lib.ParseConfigDuration(config.PrinterAllowlistInterval, lib.DefaultConfig.PrinterAllowlistInterval); err != nil {
+		return options, fmt.Errorf("Failed to parse printer allowlist interval: %s", err)
+	}
+	if config.KeepJobFiles {
+		if options.KeepJobFilesMaxAge, err = time.ParseDuration(config.KeepJobFilesMaxAge); err != nil {
+			return options, fmt.Errorf("Failed to parse keep job files max age: %s", err)
+		}
+	}
+	if config.JobDedupTTL != "" {
+		if options.JobDedupTTL, err = time.ParseDuration(config.JobDedupTTL); err != nil {
+			return options, fmt.Errorf("Failed to parse job dedup TTL: %s", err)
+		}
+	}
+	return options, nil
+}
+
+// startLogging directs the connector log to the configured log file, and to
+// STDERR if requested.
+func startLogging(context *cli.Context, config *lib.Config) error {
+	logFileMaxBytes := config.LogFileMaxMegabytes * 1024 * 1024
+	var logWriter io.Writer
+	logWriter, err := log.NewLogRoller(config.LogFileName, logFileMaxBytes, config.LogMaxFiles, config.LogCompress)
+	if err != nil {
+		return fmt.Errorf("Failed to start log roller: %s", err)
+	}
+
+	if context.GlobalBool("log-to-console") {
+		logWriter = io.MultiWriter(logWriter, os.Stderr)
+	}
+	logLevel, ok := log.LevelFromString(config.LogLevel)
+	if !ok {
+		return fmt.Errorf("Log level %s is not recognized", config.LogLevel)
+	}
+	log.SetLevel(logLevel)
+	log.SetWriter(logWriter)
+
+	return nil
+}
+
+// setupHTTP prepares http.DefaultTransport, which every outbound HTTP request
+// goes through, with the CA certificates, minimum TLS version and User-Agent
+// of config.
+func setupHTTP(config *lib.Config) error {
+	if config.CACertFile != "" {
+		if err := lib.TrustCACertFile(config.CACertFile); err != nil {
+			return err
+		}
+	}
+	minTLSVersion, err := lib.ParseTLSVersion(config.MinTLSVersion)
+	if err != nil {
+		return err
+	}
+	if err = lib.SetMinTLSVersion(minTLSVersion); err != nil {
+		return err
+	}
+	lib.SetUserAgent(config.HTTPUserAgent())
+	return nil
+}
+
+// warnClockSkew warns when the system clock is off from GCP's, which makes
+// OAuth fail with errors that don't say why.
+func warnClockSkew(config *lib.Config) {
+	apiTimeout, err := lib.ParseConfigDuration(config.GCPAPITimeout, lib.DefaultConfig.GCPAPITimeout)
+	if err != nil {
+		log.Warningf("Failed to parse GCP API timeout, so not checking the system clock: %s", err)
+		return
+	}
+	skew, err := gcp.ClockSkew(config.GCPBaseURL, apiTimeout)
+	if err != nil {
+		log.Warningf("Failed to check the system clock against GCP: %s", err)
+		return
+	}
+	if gcp.ClockSkewTooLarge(skew) {
+		log.Warningf("The system clock is off from GCP's by %s, so OAuth is likely to fail; sync it, eg with NTP", skew)
+	} else {
+		log.Debugf("The system clock is off from GCP's by %s", skew)
+	}
+}
+
+// newGoogleCloudPrint creates a GoogleCloudPrint with the refresh tokens in
+// the token store, which is read again when GCP rejects the robot token.
+func newGoogleCloudPrint(context *cli.Context, config *lib.Config, jobs chan<- *lib.Job) (*gcp.GoogleCloudPrint, error) {
+	apiTimeout, err := lib.ParseConfigDuration(config.GCPAPITimeout, lib.DefaultConfig.GCPAPITimeout)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse GCP API timeout: %s", err)
+	}
+	downloadTimeout, err := lib.ParseConfigDuration(config.GCPDownloadTimeout, lib.DefaultConfig.GCPDownloadTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse GCP download timeout: %s", err)
+	}
+
+	store, err := lib.NewTokenStore(context, config)
+	if err != nil {
+		return nil, err
+	}
+	if err = lib.LoadTokens(store, config); err != nil {
+		return nil, err
+	}
+
+	g, err := gcp.NewGoogleCloudPrint(config.GCPBaseURL, config.RobotRefreshToken,
+		config.UserRefreshToken, config.ProxyName, config.GCPOAuthClientID,
+		config.GCPOAuthClientSecret, config.GCPOAuthAuthURL, config.GCPOAuthTokenURL,
+		apiTimeout, downloadTimeout, config.GCPMaxConcurrentDownloads, config.TempDir, config.AcceptedJobFormats, jobs)
+	if err != nil {
+		return nil, err
+	}
+	g.SetRobotRefreshTokenLoader(func() (string, error) {
+		return store.Load(lib.RobotRefreshToken)
+	})
+	return g, nil
+}
+
+func newCUPS(config *lib.Config) (*cups.CUPS, error) {
+	cupsConnectTimeout, err := time.ParseDuration(config.CUPSConnectTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse CUPS connect timeout: %s", err)
+	}
+	return cups.NewCUPS(cups.Options{
+		InfoToDisplayName:     config.CopyPrinterInfoToDisplayName,
+		JobTitleTemplate:      config.JobTitleTemplate,
+		PrefixJobIDToJobTitle: config.PrefixJobIDToJobTitle,
+		SanitizeJobTitle:      config.JobTitleSanitize,
+		JobTitleMaxLength:     config.JobTitleMaxLength,
+		DisplayNamePrefix:     config.DisplayNamePrefix,
+		DisplayNameSuffix:     config.DisplayNameSuffix,
+		SetupURL:              config.PrinterSetupURL,
+		SupportURL:            config.PrinterSupportURL,
+		UpdateURL:             config.PrinterUpdateURL,
+		PrinterAttributes:     config.CUPSPrinterAttributes,
+		RawMakeAndModels:      config.CUPSRawPrinterMakeModels,
+		MissingPPDIsRaw:       config.CUPSMissingPPDIsRaw,
+		DescribeDriverless:    config.CUPSDriverlessPrinters,
+		MaxConnections:        config.CUPSMaxConnections,
+		ConnectTimeout:        cupsConnectTimeout,
+		ServerHost:            config.CUPSServerHost,
+		ServerPort:            config.CUPSServerPort,
+		Encryption:            config.CUPSEncryption,
+		CACertFile:            config.CUPSCACertFile,
+		TempDir:               config.TempDir,
+		ConnectorDisplayName:  config.DisplayName(),
+		StateReasonMessages:   config.PrinterStateReasonMessages,
+		DefaultMediaSize:      config.DefaultMediaSize,
+		DefaultOptions:        config.PrinterDefaultOptions,
+	})
+}
+
+// prepareTempDir creates dir if it doesn't exist, then checks that files can
+// be created in it.
+func prepareTempDir(dir string) error {
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		if err = os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+		log.Infof("Created temp directory %s", dir)
+	}
+
+	f, err := ioutil.TempFile(dir, "cups-connector-check-")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
 // Blocks until Ctrl-C or SIGTERM.
 func waitIndefinitely() {
 	ch := make(chan os.Signal)
@@ -0,0 +1,49 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/google/cups-connector/log"
+)
+
+// startPprof serves the net/http/pprof profiles on address until the
+// connector exits.
+func startPprof(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("Failed to listen for pprof on %s: %s", address, err)
+	}
+	if host, _, err := net.SplitHostPort(listener.Addr().String()); err == nil {
+		if ip := net.ParseIP(host); ip != nil && !ip.IsLoopback() {
+			log.Warningf("Serving pprof on %s, which isn't only reachable from localhost", listener.Addr())
+		}
+	}
+
+	// Not http.DefaultServeMux, so that nothing else is served by accident.
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	go func() {
+		if err := http.Serve(listener, mux); err != nil {
+			log.Errorf("Stopped serving pprof: %s", err)
+		}
+	}()
+
+	log.Infof("Serving pprof on http://%s/debug/pprof/", listener.Addr())
+	return nil
+}
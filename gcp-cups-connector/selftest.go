@@ -0,0 +1,258 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/codegangsta/cli"
+	"github.com/google/cups-connector/cups"
+	"github.com/google/cups-connector/gcp"
+	"github.com/google/cups-connector/lib"
+	"github.com/google/cups-connector/monitor"
+	"github.com/google/cups-connector/xmpp"
+)
+
+// How long each self-test check may take.
+const selfTestTimeout = 30 * time.Second
+
+// errSelfTestSkipped marks a check that didn't run, because a check it
+// depends on failed or because there is nothing to check.
+var errSelfTestSkipped = errors.New("skipped")
+
+// selfTest checks, one by one, everything that the connector needs to run,
+// printing the result of each check with a hint on how to fix failures.
+// Returns non-zero if any check failed.
+func selfTest(context *cli.Context) int {
+	config, configFilename, err := lib.GetConfig(context)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read config file: %s\n", err)
+		return 1
+	}
+	if configFilename == "" {
+		fmt.Println("No config file was found, so using defaults")
+	}
+	if err = startLogging(context, config); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	failed := false
+	check := func(name, hint string, run func() error) bool {
+		err := run()
+		switch err {
+		case nil:
+			fmt.Printf("PASS %s\n", name)
+			return true
+		case errSelfTestSkipped:
+			fmt.Printf("SKIP %s\n", name)
+		default:
+			failed = true
+			fmt.Printf("FAIL %s: %s\n", name, err)
+			fmt.Printf("     %s\n", hint)
+		}
+		return false
+	}
+
+	check("config file", "Fix the value named above, or run gcp-cups-connector-util update-config-file.",
+		config.Validate)
+
+	var c *cups.CUPS
+	var printers []lib.Printer
+	cupsOK := check("CUPS connection",
+		"Check that CUPS is running, and that cups_server_host and cups_server_port point to it.",
+		func() error {
+			var err error
+			if c, err = newCUPS(config); err != nil {
+				return err
+			}
+			ctx, cancel := selfTestContext()
+			defer cancel()
+			printers, err = c.GetPrinters(ctx)
+			return err
+		})
+	if c != nil {
+		defer c.Quit()
+	}
+
+	check("PPD fetch", "Check that the printer's driver is installed, eg with lpinfo -m.", func() error {
+		if !cupsOK {
+			return errSelfTestSkipped
+		}
+		for _, p := range printers {
+			if p.Raw {
+				continue
+			}
+			ctx, cancel := selfTestContext()
+			defer cancel()
+			if _, err := c.FetchPPD(ctx, p.Name); err != nil {
+				return fmt.Errorf("%s: %s", p.Name, err)
+			}
+			return nil
+		}
+		return errSelfTestSkipped
+	})
+
+	var g *gcp.GoogleCloudPrint
+	var httpOK bool
+	gcpOK := check("GCP credentials", "Run gcp-cups-connector-util reauth to get new OAuth credentials.",
+		func() error {
+			if !config.CloudPrintingEnable {
+				return errSelfTestSkipped
+			}
+			var err error
+			if err = setupHTTP(config); err != nil {
+				return err
+			}
+			httpOK = true
+			if g, err = newGoogleCloudPrint(context, config, nil); err != nil {
+				return err
+			}
+			ctx, cancel := selfTestContext()
+			defer cancel()
+			_, _, err = g.ListPrinters(ctx)
+			return err
+		})
+
+	// Checked even when the credentials failed, since a skewed clock is a
+	// common cause.
+	var skew time.Duration
+	var skewOK bool
+	check("clock skew", "Sync the system clock, eg with NTP; OAuth fails when it is off.", func() error {
+		if !httpOK {
+			return errSelfTestSkipped
+		}
+		apiTimeout, err := lib.ParseConfigDuration(config.GCPAPITimeout, lib.DefaultConfig.GCPAPITimeout)
+		if err != nil {
+			return err
+		}
+		if skew, err = gcp.ClockSkew(config.GCPBaseURL, apiTimeout); err != nil {
+			return err
+		}
+		skewOK = true
+		if gcp.ClockSkewTooLarge(skew) {
+			return fmt.Errorf("Off from GCP by more than %s", gcp.MaxClockSkew)
+		}
+		return nil
+	})
+	if skewOK {
+		fmt.Printf("     Skew: %s\n", skew)
+	}
+
+	var granted []string
+	check("GCP scopes", "Run gcp-cups-connector-util init again to create a robot account with every scope.",
+		func() error {
+			if !gcpOK {
+				return errSelfTestSkipped
+			}
+			var ok bool
+			var err error
+			if granted, ok, err = g.GetRobotScopes(); err != nil {
+				return err
+			}
+			if !ok {
+				// The token endpoint didn't say which scopes it granted.
+				return errSelfTestSkipped
+			}
+			if missing := gcp.MissingScopes([]string{gcp.ScopeCloudPrint, gcp.ScopeGoogleTalk}, granted); len(missing) > 0 {
+				return fmt.Errorf("Missing %s", strings.Join(missing, " "))
+			}
+			return nil
+		})
+	if len(granted) > 0 {
+		fmt.Printf("     Granted: %s\n", strings.Join(granted, " "))
+	}
+
+	var xmppServer string
+	check("XMPP connection",
+		"Check that outgoing connections to xmpp_server or xmpp_server_fallbacks on xmpp_port are allowed by the firewall.",
+		func() error {
+			if !gcpOK {
+				return errSelfTestSkipped
+			}
+			pingTimeout, err := time.ParseDuration(config.XMPPPingTimeout)
+			if err != nil {
+				return fmt.Errorf("Failed to parse xmpp ping timeout: %s", err)
+			}
+			pingInterval, err := time.ParseDuration(config.XMPPPingInterval)
+			if err != nil {
+				return fmt.Errorf("Failed to parse xmpp ping interval default: %s", err)
+			}
+			minTLSVersion, err := lib.ParseTLSVersion(config.MinTLSVersion)
+			if err != nil {
+				return err
+			}
+			x, err := xmpp.NewXMPP(config.XMPPJID, config.ProxyName, config.XMPPServers(), config.XMPPPort, minTLSVersion,
+				pingTimeout, pingInterval, g.GetRobotAccessToken, make(chan xmpp.PrinterNotification, 5))
+			if err != nil {
+				return err
+			}
+			xmppServer = x.Server()
+			x.Quit()
+			return nil
+		})
+	if xmppServer != "" {
+		fmt.Printf("     Server: %s\n", xmppServer)
+	}
+
+	var monitorAnswered bool
+	check("monitor socket",
+		"Remove a stale socket, or choose another monitor_socket_filename.",
+		func() error {
+			var err error
+			monitorAnswered, err = checkMonitorSocket(config.MonitorSocketFilename)
+			return err
+		})
+	if monitorAnswered {
+		fmt.Println("     A running connector answers on it")
+	}
+
+	if failed {
+		return 1
+	}
+	fmt.Println("All checks passed")
+	return 0
+}
+
+// selfTestContext returns a context that times out after selfTestTimeout.
+func selfTestContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), selfTestTimeout)
+}
+
+// checkMonitorSocket checks that the monitor socket can be created, by
+// creating then removing it, or that a running connector answers on it,
+// which is reported by answered.
+func checkMonitorSocket(socketFilename string) (answered bool, err error) {
+	if _, err := os.Stat(socketFilename); !os.IsNotExist(err) {
+		if err != nil {
+			return false, err
+		}
+		conn, err := net.DialTimeout("unix", socketFilename, time.Second)
+		if err != nil {
+			return false, fmt.Errorf("%s already exists, and nothing answers on it", socketFilename)
+		}
+		conn.Close()
+		return true, nil
+	}
+	if err := monitor.CreateSocketDir(socketFilename); err != nil {
+		return false, err
+	}
+	listener, err := net.Listen("unix", socketFilename)
+	if err != nil {
+		return false, err
+	}
+	// Closing a unix listener removes its socket file.
+	return false, listener.Close()
+}
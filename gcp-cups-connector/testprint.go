@@ -0,0 +1,129 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+
+	"github.com/codegangsta/cli"
+	"github.com/google/cups-connector/lib"
+	"github.com/google/cups-connector/log"
+	"github.com/google/cups-connector/manager"
+)
+
+// testPage is a one-page PDF printed by the testprint command.
+const testPage = "%PDF-1.4\n" +
+	"1 0 obj\n" +
+	"<< /Type /Catalog /Pages 2 0 R >>\n" +
+	"endobj\n" +
+	"2 0 obj\n" +
+	"<< /Type /Pages /Kids [3 0 R] /Count 1 >>\n" +
+	"endobj\n" +
+	"3 0 obj\n" +
+	"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Contents 4 0 R /Resources << /Font << /F1 5 0 R >> >> >>\n" +
+	"endobj\n" +
+	"4 0 obj\n" +
+	"<< /Length 109 >>\n" +
+	"stream\n" +
+	"BT /F1 24 Tf 72 720 Td (Google Cloud Print CUPS Connector) Tj 0 -36 Td /F1 14 Tf (This is a test page.) Tj ET\n" +
+	"endstream\n" +
+	"endobj\n" +
+	"5 0 obj\n" +
+	"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>\n" +
+	"endobj\n" +
+	"xref\n" +
+	"0 6\n" +
+	"0000000000 65535 f \n" +
+	"0000000009 00000 n \n" +
+	"0000000058 00000 n \n" +
+	"0000000115 00000 n \n" +
+	"0000000241 00000 n \n" +
+	"0000000401 00000 n \n" +
+	"trailer\n" +
+	"<< /Size 6 /Root 1 0 R >>\n" +
+	"startxref\n" +
+	"471\n" +
+	"%%EOF\n"
+
+// testPrint prints the test page to the CUPS printer named by the first
+// argument, through the same ticket translation and CUPS submission as cloud
+// jobs. Returns non-zero unless CUPS printed it.
+func testPrint(context *cli.Context) int {
+	if len(context.Args()) != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: testprint PRINTER")
+		return 1
+	}
+	printerName := context.Args()[0]
+
+	config, _, err := lib.GetConfig(context)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read config file: %s\n", err)
+		return 1
+	}
+
+	if err = startLogging(context, config); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if config.TempDir != "" {
+		if err := prepareTempDir(config.TempDir); err != nil {
+			log.Errorf("Temp directory %s is not usable: %s", config.TempDir, err)
+			return 1
+		}
+	}
+
+	options, err := managerOptions(config)
+	if err != nil {
+		log.Error(err)
+		return 1
+	}
+
+	c, err := newCUPS(config)
+	if err != nil {
+		log.Error(err)
+		return 1
+	}
+	defer c.Quit()
+
+	// Written where downloaded cloud jobs are, and removed once printed.
+	f, err := ioutil.TempFile(config.TempDir, "cups-connector-testprint-")
+	if err != nil {
+		log.Errorf("Failed to create test page: %s", err)
+		return 1
+	}
+	_, err = f.WriteString(testPage)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		log.Errorf("Failed to write test page: %s", err)
+		return 1
+	}
+
+	var username string
+	if u, err := user.Current(); err == nil {
+		username = u.Username
+	}
+
+	fmt.Printf("Printing a test page to %s\n", printerName)
+	err = manager.TestPrint(c, printerName, f.Name(), username, options)
+	if err != nil {
+		log.Error(err)
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	fmt.Println("The test page printed")
+	return 0
+}
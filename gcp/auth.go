@@ -0,0 +1,144 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package gcp
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/google/cups-connector/log"
+)
+
+const (
+	// Consecutive rejections of the robot credentials before cloud
+	// operations are paused.
+	authFailureThreshold = 3
+
+	// How long cloud operations stay paused before the robot credentials are
+	// tried again.
+	authRetryInterval = 5 * time.Minute
+)
+
+// ErrAuthDegraded is returned, without calling GCP, while cloud operations
+// are paused because GCP rejected the robot credentials.
+var ErrAuthDegraded = errors.New("Cloud operations are paused because GCP rejected the robot credentials")
+
+// SetRobotRefreshTokenLoader sets a function that loads the robot refresh
+// token, for example from the config file. While cloud operations are
+// paused, the token is loaded again before the credentials are retried, so
+// that a token replaced by gcp-cups-connector-util reauth is picked up.
+func (gcp *GoogleCloudPrint) SetRobotRefreshTokenLoader(loader func() (string, error)) {
+	gcp.authMutex.Lock()
+	defer gcp.authMutex.Unlock()
+
+	gcp.robotRefreshTokenLoader = loader
+}
+
+// AuthDegraded checks whether cloud operations are paused because GCP
+// rejected the robot credentials.
+func (gcp *GoogleCloudPrint) AuthDegraded() bool {
+	gcp.authMutex.Lock()
+	defer gcp.authMutex.Unlock()
+
+	return gcp.authDegraded
+}
+
+// robot returns the robot client, or ErrAuthDegraded while cloud operations
+// are paused. Once per authRetryInterval, one caller gets the client to try
+// the credentials again.
+func (gcp *GoogleCloudPrint) robot() (*http.Client, error) {
+	gcp.authMutex.Lock()
+	defer gcp.authMutex.Unlock()
+
+	if !gcp.authDegraded {
+		return gcp.robotClient, nil
+	}
+	if time.Now().Before(gcp.authRetryAt) {
+		return nil, ErrAuthDegraded
+	}
+	gcp.authRetryAt = time.Now().Add(authRetryInterval)
+
+	if gcp.robotRefreshTokenLoader != nil {
+		token, err := gcp.robotRefreshTokenLoader()
+		if err != nil {
+			log.Warningf("Failed to load the robot refresh token: %s", err)
+		} else if token != gcp.robotRefreshToken {
+			robotClient, err := gcp.newRobotClient(token)
+			if err != nil {
+				log.Warningf("Failed to use the new robot refresh token: %s", err)
+			} else {
+				log.Info("Loaded a new robot refresh token")
+				gcp.robotClient, gcp.robotRefreshToken = robotClient, token
+			}
+		}
+	}
+
+	return gcp.robotClient, nil
+}
+
+// authResult records the outcome of a call made with the robot client,
+// pausing cloud operations after repeated rejections, and resuming them
+// after any call that isn't rejected.
+func (gcp *GoogleCloudPrint) authResult(err error) {
+	gcp.authMutex.Lock()
+	defer gcp.authMutex.Unlock()
+
+	if _, ok := err.(*AuthError); !ok {
+		if gcp.authDegraded {
+			log.Info("GCP accepted the robot credentials; resuming cloud operations")
+		}
+		gcp.authFailures = 0
+		gcp.authDegraded = false
+		return
+	}
+
+	gcp.authFailures++
+	if gcp.authDegraded {
+		log.Errorf("GCP still rejects the robot credentials; trying again in %s: %s", authRetryInterval, err)
+	} else if gcp.authFailures >= authFailureThreshold {
+		gcp.authDegraded = true
+		gcp.authRetryAt = time.Now().Add(authRetryInterval)
+		log.Errorf("GCP rejected the robot credentials %d times; pausing cloud operations. "+
+			"Run gcp-cups-connector-util reauth to get new credentials: %s", gcp.authFailures, err)
+	}
+}
+
+// robotPost calls postWithRetry with the robot client, unless cloud
+// operations are paused.
+func (gcp *GoogleCloudPrint) robotPost(ctx context.Context, url string, form url.Values) ([]byte, uint, int, error) {
+	hc, err := gcp.robot()
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	responseBody, gcpErrorCode, httpStatusCode, err := postWithRetry(ctx, hc, url, form)
+	if ctx.Err() == nil {
+		// A cancelled call says nothing about the credentials.
+		gcp.authResult(err)
+	}
+	return responseBody, gcpErrorCode, httpStatusCode, err
+}
+
+// robotGet calls getWithRetry with the robot client, unless cloud operations
+// are paused.
+func (gcp *GoogleCloudPrint) robotGet(ctx context.Context, url string) (*http.Response, error) {
+	hc, err := gcp.robot()
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := getWithRetry(ctx, hc, url)
+	if ctx.Err() == nil {
+		gcp.authResult(err)
+	}
+	return response, err
+}
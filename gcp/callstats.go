@@ -0,0 +1,143 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package gcp
+
+import (
+	"net/http"
+	"path"
+	"sort"
+	"sync"
+	"time"
+)
+
+// How many of the latest latencies of each operation the percentiles are
+// taken from.
+const callLatencyWindow = 1024
+
+// callOperations groups the GCP and OAuth endpoints, by the last element of
+// their path, into the operations that CallStats reports.
+var callOperations = map[string]string{
+	"register":       "register",
+	"update":         "update",
+	"delete":         "delete",
+	"share":          "share",
+	"unshare":        "share",
+	"list":           "list",
+	"printer":        "list",
+	"fetch":          "job",
+	"control":        "job",
+	"deletejob":      "job",
+	"ticket":         "job",
+	"jobs":           "job",
+	"download":       "job",
+	"proximitytoken": "privet",
+	"token":          "oauth",
+}
+
+// callOperation finds the operation of a request to url path p. Requests for
+// job files that aren't on GCP, so their path is unknown, are "job".
+func callOperation(p string) string {
+	if operation, exists := callOperations[path.Base(p)]; exists {
+		return operation
+	}
+	return "job"
+}
+
+// CallStats describes the HTTP calls of an operation, like register, since
+// the connector started.
+type CallStats struct {
+	Calls  uint
+	Errors uint
+
+	// Latency percentiles of the latest calls, up to the response headers.
+	P50, P90, P99 time.Duration
+}
+
+type operationCalls struct {
+	calls, errors uint
+	latencies     []time.Duration
+	next          int
+}
+
+var callStats = struct {
+	m          sync.Mutex
+	operations map[string]*operationCalls
+}{operations: make(map[string]*operationCalls)}
+
+func recordCall(operation string, latency time.Duration, failed bool) {
+	callStats.m.Lock()
+	defer callStats.m.Unlock()
+
+	o, exists := callStats.operations[operation]
+	if !exists {
+		o = &operationCalls{}
+		callStats.operations[operation] = o
+	}
+	o.calls++
+	if failed {
+		o.errors++
+	}
+	if len(o.latencies) < callLatencyWindow {
+		o.latencies = append(o.latencies, latency)
+	} else {
+		o.latencies[o.next] = latency
+		o.next = (o.next + 1) % callLatencyWindow
+	}
+}
+
+// GetCallStats returns the CallStats of every GCP and OAuth HTTP call made
+// with NewHTTPClient, by operation: register, update, delete, share, list,
+// job, privet and oauth.
+func GetCallStats() map[string]CallStats {
+	callStats.m.Lock()
+	defer callStats.m.Unlock()
+
+	stats := make(map[string]CallStats, len(callStats.operations))
+	for operation, o := range callStats.operations {
+		latencies := append([]time.Duration(nil), o.latencies...)
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		stats[operation] = CallStats{
+			Calls:  o.calls,
+			Errors: o.errors,
+			P50:    percentile(latencies, 50),
+			P90:    percentile(latencies, 90),
+			P99:    percentile(latencies, 99),
+		}
+	}
+	return stats
+}
+
+// percentile picks the pth percentile of sorted, by nearest rank.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := (len(sorted)*p+99)/100 - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
+
+// callStatsTransport records the latency and outcome of each request in
+// callStats. Requests whose context is done are left out, since they say
+// nothing about GCP.
+type callStatsTransport struct {
+	base http.RoundTripper
+}
+
+func (t callStatsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	response, err := t.base.RoundTrip(req)
+	if req.Context().Err() == nil {
+		failed := err != nil || response.StatusCode >= http.StatusBadRequest
+		recordCall(callOperation(req.URL.Path), time.Since(start), failed)
+	}
+	return response, err
+}
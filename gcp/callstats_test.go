@@ -0,0 +1,127 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package gcp
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestCallOperation(t *testing.T) {
+	testCases := map[string]string{
+		"/cloudprint/register":  "register",
+		"/cloudprint/unshare":   "share",
+		"/cloudprint/printer":   "list",
+		"/cloudprint/deletejob": "job",
+		"/o/oauth2/token":       "oauth",
+		"/files/1234":           "job",
+	}
+	for p, expected := range testCases {
+		if operation := callOperation(p); operation != expected {
+			t.Logf("expected %s to be %s, got %s", p, expected, operation)
+			t.Fail()
+		}
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	if p := percentile(nil, 50); p != 0 {
+		t.Logf("expected 0 without latencies, got %s", p)
+		t.Fail()
+	}
+
+	sorted := make([]time.Duration, 100)
+	for i := range sorted {
+		sorted[i] = time.Duration(i+1) * time.Millisecond
+	}
+	for p, expected := range map[int]time.Duration{
+		1:   1 * time.Millisecond,
+		50:  50 * time.Millisecond,
+		90:  90 * time.Millisecond,
+		99:  99 * time.Millisecond,
+		100: 100 * time.Millisecond,
+	} {
+		if got := percentile(sorted, p); got != expected {
+			t.Logf("expected percentile %d to be %s, got %s", p, expected, got)
+			t.Fail()
+		}
+	}
+
+	if got := percentile([]time.Duration{time.Second}, 99); got != time.Second {
+		t.Logf("expected percentile 99 of one latency to be it, got %s", got)
+		t.Fail()
+	}
+}
+
+func TestRecordCall(t *testing.T) {
+	// Operations that no other test records, since callStats is global.
+	for i := 1; i <= 10; i++ {
+		recordCall("test-counts", time.Duration(i)*time.Millisecond, i%5 == 0)
+	}
+	stats := GetCallStats()["test-counts"]
+	expected := CallStats{Calls: 10, Errors: 2, P50: 5 * time.Millisecond, P90: 9 * time.Millisecond, P99: 10 * time.Millisecond}
+	if stats != expected {
+		t.Logf("expected %+v, got %+v", expected, stats)
+		t.Fail()
+	}
+
+	// Slow calls that fall out of the window no longer count toward the
+	// percentiles, but still count as calls.
+	for i := 0; i < callLatencyWindow; i++ {
+		recordCall("test-window", time.Hour, false)
+	}
+	for i := 0; i < callLatencyWindow; i++ {
+		recordCall("test-window", time.Millisecond, false)
+	}
+	stats = GetCallStats()["test-window"]
+	if stats.Calls != 2*callLatencyWindow || stats.P99 != time.Millisecond {
+		t.Logf("expected %d calls with p99 of 1ms, got %+v", 2*callLatencyWindow, stats)
+		t.Fail()
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestCallStatsTransport(t *testing.T) {
+	before := GetCallStats()["register"]
+
+	var status int
+	var err error
+	transport := callStatsTransport{roundTripFunc(func(*http.Request) (*http.Response, error) {
+		if err != nil {
+			return nil, err
+		}
+		return &http.Response{StatusCode: status}, nil
+	})}
+
+	for _, tc := range []struct {
+		status int
+		err    error
+	}{
+		{http.StatusOK, nil},
+		{http.StatusForbidden, nil},
+		{0, errors.New("connection refused")},
+	} {
+		status, err = tc.status, tc.err
+		req, _ := http.NewRequest("POST", "https://www.google.com/cloudprint/register", nil)
+		transport.RoundTrip(req)
+	}
+
+	after := GetCallStats()["register"]
+	if after.Calls-before.Calls != 3 || after.Errors-before.Errors != 2 {
+		t.Logf("expected 3 calls and 2 errors, got %+v then %+v", before, after)
+		t.Fail()
+	}
+}
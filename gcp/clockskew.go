@@ -0,0 +1,49 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package gcp
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// MaxClockSkew is the most that the local clock may be off from GCP's before
+// OAuth token exchanges are likely to fail.
+const MaxClockSkew = time.Minute
+
+// ClockSkew compares the local clock to the Date header of a response from
+// url, like the GCP base URL. A positive skew means that the local clock is
+// ahead. The Date header has a precision of one second.
+func ClockSkew(url string, timeout time.Duration) (time.Duration, error) {
+	// Not NewHTTPClient, so that this check isn't counted with GCP calls.
+	hc := &http.Client{Timeout: timeout}
+
+	start := time.Now()
+	response, err := hc.Head(url)
+	if err != nil {
+		return 0, err
+	}
+	end := time.Now()
+	response.Body.Close()
+
+	date, err := http.ParseTime(response.Header.Get("Date"))
+	if err != nil {
+		return 0, fmt.Errorf("No usable Date header from %s: %s", url, err)
+	}
+	// The server read its clock somewhere during the request.
+	local := start.Add(end.Sub(start) / 2)
+	return local.Sub(date).Round(time.Second), nil
+}
+
+// ClockSkewTooLarge answers whether skew, from ClockSkew, exceeds
+// MaxClockSkew.
+func ClockSkewTooLarge(skew time.Duration) bool {
+	return skew > MaxClockSkew || skew < -MaxClockSkew
+}
@@ -0,0 +1,65 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package gcp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClockSkew(t *testing.T) {
+	var offset time.Duration
+	var date string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if date == "" {
+			w.Header().Set("Date", time.Now().Add(offset).UTC().Format(http.TimeFormat))
+		} else {
+			w.Header().Set("Date", date)
+		}
+	}))
+	defer s.Close()
+
+	for _, offset = range []time.Duration{0, 5 * time.Minute, -5 * time.Minute} {
+		skew, err := ClockSkew(s.URL, time.Second)
+		if err != nil {
+			t.Logf("unexpected error: %s", err)
+			t.Fail()
+			continue
+		}
+		// The Date header has a precision of one second.
+		if diff := skew + offset; diff > 2*time.Second || diff < -2*time.Second {
+			t.Logf("expected a skew of about %s, got %s", -offset, skew)
+			t.Fail()
+		}
+	}
+
+	date = "yesterday"
+	if _, err := ClockSkew(s.URL, time.Second); err == nil {
+		t.Log("expected error for a bad Date header")
+		t.Fail()
+	}
+}
+
+func TestClockSkewTooLarge(t *testing.T) {
+	for skew, expected := range map[time.Duration]bool{
+		0:                           false,
+		MaxClockSkew:                false,
+		-MaxClockSkew:               false,
+		MaxClockSkew + time.Second:  true,
+		-MaxClockSkew - time.Second: true,
+		30 * time.Second:            false,
+	} {
+		if tooLarge := ClockSkewTooLarge(skew); tooLarge != expected {
+			t.Logf("expected ClockSkewTooLarge(%s) to be %t", skew, expected)
+			t.Fail()
+		}
+	}
+}
@@ -11,6 +11,7 @@ package gcp
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -21,6 +22,7 @@ import (
 	"os"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"golang.org/x/oauth2"
@@ -41,30 +43,50 @@ const (
 	ScopeCloudPrint = "https://www.googleapis.com/auth/cloudprint"
 	ScopeGoogleTalk = "https://www.googleapis.com/auth/googletalk"
 	AccessType      = "offline"
+
+	// ScopeDirectoryGroups lets the user credentials list the groups of a
+	// domain, to resolve a share scope pattern.
+	ScopeDirectoryGroups = "https://www.googleapis.com/auth/admin.directory.group.readonly"
 )
 
 // GoogleCloudPrint is the interface between Go and the Google Cloud Print API.
 type GoogleCloudPrint struct {
 	baseURL                 string
-	robotClient             *http.Client
 	userClient              *http.Client
 	proxyName               string
 	xmppPingIntervalDefault time.Duration
 
+	authMutex               sync.Mutex
+	robotClient             *http.Client
+	robotRefreshToken       string
+	robotRefreshTokenLoader func() (string, error)
+	newRobotClient          func(refreshToken string) (*http.Client, error)
+	authFailures            uint
+	authDegraded            bool
+	authRetryAt             time.Time
+
 	jobs              chan<- *lib.Job
 	downloadSemaphore *lib.Semaphore
+	downloadTimeout   time.Duration
+	tempDir           string
+
+	// Submitted content types of jobs to print; see lib.JobFormatAccepted.
+	acceptedJobFormats []string
 }
 
 // NewGoogleCloudPrint establishes a connection with GCP, returns a new GoogleCloudPrint object.
-func NewGoogleCloudPrint(baseURL, robotRefreshToken, userRefreshToken, proxyName, oauthClientID, oauthClientSecret, oauthAuthURL, oauthTokenURL string, maxConcurrentDownload uint, jobs chan<- *lib.Job) (*GoogleCloudPrint, error) {
-	robotClient, err := newClient(oauthClientID, oauthClientSecret, oauthAuthURL, oauthTokenURL, robotRefreshToken, ScopeCloudPrint, ScopeGoogleTalk)
+func NewGoogleCloudPrint(baseURL, robotRefreshToken, userRefreshToken, proxyName, oauthClientID, oauthClientSecret, oauthAuthURL, oauthTokenURL string, apiTimeout, downloadTimeout time.Duration, maxConcurrentDownload uint, tempDir string, acceptedJobFormats []string, jobs chan<- *lib.Job) (*GoogleCloudPrint, error) {
+	newRobotClient := func(refreshToken string) (*http.Client, error) {
+		return newClient(oauthClientID, oauthClientSecret, oauthAuthURL, oauthTokenURL, apiTimeout, refreshToken, ScopeCloudPrint, ScopeGoogleTalk)
+	}
+	robotClient, err := newRobotClient(robotRefreshToken)
 	if err != nil {
 		return nil, err
 	}
 
 	var userClient *http.Client
 	if userRefreshToken != "" {
-		userClient, err = newClient(oauthClientID, oauthClientSecret, oauthAuthURL, oauthTokenURL, userRefreshToken, ScopeCloudPrint)
+		userClient, err = newClient(oauthClientID, oauthClientSecret, oauthAuthURL, oauthTokenURL, apiTimeout, userRefreshToken, ScopeCloudPrint)
 		if err != nil {
 			return nil, err
 		}
@@ -72,24 +94,55 @@ func NewGoogleCloudPrint(baseURL, robotRefreshToken, userRefreshToken, proxyName
 
 	gcp := &GoogleCloudPrint{
 		baseURL:           baseURL,
-		robotClient:       robotClient,
 		userClient:        userClient,
 		proxyName:         proxyName,
+		robotClient:       robotClient,
+		robotRefreshToken: robotRefreshToken,
+		newRobotClient:    newRobotClient,
 		jobs:              jobs,
 		downloadSemaphore: lib.NewSemaphore(maxConcurrentDownload),
+		downloadTimeout:   downloadTimeout,
+		tempDir:           tempDir,
+
+		acceptedJobFormats: acceptedJobFormats,
 	}
 
 	return gcp, nil
 }
 
 func (gcp *GoogleCloudPrint) GetRobotAccessToken() (string, error) {
-	token, err := gcp.robotClient.Transport.(*oauth2.Transport).Source.Token()
+	token, err := gcp.robotToken()
 	if err != nil {
 		return "", err
 	}
 	return token.AccessToken, nil
 }
 
+// GetRobotScopes returns the scopes granted to the robot access token. The
+// second return value is false when the token endpoint didn't say.
+func (gcp *GoogleCloudPrint) GetRobotScopes() ([]string, bool, error) {
+	token, err := gcp.robotToken()
+	if err != nil {
+		return nil, false, err
+	}
+	scopes, ok := TokenScopes(token)
+	return scopes, ok, nil
+}
+
+func (gcp *GoogleCloudPrint) robotToken() (*oauth2.Token, error) {
+	robotClient, err := gcp.robot()
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := robotClient.Transport.(*oauth2.Transport).Source.Token()
+	if _, ok := err.(*oauth2.RetrieveError); ok {
+		err = &AuthError{err}
+	}
+	gcp.authResult(err)
+	return token, err
+}
+
 // CanShare answers the question "can we share printers when they are registered?"
 func (gcp *GoogleCloudPrint) CanShare() bool {
 	return gcp.userClient != nil
@@ -97,7 +150,13 @@ func (gcp *GoogleCloudPrint) CanShare() bool {
 
 // Control calls google.com/cloudprint/control to set the state of a
 // GCP print job.
-func (gcp *GoogleCloudPrint) Control(jobID string, state cdd.PrintJobStateDiff) error {
+func (gcp *GoogleCloudPrint) Control(ctx context.Context, jobID string, state cdd.PrintJobStateDiff) error {
+	return gcp.ControlWithMessage(ctx, jobID, state, "")
+}
+
+// ControlWithMessage is Control, with a message that GCP shows with the job
+// state.
+func (gcp *GoogleCloudPrint) ControlWithMessage(ctx context.Context, jobID string, state cdd.PrintJobStateDiff, message string) error {
 	semanticState, err := json.Marshal(state)
 	if err != nil {
 		return err
@@ -106,8 +165,11 @@ func (gcp *GoogleCloudPrint) Control(jobID string, state cdd.PrintJobStateDiff)
 	form := url.Values{}
 	form.Set("jobid", jobID)
 	form.Set("semantic_state_diff", string(semanticState))
+	if message != "" {
+		form.Set("message", message)
+	}
 
-	if _, _, _, err := postWithRetry(gcp.robotClient, gcp.baseURL+"control", form); err != nil {
+	if _, _, _, err := gcp.robotPost(ctx, gcp.baseURL+"control", form); err != nil {
 		return err
 	}
 
@@ -115,11 +177,11 @@ func (gcp *GoogleCloudPrint) Control(jobID string, state cdd.PrintJobStateDiff)
 }
 
 // Delete calls google.com/cloudprint/delete to delete a printer from GCP.
-func (gcp *GoogleCloudPrint) Delete(gcpID string) error {
+func (gcp *GoogleCloudPrint) Delete(ctx context.Context, gcpID string) error {
 	form := url.Values{}
 	form.Set("printerid", gcpID)
 
-	if _, _, _, err := postWithRetry(gcp.robotClient, gcp.baseURL+"delete", form); err != nil {
+	if _, _, _, err := gcp.robotPost(ctx, gcp.baseURL+"delete", form); err != nil {
 		return err
 	}
 
@@ -127,11 +189,11 @@ func (gcp *GoogleCloudPrint) Delete(gcpID string) error {
 }
 
 // DeleteJob calls google.com/cloudprint/deletejob to delete a print job.
-func (gcp *GoogleCloudPrint) DeleteJob(gcpJobID string) error {
+func (gcp *GoogleCloudPrint) DeleteJob(ctx context.Context, gcpJobID string) error {
 	form := url.Values{}
 	form.Set("jobid", gcpJobID)
 
-	if _, _, _, err := postWithRetry(gcp.robotClient, gcp.baseURL+"deletejob", form); err != nil {
+	if _, _, _, err := gcp.robotPost(ctx, gcp.baseURL+"deletejob", form); err != nil {
 		return err
 	}
 
@@ -140,11 +202,11 @@ func (gcp *GoogleCloudPrint) DeleteJob(gcpJobID string) error {
 
 // Fetch calls google.com/cloudprint/fetch to get the outstanding print jobs for
 // a GCP printer.
-func (gcp *GoogleCloudPrint) Fetch(gcpID string) ([]Job, error) {
+func (gcp *GoogleCloudPrint) Fetch(ctx context.Context, gcpID string) ([]Job, error) {
 	form := url.Values{}
 	form.Set("printerid", gcpID)
 
-	responseBody, errorCode, _, err := postWithRetry(gcp.robotClient, gcp.baseURL+"fetch", form)
+	responseBody, errorCode, _, err := gcp.robotPost(ctx, gcp.baseURL+"fetch", form)
 	if err != nil {
 		if errorCode == 413 {
 			// 413 means "Zero print jobs returned", which isn't really an error.
@@ -155,10 +217,11 @@ func (gcp *GoogleCloudPrint) Fetch(gcpID string) ([]Job, error) {
 
 	var jobsData struct {
 		Jobs []struct {
-			ID      string
-			Title   string
-			FileURL string
-			OwnerID string
+			ID          string
+			Title       string
+			FileURL     string
+			OwnerID     string
+			ContentType string
 		}
 	}
 	if err = json.Unmarshal(responseBody, &jobsData); err != nil {
@@ -174,6 +237,7 @@ func (gcp *GoogleCloudPrint) Fetch(gcpID string) ([]Job, error) {
 			FileURL:      jobData.FileURL,
 			OwnerID:      jobData.OwnerID,
 			Title:        jobData.Title,
+			ContentType:  jobData.ContentType,
 		}
 	}
 
@@ -181,11 +245,11 @@ func (gcp *GoogleCloudPrint) Fetch(gcpID string) ([]Job, error) {
 }
 
 // Jobs calls google.com/cloudprint/jobs to get print jobs for a GCP printer.
-func (gcp *GoogleCloudPrint) Jobs(gcpID string) ([]Job, error) {
+func (gcp *GoogleCloudPrint) Jobs(ctx context.Context, gcpID string) ([]Job, error) {
 	form := url.Values{}
 	form.Set("printerid", gcpID)
 
-	responseBody, _, _, err := postWithRetry(gcp.robotClient, gcp.baseURL+"jobs", form)
+	responseBody, _, _, err := gcp.robotPost(ctx, gcp.baseURL+"jobs", form)
 	if err != nil {
 		return nil, err
 	}
@@ -222,12 +286,12 @@ func (gcp *GoogleCloudPrint) Jobs(gcpID string) ([]Job, error) {
 //
 // Returns map of GCPID => printer name. GCPID is unique to GCP; printer name
 // should be unique to CUPS. Use Printer to get details about each printer.
-func (gcp *GoogleCloudPrint) List() (map[string]string, error) {
+func (gcp *GoogleCloudPrint) List(ctx context.Context) (map[string]string, error) {
 	form := url.Values{}
 	form.Set("proxy", gcp.proxyName)
 	form.Set("extra_fields", "-tags")
 
-	responseBody, _, _, err := postWithRetry(gcp.robotClient, gcp.baseURL+"list", form)
+	responseBody, _, _, err := gcp.robotPost(ctx, gcp.baseURL+"list", form)
 	if err != nil {
 		return nil, err
 	}
@@ -253,7 +317,7 @@ func (gcp *GoogleCloudPrint) List() (map[string]string, error) {
 // Register calls google.com/cloudprint/register to register a GCP printer.
 //
 // Sets the GCPID field in the printer arg.
-func (gcp *GoogleCloudPrint) Register(printer *lib.Printer) error {
+func (gcp *GoogleCloudPrint) Register(ctx context.Context, printer *lib.Printer) error {
 	capabilities, err := marshalCapabilities(printer.Description)
 	if err != nil {
 		return err
@@ -290,7 +354,7 @@ func (gcp *GoogleCloudPrint) Register(printer *lib.Printer) error {
 		form.Add("tag", fmt.Sprintf("%s%s=%s", gcpTagPrefix, key, printer.Tags[key]))
 	}
 
-	responseBody, _, _, err := postWithRetry(gcp.robotClient, gcp.baseURL+"register", form)
+	responseBody, _, _, err := gcp.robotPost(ctx, gcp.baseURL+"register", form)
 	if err != nil {
 		return err
 	}
@@ -310,7 +374,7 @@ func (gcp *GoogleCloudPrint) Register(printer *lib.Printer) error {
 }
 
 // Update calls google.com/cloudprint/update to update a GCP printer.
-func (gcp *GoogleCloudPrint) Update(diff *lib.PrinterDiff) error {
+func (gcp *GoogleCloudPrint) Update(ctx context.Context, diff *lib.PrinterDiff) error {
 	// Ignores Name field because it never changes.
 
 	form := url.Values{}
@@ -320,6 +384,9 @@ func (gcp *GoogleCloudPrint) Update(diff *lib.PrinterDiff) error {
 	if diff.DefaultDisplayNameChanged {
 		form.Set("default_display_name", diff.Printer.DefaultDisplayName)
 	}
+	if diff.UUIDChanged {
+		form.Set("uuid", diff.Printer.UUID)
+	}
 	if diff.ManufacturerChanged {
 		form.Set("manufacturer", diff.Printer.Manufacturer)
 	}
@@ -374,7 +441,7 @@ func (gcp *GoogleCloudPrint) Update(diff *lib.PrinterDiff) error {
 		form.Set("remove_tag", gcpTagPrefix+".*")
 	}
 
-	if _, _, _, err := postWithRetry(gcp.robotClient, gcp.baseURL+"update", form); err != nil {
+	if _, _, _, err := gcp.robotPost(ctx, gcp.baseURL+"update", form); err != nil {
 		return err
 	}
 
@@ -384,13 +451,13 @@ func (gcp *GoogleCloudPrint) Update(diff *lib.PrinterDiff) error {
 // Printer gets the printer identified by it's GCPID.
 //
 // The second return value is queued print job quantity.
-func (gcp *GoogleCloudPrint) Printer(gcpID string) (*lib.Printer, uint, error) {
+func (gcp *GoogleCloudPrint) Printer(ctx context.Context, gcpID string) (*lib.Printer, uint, error) {
 	form := url.Values{}
 	form.Set("printerid", gcpID)
 	form.Set("use_cdd", "true")
 	form.Set("extra_fields", "queuedJobsCount,semanticState")
 
-	responseBody, _, _, err := postWithRetry(gcp.robotClient, gcp.baseURL+"printer", form)
+	responseBody, _, _, err := gcp.robotPost(ctx, gcp.baseURL+"printer", form)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -468,7 +535,7 @@ func marshalCapabilities(description *cdd.PrinterDescriptionSection) (string, er
 }
 
 // Share calls google.com/cloudprint/share to share a registered GCP printer.
-func (gcp *GoogleCloudPrint) Share(gcpID, shareScope string) error {
+func (gcp *GoogleCloudPrint) Share(ctx context.Context, gcpID, shareScope string) error {
 	if gcp.userClient == nil {
 		return errors.New("Cannot share because user OAuth credentials not provided.")
 	}
@@ -479,7 +546,25 @@ func (gcp *GoogleCloudPrint) Share(gcpID, shareScope string) error {
 	form.Set("role", "USER")
 	form.Set("skip_notification", "true")
 
-	if _, _, _, err := postWithRetry(gcp.userClient, gcp.baseURL+"share", form); err != nil {
+	if _, _, _, err := postWithRetry(ctx, gcp.userClient, gcp.baseURL+"share", form); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Unshare calls google.com/cloudprint/unshare to stop sharing a registered
+// GCP printer with shareScope.
+func (gcp *GoogleCloudPrint) Unshare(ctx context.Context, gcpID, shareScope string) error {
+	if gcp.userClient == nil {
+		return errors.New("Cannot unshare because user OAuth credentials not provided.")
+	}
+
+	form := url.Values{}
+	form.Set("printerid", gcpID)
+	form.Set("scope", shareScope)
+
+	if _, _, _, err := postWithRetry(ctx, gcp.userClient, gcp.baseURL+"unshare", form); err != nil {
 		return err
 	}
 
@@ -487,8 +572,25 @@ func (gcp *GoogleCloudPrint) Share(gcpID, shareScope string) error {
 }
 
 // Download downloads a URL (a print job data file) directly to a Writer.
-func (gcp *GoogleCloudPrint) Download(dst io.Writer, url string) error {
-	response, err := getWithRetry(gcp.robotClient, url)
+// The request, its retry, and reading the response body must all finish
+// within the download timeout, so that a stalled download gives up its
+// download slot.
+func (gcp *GoogleCloudPrint) Download(ctx context.Context, dst io.Writer, url string) error {
+	if gcp.downloadTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, gcp.downloadTimeout)
+		defer cancel()
+	}
+
+	err := gcp.download(ctx, dst, url)
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("Download didn't finish within %s: %s", gcp.downloadTimeout, err)
+	}
+	return err
+}
+
+func (gcp *GoogleCloudPrint) download(ctx context.Context, dst io.Writer, url string) error {
+	response, err := gcp.robotGet(ctx, url)
 	if err != nil {
 		return err
 	}
@@ -503,12 +605,12 @@ func (gcp *GoogleCloudPrint) Download(dst io.Writer, url string) error {
 }
 
 // Ticket gets a ticket, aka print job options.
-func (gcp *GoogleCloudPrint) Ticket(gcpJobID string) (*cdd.CloudJobTicket, error) {
+func (gcp *GoogleCloudPrint) Ticket(ctx context.Context, gcpJobID string) (*cdd.CloudJobTicket, error) {
 	form := url.Values{}
 	form.Set("jobid", gcpJobID)
 	form.Set("use_cjt", "true")
 
-	responseBody, _, httpStatusCode, err := postWithRetry(gcp.robotClient, gcp.baseURL+"ticket", form)
+	responseBody, _, httpStatusCode, err := gcp.robotPost(ctx, gcp.baseURL+"ticket", form)
 	// The /ticket API is different than others, because it only returns the
 	// standard GCP error information on success=false.
 	if httpStatusCode != http.StatusOK {
@@ -537,7 +639,7 @@ func (gcp *GoogleCloudPrint) ProximityToken(gcpID, user string) ([]byte, int, er
 	form.Set("printerid", gcpID)
 	form.Set("user", user)
 
-	responseBody, _, httpStatus, err := postWithRetry(gcp.robotClient, gcp.baseURL+"proximitytoken", form)
+	responseBody, _, httpStatus, err := gcp.robotPost(context.Background(), gcp.baseURL+"proximitytoken", form)
 	return responseBody, httpStatus, err
 }
 
@@ -546,8 +648,8 @@ func (gcp *GoogleCloudPrint) ProximityToken(gcpID, user string) ([]byte, int, er
 // info, which the List API does not provide.
 //
 // The second return value is a map of GCPID -> queued print job quantity.
-func (gcp *GoogleCloudPrint) ListPrinters() ([]lib.Printer, map[string]uint, error) {
-	ids, err := gcp.List()
+func (gcp *GoogleCloudPrint) ListPrinters(ctx context.Context) ([]lib.Printer, map[string]uint, error) {
+	ids, err := gcp.List(ctx)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -560,7 +662,7 @@ func (gcp *GoogleCloudPrint) ListPrinters() ([]lib.Printer, map[string]uint, err
 	ch := make(chan response)
 	for id := range ids {
 		go func(id string) {
-			printer, queuedJobsCount, err := gcp.Printer(id)
+			printer, queuedJobsCount, err := gcp.Printer(ctx, id)
 			ch <- response{printer, queuedJobsCount, err}
 		}(id)
 	}
@@ -597,15 +699,21 @@ func (gcp *GoogleCloudPrint) ListPrinters() ([]lib.Printer, map[string]uint, err
 	}
 }
 
-// HandleJobs gets and processes jobs waiting on a printer.
-func (gcp *GoogleCloudPrint) HandleJobs(printer *lib.Printer, reportJobFailed func()) {
-	jobs, err := gcp.Fetch(printer.GCPID)
+// HandleJobs gets and processes jobs waiting on a printer. Jobs for which
+// skipJob is true, like jobs that are already being printed, are left alone
+// before their files are downloaded.
+func (gcp *GoogleCloudPrint) HandleJobs(ctx context.Context, printer *lib.Printer, reportJobFailed func(), skipJob func(jobID string) bool) {
+	jobs, err := gcp.Fetch(ctx, printer.GCPID)
 	if err != nil {
 		log.Errorf("Failed to fetch jobs for GCP printer %s: %s", printer.GCPID, err)
-	} else {
-		for i := range jobs {
-			go gcp.processJob(&jobs[i], printer, reportJobFailed)
+		return
+	}
+	for i := range jobs {
+		if skipJob != nil && skipJob(jobs[i].GCPJobID) {
+			log.DebugJob(jobs[i].GCPJobID, "Skipped, since it is already being handled")
+			continue
 		}
+		go gcp.processJob(ctx, &jobs[i], printer, reportJobFailed)
 	}
 }
 
@@ -617,14 +725,14 @@ func (gcp *GoogleCloudPrint) HandleJobs(printer *lib.Printer, reportJobFailed fu
 // 4) Deletes temporary file.
 //
 // Nothing is returned; intended for use as goroutine.
-func (gcp *GoogleCloudPrint) processJob(job *Job, printer *lib.Printer, reportJobFailed func()) {
+func (gcp *GoogleCloudPrint) processJob(ctx context.Context, job *Job, printer *lib.Printer, reportJobFailed func()) {
 	log.InfoJobf(job.GCPJobID, "Received from cloud")
 
-	ticket, filename, message, state := gcp.assembleJob(job)
-	if message != "" {
+	ticket, filename, jobErr := gcp.assembleJob(ctx, job)
+	if jobErr != nil {
 		reportJobFailed()
-		log.ErrorJob(job.GCPJobID, message)
-		if err := gcp.Control(job.GCPJobID, state); err != nil {
+		log.ErrorJob(job.GCPJobID, jobErr)
+		if err := gcp.ControlWithMessage(ctx, job.GCPJobID, jobErr.State(), jobErr.Error()); err != nil {
 			log.ErrorJob(job.GCPJobID, err)
 		}
 		return
@@ -637,62 +745,61 @@ func (gcp *GoogleCloudPrint) processJob(job *Job, printer *lib.Printer, reportJo
 		User:            job.OwnerID,
 		JobID:           job.GCPJobID,
 		Ticket:          ticket,
-		UpdateJob:       gcp.Control,
+		UpdateJob: func(jobID string, state cdd.PrintJobStateDiff) error {
+			// Job state is reported even while shutting down.
+			return gcp.Control(context.Background(), jobID, state)
+		},
+		UpdateJobWithMessage: func(jobID string, state cdd.PrintJobStateDiff, message string) error {
+			return gcp.ControlWithMessage(context.Background(), jobID, state, message)
+		},
 	}
 }
 
 // assembleJob prepares for printing a job by fetching the job's ticket and payload.
+// Jobs of formats that aren't accepted fail before anything is fetched.
 //
 // The caller is responsible to remove the returned file.
 //
-// Errors are returned as a string (last return value), for reporting
-// to GCP and local log.
-func (gcp *GoogleCloudPrint) assembleJob(job *Job) (*cdd.CloudJobTicket, string, string, cdd.PrintJobStateDiff) {
-	ticket, err := gcp.Ticket(job.GCPJobID)
+// Errors are returned as a JobError, for reporting to GCP and local log.
+func (gcp *GoogleCloudPrint) assembleJob(ctx context.Context, job *Job) (*cdd.CloudJobTicket, string, *lib.JobError) {
+	if !lib.JobFormatAccepted(job.ContentType, gcp.acceptedJobFormats) {
+		return nil, "", lib.NewJobError(lib.JobErrorUnsupportedFormat, "Content type %s is not one of the accepted job formats %s",
+			job.ContentType, strings.Join(gcp.acceptedJobFormats, ", "))
+	}
+
+	ticket, err := gcp.Ticket(ctx, job.GCPJobID)
 	if err != nil {
-		return nil, "",
-			fmt.Sprintf("Failed to get a ticket: %s", err),
-			cdd.PrintJobStateDiff{
-				State: &cdd.JobState{
-					Type:              cdd.JobStateAborted,
-					DeviceActionCause: &cdd.DeviceActionCause{ErrorCode: cdd.DeviceActionCauseInvalidTicket},
-				},
-			}
+		return nil, "", lib.NewJobError(jobErrorCategory(err), "Failed to get a ticket: %s", err)
 	}
 
-	file, err := ioutil.TempFile("", "cups-connector-gcp-")
+	file, err := ioutil.TempFile(gcp.tempDir, "cups-connector-gcp-")
 	if err != nil {
-		return nil, "",
-			fmt.Sprintf("Failed to create a temporary file: %s", err),
-			cdd.PrintJobStateDiff{
-				State: &cdd.JobState{
-					Type:              cdd.JobStateAborted,
-					DeviceActionCause: &cdd.DeviceActionCause{ErrorCode: cdd.DeviceActionCauseOther},
-				},
-			}
+		return nil, "", lib.NewJobError(lib.JobErrorCUPSSubmit, "Failed to create a temporary file: %s", err)
 	}
 
 	gcp.downloadSemaphore.Acquire()
 	t := time.Now()
 	// Do not check err until semaphore is released and timer is stopped.
-	err = gcp.Download(file, job.FileURL)
+	err = gcp.Download(ctx, file, job.FileURL)
 	dt := time.Since(t)
 	gcp.downloadSemaphore.Release()
 	if err != nil {
 		// Clean up this temporary file so the caller doesn't need extra logic.
 		os.Remove(file.Name())
-		return nil, "",
-			fmt.Sprintf("Failed to download data: %s", err),
-			cdd.PrintJobStateDiff{
-				State: &cdd.JobState{
-					Type:              cdd.JobStateAborted,
-					DeviceActionCause: &cdd.DeviceActionCause{ErrorCode: cdd.DeviceActionCauseDownloadFailure},
-				},
-			}
+		return nil, "", lib.NewJobError(jobErrorCategory(err), "Failed to download data: %s", err)
 	}
 
 	log.InfoJobf(job.GCPJobID, "Downloaded in %s", dt.String())
 	defer file.Close()
 
-	return ticket, file.Name(), "", cdd.PrintJobStateDiff{}
+	return ticket, file.Name(), nil
+}
+
+// jobErrorCategory tells GCP rejecting the connector's credentials from other
+// failures to talk to GCP.
+func jobErrorCategory(err error) lib.JobErrorCategory {
+	if _, ok := err.(*AuthError); ok {
+		return lib.JobErrorAuth
+	}
+	return lib.JobErrorNetwork
 }
@@ -9,12 +9,15 @@ https://developers.google.com/open-source/licenses/bsd
 package gcp
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 
 	"github.com/google/cups-connector/lib"
 
@@ -39,9 +42,58 @@ https://github.com/golang/go/issues/6336
 */
 var lock *lib.Semaphore = lib.NewSemaphore(100)
 
+// AuthError means that GCP, or the OAuth token endpoint, rejected the OAuth
+// credentials.
+type AuthError struct {
+	err error
+}
+
+func (e *AuthError) Error() string {
+	return e.err.Error()
+}
+
+// HTTPError means that a GCP call failed with an HTTP error status, or, when
+// StatusCode is 0, without a response.
+type HTTPError struct {
+	StatusCode int
+	err        error
+}
+
+func (e *HTTPError) Error() string {
+	return e.err.Error()
+}
+
+// Retryable checks whether a GCP call that failed with err may succeed when
+// made again: when GCP was unreachable, overloaded or failing. Rejected
+// credentials and other client errors, like a bad request, are permanent, as
+// are calls that GCP answered without success.
+func Retryable(err error) bool {
+	e, ok := err.(*HTTPError)
+	if !ok {
+		return false
+	}
+	return e.StatusCode == 0 || e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= http.StatusInternalServerError
+}
+
+// isTokenError checks whether err, returned by http.Client.Do, is the failure
+// to refresh an OAuth access token.
+func isTokenError(err error) bool {
+	if ue, ok := err.(*url.Error); ok {
+		_, ok = ue.Err.(*oauth2.RetrieveError)
+		return ok
+	}
+	return false
+}
+
 // newClient creates an instance of http.Client, wrapped with OAuth credentials.
-func newClient(oauthClientID, oauthClientSecret, oauthAuthURL, oauthTokenURL, refreshToken string, scopes ...string) (*http.Client, error) {
-	config := oauth2.Config{
+func newClient(oauthClientID, oauthClientSecret, oauthAuthURL, oauthTokenURL string, timeout time.Duration, refreshToken string, scopes ...string) (*http.Client, error) {
+	config := NewOAuthConfig(oauthClientID, oauthClientSecret, oauthAuthURL, oauthTokenURL, scopes...)
+	return NewOAuthClient(config, refreshToken, timeout), nil
+}
+
+// NewOAuthConfig creates the OAuth config of the GCP OAuth client.
+func NewOAuthConfig(oauthClientID, oauthClientSecret, oauthAuthURL, oauthTokenURL string, scopes ...string) *oauth2.Config {
+	return &oauth2.Config{
 		ClientID:     oauthClientID,
 		ClientSecret: oauthClientSecret,
 		Endpoint: oauth2.Endpoint{
@@ -51,69 +103,173 @@ func newClient(oauthClientID, oauthClientSecret, oauthAuthURL, oauthTokenURL, re
 		RedirectURL: RedirectURL,
 		Scopes:      scopes,
 	}
+}
 
+// NewHTTPClient creates the http.Client that every GCP and OAuth request is
+// made with. Requests go through http.DefaultTransport, as it is when they
+// are made, so that the proxy from the environment, ca_cert_file and request
+// debugging apply, and wait no more than timeout for a response; 0 means no
+// limit. Reading the response body isn't limited, so large downloads work.
+// Each request is counted in GetCallStats.
+func NewHTTPClient(timeout time.Duration) *http.Client {
+	return &http.Client{Transport: callStatsTransport{responseTimeoutTransport(timeout)}}
+}
+
+// OAuthContext returns the context for oauth2 calls, like Config.Exchange,
+// which makes them use NewHTTPClient(timeout).
+func OAuthContext(timeout time.Duration) context.Context {
+	return context.WithValue(context.Background(), oauth2.HTTPClient, NewHTTPClient(timeout))
+}
+
+// NewOAuthClient creates an http.Client wrapped with OAuth credentials. API
+// requests and access token refreshes are both made with
+// NewHTTPClient(timeout).
+func NewOAuthClient(config *oauth2.Config, refreshToken string, timeout time.Duration) *http.Client {
 	token := oauth2.Token{RefreshToken: refreshToken}
-	client := config.Client(oauth2.NoContext, &token)
+	return config.Client(OAuthContext(timeout), &token)
+}
 
-	return client, nil
+// TokenScopes returns the scopes granted to token, from the scope field of
+// the token response. The second return value is false when there is no such
+// field.
+func TokenScopes(token *oauth2.Token) ([]string, bool) {
+	scope, ok := token.Extra("scope").(string)
+	if !ok {
+		return nil, false
+	}
+	return strings.Fields(scope), true
+}
+
+// MissingScopes returns the scopes in requested that aren't in granted.
+func MissingScopes(requested, granted []string) []string {
+	var missing []string
+	for _, r := range requested {
+		found := false
+		for _, g := range granted {
+			if g == r {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, r)
+		}
+	}
+	return missing
+}
+
+// responseTimeoutTransport limits the wait for response headers to its
+// duration, when it is positive.
+type responseTimeoutTransport time.Duration
+
+func (t responseTimeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t <= 0 {
+		return http.DefaultTransport.RoundTrip(req)
+	}
+
+	ctx, cancel := context.WithCancel(req.Context())
+	timer := time.AfterFunc(time.Duration(t), cancel)
+	response, err := http.DefaultTransport.RoundTrip(req.WithContext(ctx))
+	if !timer.Stop() {
+		if err == nil {
+			response.Body.Close()
+		}
+		cancel()
+		return nil, fmt.Errorf("No response from %s within %s", req.URL.Host, time.Duration(t))
+	}
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	response.Body = &cancelOnClose{response.Body, cancel}
+	return response, nil
+}
+
+// cancelOnClose cancels the context of a response when its body is closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
 }
 
 // getWithRetry calls get() and retries once on HTTP failure
-// (response code != 200).
-func getWithRetry(hc *http.Client, url string) (*http.Response, error) {
-	response, err := get(hc, url)
+// (response code != 200), except when the credentials were rejected or ctx
+// is done.
+func getWithRetry(ctx context.Context, hc *http.Client, url string) (*http.Response, error) {
+	response, err := get(ctx, hc, url)
 	if response != nil && response.StatusCode == http.StatusOK {
 		return response, err
 	}
+	if _, ok := err.(*AuthError); ok || ctx.Err() != nil {
+		return response, err
+	}
 
-	return get(hc, url)
+	return get(ctx, hc, url)
 }
 
 // get GETs a URL. Returns the response object (not body), in case the body
 // is very large.
 //
 // The caller must close the returned Response.Body object if err == nil.
-func get(hc *http.Client, url string) (*http.Response, error) {
+func get(ctx context.Context, hc *http.Client, url string) (*http.Response, error) {
 	request, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
+	request = request.WithContext(ctx)
 	request.Header.Set("X-CloudPrint-Proxy", lib.ShortName)
 
 	lock.Acquire()
 	response, err := hc.Do(request)
 	lock.Release()
 	if err != nil {
-		return nil, fmt.Errorf("GET failure: %s", err)
+		if isTokenError(err) {
+			return nil, &AuthError{fmt.Errorf("GET failure: %s", err)}
+		}
+		return nil, &HTTPError{0, fmt.Errorf("GET failure: %s", err)}
 	}
 	if response.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("GET HTTP-level failure: %s %s", url, response.Status)
+		err = fmt.Errorf("GET HTTP-level failure: %s %s", url, response.Status)
+		if response.StatusCode == http.StatusUnauthorized {
+			return nil, &AuthError{err}
+		}
+		return nil, &HTTPError{response.StatusCode, err}
 	}
 
 	return response, nil
 }
 
 // postWithRetry calls post() and retries once on HTTP failure
-// (response code != 200).
-func postWithRetry(hc *http.Client, url string, form url.Values) ([]byte, uint, int, error) {
-	responseBody, gcpErrorCode, httpStatusCode, err := post(hc, url, form)
+// (response code != 200), except when the credentials were rejected or ctx
+// is done.
+func postWithRetry(ctx context.Context, hc *http.Client, url string, form url.Values) ([]byte, uint, int, error) {
+	responseBody, gcpErrorCode, httpStatusCode, err := post(ctx, hc, url, form)
 	if responseBody != nil && httpStatusCode == http.StatusOK {
 		return responseBody, gcpErrorCode, httpStatusCode, err
 	}
+	if _, ok := err.(*AuthError); ok || ctx.Err() != nil {
+		return responseBody, gcpErrorCode, httpStatusCode, err
+	}
 
-	return post(hc, url, form)
+	return post(ctx, hc, url, form)
 }
 
 // post POSTs to a URL. Returns the body of the response.
 //
 // Returns the response body, GCP error code, HTTP status, and error.
 // None of the returned fields is guaranteed to be non-zero.
-func post(hc *http.Client, url string, form url.Values) ([]byte, uint, int, error) {
+func post(ctx context.Context, hc *http.Client, url string, form url.Values) ([]byte, uint, int, error) {
 	requestBody := strings.NewReader(form.Encode())
 	request, err := http.NewRequest("POST", url, requestBody)
 	if err != nil {
 		return nil, 0, 0, err
 	}
+	request = request.WithContext(ctx)
 	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	request.Header.Set("X-CloudPrint-Proxy", lib.ShortName)
 
@@ -121,17 +277,26 @@ func post(hc *http.Client, url string, form url.Values) ([]byte, uint, int, erro
 	response, err := hc.Do(request)
 	lock.Release()
 	if err != nil {
-		return nil, 0, 0, fmt.Errorf("POST failure: %s", err)
+		if isTokenError(err) {
+			return nil, 0, 0, &AuthError{fmt.Errorf("POST failure: %s", err)}
+		}
+		return nil, 0, 0, &HTTPError{0, fmt.Errorf("POST failure: %s", err)}
 	}
 
 	defer response.Body.Close()
 	responseBody, err := ioutil.ReadAll(response.Body)
 	if err != nil {
-		return nil, 0, response.StatusCode, err
+		return nil, 0, response.StatusCode, &HTTPError{0, err}
 	}
 
 	if response.StatusCode != http.StatusOK {
-		return responseBody, 0, response.StatusCode, fmt.Errorf("/%s POST HTTP-level failure: %s", url, response.Status)
+		err = fmt.Errorf("/%s POST HTTP-level failure: %s", url, response.Status)
+		if response.StatusCode == http.StatusUnauthorized {
+			err = &AuthError{err}
+		} else {
+			err = &HTTPError{response.StatusCode, err}
+		}
+		return responseBody, 0, response.StatusCode, err
 	}
 
 	var responseStatus struct {
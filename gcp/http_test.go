@@ -0,0 +1,74 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package gcp
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestRetryable(t *testing.T) {
+	testCases := []struct {
+		err       error
+		retryable bool
+	}{
+		{&HTTPError{0, errors.New("connection refused")}, true},
+		{&HTTPError{http.StatusTooManyRequests, errors.New("429")}, true},
+		{&HTTPError{http.StatusInternalServerError, errors.New("500")}, true},
+		{&HTTPError{http.StatusServiceUnavailable, errors.New("503")}, true},
+		{&HTTPError{http.StatusBadRequest, errors.New("400")}, false},
+		{&HTTPError{http.StatusForbidden, errors.New("403")}, false},
+		{&AuthError{errors.New("401")}, false},
+		{errors.New("update call failed: Printer not found"), false},
+		{nil, false},
+	}
+	for _, tc := range testCases {
+		if retryable := Retryable(tc.err); retryable != tc.retryable {
+			t.Logf("expected Retryable(%v) to be %t", tc.err, tc.retryable)
+			t.Fail()
+		}
+	}
+}
+
+func TestPostRetryable(t *testing.T) {
+	var status int
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(`{"success": false, "message": "Printer not found"}`))
+	}))
+	defer s.Close()
+
+	for _, tc := range []struct {
+		status    int
+		retryable bool
+	}{
+		{http.StatusServiceUnavailable, true},
+		{http.StatusTooManyRequests, true},
+		{http.StatusBadRequest, false},
+		{http.StatusOK, false},
+	} {
+		status = tc.status
+		_, _, _, err := post(context.Background(), http.DefaultClient, s.URL, url.Values{})
+		if err == nil || Retryable(err) != tc.retryable {
+			t.Logf("expected HTTP %d to fail with retryable %t, got %v", tc.status, tc.retryable, err)
+			t.Fail()
+		}
+	}
+
+	s.Close()
+	_, _, _, err := post(context.Background(), http.DefaultClient, s.URL, url.Values{})
+	if !Retryable(err) {
+		t.Logf("expected an unreachable server to be retryable, got %v", err)
+		t.Fail()
+	}
+}
@@ -16,5 +16,6 @@ type Job struct {
 	FileURL       string
 	OwnerID       string
 	Title         string
+	ContentType   string
 	SemanticState *cdd.PrintJobState
 }
@@ -0,0 +1,99 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package gcp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+)
+
+// directoryGroupsURL lists the groups of a domain; see
+// https://developers.google.com/admin-sdk/directory/reference/rest/v1/groups/list
+var directoryGroupsURL = "https://admin.googleapis.com/admin/directory/v1/groups"
+
+// ShareScopeIsPattern answers whether shareScope has wildcards, like
+// print-*@example.com, which match the email addresses of groups.
+func ShareScopeIsPattern(shareScope string) bool {
+	return strings.ContainsAny(shareScope, "*?[")
+}
+
+// ResolveShareScope finds the scopes to share with for shareScope. A share
+// scope pattern resolves to the email address of every group of its domain
+// that matches it, ignoring case, which needs the user credentials to have
+// the ScopeDirectoryGroups scope. Any other share scope is itself.
+func (gcp *GoogleCloudPrint) ResolveShareScope(ctx context.Context, shareScope string) ([]string, error) {
+	if !ShareScopeIsPattern(shareScope) {
+		return []string{shareScope}, nil
+	}
+	if gcp.userClient == nil {
+		return nil, errors.New("Cannot resolve share scope pattern because user OAuth credentials not provided.")
+	}
+
+	pattern := strings.ToLower(shareScope)
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("Share scope pattern %s is malformed: %s", shareScope, err)
+	}
+	at := strings.LastIndex(pattern, "@")
+	if at < 0 || at == len(pattern)-1 || ShareScopeIsPattern(pattern[at+1:]) {
+		return nil, fmt.Errorf("Share scope pattern %s must end with @ and a domain without wildcards", shareScope)
+	}
+	domain := pattern[at+1:]
+
+	var scopes []string
+	var pageToken string
+	for {
+		query := url.Values{}
+		query.Set("domain", domain)
+		query.Set("maxResults", "200")
+		if pageToken != "" {
+			query.Set("pageToken", pageToken)
+		}
+
+		response, err := getWithRetry(ctx, gcp.userClient, directoryGroupsURL+"?"+query.Encode())
+		if err != nil {
+			return nil, fmt.Errorf("Failed to list the groups of %s, which needs user OAuth credentials with the %s scope; run gcp-cups-connector-util reauth to grant it: %s",
+				domain, ScopeDirectoryGroups, err)
+		}
+
+		var page struct {
+			Groups []struct {
+				Email string `json:"email"`
+			} `json:"groups"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		err = json.NewDecoder(response.Body).Decode(&page)
+		response.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse the groups of %s: %s", domain, err)
+		}
+
+		for _, group := range page.Groups {
+			if matched, _ := path.Match(pattern, strings.ToLower(group.Email)); matched {
+				scopes = append(scopes, group.Email)
+			}
+		}
+
+		if page.NextPageToken == "" {
+			break
+		}
+		pageToken = page.NextPageToken
+	}
+
+	if len(scopes) == 0 {
+		return nil, fmt.Errorf("Share scope pattern %s matches no group of %s", shareScope, domain)
+	}
+	sort.Strings(scopes)
+	return scopes, nil
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package gcp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestShareScopeIsPattern(t *testing.T) {
+	for shareScope, expected := range map[string]bool{
+		"alice@example.com":   false,
+		"example.com":         false,
+		"print-*@example.com": true,
+		"print-?@example.com": true,
+		"[ab]@example.com":    true,
+	} {
+		if isPattern := ShareScopeIsPattern(shareScope); isPattern != expected {
+			t.Logf("expected %s to be a pattern %t, got %t", shareScope, expected, isPattern)
+			t.Fail()
+		}
+	}
+}
+
+func TestResolveShareScope(t *testing.T) {
+	// Two pages of groups.
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if domain := r.URL.Query().Get("domain"); domain != "example.com" {
+			http.Error(w, "unexpected domain "+domain, http.StatusBadRequest)
+			return
+		}
+		if r.URL.Query().Get("pageToken") == "" {
+			fmt.Fprint(w, `{"groups":[{"email":"Print-B@example.com"},{"email":"staff@example.com"}],"nextPageToken":"2"}`)
+		} else {
+			fmt.Fprint(w, `{"groups":[{"email":"print-a@example.com"}]}`)
+		}
+	}))
+	defer s.Close()
+
+	defer func(u string) { directoryGroupsURL = u }(directoryGroupsURL)
+	directoryGroupsURL = s.URL
+
+	gcp := &GoogleCloudPrint{userClient: http.DefaultClient}
+	ctx := context.Background()
+
+	scopes, err := gcp.ResolveShareScope(ctx, "print-*@Example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"Print-B@example.com", "print-a@example.com"}
+	if !reflect.DeepEqual(scopes, expected) {
+		t.Logf("expected %v, got %v", expected, scopes)
+		t.Fail()
+	}
+
+	scopes, err = gcp.ResolveShareScope(ctx, "alice@example.com")
+	if err != nil || !reflect.DeepEqual(scopes, []string{"alice@example.com"}) {
+		t.Logf("expected a scope without wildcards to be itself, got %v, %v", scopes, err)
+		t.Fail()
+	}
+
+	for _, shareScope := range []string{
+		"sales-*@example.com",
+		"print-*@*.com",
+		"print-*",
+		"print-[@example.com",
+	} {
+		if _, err := gcp.ResolveShareScope(ctx, shareScope); err == nil {
+			t.Logf("expected an error for %s", shareScope)
+			t.Fail()
+		}
+	}
+
+	gcp.userClient = nil
+	if _, err := gcp.ResolveShareScope(ctx, "print-*@example.com"); err == nil {
+		t.Log("expected an error without user credentials")
+		t.Fail()
+	}
+}
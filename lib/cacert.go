@@ -0,0 +1,49 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+// TrustCACertFile adds the PEM-encoded CA certificates in filename to the
+// roots trusted by http.DefaultTransport, which the GCP and OAuth clients
+// use. It must be called before http.DefaultTransport is wrapped.
+func TrustCACertFile(filename string) error {
+	pem, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("Failed to read CA certificate file: %s", err)
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return fmt.Errorf("No certificates found in CA certificate file %s", filename)
+	}
+
+	transport, ok := http.DefaultTransport.(*http.Transport)
+	if !ok {
+		return errors.New("Cannot add CA certificates to a wrapped HTTP transport")
+	}
+	transport = transport.Clone()
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = &tls.Config{}
+	}
+	transport.TLSClientConfig.RootCAs = pool
+	http.DefaultTransport = transport
+
+	return nil
+}
@@ -10,17 +10,33 @@ package lib
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"mime"
 	"os"
 	"path/filepath"
+	"regexp"
 	"runtime"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/codegangsta/cli"
 
 	"launchpad.net/go-xdg/v0"
 )
 
+// Monitor stat label names, like Prometheus label names.
+var labelNameRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// Label names of the monitor stats themselves.
+var reservedLabelNames = map[string]struct{}{
+	"color": {}, "operation": {}, "printer": {}, "quantile": {}, "status": {}, "supply": {},
+}
+
 const (
 	// A website with user-friendly information.
 	ConnectorHomeURL = "https://github.com/google/cups-connector"
@@ -30,6 +46,15 @@ const (
 	defaultConfigFilename = "gcp-cups-connector.config.json"
 )
 
+// Requests that clients can send to the monitor socket.
+const (
+	MonitorRequestStats     = "stats"
+	MonitorRequestInventory = "inventory"
+	MonitorRequestSupplies  = "supplies"
+	// Followed by a space and a printer name.
+	MonitorRequestRefreshPPD = "refresh-ppd"
+)
+
 var (
 	ConfigFilenameFlag = cli.StringFlag{
 		Name:  "config-filename",
@@ -43,6 +68,13 @@ var (
 	// go install -ldflags "-X github.com/google/cups-connector/lib.BuildDate=`date +%Y.%m.%d`"
 	BuildDate = "DEV"
 
+	// To be populated by something like:
+	// go install -ldflags "-X github.com/google/cups-connector/lib.GitCommit=`git rev-parse --short HEAD`"
+	GitCommit = "unknown"
+
+	// BuildVersion identifies the build, by date and commit.
+	BuildVersion = BuildDate + "-" + GitCommit
+
 	ShortName = "CUPS Connector " + BuildDate + "-" + runtime.GOOS
 
 	FullName = "Google Cloud Print CUPS Connector version " + BuildDate + "-" + runtime.GOOS
@@ -58,15 +90,34 @@ type Config struct {
 	// Associated with user account. Used for sharing GCP printers; may be omitted.
 	UserRefreshToken string `json:"user_refresh_token,omitempty"`
 
-	// Scope (user, group, domain) to share printers with.
+	// Scope (user, group, domain) to share printers with. Wildcards, like
+	// print-*@example.com, match the groups of that domain when printers are
+	// shared; the user credentials then need the
+	// https://www.googleapis.com/auth/admin.directory.group.readonly scope,
+	// which init and reauth ask for.
 	ShareScope string `json:"share_scope,omitempty"`
 
 	// User-chosen name of this proxy. Should be unique per Google user account.
 	ProxyName string `json:"proxy_name,omitempty"`
 
+	// Friendly name of this connector, for tags and monitoring. Unlike
+	// ProxyName, it can be changed without registering printers again. Empty
+	// means ProxyName.
+	ConnectorDisplayName string `json:"connector_display_name,omitempty"`
+
+	// Labels, like {"site": "nyc", "env": "prod"}, reported by the monitor in
+	// the connector-labels stat and with each inventory and supplies printer,
+	// so that a central monitoring system can tell many connectors apart.
+	// Names are letters, digits and _, not starting with a digit.
+	Labels map[string]string `json:"labels,omitempty"`
+
 	// XMPP server FQDN.
 	XMPPServer string `json:"xmpp_server,omitempty"`
 
+	// XMPP server FQDNs to try, in order, when XMPPServer can't be reached. They
+	// use XMPPPort too.
+	XMPPServerFallbacks []string `json:"xmpp_server_fallbacks"`
+
 	// XMPP server port number.
 	XMPPPort uint16 `json:"xmpp_port,omitempty"`
 
@@ -96,26 +147,228 @@ type Config struct {
 	// Maximum quantity of jobs (data) to download concurrently.
 	GCPMaxConcurrentDownloads uint `json:"gcp_max_concurrent_downloads,omitempty"`
 
-	// Maximum quantity of open CUPS connections.
+	// Time (eg 30s, 1m) to wait for each GCP and OAuth response. 0s means no
+	// limit.
+	GCPAPITimeout string `json:"gcp_api_timeout"`
+
+	// Time (eg 5m, 30m) to download each job, response and data included, before
+	// the job fails. 0s means no limit.
+	GCPDownloadTimeout string `json:"gcp_download_timeout"`
+
+	// Content types of GCP jobs to print, like application/pdf or image/*. Jobs
+	// of other types are reported to GCP as unsupported, before they are
+	// downloaded. This is the type the job was submitted as, like image/jpeg or
+	// a Google Docs type, not the PDF that GCP converts it to, so listing only
+	// application/pdf rejects most jobs. Empty means every type.
+	AcceptedJobFormats []string `json:"accepted_job_formats"`
+
+	// Interval (eg 30m, 1h) between refreshes of the GCP printer list, which
+	// reconcile printers changed outside the connector. 0s disables refreshes.
+	GCPPrinterListRefreshInterval string `json:"gcp_printer_list_refresh_interval"`
+
+	// Interval (eg 5m) between fetches of the queued jobs of every GCP printer, in
+	// case an XMPP notification was missed; independent of
+	// cups_printer_poll_interval. Each poll is one GCP call per printer, counted
+	// against the API quota, so shorter intervals trade quota for less delay
+	// before a missed job prints. 0s disables polling, relying on XMPP alone.
+	CloudJobPollInterval string `json:"cloud_job_poll_interval"`
+
+	// Interval (eg 15m) between checks that the registered printers are still in
+	// GCP. Printers deleted from GCP, like in the web UI, while still in CUPS are
+	// registered again right away, instead of at the next GCP printer list
+	// refresh. 0s disables the checks, as does no value, like in config files
+	// written before the key existed.
+	SelfHealInterval string `json:"self_heal_interval"`
+
+	// Most printers to register in GCP, to stay under the GCP per-account limit.
+	// Printers beyond it, by name, are not registered, with one warning, and
+	// counted in the monitor stats. 0 means no limit.
+	MaxRegisteredPrinters uint `json:"max_registered_printers"`
+
+	// Quantity of times to retry each update, share and delete of a printer
+	// sync that failed with a network error, a 429 or a 5xx, before the
+	// failure is reported. Registrations aren't retried.
+	SyncMaxRetries uint `json:"sync_max_retries"`
+
+	// Time (eg 5s, 1m) to wait before the next sync retries a failed printer
+	// sync operation. The wait doubles with each retry.
+	SyncRetryBackoff string `json:"sync_retry_backoff"`
+
+	// PEM file of CA certificates to trust, in addition to the system's, for
+	// HTTPS connections to GCP and OAuth, like behind a TLS-intercepting proxy.
+	CACertFile string `json:"ca_cert_file"`
+
+	// The oldest TLS version, like 1.2 or 1.3, to accept from GCP, OAuth, XMPP
+	// and webhook servers. Servers that can't meet it fail the TLS handshake.
+	// Empty means the Go default. Connections to CUPS follow SSLOptions in the
+	// CUPS client.conf instead.
+	MinTLSVersion string `json:"min_tls_version"`
+
+	// Where the robot and user refresh tokens are kept: "file" (this config
+	// file), "env" (the CC_ROBOT_REFRESH_TOKEN and CC_USER_REFRESH_TOKEN
+	// environment variables) or "command" (the output of token_store_command).
+	TokenStore string `json:"token_store"`
+
+	// Command that prints a refresh token, when token_store is "command". The
+	// token name (robot_refresh_token or user_refresh_token) is appended as the
+	// last argument.
+	TokenStoreCommand string `json:"token_store_command"`
+
+	// Maximum quantity of open CUPS connections. Idle connections are kept
+	// open, up to this quantity, and reused.
 	CUPSMaxConnections uint `json:"cups_max_connections"`
 
 	// CUPS timeout for opening a new connection.
 	CUPSConnectTimeout string `json:"cups_connect_timeout"`
 
+	// CUPS server hostname or socket path. Empty means the CUPS client default.
+	CUPSServerHost string `json:"cups_server_host"`
+
+	// CUPS server port. Zero means the CUPS client default.
+	CUPSServerPort uint16 `json:"cups_server_port"`
+
+	// CUPS connection encryption: never, ifrequested, required, or always.
+	// Empty means the CUPS client default.
+	CUPSEncryption string `json:"cups_encryption"`
+
+	// PEM file of CA certificates that must have signed the CUPS server
+	// certificate. Empty means the certificate isn't checked by the connector.
+	CUPSCACertFile string `json:"cups_ca_cert_file"`
+
 	// CUPS job queue size.
 	CUPSJobQueueSize uint `json:"cups_job_queue_size"`
 
+	// Quantity of times to retry a CUPS job submission that failed because CUPS
+	// was unreachable or busy. Rejected submissions aren't retried.
+	CUPSJobRetries uint `json:"cups_job_retries"`
+
+	// Time (eg 1h, 30m) that a CUPS job may stay stopped, like when its printer
+	// is paused, before it fails and its place in the CUPS job queue is freed.
+	// 0s means no limit.
+	CUPSJobMaxStopped string `json:"cups_job_max_stopped"`
+
 	// Interval (eg 10s, 1m) between CUPS printer state polls.
 	CUPSPrinterPollInterval string `json:"cups_printer_poll_interval"`
 
-	// CUPS printer attributes to copy to GCP.
+	// Per-printer poll intervals (eg 10s, 5m), keyed by CUPS printer name or by
+	// regular expression matching the whole name. Overrides CUPSPrinterPollInterval.
+	PrinterPollIntervalOverrides map[string]string `json:"printer_poll_interval_overrides"`
+
+	// Minimum interval (eg 30s, 1m) between GCP updates of one printer. Updates
+	// within the interval are coalesced, except for changes into an error state.
+	MinUpdateInterval string `json:"min_update_interval"`
+
+	// File listing the CUPS printers to share, one printer name or regular
+	// expression per line. Empty means all printers are shared.
+	PrinterAllowlistFile string `json:"printer_allowlist_file"`
+
+	// Interval (eg 30s, 5m) between reads of the printer allowlist file. The
+	// file is also read on SIGHUP.
+	PrinterAllowlistInterval string `json:"printer_allowlist_interval"`
+
+	// CUPS printers to keep out of GCP, like during maintenance, by exact name.
+	// They are deleted from GCP, but not from CUPS, and registered again once
+	// removed from this list.
+	DisabledPrinters []string `json:"disabled_printers"`
+
+	// CUPS printers to delete from GCP and register again, once, like when their
+	// capabilities changed beyond an update. Re-registered printers are
+	// recorded in temp_dir; remove one from this list and add it back to
+	// re-register it again.
+	ForceReregister []string `json:"force_reregister"`
+
+	// Whether to delete from GCP and register again the CUPS printers whose UUID
+	// changed, like after a driver reinstall, instead of updating the UUID of
+	// the GCP printer. A warning is logged either way.
+	ReregisterOnUUIDChange bool `json:"reregister_on_uuid_change"`
+
+	// CUPS printer attributes to copy to GCP. The media, sides and
+	// printer-resolution attributes describe driverless (IPP Everywhere)
+	// printers, which have no PPD, when cups_driverless_printers is true.
 	CUPSPrinterAttributes []string `json:"cups_printer_attributes"`
 
 	// Whether to use the full username (joe@example.com) in CUPS jobs.
 	CUPSJobFullUsername bool `json:"cups_job_full_username"`
 
-	// Whether to ignore printers with make/model 'Local Raw Printer'.
-	CUPSIgnoreRawPrinters bool `json:"cups_ignore_raw_printers"`
+	// Template (text/template, with .Local, .Domain, and .Full) for the CUPS job
+	// username. Overrides CUPSJobFullUsername when not empty.
+	CUPSJobUsernameTemplate string `json:"cups_job_username_template"`
+
+	// What to do with raw printers (see cups_raw_printer_make_models): "ignore"
+	// leaves them out of GCP, without deleting the ones already registered;
+	// "register" registers them like other printers; "delete-from-gcp" leaves
+	// them out of GCP, and deletes the ones already registered.
+	CUPSRawPrinterPolicy string `json:"cups_raw_printer_policy"`
+
+	// Replaced by cups_raw_printer_policy; read from older config files only.
+	CUPSIgnoreRawPrinters *bool `json:"cups_ignore_raw_printers,omitempty"`
+
+	// CUPS printers whose device-uri has one of these schemes, like cups-pdf or
+	// smb, are left out of GCP, like virtual printers that can't print on paper.
+	SkipDeviceURISchemes []string `json:"skip_device_uri_schemes"`
+
+	// Printers whose make-and-model contains one of these strings, ignoring case,
+	// are raw. CUPS localizes the make-and-model of raw queues.
+	CUPSRawPrinterMakeModels []string `json:"cups_raw_printer_make_models"`
+
+	// Treat printers without a PPD as raw.
+	CUPSMissingPPDIsRaw bool `json:"cups_missing_ppd_is_raw"`
+
+	// Describe printers without a PPD that CUPS drives with IPP Everywhere,
+	// by their device URI or make-and-model, from their media, sides and
+	// printer-resolution attributes. Otherwise cups_missing_ppd_is_raw decides.
+	CUPSDriverlessPrinters bool `json:"cups_driverless_printers"`
+
+	// The default media size of printers whose PPD doesn't mark one, and the size
+	// of their jobs that don't ask for one. A PPD PageSize keyword, like A4 or
+	// Letter, or a CDD media size name, like ISO_A4. When empty, or a printer
+	// doesn't have this size, the printer's first size is the default.
+	DefaultMediaSize string `json:"default_media_size"`
+
+	// Whether to fail printer syncs when CUPS printers share a name, instead of
+	// warning and using the last printer with that name.
+	StrictNames bool `json:"strict_names"`
+
+	// Whether to fail print jobs that ask for options the printer can't honor,
+	// instead of printing them with a warning, and those options left for CUPS
+	// to honor if it can.
+	StrictJobOptions bool `json:"strict_job_options"`
+
+	// Whether to sync when CUPS has no printers while GCP has some, which deletes
+	// them all. Otherwise such syncs are skipped, as CUPS is probably restarting.
+	AllowEmptyCUPSSync bool `json:"allow_empty_cups_sync"`
+
+	// Whether to log the printers that would be registered, updated and deleted
+	// in GCP, without changing them, to try the connector before trusting it.
+	ShadowMode bool `json:"shadow_mode"`
+
+	// Capabilities to remove or pin, by CUPS printer name, then by capability.
+	// color can be "remove", "color" or "monochrome"; duplex can be "remove",
+	// "no_duplex", "long_edge" or "short_edge". Jobs that ask for anything else are
+	// rejected.
+	CapabilityOverrides map[string]map[string]string `json:"capability_overrides"`
+
+	// Manufacturer and model to use instead of those in the PPD, by CUPS printer
+	// name, then by "manufacturer" or "model", for drivers that report them poorly.
+	MakeModelOverrides map[string]map[string]string `json:"make_model_overrides"`
+
+	// Custom tags (eg building, department) to add to printers, by exact CUPS
+	// printer name, then by tag. The keys tagshash, connection-type,
+	// snmp-serial-number and snmp-marker-capacities are reserved, and tags
+	// copied from CUPS printer attributes win over custom tags of the same key.
+	PrinterTags map[string]map[string]string `json:"printer_tags"`
+
+	// CUPS job options (eg Duplex, InputSlot, PageSize) to print with, by CUPS printer
+	// name, then by option. An option only fills in what the job didn't ask for, so
+	// the job's option wins; an option named with a leading ! (eg !Duplex) is forced,
+	// and wins over the job's. GCP clients tend to ask for every option that the
+	// printer has, so force the options that must hold.
+	PrinterDefaultOptions map[string]map[string]string `json:"printer_default_options"`
+
+	// Messages that describe printer states in GCP, by CUPS printer-state-reasons
+	// keyword, like toner-low or toner-low-warning, for other languages or custom
+	// reasons. They win over the built-in messages.
+	PrinterStateReasonMessages map[string]string `json:"printer_state_reason_messages"`
 
 	// Whether to copy the CUPS printer's printer-info attribute to the GCP printer's defaultDisplayName.
 	CopyPrinterInfoToDisplayName bool `json:"copy_printer_info_to_display_name"`
@@ -123,21 +376,84 @@ type Config struct {
 	// Whether to add the job ID to the beginning of the job title. Useful for debugging.
 	PrefixJobIDToJobTitle bool `json:"prefix_job_id_to_job_title"`
 
+	// Template (text/template, with .ID and .Title) for the CUPS job title.
+	// Overrides PrefixJobIDToJobTitle when not empty.
+	JobTitleTemplate string `json:"job_title_template"`
+
+	// Whether to replace control characters in CUPS job titles with spaces, and
+	// to shorten them to JobTitleMaxLength. Applies after JobTitleTemplate.
+	JobTitleSanitize bool `json:"job_title_sanitize"`
+
+	// Maximum length, in bytes, of sanitized CUPS job titles; 0 means the CUPS
+	// maximum of 255.
+	JobTitleMaxLength uint `json:"job_title_max_length"`
+
+	// Whether to log job titles, which may be the names of sensitive documents.
+	LogJobTitles bool `json:"log_job_titles"`
+
 	// Prefix for all GCP printers hosted by this connector.
 	DisplayNamePrefix string `json:"display_name_prefix"`
 
+	// Suffix for all GCP printers hosted by this connector. Display names are
+	// the prefix, then the CUPS printer name or info, then the suffix.
+	DisplayNameSuffix string `json:"display_name_suffix"`
+
+	// Templates (text/template, with the lib.Printer as data) for the setup,
+	// support and update URLs of each printer, e.g. including {{.Name}}.
+	PrinterSetupURL   string `json:"printer_setup_url"`
+	PrinterSupportURL string `json:"printer_support_url"`
+	PrinterUpdateURL  string `json:"printer_update_url"`
+
 	// Filename of unix socket for connector-check to talk to connector.
 	MonitorSocketFilename string `json:"monitor_socket_filename"`
 
+	// Address, like localhost:6060, to serve net/http/pprof profiles on; empty
+	// disables it. The profiles aren't authenticated, so only bind to localhost.
+	DebugPprofAddress string `json:"debug_pprof_address"`
+
+	// Directory for downloaded job files and cached PPDs.
+	// Empty means the system temporary directory.
+	TempDir string `json:"temp_dir"`
+
+	// Whether to keep the files of printed jobs in temp_dir, instead of removing
+	// them, to look into jobs that print wrong. Each kept file's path is logged.
+	KeepJobFiles bool `json:"keep_job_files"`
+
+	// With keep_job_files, how many of the newest job files to keep.
+	KeepJobFilesCount uint `json:"keep_job_files_count"`
+
+	// With keep_job_files, how long (eg 24h) to keep each job file; 0 means no limit.
+	KeepJobFilesMaxAge string `json:"keep_job_files_max_age"`
+
+	// How long (eg 24h) to remember the IDs of jobs submitted to CUPS, so that a
+	// job delivered again by GCP is reported done instead of printed twice. The
+	// IDs are kept in temp_dir, across restarts. 0s disables it.
+	JobDedupTTL string `json:"job_dedup_ttl"`
+
+	// URL to POST a JSON notification to when a printer's state changes.
+	// Empty means no notifications.
+	StateChangeWebhookURL string `json:"state_change_webhook_url"`
+
+	// User-Agent header of outbound HTTP requests, to identify this connector
+	// in proxy and server logs. Empty means the connector version and proxy name.
+	UserAgent string `json:"user_agent"`
+
 	// Enable SNMP to augment CUPS printer information.
 	SNMPEnable bool `json:"snmp_enable"`
 
 	// Community string to use.
 	SNMPCommunity string `json:"snmp_community"`
 
-	// Maximum quantity of open SNMP connections.
+	// Maximum quantity of open SNMP connections. Each poll spreads its SNMP
+	// queries over half of cups_printer_poll_interval.
 	SNMPMaxConnections uint `json:"snmp_max_connections"`
 
+	// Vendor-specific OIDs, like 1.3.6.1.4.1.11.2.3.9.4.2.1.4.1.2.5.0, to get from
+	// each printer's SNMP agent, mapped to the printer tag to put each value in.
+	// OIDs that an agent doesn't have are skipped. The tags reserved from
+	// printer_tags are reserved here too.
+	SNMPExtraOIDs map[string]string `json:"snmp_extra_oids"`
+
 	// Enable local discovery and printing.
 	LocalPrintingEnable bool `json:"local_printing_enable"`
 
@@ -153,29 +469,62 @@ type Config struct {
 	// Maximum log file quantity.
 	LogMaxFiles uint `json:"log_max_files"`
 
+	// Whether to gzip rolled log files.
+	LogCompress bool `json:"log_compress"`
+
 	// Least severity to log.
 	LogLevel string `json:"log_level"`
+
+	// Field values replaced by environment variables, by field index.
+	envOverrides map[int]envOverride
 }
 
 // DefaultConfig represents reasonable default values for Config fields.
 // Omitted Config fields are omitted on purpose; they are unique per
 // connector instance.
 var DefaultConfig = Config{
-	XMPPServer:                "talk.google.com",
-	XMPPPort:                  443,
-	XMPPPingTimeout:           "5s",
-	XMPPPingInterval:          "2m",
-	GCPBaseURL:                "https://www.google.com/cloudprint/",
-	GCPOAuthClientID:          "539833558011-35iq8btpgas80nrs3o7mv99hm95d4dv6.apps.googleusercontent.com",
-	GCPOAuthClientSecret:      "V9BfPOvdiYuw12hDx5Y5nR0a",
-	GCPOAuthAuthURL:           "https://accounts.google.com/o/oauth2/auth",
-	GCPOAuthTokenURL:          "https://accounts.google.com/o/oauth2/token",
-	GCPMaxConcurrentDownloads: 5,
-
-	CUPSMaxConnections:      50,
-	CUPSConnectTimeout:      "5s",
-	CUPSJobQueueSize:        3,
-	CUPSPrinterPollInterval: "1m",
+	XMPPServer:                    "talk.google.com",
+	XMPPServerFallbacks:           []string{},
+	XMPPPort:                      443,
+	XMPPPingTimeout:               "5s",
+	XMPPPingInterval:              "2m",
+	GCPBaseURL:                    "https://www.google.com/cloudprint/",
+	GCPOAuthClientID:              "539833558011-35iq8btpgas80nrs3o7mv99hm95d4dv6.apps.googleusercontent.com",
+	GCPOAuthClientSecret:          "V9BfPOvdiYuw12hDx5Y5nR0a",
+	GCPOAuthAuthURL:               "https://accounts.google.com/o/oauth2/auth",
+	GCPOAuthTokenURL:              "https://accounts.google.com/o/oauth2/token",
+	GCPMaxConcurrentDownloads:     5,
+	GCPAPITimeout:                 "30s",
+	GCPDownloadTimeout:            "5m",
+	AcceptedJobFormats:            []string{},
+	GCPPrinterListRefreshInterval: "1h",
+	CloudJobPollInterval:          "0s",
+	SelfHealInterval:              "15m",
+	MaxRegisteredPrinters:         0,
+	SyncMaxRetries:                2,
+	SyncRetryBackoff:              "5s",
+	CACertFile:                    "",
+	MinTLSVersion:                 "1.2",
+	TokenStore:                    TokenStoreFile,
+	TokenStoreCommand:             "",
+
+	CUPSMaxConnections:           50,
+	CUPSConnectTimeout:           "5s",
+	CUPSServerHost:               "",
+	CUPSServerPort:               0,
+	CUPSEncryption:               "",
+	CUPSCACertFile:               "",
+	CUPSJobQueueSize:             3,
+	CUPSJobRetries:               3,
+	CUPSJobMaxStopped:            "24h",
+	CUPSPrinterPollInterval:      "1m",
+	PrinterPollIntervalOverrides: map[string]string{},
+	MinUpdateInterval:            "0s",
+	PrinterAllowlistFile:         "",
+	PrinterAllowlistInterval:     "1m",
+	DisabledPrinters:             []string{},
+	ForceReregister:              []string{},
+	ReregisterOnUUIDChange:       false,
 	CUPSPrinterAttributes: []string{
 		"cups-version",
 		"device-uri",
@@ -199,32 +548,75 @@ var DefaultConfig = Config{
 		"orientation-requested-default",
 		"orientation-requested-supported",
 		"pdf-versions-supported",
+		"media-default",
+		"media-supported",
+		"sides-default",
+		"sides-supported",
+		"printer-resolution-default",
+		"printer-resolution-supported",
 	},
 	CUPSJobFullUsername:          false,
-	CUPSIgnoreRawPrinters:        true,
+	CUPSJobUsernameTemplate:      "",
+	CUPSRawPrinterPolicy:         RawPrinterPolicyDeleteFromGCP,
+	SkipDeviceURISchemes:         []string{"cups-pdf"},
+	CUPSRawPrinterMakeModels:     []string{"Local Raw Printer"},
+	CUPSMissingPPDIsRaw:          true,
+	CUPSDriverlessPrinters:       false,
+	DefaultMediaSize:             "",
+	StrictNames:                  false,
+	StrictJobOptions:             false,
+	AllowEmptyCUPSSync:           false,
+	ShadowMode:                   false,
+	CapabilityOverrides:          map[string]map[string]string{},
+	MakeModelOverrides:           map[string]map[string]string{},
+	PrinterTags:                  map[string]map[string]string{},
+	PrinterDefaultOptions:        map[string]map[string]string{},
+	PrinterStateReasonMessages:   map[string]string{},
 	CopyPrinterInfoToDisplayName: true,
 	PrefixJobIDToJobTitle:        false,
+	JobTitleTemplate:             "",
+	JobTitleSanitize:             false,
+	JobTitleMaxLength:            0,
+	LogJobTitles:                 false,
 	DisplayNamePrefix:            "",
+	DisplayNameSuffix:            "",
+	PrinterSetupURL:              ConnectorHomeURL,
+	PrinterSupportURL:            ConnectorHomeURL,
+	PrinterUpdateURL:             ConnectorHomeURL,
 	MonitorSocketFilename:        "/tmp/cups-connector-monitor.sock",
+	DebugPprofAddress:            "",
+	TempDir:                      "",
+	KeepJobFiles:                 false,
+	KeepJobFilesCount:            20,
+	KeepJobFilesMaxAge:           "24h",
+	JobDedupTTL:                  "0s",
+	StateChangeWebhookURL:        "",
+	UserAgent:                    "",
 	SNMPEnable:                   false,
 	SNMPCommunity:                "public",
 	SNMPMaxConnections:           100,
+	SNMPExtraOIDs:                map[string]string{},
 	LocalPrintingEnable:          true,
 	CloudPrintingEnable:          false,
 	LogFileName:                  "/tmp/cups-connector",
 	LogFileMaxMegabytes:          1,
 	LogMaxFiles:                  3,
+	LogCompress:                  false,
 	LogLevel:                     "INFO",
 }
 
-// getConfigFilename gets the absolute filename of the config file specified by
-// the ConfigFilename flag, and whether it exists.
+// GetConfigFilename gets the absolute filename of the config file specified by
+// the ConfigFilename flag, and whether it exists. The flag may be given either
+// globally or to the command; the command flag wins.
 //
 // If the (relative or absolute) ConfigFilename exists, then it is returned.
 // If the ConfigFilename exists in a valid XDG path, then it is returned.
 // If neither of those exist, the (relative or absolute) ConfigFilename is returned.
-func getConfigFilename(context *cli.Context) (string, bool) {
+func GetConfigFilename(context *cli.Context) (string, bool) {
 	cf := context.GlobalString("config-filename")
+	if context.IsSet("config-filename") {
+		cf = context.String("config-filename")
+	}
 
 	if filepath.IsAbs(cf) {
 		// Absolute path specified; user knows what they want.
@@ -253,33 +645,241 @@ func getConfigFilename(context *cli.Context) (string, bool) {
 }
 
 // GetConfig reads a Config object from the config file indicated by the config
-// filename flag. If no such file exists, then DefaultConfig is returned.
+// filename flag. If no such file exists, then DefaultConfig is used. Then the
+// values of CC_ environment variables, like CC_PROXY_NAME for proxy_name,
+// override those of the file, so that the connector can be configured without
+// a config file. ToFile writes the file values back, not the environment's.
 func GetConfig(context *cli.Context) (*Config, string, error) {
-	cf, exists := getConfigFilename(context)
-	if !exists {
-		return &DefaultConfig, "", nil
+	config := DefaultConfig
+	cf, exists := GetConfigFilename(context)
+	if exists {
+		b, err := ioutil.ReadFile(cf)
+		if err != nil {
+			return nil, "", err
+		}
+		config = Config{}
+		if err = json.Unmarshal(b, &config); err != nil {
+			return nil, "", err
+		}
+	} else {
+		cf = ""
 	}
 
-	b, err := ioutil.ReadFile(cf)
-	if err != nil {
+	if err := config.applyEnv(os.LookupEnv); err != nil {
 		return nil, "", err
 	}
+	return &config, cf, nil
+}
 
-	var config Config
-	if err = json.Unmarshal(b, &config); err != nil {
-		return nil, "", err
+// DisplayName returns ConnectorDisplayName, or ProxyName when it is empty.
+func (c *Config) DisplayName() string {
+	if c.ConnectorDisplayName != "" {
+		return c.ConnectorDisplayName
 	}
-	return &config, cf, nil
+	return c.ProxyName
+}
+
+// HTTPUserAgent returns UserAgent, or the connector version and proxy name
+// when it is empty.
+func (c *Config) HTTPUserAgent() string {
+	if c.UserAgent != "" {
+		return c.UserAgent
+	}
+	if c.ProxyName == "" {
+		return ShortName
+	}
+	return fmt.Sprintf("%s (%s)", ShortName, c.ProxyName)
+}
+
+// RawPrinterPolicy returns CUPSRawPrinterPolicy. Older config files lack it;
+// then the policy is the one of cups_ignore_raw_printers: raw printers are
+// registered when it is false, and deleted from GCP otherwise.
+func (c *Config) RawPrinterPolicy() string {
+	if c.CUPSRawPrinterPolicy != "" {
+		return c.CUPSRawPrinterPolicy
+	}
+	if c.CUPSIgnoreRawPrinters != nil && !*c.CUPSIgnoreRawPrinters {
+		return RawPrinterPolicyRegister
+	}
+	return RawPrinterPolicyDeleteFromGCP
+}
+
+// XMPPServers returns XMPPServer followed by XMPPServerFallbacks, in the
+// order they are tried.
+func (c *Config) XMPPServers() []string {
+	servers := make([]string, 0, 1+len(c.XMPPServerFallbacks))
+	if c.XMPPServer != "" {
+		servers = append(servers, c.XMPPServer)
+	}
+	for _, server := range c.XMPPServerFallbacks {
+		if server != "" {
+			servers = append(servers, server)
+		}
+	}
+	return servers
+}
+
+// Validate checks the values that can't be checked by their type, naming the
+// config file key of the first bad value.
+func (c *Config) Validate() error {
+	type duration struct{ key, value string }
+	durations := []duration{
+		{"cups_connect_timeout", c.CUPSConnectTimeout},
+		{"cups_printer_poll_interval", c.CUPSPrinterPollInterval},
+		{"min_update_interval", c.MinUpdateInterval},
+		{"printer_allowlist_interval", c.PrinterAllowlistInterval},
+		{"cups_job_max_stopped", c.CUPSJobMaxStopped},
+	}
+	if _, err := ParseTLSVersion(c.MinTLSVersion); err != nil {
+		return errors.New("min_tls_version must be 1.0, 1.1, 1.2 or 1.3")
+	}
+	if err := validateLabels(c.Labels); err != nil {
+		return err
+	}
+	if err := validatePrinterDefaultOptions(c.PrinterDefaultOptions); err != nil {
+		return err
+	}
+	if err := validateSNMPExtraOIDs(c.SNMPExtraOIDs); err != nil {
+		return err
+	}
+	for _, format := range c.AcceptedJobFormats {
+		if mediaType, params, err := mime.ParseMediaType(format); err != nil || len(params) > 0 || !strings.Contains(mediaType, "/") {
+			return fmt.Errorf("accepted_job_formats %q is not a content type, like application/pdf or image/*", format)
+		}
+	}
+	if c.KeepJobFiles {
+		durations = append(durations, duration{"keep_job_files_max_age", c.KeepJobFilesMaxAge})
+	}
+	if c.JobDedupTTL != "" {
+		durations = append(durations, duration{"job_dedup_ttl", c.JobDedupTTL})
+	}
+	if c.CloudPrintingEnable {
+		if c.XMPPPort == 0 {
+			return errors.New("xmpp_port must be between 1 and 65535")
+		}
+		durations = append(durations,
+			duration{"gcp_xmpp_ping_timeout", c.XMPPPingTimeout},
+			duration{"gcp_xmpp_ping_interval_default", c.XMPPPingInterval},
+			duration{"gcp_printer_list_refresh_interval", c.GCPPrinterListRefreshInterval},
+			duration{"cloud_job_poll_interval", c.CloudJobPollInterval},
+			duration{"sync_retry_backoff", c.SyncRetryBackoff},
+			duration{"gcp_api_timeout", c.GCPAPITimeout},
+			duration{"gcp_download_timeout", c.GCPDownloadTimeout})
+		if c.SelfHealInterval != "" {
+			durations = append(durations, duration{"self_heal_interval", c.SelfHealInterval})
+		}
+	}
+
+	names := make([]string, 0, len(c.PrinterPollIntervalOverrides))
+	for name := range c.PrinterPollIntervalOverrides {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		durations = append(durations, duration{
+			fmt.Sprintf("printer_poll_interval_overrides[%q]", name), c.PrinterPollIntervalOverrides[name]})
+	}
+
+	for _, d := range durations {
+		if _, err := ParseConfigDuration(d.value, missingDurationDefaults[d.key]); err != nil {
+			return fmt.Errorf("%s is not a duration (eg 30s, 5m): %s", d.key, err)
+		}
+	}
+	return nil
+}
+
+// missingDurationDefaults are the values of the duration keys that config
+// files written before the key existed don't have, by key.
+var missingDurationDefaults = map[string]string{
+	"min_update_interval":               DefaultConfig.MinUpdateInterval,
+	"printer_allowlist_interval":        DefaultConfig.PrinterAllowlistInterval,
+	"gcp_printer_list_refresh_interval": DefaultConfig.GCPPrinterListRefreshInterval,
+	"gcp_api_timeout":                   DefaultConfig.GCPAPITimeout,
+	"gcp_download_timeout":              DefaultConfig.GCPDownloadTimeout,
+	"cloud_job_poll_interval":           DefaultConfig.CloudJobPollInterval,
+	"sync_retry_backoff":                DefaultConfig.SyncRetryBackoff,
+	"cups_job_max_stopped":              DefaultConfig.CUPSJobMaxStopped,
+}
+
+// ParseConfigDuration parses value, the duration of a config key, or
+// defaultValue when value is empty, like in config files written before the
+// key existed.
+func ParseConfigDuration(value, defaultValue string) (time.Duration, error) {
+	if value == "" {
+		value = defaultValue
+	}
+	return time.ParseDuration(value)
+}
+
+// validateLabels checks that the labels can be joined to the labels of the
+// monitor stats, like printer, without clashing.
+func validateLabels(labels map[string]string) error {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if !labelNameRegexp.MatchString(name) {
+			return fmt.Errorf("labels[%q] is not a label name; use letters, digits and _, not starting with a digit", name)
+		}
+		if _, reserved := reservedLabelNames[name]; reserved {
+			return fmt.Errorf("labels[%q] is used by the monitor stats; choose another name", name)
+		}
+		value := labels[name]
+		if !utf8.ValidString(value) || strings.IndexFunc(value, unicode.IsControl) >= 0 {
+			return fmt.Errorf("labels[%q] must be printable text", name)
+		}
+	}
+	return nil
+}
+
+// validatePrinterDefaultOptions checks that each default option has a name,
+// after the ! that forces it.
+func validatePrinterDefaultOptions(options map[string]map[string]string) error {
+	printers := make([]string, 0, len(options))
+	for printer := range options {
+		printers = append(printers, printer)
+	}
+	sort.Strings(printers)
+
+	for _, printer := range printers {
+		for name := range options[printer] {
+			if strings.TrimSpace(strings.TrimPrefix(name, "!")) == "" {
+				return fmt.Errorf("printer_default_options[%q] has an option without a name", printer)
+			}
+		}
+	}
+	return nil
+}
+
+// validateSNMPExtraOIDs checks that no SNMP extra OID puts its value in a
+// reserved tag, which would overwrite a tag that the connector sets itself.
+func validateSNMPExtraOIDs(extraOIDs map[string]string) error {
+	oids := make([]string, 0, len(extraOIDs))
+	for oid := range extraOIDs {
+		oids = append(oids, oid)
+	}
+	sort.Strings(oids)
+
+	for _, oid := range oids {
+		tag := strings.ToLower(strings.TrimSpace(extraOIDs[oid]))
+		if _, reserved := ReservedTags[tag]; reserved {
+			return fmt.Errorf("snmp_extra_oids[%q] tag %s is reserved; choose another tag", oid, tag)
+		}
+	}
+	return nil
 }
 
 // ToFile writes this Config object to the config file indicated by ConfigFile.
 func (c *Config) ToFile(context *cli.Context) (string, error) {
-	b, err := json.MarshalIndent(c, "", "  ")
+	b, err := json.MarshalIndent(c.withoutEnv(), "", "  ")
 	if err != nil {
 		return "", err
 	}
 
-	cf, _ := getConfigFilename(context)
+	cf, _ := GetConfigFilename(context)
 	if err = ioutil.WriteFile(cf, b, 0600); err != nil {
 		return "", err
 	}
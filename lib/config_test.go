@@ -0,0 +1,182 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfigValidate(t *testing.T) {
+	config := DefaultConfig
+	if err := config.Validate(); err != nil {
+		t.Logf("expected default config to be valid, got %s", err)
+		t.Fail()
+	}
+
+	// min_update_interval is missing from older config files.
+	config.MinUpdateInterval = ""
+	if err := config.Validate(); err != nil {
+		t.Logf("expected empty min_update_interval to be valid, got %s", err)
+		t.Fail()
+	}
+	config.MinUpdateInterval = "5"
+	if err := config.Validate(); err == nil || !strings.HasPrefix(err.Error(), "min_update_interval ") {
+		t.Logf("expected error naming min_update_interval, got %v", err)
+		t.Fail()
+	}
+
+	config = DefaultConfig
+	config.CloudPrintingEnable = true
+	if err := config.Validate(); err != nil {
+		t.Logf("expected default cloud config to be valid, got %s", err)
+		t.Fail()
+	}
+	// self_heal_interval is missing from older config files, which disables it.
+	config.SelfHealInterval = ""
+	if err := config.Validate(); err != nil {
+		t.Logf("expected empty self_heal_interval to be valid, got %s", err)
+		t.Fail()
+	}
+	config.XMPPPort = 0
+	if err := config.Validate(); err == nil {
+		t.Log("expected error for xmpp_port 0")
+		t.Fail()
+	}
+
+	// Cloud-only values aren't checked when cloud printing is disabled.
+	config.CloudPrintingEnable = false
+	config.XMPPPingTimeout = ""
+	if err := config.Validate(); err != nil {
+		t.Logf("expected local config to be valid, got %s", err)
+		t.Fail()
+	}
+
+	config = DefaultConfig
+	config.PrinterPollIntervalOverrides = map[string]string{"lobby": "soon"}
+	if err := config.Validate(); err == nil || !strings.Contains(err.Error(), `"lobby"`) {
+		t.Logf("expected error naming the lobby override, got %v", err)
+		t.Fail()
+	}
+
+	config = DefaultConfig
+	config.SNMPExtraOIDs = map[string]string{"1.3.6.1.4.1.11.2.3.9.4.2.1.4.1.2.5.0": "page-count"}
+	if err := config.Validate(); err != nil {
+		t.Logf("expected SNMP extra OIDs to be valid, got %s", err)
+		t.Fail()
+	}
+	config.SNMPExtraOIDs["1.3.6.1.2.1.43.5.1.1.17.1"] = " SNMP-Serial-Number"
+	if err := config.Validate(); err == nil || !strings.HasPrefix(err.Error(), `snmp_extra_oids["1.3.6.1.2.1.43.5.1.1.17.1"]`) {
+		t.Logf("expected error naming the reserved tag's OID, got %v", err)
+		t.Fail()
+	}
+
+	config = DefaultConfig
+	config.PrinterDefaultOptions = map[string]map[string]string{"lobby": {"Duplex": "DuplexNoTumble", "!InputSlot": "Tray2"}}
+	if err := config.Validate(); err != nil {
+		t.Logf("expected printer default options to be valid, got %s", err)
+		t.Fail()
+	}
+	config.PrinterDefaultOptions["lobby"]["!"] = "Tray2"
+	if err := config.Validate(); err == nil || !strings.HasPrefix(err.Error(), `printer_default_options["lobby"]`) {
+		t.Logf("expected error naming the lobby printer, got %v", err)
+		t.Fail()
+	}
+
+	// job_dedup_ttl is missing from older config files, which disables it.
+	config = DefaultConfig
+	config.JobDedupTTL = ""
+	if err := config.Validate(); err != nil {
+		t.Logf("expected empty job_dedup_ttl to be valid, got %s", err)
+		t.Fail()
+	}
+	config.JobDedupTTL = "1 day"
+	if err := config.Validate(); err == nil || !strings.HasPrefix(err.Error(), "job_dedup_ttl ") {
+		t.Logf("expected error naming job_dedup_ttl, got %v", err)
+		t.Fail()
+	}
+
+	config = DefaultConfig
+	config.Labels = map[string]string{"site": "nyc", "env_2": "prod"}
+	if err := config.Validate(); err != nil {
+		t.Logf("expected labels to be valid, got %s", err)
+		t.Fail()
+	}
+	for _, labels := range []map[string]string{
+		{"2site": "nyc"},
+		{"site-name": "nyc"},
+		{"printer": "lobby"},
+		{"site": "new\nyork"},
+	} {
+		config.Labels = labels
+		if err := config.Validate(); err == nil || !strings.HasPrefix(err.Error(), "labels[") {
+			t.Logf("expected error naming the label of %v, got %v", labels, err)
+			t.Fail()
+		}
+	}
+}
+
+func TestConfigHTTPUserAgent(t *testing.T) {
+	config := Config{ProxyName: "office"}
+	if ua := config.HTTPUserAgent(); ua != ShortName+" (office)" {
+		t.Logf("expected the version and proxy name, got %q", ua)
+		t.Fail()
+	}
+
+	config.UserAgent = "acme-print/1.0"
+	if ua := config.HTTPUserAgent(); ua != "acme-print/1.0" {
+		t.Logf("expected the configured user agent, got %q", ua)
+		t.Fail()
+	}
+}
+
+func TestConfigRawPrinterPolicy(t *testing.T) {
+	ignore, register := true, false
+	for _, tc := range []struct {
+		config Config
+		policy string
+	}{
+		{Config{CUPSRawPrinterPolicy: RawPrinterPolicyIgnore}, RawPrinterPolicyIgnore},
+		{Config{CUPSRawPrinterPolicy: RawPrinterPolicyIgnore, CUPSIgnoreRawPrinters: &register}, RawPrinterPolicyIgnore},
+		{Config{}, RawPrinterPolicyDeleteFromGCP},
+		{Config{CUPSIgnoreRawPrinters: &ignore}, RawPrinterPolicyDeleteFromGCP},
+		{Config{CUPSIgnoreRawPrinters: &register}, RawPrinterPolicyRegister},
+	} {
+		if policy := tc.config.RawPrinterPolicy(); policy != tc.policy {
+			t.Logf("expected policy %s, got %s", tc.policy, policy)
+			t.Fail()
+		}
+	}
+}
+
+func TestConfigXMPPServers(t *testing.T) {
+	config := Config{XMPPServer: "talk.google.com", XMPPServerFallbacks: []string{"", "backup.example.com"}}
+	servers := config.XMPPServers()
+	if !reflect.DeepEqual(servers, []string{"talk.google.com", "backup.example.com"}) {
+		t.Logf("expected the primary then the fallback, got %v", servers)
+		t.Fail()
+	}
+}
+
+func TestParseConfigDuration(t *testing.T) {
+	if d, err := ParseConfigDuration("", "1m"); err != nil || d != time.Minute {
+		t.Logf("expected the default 1m for an empty value, got %s, %v", d, err)
+		t.Fail()
+	}
+	if d, err := ParseConfigDuration("5s", "1m"); err != nil || d != 5*time.Second {
+		t.Logf("expected 5s, got %s, %v", d, err)
+		t.Fail()
+	}
+	if _, err := ParseConfigDuration("", ""); err == nil {
+		t.Log("expected error for an empty value without a default")
+		t.Fail()
+	}
+}
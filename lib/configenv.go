@@ -0,0 +1,88 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Prefix of the environment variables that override config file values,
+// including the refresh tokens of the env token store.
+const configEnvPrefix = "CC_"
+
+// envOverride is a config field value replaced by an environment variable.
+type envOverride struct {
+	fileValue, envValue interface{}
+}
+
+// configEnvName returns the environment variable that overrides the config
+// file key, like CC_PROXY_NAME for proxy_name.
+func configEnvName(key string) string {
+	return configEnvPrefix + strings.ToUpper(key)
+}
+
+// applyEnv sets each field that has an environment variable, as found by
+// lookupEnv. String values are used as they are; other values are JSON, like
+// true, 631, or ["printer-make-and-model"]. The replaced values are kept for
+// withoutEnv.
+func (c *Config) applyEnv(lookupEnv func(string) (string, bool)) error {
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		key := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if key == "" || key == "-" {
+			continue
+		}
+		name := configEnvName(key)
+		value, exists := lookupEnv(name)
+		if !exists {
+			continue
+		}
+
+		field := v.Field(i)
+		fileValue := field.Interface()
+		if field.Kind() == reflect.String {
+			field.SetString(value)
+		} else {
+			p := reflect.New(field.Type())
+			if err := json.Unmarshal([]byte(value), p.Interface()); err != nil {
+				return fmt.Errorf("Failed to parse environment variable %s: %s", name, err)
+			}
+			field.Set(p.Elem())
+		}
+
+		if c.envOverrides == nil {
+			c.envOverrides = make(map[int]envOverride)
+		}
+		if o, exists := c.envOverrides[i]; exists {
+			fileValue = o.fileValue
+		}
+		c.envOverrides[i] = envOverride{fileValue, field.Interface()}
+	}
+	return nil
+}
+
+// withoutEnv returns a copy of c with the config file values of the fields
+// that environment variables override, so that environment variables, which
+// may hold secrets, aren't written to the config file. Fields changed since
+// they were overridden keep their new value.
+func (c *Config) withoutEnv() *Config {
+	config := *c
+	config.envOverrides = nil
+	v := reflect.ValueOf(&config).Elem()
+	for i, o := range c.envOverrides {
+		if reflect.DeepEqual(v.Field(i).Interface(), o.envValue) {
+			v.Field(i).Set(reflect.ValueOf(o.fileValue))
+		}
+	}
+	return &config
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestConfigApplyEnv(t *testing.T) {
+	env := map[string]string{
+		"CC_PROXY_NAME":              "office",
+		"CC_ROBOT_REFRESH_TOKEN":     "secret",
+		"CC_CLOUD_PRINTING_ENABLE":   "true",
+		"CC_XMPP_PORT":               "5223",
+		"CC_CUPS_PRINTER_ATTRIBUTES": `["printer-name"]`,
+	}
+	lookupEnv := func(name string) (string, bool) {
+		value, exists := env[name]
+		return value, exists
+	}
+
+	config := DefaultConfig
+	config.ProxyName = "from-file"
+	if err := config.applyEnv(lookupEnv); err != nil {
+		t.Fatal(err)
+	}
+	if config.ProxyName != "office" || config.RobotRefreshToken != "secret" {
+		t.Logf("expected strings from the environment, got %q and %q", config.ProxyName, config.RobotRefreshToken)
+		t.Fail()
+	}
+	if !config.CloudPrintingEnable || config.XMPPPort != 5223 {
+		t.Logf("expected true and 5223, got %t and %d", config.CloudPrintingEnable, config.XMPPPort)
+		t.Fail()
+	}
+	if !reflect.DeepEqual(config.CUPSPrinterAttributes, []string{"printer-name"}) {
+		t.Logf("expected [printer-name], got %v", config.CUPSPrinterAttributes)
+		t.Fail()
+	}
+	if config.LogLevel != DefaultConfig.LogLevel {
+		t.Logf("expected log level without a variable to be unchanged, got %q", config.LogLevel)
+		t.Fail()
+	}
+
+	env = map[string]string{"CC_XMPP_PORT": "many"}
+	if err := config.applyEnv(lookupEnv); err == nil {
+		t.Log("expected error for a bad number")
+		t.Fail()
+	}
+}
+
+func TestConfigWithoutEnv(t *testing.T) {
+	env := map[string]string{
+		"CC_ROBOT_REFRESH_TOKEN": "secret",
+		"CC_PROXY_NAME":          "office",
+		"CC_XMPP_PORT":           "5223",
+	}
+	lookupEnv := func(name string) (string, bool) {
+		value, exists := env[name]
+		return value, exists
+	}
+
+	config := DefaultConfig
+	config.RobotRefreshToken = "from-file"
+	if err := config.applyEnv(lookupEnv); err != nil {
+		t.Fatal(err)
+	}
+	config.ProxyName = "renamed"
+
+	file := config.withoutEnv()
+	if file.RobotRefreshToken != "from-file" || file.XMPPPort != DefaultConfig.XMPPPort {
+		t.Logf("expected file values, got %q and %d", file.RobotRefreshToken, file.XMPPPort)
+		t.Fail()
+	}
+	if file.ProxyName != "renamed" {
+		t.Logf("expected a changed value to be kept, got %q", file.ProxyName)
+		t.Fail()
+	}
+	if config.RobotRefreshToken != "secret" {
+		t.Logf("expected the config to keep the environment value, got %q", config.RobotRefreshToken)
+		t.Fail()
+	}
+}
@@ -41,15 +41,15 @@ func (sv sortableValues) Less(i, j int) bool {
 	case reflect.Bool:
 		return sv[i].Bool() == false && sv[j].Bool() == true
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		return sv[i].Int() < sv[i].Int()
+		return sv[i].Int() < sv[j].Int()
 	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-		return sv[i].Uint() < sv[i].Uint()
+		return sv[i].Uint() < sv[j].Uint()
 	case reflect.Float32, reflect.Float64:
-		return sv[i].Float() < sv[i].Float()
+		return sv[i].Float() < sv[j].Float()
 	case reflect.String:
 		return sv[i].String() < sv[j].String()
 	case reflect.Ptr:
-		return sv[i].Pointer() < sv[i].Pointer()
+		return sv[i].Pointer() < sv[j].Pointer()
 	default:
 		panic(fmt.Sprintf("Cannot compare type %s", sv[i].Kind().String()))
 	}
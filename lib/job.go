@@ -8,7 +8,18 @@ https://developers.google.com/open-source/licenses/bsd
 
 package lib
 
-import "github.com/google/cups-connector/cdd"
+import (
+	"fmt"
+	"mime"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/google/cups-connector/cdd"
+)
+
+// MaxJobTitleLength is the longest job title, in bytes, that CUPS accepts.
+const MaxJobTitleLength = 255
 
 type Job struct {
 	CUPSPrinterName string
@@ -18,4 +29,115 @@ type Job struct {
 	JobID           string
 	Ticket          *cdd.CloudJobTicket
 	UpdateJob       func(string, cdd.PrintJobStateDiff) error
+
+	// Like UpdateJob, with a message for the user. Nil when job state has no
+	// message.
+	UpdateJobWithMessage func(string, cdd.PrintJobStateDiff, string) error
+}
+
+// JobErrorCategory says, broadly, why a job failed.
+type JobErrorCategory string
+
+const (
+	// The job couldn't be fetched, for lack of a working network.
+	JobErrorNetwork JobErrorCategory = "network"
+	// The job couldn't be fetched, because the connector's credentials were
+	// rejected.
+	JobErrorAuth JobErrorCategory = "auth"
+	// CUPS didn't accept the job.
+	JobErrorCUPSSubmit JobErrorCategory = "cups-submit"
+	// The job asked for options that the printer can't honor.
+	JobErrorUnsupportedOption JobErrorCategory = "unsupported-option"
+	// The printer went away, or failed while it had the job.
+	JobErrorPrinter JobErrorCategory = "printer-error"
+	// The job's content type isn't one of the accepted job formats.
+	JobErrorUnsupportedFormat JobErrorCategory = "unsupported-format"
+)
+
+// JobError is why a job failed, to report to the job's owner and to log.
+type JobError struct {
+	Category JobErrorCategory
+	Message  string
+}
+
+// NewJobError formats a JobError like fmt.Sprintf.
+func NewJobError(category JobErrorCategory, format string, args ...interface{}) *JobError {
+	return &JobError{category, fmt.Sprintf(format, args...)}
+}
+
+// Error is the category and the message, as shown to the job's owner and
+// logged, so that the two can be matched.
+func (e *JobError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Category, e.Message)
+}
+
+// State is the aborted job state that reports e.
+func (e *JobError) State() cdd.PrintJobStateDiff {
+	state := cdd.JobState{Type: cdd.JobStateAborted}
+	switch e.Category {
+	case JobErrorNetwork:
+		state.DeviceActionCause = &cdd.DeviceActionCause{ErrorCode: cdd.DeviceActionCauseDownloadFailure}
+	case JobErrorAuth:
+		state.ServiceActionCause = &cdd.ServiceActionCause{ErrorCode: cdd.ServiceActionCauseFetchForbidden}
+	case JobErrorCUPSSubmit:
+		state.DeviceActionCause = &cdd.DeviceActionCause{ErrorCode: cdd.DeviceActionCausePrintFailure}
+	case JobErrorUnsupportedOption:
+		state.DeviceActionCause = &cdd.DeviceActionCause{ErrorCode: cdd.DeviceActionCauseInvalidTicket}
+	case JobErrorUnsupportedFormat:
+		state.ServiceActionCause = &cdd.ServiceActionCause{ErrorCode: cdd.ServiceActionCauseConversionType}
+	default:
+		state.DeviceActionCause = &cdd.DeviceActionCause{ErrorCode: cdd.DeviceActionCauseOther}
+	}
+	return cdd.PrintJobStateDiff{State: &state}
+}
+
+// JobFormatAccepted answers whether contentType, like application/pdf, is one
+// of the accepted job formats, ignoring case and parameters. A format like
+// image/* accepts every subtype. Empty accepted formats accept every content
+// type, as does an empty content type, which can't be judged.
+func JobFormatAccepted(contentType string, accepted []string) bool {
+	if len(accepted) == 0 || contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	for _, format := range accepted {
+		format = strings.ToLower(format)
+		if format == mediaType {
+			return true
+		}
+		if strings.HasSuffix(format, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(format, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// SanitizeJobTitle replaces control characters in title with spaces, trims
+// it, and shortens it to maxLength bytes without splitting a character. A
+// maxLength of 0, or over MaxJobTitleLength, means MaxJobTitleLength.
+func SanitizeJobTitle(title string, maxLength uint) string {
+	if maxLength == 0 || maxLength > MaxJobTitleLength {
+		maxLength = MaxJobTitleLength
+	}
+
+	title = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == utf8.RuneError {
+			return ' '
+		}
+		return r
+	}, title)
+	title = strings.TrimSpace(title)
+
+	if uint(len(title)) > maxLength {
+		cut := int(maxLength)
+		for cut > 0 && !utf8.RuneStart(title[cut]) {
+			cut--
+		}
+		title = strings.TrimSpace(title[:cut])
+	}
+	return title
 }
@@ -0,0 +1,91 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/cups-connector/cdd"
+)
+
+func TestSanitizeJobTitle(t *testing.T) {
+	testCases := []struct {
+		title     string
+		maxLength uint
+		expected  string
+	}{
+		{"Quarterly report.pdf", 0, "Quarterly report.pdf"},
+		{"line one\nline two\t\x00end\x7f", 0, "line one line two  end"},
+		{" \r\npadded\r\n ", 0, "padded"},
+		{"Quarterly report.pdf", 9, "Quarterly"},
+		{"héllo", 2, "h"},
+		{strings.Repeat("a", 300), 0, strings.Repeat("a", MaxJobTitleLength)},
+		{strings.Repeat("a", 300), 1000, strings.Repeat("a", MaxJobTitleLength)},
+	}
+	for _, tc := range testCases {
+		if got := SanitizeJobTitle(tc.title, tc.maxLength); got != tc.expected {
+			t.Logf("expected %q for %q with max %d, got %q", tc.expected, tc.title, tc.maxLength, got)
+			t.Fail()
+		}
+	}
+}
+
+func TestJobError(t *testing.T) {
+	jobErr := NewJobError(JobErrorCUPSSubmit, "Failed to submit to CUPS: %s", "client-error-not-found")
+	if got := jobErr.Error(); got != "cups-submit: Failed to submit to CUPS: client-error-not-found" {
+		t.Logf("unexpected error string %q", got)
+		t.Fail()
+	}
+
+	state := jobErr.State()
+	if state.State == nil || state.State.Type != cdd.JobStateAborted ||
+		state.State.DeviceActionCause == nil || state.State.DeviceActionCause.ErrorCode != cdd.DeviceActionCausePrintFailure {
+		t.Logf("expected an aborted state with a print failure, got %+v", state.State)
+		t.Fail()
+	}
+
+	state = NewJobError(JobErrorAuth, "Forbidden").State()
+	if state.State.ServiceActionCause == nil || state.State.ServiceActionCause.ErrorCode != cdd.ServiceActionCauseFetchForbidden {
+		t.Logf("expected a forbidden fetch, got %+v", state.State)
+		t.Fail()
+	}
+
+	state = NewJobError(JobErrorUnsupportedFormat, "Unsupported").State()
+	if state.State.ServiceActionCause == nil || state.State.ServiceActionCause.ErrorCode != cdd.ServiceActionCauseConversionType {
+		t.Logf("expected an unsupported content type, got %+v", state.State)
+		t.Fail()
+	}
+}
+
+func TestJobFormatAccepted(t *testing.T) {
+	accepted := []string{"application/pdf", "image/*"}
+	for contentType, expected := range map[string]bool{
+		"application/pdf":                true,
+		"Application/PDF":                true,
+		"application/pdf; version=1.7":   true,
+		"image/jpeg":                     true,
+		"image/png":                      true,
+		"application/postscript":         false,
+		"text/plain; charset=utf-8":      false,
+		"imagery/png":                    false,
+		"":                               true,
+		"not a content type; version=1;": false,
+	} {
+		if got := JobFormatAccepted(contentType, accepted); got != expected {
+			t.Logf("expected %t for %q, got %t", expected, contentType, got)
+			t.Fail()
+		}
+	}
+
+	if !JobFormatAccepted("application/postscript", nil) {
+		t.Log("expected no accepted formats to accept every content type")
+		t.Fail()
+	}
+}
@@ -9,10 +9,17 @@ https://developers.google.com/open-source/licenses/bsd
 package lib
 
 import (
+	"fmt"
+	"hash/adler32"
+	"net/url"
 	"reflect"
 	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/google/cups-connector/cdd"
+	"github.com/google/cups-connector/log"
 )
 
 type PrinterState uint8
@@ -34,22 +41,156 @@ type Printer struct {
 	Description        *cdd.PrinterDescriptionSection // CUPS: translated PPD;              GCP: capabilities field
 	CapsHash           string                         // CUPS: hash of PPD;                 GCP: capsHash field
 	Tags               map[string]string              // CUPS: all printer attributes;      GCP: repeated tag field
+	Raw                bool                           // CUPS: make-and-model or no PPD
 	CUPSJobSemaphore   *Semaphore
 }
 
-var rDeviceURIHostname *regexp.Regexp = regexp.MustCompile(
-	"(?i)^(?:socket|http|https|ipp|ipps|lpd)://([a-z][a-z0-9.-]*)")
+// Device URI schemes whose host is a network hostname.
+var networkDeviceURISchemes = map[string]struct{}{
+	"socket": struct{}{},
+	"http":   struct{}{},
+	"https":  struct{}{},
+	"ipp":    struct{}{},
+	"ipps":   struct{}{},
+	"lpd":    struct{}{},
+}
+
+var rDeviceURIHostname *regexp.Regexp = regexp.MustCompile("(?i)^[a-z][a-z0-9.-]*$")
+
+// Matches the userinfo of URIs that net/url can't parse.
+var rDeviceURIUserinfo *regexp.Regexp = regexp.MustCompile("^([a-zA-Z][a-zA-Z0-9+.-]*://)[^/?#]*@")
+
+const (
+	// ConnectionTypeTag is the printer tag that holds the device URI scheme,
+	// like ipp or usb.
+	ConnectionTypeTag = "connection-type"
+	// UnknownConnectionType is the connection type of printers without a
+	// device URI scheme.
+	UnknownConnectionType = "unknown"
+	// MarkerCapacitiesTag is the printer tag that holds the capacity of each
+	// marker supply reported by SNMP, like 1=24000,2=12000.
+	MarkerCapacitiesTag = "snmp-marker-capacities"
+)
+
+// ReservedTags are the printer tags that the connector sets itself, so can't
+// be set by config, like custom tags or SNMP extra OIDs.
+var ReservedTags = map[string]struct{}{
+	"tagshash":           struct{}{},
+	ConnectionTypeTag:    struct{}{},
+	"snmp-serial-number": struct{}{},
+	MarkerCapacitiesTag:  struct{}{},
+}
+
+// FormatMarkerCapacities formats the capacity of each marker supply, by
+// marker vendor ID, as the value of MarkerCapacitiesTag.
+func FormatMarkerCapacities(capacities map[string]int64) string {
+	ids := make([]string, 0, len(capacities))
+	for id := range capacities {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	pairs := make([]string, len(ids))
+	for i, id := range ids {
+		pairs[i] = fmt.Sprintf("%s=%d", id, capacities[id])
+	}
+	return strings.Join(pairs, ",")
+}
+
+// ParseMarkerCapacities parses the value of MarkerCapacitiesTag, skipping
+// pairs that aren't understood.
+func ParseMarkerCapacities(tag string) map[string]int64 {
+	capacities := make(map[string]int64)
+	for _, pair := range strings.Split(tag, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		capacity, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		capacities[parts[0]] = capacity
+	}
+	return capacities
+}
+
+var rDeviceURIScheme *regexp.Regexp = regexp.MustCompile("^([a-zA-Z][a-zA-Z0-9+.-]*):")
 
 // GetHostname gets the network hostname, parsed from Printer.Tags["device-uri"].
+// Credentials and percent-encoding in the URI are ignored.
 func (p *Printer) GetHostname() (string, bool) {
 	deviceURI, ok := p.Tags["device-uri"]
 	if !ok {
 		return "", false
 	}
 
-	parts := rDeviceURIHostname.FindStringSubmatch(deviceURI)
+	u, err := parseDeviceURI(deviceURI)
+	if err != nil {
+		return "", false
+	}
+	if _, exists := networkDeviceURISchemes[strings.ToLower(u.Scheme)]; !exists {
+		return "", false
+	}
+	// Hostname() excludes userinfo and port.
+	hostname := u.Hostname()
+	if !rDeviceURIHostname.MatchString(hostname) {
+		return "", false
+	}
+
+	return hostname, true
+}
+
+// parseDeviceURI parses deviceURI, percent-decoding its authority first if
+// net/url rejects it, because net/url doesn't allow percent-encoded ASCII in
+// hosts.
+func parseDeviceURI(deviceURI string) (*url.URL, error) {
+	u, err := url.Parse(deviceURI)
+	if err == nil {
+		return u, nil
+	}
+
+	i := strings.Index(deviceURI, "://")
+	if i < 0 {
+		return nil, err
+	}
+	rest := deviceURI[i+len("://"):]
+	j := strings.IndexAny(rest, "/?#")
+	if j < 0 {
+		j = len(rest)
+	}
+	authority, unescapeErr := url.PathUnescape(rest[:j])
+	if unescapeErr != nil {
+		return nil, err
+	}
+	return url.Parse(deviceURI[:i+len("://")] + authority + rest[j:])
+}
+
+// RedactDeviceURI removes credentials, like user:password@, from a device URI,
+// so that they don't end up in GCP tags or logs.
+func RedactDeviceURI(deviceURI string) string {
+	u, err := url.Parse(deviceURI)
+	if err != nil {
+		return rDeviceURIUserinfo.ReplaceAllString(deviceURI, "$1")
+	}
+	if u.User == nil {
+		return deviceURI
+	}
+	u.User = nil
+	return u.String()
+}
+
+// GetDeviceURIScheme gets the lowercase scheme, like ipp or usb, parsed from
+// Printer.Tags["device-uri"].
+func (p *Printer) GetDeviceURIScheme() (string, bool) {
+	deviceURI, ok := p.Tags["device-uri"]
+	if !ok {
+		return "", false
+	}
+
+	parts := rDeviceURIScheme.FindStringSubmatch(deviceURI)
 	if len(parts) == 2 {
-		return parts[1], true
+		return strings.ToLower(parts[1]), true
 	}
 
 	return "", false
@@ -70,6 +211,7 @@ type PrinterDiff struct {
 	Printer   Printer
 
 	DefaultDisplayNameChanged bool
+	UUIDChanged               bool
 	ManufacturerChanged       bool
 	ModelChanged              bool
 	GCPVersionChanged         bool
@@ -83,26 +225,99 @@ type PrinterDiff struct {
 	TagsChanged               bool
 }
 
-func printerSliceToMapByName(s []Printer) map[string]Printer {
+// String describes the change, like "update lobby (GCP ID abc): state, tags".
+func (d *PrinterDiff) String() string {
+	switch d.Operation {
+	case RegisterPrinter:
+		return fmt.Sprintf("register %s", d.Printer.Name)
+	case DeletePrinter:
+		return fmt.Sprintf("delete %s (GCP ID %s)", d.Printer.Name, d.Printer.GCPID)
+	case NoChangeToPrinter:
+		return fmt.Sprintf("no change to %s", d.Printer.Name)
+	}
+
+	var changes []string
+	for _, c := range []struct {
+		changed bool
+		name    string
+	}{
+		{d.DefaultDisplayNameChanged, "display name"},
+		{d.UUIDChanged, "UUID"},
+		{d.ManufacturerChanged, "manufacturer"},
+		{d.ModelChanged, "model"},
+		{d.GCPVersionChanged, "GCP version"},
+		{d.SetupURLChanged, "setup URL"},
+		{d.SupportURLChanged, "support URL"},
+		{d.UpdateURLChanged, "update URL"},
+		{d.ConnectorVersionChanged, "connector version"},
+		{d.StateChanged, "state"},
+		{d.DescriptionChanged, "capabilities"},
+		{d.CapsHashChanged, "capabilities hash"},
+		{d.TagsChanged, "tags"},
+	} {
+		if c.changed {
+			changes = append(changes, c.name)
+		}
+	}
+	return fmt.Sprintf("update %s (GCP ID %s): %s", d.Printer.Name, d.Printer.GCPID, strings.Join(changes, ", "))
+}
+
+// printerSliceToMapByName maps printers by name. When printers share a name,
+// the last one wins, and the name is returned in the duplicates slice.
+func printerSliceToMapByName(s []Printer) (map[string]Printer, []string) {
 	m := make(map[string]Printer, len(s))
+	var duplicates []string
 	for i := range s {
+		if _, exists := m[s[i].Name]; exists {
+			duplicates = append(duplicates, s[i].Name)
+		}
 		m[s[i].Name] = s[i]
 	}
-	return m
+	return m, duplicates
+}
+
+// DuplicatePrinterNames returns the names shared by more than one printer.
+func DuplicatePrinterNames(printers []Printer) []string {
+	_, duplicates := printerSliceToMapByName(printers)
+	return duplicates
+}
+
+// GCPVersionDowngradeError is returned by DiffPrinters when a CUPS printer
+// has an older GCP version than the corresponding GCP printer.
+type GCPVersionDowngradeError struct {
+	PrinterName    string
+	GCPVersion     string
+	CUPSGCPVersion string
+}
+
+func (e *GCPVersionDowngradeError) Error() string {
+	return fmt.Sprintf("GCP version of printer %s cannot be downgraded from %s to %s; delete GCP printers or list them in force_reregister",
+		e.PrinterName, e.GCPVersion, e.CUPSGCPVersion)
 }
 
 // DiffPrinters returns the diff between old (GCP) and new (CUPS) printers.
-// Returns nil if zero printers or if all diffs are NoChangeToPrinter operation.
-func DiffPrinters(cupsPrinters, gcpPrinters []Printer) []PrinterDiff {
+// Returns nil diffs if zero printers or if all diffs are NoChangeToPrinter
+// operation. Returns an error, and no diffs, if the printers can't be diffed.
+func DiffPrinters(cupsPrinters, gcpPrinters []Printer) ([]PrinterDiff, error) {
 	// So far, no changes.
 	dirty := false
 
 	diffs := make([]PrinterDiff, 0, 1)
 	printersConsidered := make(map[string]struct{}, len(cupsPrinters))
-	cupsPrintersByName := printerSliceToMapByName(cupsPrinters)
+	cupsPrintersByName, duplicates := printerSliceToMapByName(cupsPrinters)
+	if len(duplicates) > 0 {
+		log.Warningf("Multiple CUPS printers share these names; only the last of each is used: %s",
+			strings.Join(duplicates, ", "))
+	}
 
 	for i := range gcpPrinters {
-		if _, exists := printersConsidered[gcpPrinters[i].Name]; exists {
+		if gcpPrinters[i].Name == "" {
+			// A corrupt registration; it can't match any CUPS printer.
+			log.Warningf("GCP printer %s has no name, so it will be deleted", gcpPrinters[i].GCPID)
+			diffs = append(diffs, PrinterDiff{Operation: DeletePrinter, Printer: gcpPrinters[i]})
+			dirty = true
+
+		} else if _, exists := printersConsidered[gcpPrinters[i].Name]; exists {
 			// GCP can have multiple printers with one name. Remove dupes.
 			diffs = append(diffs, PrinterDiff{Operation: DeletePrinter, Printer: gcpPrinters[i]})
 			dirty = true
@@ -116,7 +331,10 @@ func DiffPrinters(cupsPrinters, gcpPrinters []Printer) []PrinterDiff {
 				// Don't lose track of this semaphore.
 				cupsPrinter.CUPSJobSemaphore = gcpPrinters[i].CUPSJobSemaphore
 
-				diff := diffPrinter(&cupsPrinter, &gcpPrinters[i])
+				diff, err := diffPrinter(&cupsPrinter, &gcpPrinters[i])
+				if err != nil {
+					return nil, err
+				}
 				diffs = append(diffs, diff)
 
 				if diff.Operation != NoChangeToPrinter {
@@ -138,10 +356,40 @@ func DiffPrinters(cupsPrinters, gcpPrinters []Printer) []PrinterDiff {
 	}
 
 	if dirty {
-		return diffs
+		return diffs, nil
 	} else {
-		return nil
+		return nil, nil
+	}
+}
+
+// CheckEmptyCUPSPrinters returns an error when there are no CUPS printers
+// but there are GCP printers, because syncing would delete every GCP printer,
+// and CUPS is probably just restarting.
+func CheckEmptyCUPSPrinters(cupsPrinters, gcpPrinters []Printer) error {
+	if len(cupsPrinters) == 0 && len(gcpPrinters) > 0 {
+		return fmt.Errorf("CUPS has no printers while GCP has %d, so skipping sync to avoid deleting them; "+
+			"set allow_empty_cups_sync to sync anyway", len(gcpPrinters))
 	}
+	return nil
+}
+
+// TagsHash hashes tags in key order, so that the same tags always have the
+// same hash. The tagshash tag itself is left out.
+func TagsHash(tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		if k != "tagshash" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	h := adler32.New()
+	for _, k := range keys {
+		// Length prefixes keep {"ab": "c"} and {"a": "bc"} apart.
+		fmt.Fprintf(h, "%d:%s%d:%s", len(k), k, len(tags[k]), tags[k])
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
 }
 
 // diffPrinter finds the difference between a CUPS printer and the corresponding GCP printer.
@@ -149,7 +397,7 @@ func DiffPrinters(cupsPrinters, gcpPrinters []Printer) []PrinterDiff {
 // pc: printer-CUPS; the thing that is correct
 //
 // pg: printer-GCP; the thing that will be updated
-func diffPrinter(pc, pg *Printer) PrinterDiff {
+func diffPrinter(pc, pg *Printer) (PrinterDiff, error) {
 	d := PrinterDiff{
 		Operation: UpdatePrinter,
 		Printer:   *pc,
@@ -158,6 +406,11 @@ func diffPrinter(pc, pg *Printer) PrinterDiff {
 	if pg.DefaultDisplayName != pc.DefaultDisplayName {
 		d.DefaultDisplayNameChanged = true
 	}
+	// A GCP printer registered before UUIDs were tracked has none; that is not
+	// drift.
+	if pg.UUID != "" && pg.UUID != pc.UUID {
+		d.UUIDChanged = true
+	}
 	if pg.Manufacturer != pc.Manufacturer {
 		d.ManufacturerChanged = true
 	}
@@ -166,7 +419,7 @@ func diffPrinter(pc, pg *Printer) PrinterDiff {
 	}
 	if pg.GCPVersion != pc.GCPVersion {
 		if pg.GCPVersion > pc.GCPVersion {
-			panic("GCP version cannot be downgraded; delete GCP printers")
+			return PrinterDiff{}, &GCPVersionDowngradeError{pc.Name, pg.GCPVersion, pc.GCPVersion}
 		}
 		d.GCPVersionChanged = true
 	}
@@ -198,24 +451,25 @@ func diffPrinter(pc, pg *Printer) PrinterDiff {
 		d.TagsChanged = true
 	}
 
-	if d.DefaultDisplayNameChanged || d.ManufacturerChanged || d.ModelChanged ||
+	if d.DefaultDisplayNameChanged || d.UUIDChanged || d.ManufacturerChanged || d.ModelChanged ||
 		d.GCPVersionChanged || d.SetupURLChanged || d.SupportURLChanged ||
 		d.UpdateURLChanged || d.ConnectorVersionChanged || d.StateChanged ||
 		d.DescriptionChanged || d.CapsHashChanged || d.TagsChanged {
-		return d
+		return d, nil
 	}
 
 	return PrinterDiff{
 		Operation: NoChangeToPrinter,
 		Printer:   *pg,
-	}
+	}, nil
 }
 
-// FilterRawPrinters splits a slice of printers into non-raw and raw.
-func FilterRawPrinters(printers []Printer) ([]Printer, []Printer) {
+// FilterRawPrinters splits a slice of printers into non-raw and raw. Printers
+// are raw when marked so, or by make-and-model.
+func FilterRawPrinters(printers []Printer, rawMakeAndModels []string) ([]Printer, []Printer) {
 	notRaw, raw := make([]Printer, 0, len(printers)), make([]Printer, 0, 0)
 	for i := range printers {
-		if PrinterIsRaw(printers[i]) {
+		if printers[i].Raw || PrinterIsRaw(printers[i], rawMakeAndModels) {
 			raw = append(raw, printers[i])
 		} else {
 			notRaw = append(notRaw, printers[i])
@@ -224,9 +478,124 @@ func FilterRawPrinters(printers []Printer) ([]Printer, []Printer) {
 	return notRaw, raw
 }
 
-func PrinterIsRaw(printer Printer) bool {
-	if printer.Tags["printer-make-and-model"] == "Local Raw Printer" {
-		return true
+// PrinterIsRaw checks whether the printer's make-and-model contains one of
+// rawMakeAndModels, ignoring case. CUPS localizes the make-and-model of raw
+// queues, so one language's string is not enough.
+//
+// Config files older than cups_raw_printer_make_models give nil
+// rawMakeAndModels; then only an exact "Local Raw Printer" is raw, as before.
+func PrinterIsRaw(printer Printer, rawMakeAndModels []string) bool {
+	if rawMakeAndModels == nil {
+		return printer.Tags["printer-make-and-model"] == "Local Raw Printer"
+	}
+
+	makeAndModel := strings.ToLower(printer.Tags["printer-make-and-model"])
+	for _, raw := range rawMakeAndModels {
+		if raw != "" && strings.Contains(makeAndModel, strings.ToLower(raw)) {
+			return true
+		}
 	}
 	return false
 }
+
+// What to do with raw printers.
+const (
+	// Leave raw printers out of GCP, without deleting the ones that are
+	// already registered.
+	RawPrinterPolicyIgnore = "ignore"
+	// Register raw printers like other printers.
+	RawPrinterPolicyRegister = "register"
+	// Leave raw printers out of GCP, and delete the ones that are already
+	// registered.
+	RawPrinterPolicyDeleteFromGCP = "delete-from-gcp"
+)
+
+// CheckRawPrinterPolicy returns an error when policy is not a raw printer
+// policy.
+func CheckRawPrinterPolicy(policy string) error {
+	switch policy {
+	case RawPrinterPolicyIgnore, RawPrinterPolicyRegister, RawPrinterPolicyDeleteFromGCP:
+		return nil
+	}
+	return fmt.Errorf("Invalid raw printer policy %q; use %s, %s or %s", policy,
+		RawPrinterPolicyIgnore, RawPrinterPolicyRegister, RawPrinterPolicyDeleteFromGCP)
+}
+
+// ApplyRawPrinterPolicy returns the CUPS printers to sync, and the GCP
+// printers to compare them to, according to policy. Raw CUPS printers are
+// those marked Raw. GCP printers that are left out of the comparison are
+// neither updated nor deleted.
+func ApplyRawPrinterPolicy(policy string, cupsPrinters, gcpPrinters []Printer) ([]Printer, []Printer) {
+	if policy == RawPrinterPolicyRegister {
+		return cupsPrinters, gcpPrinters
+	}
+
+	notRaw := make([]Printer, 0, len(cupsPrinters))
+	rawNames := make(map[string]struct{})
+	for i := range cupsPrinters {
+		if cupsPrinters[i].Raw {
+			rawNames[cupsPrinters[i].Name] = struct{}{}
+		} else {
+			notRaw = append(notRaw, cupsPrinters[i])
+		}
+	}
+	if policy != RawPrinterPolicyIgnore || len(rawNames) == 0 {
+		return notRaw, gcpPrinters
+	}
+
+	notIgnored := make([]Printer, 0, len(gcpPrinters))
+	for i := range gcpPrinters {
+		if _, exists := rawNames[gcpPrinters[i].Name]; !exists {
+			notIgnored = append(notIgnored, gcpPrinters[i])
+		}
+	}
+	return notRaw, notIgnored
+}
+
+// Keys of make and model overrides.
+const (
+	MakeModelOverrideManufacturer = "manufacturer"
+	MakeModelOverrideModel        = "model"
+)
+
+// CheckMakeModelOverrides returns an error when overrides, which map printer
+// name to manufacturer or model to value, override anything else, override
+// with an empty value, or override nothing.
+func CheckMakeModelOverrides(overrides map[string]map[string]string) error {
+	for printerName, override := range overrides {
+		if len(override) == 0 {
+			return fmt.Errorf("Printer %s overrides neither %s nor %s",
+				printerName, MakeModelOverrideManufacturer, MakeModelOverrideModel)
+		}
+		for key, value := range override {
+			if key != MakeModelOverrideManufacturer && key != MakeModelOverrideModel {
+				return fmt.Errorf("%s of printer %s cannot be overridden; use %s or %s",
+					key, printerName, MakeModelOverrideManufacturer, MakeModelOverrideModel)
+			}
+			if strings.TrimSpace(value) == "" {
+				return fmt.Errorf("%s of printer %s cannot be overridden with an empty value", key, printerName)
+			}
+		}
+	}
+	return nil
+}
+
+// ApplyMakeModelOverrides replaces the manufacturer and model of printers, as
+// translated from their PPDs, with those in overrides, by printer name.
+func ApplyMakeModelOverrides(printers []Printer, overrides map[string]map[string]string) {
+	if len(overrides) == 0 {
+		return
+	}
+	for i := range printers {
+		override, exists := overrides[printers[i].Name]
+		if !exists {
+			continue
+		}
+		if manufacturer, exists := override[MakeModelOverrideManufacturer]; exists {
+			printers[i].Manufacturer = manufacturer
+		}
+		if model, exists := override[MakeModelOverrideModel]; exists {
+			printers[i].Model = model
+		}
+	}
+}
@@ -0,0 +1,59 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ParseTLSVersion parses a TLS version like 1.2 to its crypto/tls constant.
+// An empty version is 0, which is the crypto/tls default.
+func ParseTLSVersion(version string) (uint16, error) {
+	if version == "" {
+		return 0, nil
+	}
+	v, exists := tlsVersions[version]
+	if !exists {
+		return 0, fmt.Errorf("TLS version %s is not recognized; use 1.0, 1.1, 1.2 or 1.3", version)
+	}
+	return v, nil
+}
+
+// SetMinTLSVersion makes http.DefaultTransport, which the GCP, OAuth and
+// webhook clients use, refuse servers that can't speak at least TLS version.
+// Like TrustCACertFile, it must be called before http.DefaultTransport is
+// wrapped.
+func SetMinTLSVersion(version uint16) error {
+	if version == 0 {
+		return nil
+	}
+
+	transport, ok := http.DefaultTransport.(*http.Transport)
+	if !ok {
+		return errors.New("Cannot set the minimum TLS version of a wrapped HTTP transport")
+	}
+	transport = transport.Clone()
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = &tls.Config{}
+	}
+	transport.TLSClientConfig.MinVersion = version
+	http.DefaultTransport = transport
+
+	return nil
+}
@@ -0,0 +1,35 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"crypto/tls"
+	"testing"
+)
+
+func TestParseTLSVersion(t *testing.T) {
+	for version, expected := range map[string]uint16{
+		"":    0,
+		"1.0": tls.VersionTLS10,
+		"1.2": tls.VersionTLS12,
+		"1.3": tls.VersionTLS13,
+	} {
+		if v, err := ParseTLSVersion(version); err != nil || v != expected {
+			t.Logf("expected %d for %q, got %d, %v", expected, version, v, err)
+			t.Fail()
+		}
+	}
+
+	for _, version := range []string{"1.4", "TLS1.2", "SSLv3"} {
+		if _, err := ParseTLSVersion(version); err == nil {
+			t.Logf("expected error for %q", version)
+			t.Fail()
+		}
+	}
+}
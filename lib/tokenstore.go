@@ -0,0 +1,158 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/codegangsta/cli"
+)
+
+// TokenName names a refresh token; it matches the config file key.
+type TokenName string
+
+const (
+	RobotRefreshToken TokenName = "robot_refresh_token"
+	UserRefreshToken  TokenName = "user_refresh_token"
+)
+
+// Token stores.
+const (
+	TokenStoreFile    = "file"
+	TokenStoreEnv     = "env"
+	TokenStoreCommand = "command"
+)
+
+// How long the command token store waits for the command.
+const tokenStoreCommandTimeout = 30 * time.Second
+
+// ErrTokenStoreReadOnly is returned when saving to a token store that can
+// only be read.
+var ErrTokenStoreReadOnly = errors.New("Token store is read-only")
+
+// TokenStore loads and saves refresh tokens. Loading a token that isn't
+// stored returns an empty string.
+type TokenStore interface {
+	Load(name TokenName) (string, error)
+	Save(name TokenName, token string) error
+}
+
+// NewTokenStore creates the token store chosen by config.
+func NewTokenStore(context *cli.Context, config *Config) (TokenStore, error) {
+	switch config.TokenStore {
+	case TokenStoreFile, "":
+		return &fileTokenStore{context}, nil
+	case TokenStoreEnv:
+		return envTokenStore{}, nil
+	case TokenStoreCommand:
+		command := strings.Fields(config.TokenStoreCommand)
+		if len(command) == 0 {
+			return nil, errors.New("The command token store requires token_store_command")
+		}
+		return commandTokenStore(command), nil
+	}
+	return nil, fmt.Errorf("Token store %q is not recognized", config.TokenStore)
+}
+
+// LoadTokens replaces the refresh tokens in config with those in store.
+func LoadTokens(store TokenStore, config *Config) error {
+	if _, ok := store.(*fileTokenStore); ok {
+		// Already loaded with the config file.
+		return nil
+	}
+
+	var err error
+	if config.RobotRefreshToken, err = store.Load(RobotRefreshToken); err != nil {
+		return err
+	}
+	if config.UserRefreshToken, err = store.Load(UserRefreshToken); err != nil {
+		return err
+	}
+	return nil
+}
+
+// fileTokenStore keeps tokens in the config file.
+type fileTokenStore struct {
+	context *cli.Context
+}
+
+func (s *fileTokenStore) Load(name TokenName) (string, error) {
+	config, _, err := GetConfig(s.context)
+	if err != nil {
+		return "", err
+	}
+	switch name {
+	case RobotRefreshToken:
+		return config.RobotRefreshToken, nil
+	case UserRefreshToken:
+		return config.UserRefreshToken, nil
+	}
+	return "", fmt.Errorf("Unknown token %s", name)
+}
+
+func (s *fileTokenStore) Save(name TokenName, token string) error {
+	config, _, err := GetConfig(s.context)
+	if err != nil {
+		return err
+	}
+	switch name {
+	case RobotRefreshToken:
+		config.RobotRefreshToken = token
+	case UserRefreshToken:
+		config.UserRefreshToken = token
+	default:
+		return fmt.Errorf("Unknown token %s", name)
+	}
+	_, err = config.ToFile(s.context)
+	return err
+}
+
+// envTokenStore reads tokens from the environment variables that override
+// their config file keys, like CC_ROBOT_REFRESH_TOKEN.
+type envTokenStore struct{}
+
+func (envTokenStore) Load(name TokenName) (string, error) {
+	if name != RobotRefreshToken && name != UserRefreshToken {
+		return "", fmt.Errorf("Unknown token %s", name)
+	}
+	return strings.TrimSpace(os.Getenv(configEnvName(string(name)))), nil
+}
+
+func (envTokenStore) Save(name TokenName, token string) error {
+	return ErrTokenStoreReadOnly
+}
+
+// commandTokenStore reads tokens from the output of a command, which is run
+// with the token name as its last argument.
+type commandTokenStore []string
+
+func (s commandTokenStore) Load(name TokenName) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), tokenStoreCommandTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, s[0], append(s[1:len(s):len(s)], string(name))...)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("Token store command failed to print %s: %s: %s",
+			name, err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+func (s commandTokenStore) Save(name TokenName, token string) error {
+	return ErrTokenStoreReadOnly
+}
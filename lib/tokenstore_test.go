@@ -0,0 +1,73 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import (
+	"os"
+	"testing"
+)
+
+func TestEnvTokenStore(t *testing.T) {
+	store, err := NewTokenStore(nil, &Config{TokenStore: TokenStoreEnv})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv("CC_ROBOT_REFRESH_TOKEN", " robot-token\n")
+	defer os.Unsetenv("CC_ROBOT_REFRESH_TOKEN")
+	os.Unsetenv("CC_USER_REFRESH_TOKEN")
+
+	config := Config{RobotRefreshToken: "old", UserRefreshToken: "old"}
+	if err = LoadTokens(store, &config); err != nil {
+		t.Logf("unexpected error: %s", err)
+		t.Fail()
+	}
+	if config.RobotRefreshToken != "robot-token" || config.UserRefreshToken != "" {
+		t.Logf("expected robot-token and no user token, got %q and %q",
+			config.RobotRefreshToken, config.UserRefreshToken)
+		t.Fail()
+	}
+
+	if err = store.Save(RobotRefreshToken, "new"); err != ErrTokenStoreReadOnly {
+		t.Logf("expected ErrTokenStoreReadOnly, got %v", err)
+		t.Fail()
+	}
+}
+
+func TestCommandTokenStore(t *testing.T) {
+	store, err := NewTokenStore(nil, &Config{TokenStore: TokenStoreCommand, TokenStoreCommand: "echo token-for"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := store.Load(UserRefreshToken)
+	if err != nil {
+		t.Logf("unexpected error: %s", err)
+		t.Fail()
+	}
+	if token != "token-for user_refresh_token" {
+		t.Logf("expected the command output, got %q", token)
+		t.Fail()
+	}
+
+	store, _ = NewTokenStore(nil, &Config{TokenStore: TokenStoreCommand, TokenStoreCommand: "false"})
+	if _, err = store.Load(RobotRefreshToken); err == nil {
+		t.Log("expected error from a failing command")
+		t.Fail()
+	}
+
+	if _, err = NewTokenStore(nil, &Config{TokenStore: TokenStoreCommand}); err == nil {
+		t.Log("expected error without a command")
+		t.Fail()
+	}
+	if _, err = NewTokenStore(nil, &Config{TokenStore: "vault"}); err == nil {
+		t.Log("expected error for an unknown token store")
+		t.Fail()
+	}
+}
@@ -0,0 +1,32 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package lib
+
+import "net/http"
+
+// SetUserAgent wraps http.DefaultTransport, which the GCP, OAuth and webhook
+// clients use, so that requests without a User-Agent header are sent with
+// userAgent. TrustCACertFile must be called first.
+func SetUserAgent(userAgent string) {
+	http.DefaultTransport = &userAgentTransport{userAgent, http.DefaultTransport}
+}
+
+type userAgentTransport struct {
+	userAgent string
+	base      http.RoundTripper
+}
+
+func (t *userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("User-Agent") == "" {
+		// A RoundTripper must not modify the request it is given.
+		req = req.Clone(req.Context())
+		req.Header.Set("User-Agent", t.userAgent)
+	}
+	return t.base.RoundTrip(req)
+}
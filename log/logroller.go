@@ -9,7 +9,9 @@ https://developers.google.com/open-source/licenses/bsd
 package log
 
 import (
+	"compress/gzip"
 	"fmt"
+	"io"
 	"math"
 	"os"
 	"path/filepath"
@@ -19,7 +21,7 @@ import (
 	"sync"
 )
 
-var rollPattern = regexp.MustCompile(`^\.([0-9]+)$`)
+var rollPattern = regexp.MustCompile(`^\.([0-9]+)(\.gz)?$`)
 
 const rollFormatFormat = "%s.%%0%dd"
 
@@ -27,6 +29,7 @@ type LogRoller struct {
 	fileName     string
 	fileMaxBytes uint
 	maxFiles     uint
+	compress     bool
 
 	rollFormat string
 
@@ -35,7 +38,9 @@ type LogRoller struct {
 	fileSize uint
 }
 
-func NewLogRoller(fileName string, fileMaxBytes, maxFiles uint) (*LogRoller, error) {
+// NewLogRoller creates a new LogRoller. When compress is true, rolled log
+// files are gzipped.
+func NewLogRoller(fileName string, fileMaxBytes, maxFiles uint, compress bool) (*LogRoller, error) {
 	// How many digits to append to rolled file name?
 	// 0 => 0 ; 1 => 1 ; 9 => 1 ; 99 => 2 ; 100 => 3
 	var digits int
@@ -48,6 +53,7 @@ func NewLogRoller(fileName string, fileMaxBytes, maxFiles uint) (*LogRoller, err
 		fileName:     fileName,
 		fileMaxBytes: fileMaxBytes,
 		maxFiles:     maxFiles,
+		compress:     compress,
 		rollFormat:   rollFormat,
 	}
 	return &lr, nil
@@ -111,7 +117,9 @@ func (s sortableNumberStrings) Swap(i, j int) {
 
 // roll deletes old log files until there are lr.maxFiles or fewer,
 // and renames remaining log files so that the file named lr.fileName+".3" becomes lr.fileName+".4".
-// The file named lr.fileName becomes lr.fileName+".0".
+// The file named lr.fileName becomes lr.fileName+".0", which is gzipped to
+// lr.fileName+".0.gz" if lr.compress is true. Compressed and uncompressed
+// rolled files count alike towards lr.maxFiles.
 // If lr.fileName does not exist, then this is a noop.
 func (lr *LogRoller) roll() error {
 	if _, err := os.Stat(lr.fileName); os.IsNotExist(err) {
@@ -127,17 +135,26 @@ func (lr *LogRoller) roll() error {
 
 	// Get number suffixes from the rolled logs; ignore non-matches.
 	numbers := make(sortableNumberStrings, 0, len(matches))
+	suffixes := make(map[string]string, len(matches))
 	for _, match := range matches {
-		number := rollPattern.FindStringSubmatch(match[len(lr.fileName):])[1]
+		parts := rollPattern.FindStringSubmatch(match[len(lr.fileName):])
+		if parts == nil {
+			continue
+		}
+		number := parts[1]
+		if _, exists := suffixes[number]; exists {
+			continue
+		}
 		if _, err := strconv.ParseUint(number, 10, 16); err == nil {
 			numbers = append(numbers, number)
+			suffixes[number] = parts[2]
 		}
 	}
 
 	// Delete old log files and rename the rest.
 	sort.Sort(numbers)
 	for i := len(numbers) - 1; i >= 0; i-- {
-		oldpath := fmt.Sprintf("%s.%s", lr.fileName, numbers[i])
+		oldpath := fmt.Sprintf("%s.%s%s", lr.fileName, numbers[i], suffixes[numbers[i]])
 		if uint(i+1) >= lr.maxFiles {
 			err := os.Remove(oldpath)
 			if err != nil {
@@ -146,7 +163,7 @@ func (lr *LogRoller) roll() error {
 
 		} else {
 			n, _ := strconv.ParseUint(numbers[i], 10, 16)
-			newpath := fmt.Sprintf(lr.rollFormat, n+1)
+			newpath := fmt.Sprintf(lr.rollFormat, n+1) + suffixes[numbers[i]]
 			err := os.Rename(oldpath, newpath)
 			if err != nil {
 				return err
@@ -160,7 +177,41 @@ func (lr *LogRoller) roll() error {
 		if err != nil {
 			return err
 		}
+		if lr.compress {
+			if err = compressFile(newpath); err != nil {
+				return err
+			}
+		}
 	} // Else the existing file will be truncated.
 
 	return nil
 }
+
+// compressFile gzips the named file to name+".gz", then removes the original.
+func compressFile(name string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.Create(name + ".gz")
+	if err != nil {
+		return err
+	}
+
+	w := gzip.NewWriter(dst)
+	_, err = io.Copy(w, src)
+	if err == nil {
+		err = w.Close()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(name + ".gz")
+		return err
+	}
+
+	return os.Remove(name)
+}
@@ -9,6 +9,10 @@ https://developers.google.com/open-source/licenses/bsd
 package log
 
 import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"sort"
 	"strconv"
 	"testing"
@@ -56,3 +60,54 @@ func TestSortableNumberStrings(t *testing.T) {
 	s = sortableNumberStrings{"0100", "10", "11", "10"}
 	testSort(t, s)
 }
+
+func TestRollCompress(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logroller-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fileName := filepath.Join(dir, "connector.log")
+	lr, err := NewLogRoller(fileName, 1, 2, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each write exceeds fileMaxBytes, so each write after the first rolls.
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err = lr.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// One more write rolls the fourth file.
+	if _, err = lr.Write([]byte("fifth\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	matches, _ := filepath.Glob(fileName + ".*")
+	sort.Strings(matches)
+	expected := []string{fileName + ".0.gz", fileName + ".1.gz"}
+	if len(matches) != len(expected) || matches[0] != expected[0] || matches[1] != expected[1] {
+		t.Logf("expected %v, got %v", expected, matches)
+		t.FailNow()
+	}
+
+	f, err := os.Open(expected[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "fourth\n" {
+		t.Logf("expected the newest rolled file to contain %q, got %q", "fourth\n", content)
+		t.Fail()
+	}
+}
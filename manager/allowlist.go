@@ -0,0 +1,129 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package manager
+
+import (
+	"bufio"
+	"os"
+	"os/signal"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/google/cups-connector/lib"
+	"github.com/google/cups-connector/log"
+)
+
+// printerAllowlist limits the CUPS printers that are shared to those listed
+// in a file. Each line of the file is a printer name, or a regular expression
+// matching the whole printer name. Blank lines and lines starting with # are
+// ignored.
+type printerAllowlist struct {
+	filename string
+
+	mutex    sync.RWMutex
+	names    map[string]struct{}
+	patterns []*regexp.Regexp
+}
+
+func newPrinterAllowlist(filename string) (*printerAllowlist, error) {
+	a := printerAllowlist{filename: filename}
+	if err := a.reload(); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// reload reads the allowlist file. On failure, the previous allowlist is kept.
+func (a *printerAllowlist) reload() error {
+	f, err := os.Open(a.filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	names := make(map[string]struct{})
+	var patterns []*regexp.Regexp
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		names[line] = struct{}{}
+		if re, err := regexp.Compile("^(?:" + line + ")$"); err == nil {
+			patterns = append(patterns, re)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.names, a.patterns = names, patterns
+	return nil
+}
+
+// reloadPeriodically rereads the allowlist file every interval, and when
+// SIGHUP is received, until quit is closed.
+func (a *printerAllowlist) reloadPeriodically(interval time.Duration, quit <-chan struct{}) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	go func() {
+		defer signal.Stop(hup)
+
+		t := time.NewTicker(interval)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-t.C:
+			case <-hup:
+				log.Info("Received SIGHUP; reading printer allowlist")
+			case <-quit:
+				return
+			}
+			if err := a.reload(); err != nil {
+				log.Errorf("Failed to read printer allowlist %s: %s", a.filename, err)
+			}
+		}
+	}()
+}
+
+func (a *printerAllowlist) allows(name string) bool {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	if _, exists := a.names[name]; exists {
+		return true
+	}
+	for _, re := range a.patterns {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// filter returns the printers that are allowed.
+func (a *printerAllowlist) filter(printers []lib.Printer) []lib.Printer {
+	allowed := make([]lib.Printer, 0, len(printers))
+	for i := range printers {
+		if a.allows(printers[i].Name) {
+			allowed = append(allowed, printers[i])
+		}
+	}
+	return allowed
+}
@@ -0,0 +1,169 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/cups-connector/cdd"
+	"github.com/google/cups-connector/lib"
+	"github.com/google/cups-connector/log"
+)
+
+const (
+	capabilityColor  = "color"
+	capabilityDuplex = "duplex"
+
+	// Removes a capability from the printer description.
+	overrideRemove = "remove"
+	// Values that color can be pinned to.
+	overrideColor      = "color"
+	overrideMonochrome = "monochrome"
+)
+
+// capabilityOverride is the override of each capability of one printer; an
+// empty string means no override.
+type capabilityOverride struct {
+	color  string
+	duplex string
+}
+
+// capabilityOverrides removes or pins the capabilities of printers, by printer
+// name.
+type capabilityOverrides map[string]capabilityOverride
+
+// newCapabilityOverrides parses overrides, which map printer name to
+// capability (color or duplex) to value. Every capability can be "remove";
+// color can be pinned to "color" or "monochrome", and duplex to "no_duplex",
+// "long_edge" or "short_edge".
+func newCapabilityOverrides(overrides map[string]map[string]string) (capabilityOverrides, error) {
+	cos := make(capabilityOverrides, len(overrides))
+	for printerName, capabilities := range overrides {
+		var co capabilityOverride
+		for capability, value := range capabilities {
+			value = strings.ToLower(value)
+			switch capability {
+			case capabilityColor:
+				if value != overrideRemove && value != overrideColor && value != overrideMonochrome {
+					return nil, fmt.Errorf("Color of printer %s cannot be overridden with %s", printerName, value)
+				}
+				co.color = value
+			case capabilityDuplex:
+				duplex := cdd.DuplexType(strings.ToUpper(value))
+				if value != overrideRemove && duplex != cdd.DuplexNoDuplex &&
+					duplex != cdd.DuplexLongEdge && duplex != cdd.DuplexShortEdge {
+					return nil, fmt.Errorf("Duplex of printer %s cannot be overridden with %s", printerName, value)
+				}
+				co.duplex = value
+			default:
+				return nil, fmt.Errorf("Capability %s of printer %s cannot be overridden", capability, printerName)
+			}
+		}
+		cos[printerName] = co
+	}
+	return cos, nil
+}
+
+func colorTypeIsMonochrome(t cdd.ColorType) bool {
+	return t == cdd.ColorTypeStandardMonochrome || t == cdd.ColorTypeCustomMonochrome
+}
+
+// apply overrides the capabilities of each printer. The description and its
+// capabilities are replaced, not changed in place, because they may be shared
+// with other printers.
+func (cos capabilityOverrides) apply(printers []lib.Printer) {
+	for i := range printers {
+		co, exists := cos[printers[i].Name]
+		if !exists || printers[i].Description == nil {
+			continue
+		}
+		description := *printers[i].Description
+		printers[i].Description = &description
+
+		switch co.color {
+		case "":
+		case overrideRemove:
+			description.Color = nil
+		default:
+			if description.Color == nil {
+				break
+			}
+			color := cdd.Color{}
+			hasDefault := false
+			for _, option := range description.Color.Option {
+				if colorTypeIsMonochrome(option.Type) == (co.color == overrideMonochrome) {
+					color.Option = append(color.Option, option)
+					hasDefault = hasDefault || option.IsDefault
+				}
+			}
+			if len(color.Option) == 0 {
+				log.WarningPrinterf(printers[i].Name, "Cannot pin color to %s, which the printer doesn't support", co.color)
+				break
+			}
+			if !hasDefault {
+				color.Option[0].IsDefault = true
+			}
+			description.Color = &color
+		}
+
+		switch co.duplex {
+		case "":
+		case overrideRemove:
+			description.Duplex = nil
+		default:
+			if description.Duplex == nil {
+				break
+			}
+			duplex := cdd.Duplex{}
+			for _, option := range description.Duplex.Option {
+				if option.Type == cdd.DuplexType(strings.ToUpper(co.duplex)) {
+					option.IsDefault = true
+					duplex.Option = append(duplex.Option, option)
+					break
+				}
+			}
+			if len(duplex.Option) == 0 {
+				log.WarningPrinterf(printers[i].Name, "Cannot pin duplex to %s, which the printer doesn't support", co.duplex)
+			} else {
+				description.Duplex = &duplex
+			}
+		}
+	}
+}
+
+// checkTicket returns an error when the ticket asks for a capability that is
+// removed, or for a value other than the pinned one.
+func (cos capabilityOverrides) checkTicket(printerName string, ticket *cdd.CloudJobTicket) error {
+	co, exists := cos[printerName]
+	if !exists || ticket == nil {
+		return nil
+	}
+
+	if color := ticket.Print.Color; color != nil && co.color != "" {
+		if co.color == overrideRemove {
+			return errors.New("Color is not allowed on this printer")
+		}
+		if colorTypeIsMonochrome(color.Type) != (co.color == overrideMonochrome) {
+			return fmt.Errorf("Only %s printing is allowed on this printer", co.color)
+		}
+	}
+
+	if duplex := ticket.Print.Duplex; duplex != nil && co.duplex != "" {
+		if co.duplex == overrideRemove {
+			return errors.New("Duplex is not allowed on this printer")
+		}
+		if duplex.Type != cdd.DuplexType(strings.ToUpper(co.duplex)) {
+			return fmt.Errorf("Only duplex %s is allowed on this printer", co.duplex)
+		}
+	}
+
+	return nil
+}
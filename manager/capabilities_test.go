@@ -0,0 +1,144 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package manager
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/cups-connector/cdd"
+	"github.com/google/cups-connector/lib"
+)
+
+func TestNewCapabilityOverrides(t *testing.T) {
+	valid := map[string]map[string]string{
+		"lobby":  {"color": "Monochrome", "duplex": "long_edge"},
+		"office": {"color": "remove", "duplex": "remove"},
+	}
+	cos, err := newCapabilityOverrides(valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := capabilityOverrides{
+		"lobby":  {color: "monochrome", duplex: "long_edge"},
+		"office": {color: "remove", duplex: "remove"},
+	}
+	if !reflect.DeepEqual(cos, expected) {
+		t.Logf("expected %v, got %v", expected, cos)
+		t.Fail()
+	}
+
+	for _, invalid := range []map[string]string{
+		{"color": "sepia"},
+		{"duplex": "both_edges"},
+		{"staple": "remove"},
+	} {
+		if _, err := newCapabilityOverrides(map[string]map[string]string{"lobby": invalid}); err == nil {
+			t.Logf("expected an error for %v", invalid)
+			t.Fail()
+		}
+	}
+}
+
+func testDescription() *cdd.PrinterDescriptionSection {
+	return &cdd.PrinterDescriptionSection{
+		Color: &cdd.Color{Option: []cdd.ColorOption{
+			{VendorID: "RGB", Type: cdd.ColorTypeStandardColor, IsDefault: true},
+			{VendorID: "Gray", Type: cdd.ColorTypeStandardMonochrome},
+		}},
+		Duplex: &cdd.Duplex{Option: []cdd.DuplexOption{
+			{Type: cdd.DuplexNoDuplex, IsDefault: true},
+			{Type: cdd.DuplexLongEdge},
+		}},
+	}
+}
+
+func TestCapabilityOverridesApply(t *testing.T) {
+	cos, err := newCapabilityOverrides(map[string]map[string]string{
+		"lobby":  {"color": "monochrome", "duplex": "long_edge"},
+		"office": {"color": "remove", "duplex": "remove"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The printers share a description, which must not change.
+	shared := testDescription()
+	printers := []lib.Printer{
+		{Name: "lobby", Description: shared},
+		{Name: "office", Description: shared},
+		{Name: "other", Description: shared},
+	}
+	cos.apply(printers)
+
+	if !reflect.DeepEqual(shared, testDescription()) {
+		t.Logf("expected the shared description to be unchanged, got %+v", shared)
+		t.Fail()
+	}
+
+	lobby := printers[0].Description
+	expectedColor := &cdd.Color{Option: []cdd.ColorOption{
+		{VendorID: "Gray", Type: cdd.ColorTypeStandardMonochrome, IsDefault: true},
+	}}
+	if !reflect.DeepEqual(lobby.Color, expectedColor) {
+		t.Logf("expected lobby color %+v, got %+v", expectedColor, lobby.Color)
+		t.Fail()
+	}
+	expectedDuplex := &cdd.Duplex{Option: []cdd.DuplexOption{{Type: cdd.DuplexLongEdge, IsDefault: true}}}
+	if !reflect.DeepEqual(lobby.Duplex, expectedDuplex) {
+		t.Logf("expected lobby duplex %+v, got %+v", expectedDuplex, lobby.Duplex)
+		t.Fail()
+	}
+
+	if office := printers[1].Description; office.Color != nil || office.Duplex != nil {
+		t.Logf("expected office color and duplex to be removed, got %+v", office)
+		t.Fail()
+	}
+
+	if printers[2].Description != shared {
+		t.Logf("expected other printer to keep its description")
+		t.Fail()
+	}
+}
+
+func TestCapabilityOverridesCheckTicket(t *testing.T) {
+	cos, err := newCapabilityOverrides(map[string]map[string]string{
+		"lobby":  {"color": "monochrome", "duplex": "no_duplex"},
+		"office": {"color": "remove", "duplex": "remove"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mono := &cdd.ColorTicketItem{Type: cdd.ColorTypeStandardMonochrome}
+	color := &cdd.ColorTicketItem{Type: cdd.ColorTypeStandardColor}
+	simplex := &cdd.DuplexTicketItem{Type: cdd.DuplexNoDuplex}
+	duplex := &cdd.DuplexTicketItem{Type: cdd.DuplexLongEdge}
+
+	testCases := []struct {
+		printer string
+		print   cdd.PrintTicketSection
+		allowed bool
+	}{
+		{"lobby", cdd.PrintTicketSection{Color: mono, Duplex: simplex}, true},
+		{"lobby", cdd.PrintTicketSection{Color: color}, false},
+		{"lobby", cdd.PrintTicketSection{Duplex: duplex}, false},
+		{"lobby", cdd.PrintTicketSection{}, true},
+		{"office", cdd.PrintTicketSection{Color: mono}, false},
+		{"office", cdd.PrintTicketSection{Duplex: simplex}, false},
+		{"other", cdd.PrintTicketSection{Color: color, Duplex: duplex}, true},
+	}
+	for _, tc := range testCases {
+		err := cos.checkTicket(tc.printer, &cdd.CloudJobTicket{Print: tc.print})
+		if (err == nil) != tc.allowed {
+			t.Logf("expected %s ticket %+v allowed %t, got error %v", tc.printer, tc.print, tc.allowed, err)
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package manager
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/cups-connector/cdd"
+	"github.com/google/cups-connector/lib"
+	"github.com/google/cups-connector/log"
+)
+
+// pollCloudJobsPeriodically fetches the queued jobs of every GCP printer
+// every interval, to print jobs whose XMPP notification was missed. Jobs that
+// are already being printed are skipped by handleJobs.
+func (pm *PrinterManager) pollCloudJobsPeriodically(interval time.Duration) {
+	go func() {
+		t := time.NewTimer(interval)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-t.C:
+				if pm.CloudPaused() {
+					log.Debug("Cloud operations are paused; not polling for GCP jobs")
+				} else {
+					pm.pollCloudJobs()
+				}
+				t.Reset(interval)
+
+			case <-pm.quit:
+				return
+			}
+		}
+	}()
+}
+
+func (pm *PrinterManager) pollCloudJobs() {
+	printers := pm.printers.GetAll()
+	log.Debugf("Polling %d GCP printers for jobs", len(printers))
+	for i := range printers {
+		if printers[i].GCPID == "" {
+			continue
+		}
+		if !pm.cloudJobPolls.start(printers[i].GCPID) {
+			log.DebugPrinterf(printers[i].Name, "Still polling for GCP jobs since the last interval")
+			continue
+		}
+		go func(p lib.Printer) {
+			defer pm.cloudJobPolls.done(p.GCPID)
+			pm.handleJobs(p)
+		}(printers[i])
+	}
+}
+
+// handleJobs gets and processes the GCP jobs waiting on a printer. Jobs that
+// are already being printed, or were already submitted to CUPS, are skipped
+// before they are downloaded again.
+func (pm *PrinterManager) handleJobs(p lib.Printer) {
+	pm.gcp.HandleJobs(pm.ctx, &p, func() { pm.incrementJobsProcessed(p.Name, jobStatusError) }, pm.skipCloudJob)
+}
+
+// skipCloudJob checks whether a GCP job is being printed, or was already
+// submitted to CUPS, like when GCP delivers it again after a network retry.
+// Submitted jobs are reported done, so that they aren't delivered yet again,
+// instead of being printed twice.
+func (pm *PrinterManager) skipCloudJob(jobID string) bool {
+	if pm.jobInFlight(jobID) {
+		return true
+	}
+	if pm.jobDeduper == nil || !pm.jobDeduper.wasSubmitted(jobID) {
+		return false
+	}
+
+	log.InfoJobf(jobID, "Already submitted to CUPS; reporting it done instead of printing it again")
+	state := cdd.PrintJobStateDiff{State: &cdd.JobState{Type: cdd.JobStateDone}}
+	if err := pm.gcp.Control(pm.ctx, jobID, state); err != nil {
+		log.ErrorJob(jobID, err)
+	}
+	return true
+}
+
+// cloudJobPolls holds the GCP IDs of the printers whose jobs pollCloudJobs
+// is fetching, so that a printer whose fetch is slow isn't polled again
+// until it is done. The zero value is ready to use.
+type cloudJobPolls struct {
+	m       sync.Mutex
+	polling map[string]struct{}
+}
+
+// start marks the printer as being polled. Returns false when it already is.
+func (c *cloudJobPolls) start(gcpID string) bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if _, exists := c.polling[gcpID]; exists {
+		return false
+	}
+	if c.polling == nil {
+		c.polling = make(map[string]struct{})
+	}
+	c.polling[gcpID] = struct{}{}
+	return true
+}
+
+func (c *cloudJobPolls) done(gcpID string) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	delete(c.polling, gcpID)
+}
@@ -0,0 +1,51 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package manager
+
+import "testing"
+
+func TestCloudJobPolls(t *testing.T) {
+	var polls cloudJobPolls
+	if !polls.start("lobby") {
+		t.Log("expected the first poll of lobby to start")
+		t.Fail()
+	}
+	if polls.start("lobby") {
+		t.Log("expected a second poll of lobby to be skipped while the first runs")
+		t.Fail()
+	}
+	if !polls.start("office") {
+		t.Log("expected a poll of office to start while lobby is polled")
+		t.Fail()
+	}
+
+	polls.done("lobby")
+	if !polls.start("lobby") {
+		t.Log("expected a poll of lobby to start once the last one is done")
+		t.Fail()
+	}
+}
+
+func TestJobInFlight(t *testing.T) {
+	pm := PrinterManager{jobsInFlight: make(map[string]struct{})}
+	if pm.jobInFlight("1") {
+		t.Log("expected job 1 to not be in flight")
+		t.Fail()
+	}
+	pm.addInFlightJob("1")
+	if !pm.jobInFlight("1") {
+		t.Log("expected job 1 to be in flight")
+		t.Fail()
+	}
+	pm.deleteInFlightJob("1")
+	if pm.jobInFlight("1") {
+		t.Log("expected job 1 to not be in flight once deleted")
+		t.Fail()
+	}
+}
@@ -0,0 +1,77 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package manager
+
+import (
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/google/cups-connector/log"
+)
+
+// PauseCloud stops GCP operations, like during a GCP outage, until
+// ResumeCloud is called. Local printing continues.
+func (pm *PrinterManager) PauseCloud() {
+	if atomic.CompareAndSwapUint32(&pm.cloudPaused, 0, 1) {
+		log.Warning("Paused cloud operations; local printing continues")
+	}
+}
+
+// ResumeCloud restarts GCP operations, then reconciles the printers and
+// fetches the jobs that changed while paused.
+func (pm *PrinterManager) ResumeCloud() {
+	if !atomic.CompareAndSwapUint32(&pm.cloudPaused, 1, 0) {
+		return
+	}
+	log.Info("Resumed cloud operations")
+
+	queuedJobsCount, err := pm.refreshGCPPrinters()
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	for gcpPrinterID := range queuedJobsCount {
+		if p, exists := pm.printers.GetByGCPID(gcpPrinterID); exists {
+			go pm.handleJobs(p)
+		}
+	}
+}
+
+// CloudPaused is true between PauseCloud and ResumeCloud.
+func (pm *PrinterManager) CloudPaused() bool {
+	return atomic.LoadUint32(&pm.cloudPaused) == 1
+}
+
+// handleCloudPauseSignals pauses cloud operations on SIGUSR1 and resumes them
+// on SIGUSR2, until pm.quit is closed.
+func (pm *PrinterManager) handleCloudPauseSignals() {
+	usr := make(chan os.Signal, 1)
+	signal.Notify(usr, syscall.SIGUSR1, syscall.SIGUSR2)
+
+	go func() {
+		defer signal.Stop(usr)
+
+		for {
+			select {
+			case s := <-usr:
+				if s == syscall.SIGUSR1 {
+					log.Info("Received SIGUSR1; pausing cloud operations")
+					pm.PauseCloud()
+				} else {
+					log.Info("Received SIGUSR2; resuming cloud operations")
+					pm.ResumeCloud()
+				}
+			case <-pm.quit:
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,62 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package manager
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/cups-connector/lib"
+	"github.com/google/cups-connector/log"
+)
+
+// customTags are the custom tags of printers, by printer name, then by tag.
+type customTags map[string]map[string]string
+
+// newCustomTags parses printerTags, which are keyed by exact printer name,
+// then by tag.
+func newCustomTags(printerTags map[string]map[string]string) (customTags, error) {
+	ct := make(customTags, len(printerTags))
+	for name, printerTags := range printerTags {
+		tags := make(map[string]string, len(printerTags))
+		for tag, value := range printerTags {
+			tag = strings.ToLower(strings.TrimSpace(tag))
+			if tag == "" {
+				return nil, fmt.Errorf("Custom tags of %s include an empty tag", name)
+			}
+			if _, exists := lib.ReservedTags[tag]; exists {
+				return nil, fmt.Errorf("Custom tag %s of %s is reserved", tag, name)
+			}
+			tags[tag] = strings.TrimSpace(value)
+		}
+		ct[name] = tags
+	}
+	return ct, nil
+}
+
+// apply merges custom tags into the tags of each printer. Tags that are
+// already set, eg from CUPS attributes, are kept.
+func (ct customTags) apply(printers []lib.Printer) {
+	for i := range printers {
+		tags, exists := ct[printers[i].Name]
+		if !exists {
+			continue
+		}
+		if printers[i].Tags == nil {
+			printers[i].Tags = make(map[string]string, len(tags))
+		}
+		for tag, value := range tags {
+			if _, exists := printers[i].Tags[tag]; exists {
+				log.WarningPrinterf(printers[i].Name, "Custom tag %s is already set by CUPS; ignoring it", tag)
+				continue
+			}
+			printers[i].Tags[tag] = value
+		}
+	}
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package manager
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/cups-connector/lib"
+)
+
+func TestNewCustomTags(t *testing.T) {
+	ct, err := newCustomTags(map[string]map[string]string{
+		"lobby": {" Building ": " North ", "department": "Sales"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := customTags{"lobby": {"building": "North", "department": "Sales"}}
+	if !reflect.DeepEqual(ct, expected) {
+		t.Logf("expected %v, got %v", expected, ct)
+		t.Fail()
+	}
+
+	for _, invalid := range []map[string]string{
+		{" ": "North"},
+		{"tagshash": "0"},
+		{"Connection-Type": "usb"},
+	} {
+		if _, err := newCustomTags(map[string]map[string]string{"lobby": invalid}); err == nil {
+			t.Logf("expected an error for %v", invalid)
+			t.Fail()
+		}
+	}
+}
+
+func TestCustomTagsApply(t *testing.T) {
+	ct, err := newCustomTags(map[string]map[string]string{
+		"lobby":    {"building": "North", "printer-location": "Custom"},
+		"office.*": {"building": "South"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	printers := []lib.Printer{
+		{Name: "lobby", Tags: map[string]string{"printer-location": "Lobby"}},
+		{Name: "office1", Tags: map[string]string{}},
+		{Name: "office.*"},
+	}
+	ct.apply(printers)
+
+	expected := []map[string]string{
+		{"building": "North", "printer-location": "Lobby"},
+		{},
+		{"building": "South"},
+	}
+	for i := range printers {
+		if !reflect.DeepEqual(printers[i].Tags, expected[i]) {
+			t.Logf("expected %s tags %v, got %v", printers[i].Name, expected[i], printers[i].Tags)
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,67 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package manager
+
+import (
+	"github.com/google/cups-connector/lib"
+	"github.com/google/cups-connector/log"
+)
+
+// disabledPrinters keeps CUPS printers out of GCP, like for maintenance,
+// without changing them in CUPS. Unlike the allowlist, which filters printers
+// quietly, each printer being disabled or enabled is logged.
+type disabledPrinters struct {
+	names map[string]struct{}
+
+	// Printers that were disabled during the last sync, so that each is
+	// logged once.
+	disabled map[string]struct{}
+}
+
+// newDisabledPrinters disables the printers named exactly in printers.
+func newDisabledPrinters(printers []string) *disabledPrinters {
+	d := disabledPrinters{
+		names:    make(map[string]struct{}, len(printers)),
+		disabled: make(map[string]struct{}),
+	}
+	for _, p := range printers {
+		d.names[p] = struct{}{}
+	}
+	return &d
+}
+
+func (d *disabledPrinters) matches(name string) bool {
+	_, exists := d.names[name]
+	return exists
+}
+
+// filter returns the printers that aren't disabled, so that the disabled
+// ones are deleted from GCP, and registered again once they are enabled.
+func (d *disabledPrinters) filter(printers []lib.Printer) []lib.Printer {
+	enabled := make([]lib.Printer, 0, len(printers))
+	disabled := make(map[string]struct{})
+	for i := range printers {
+		name := printers[i].Name
+		if !d.matches(name) {
+			enabled = append(enabled, printers[i])
+			continue
+		}
+		disabled[name] = struct{}{}
+		if _, exists := d.disabled[name]; !exists {
+			log.InfoPrinter(name, "Disabled by disabled_printers; keeping it out of GCP until it is enabled")
+		}
+	}
+	for name := range d.disabled {
+		if _, exists := disabled[name]; !exists {
+			log.InfoPrinter(name, "No longer in disabled_printers; registering it in GCP again")
+		}
+	}
+	d.disabled = disabled
+	return enabled
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package manager
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/cups-connector/lib"
+	"github.com/google/cups-connector/log"
+)
+
+func printerNames(printers []lib.Printer) []string {
+	names := make([]string, len(printers))
+	for i := range printers {
+		names[i] = printers[i].Name
+	}
+	return names
+}
+
+func TestDisabledPrintersExactNames(t *testing.T) {
+	d := newDisabledPrinters([]string{"lobby", "office.*"})
+	printers := []lib.Printer{{Name: "lobby"}, {Name: "lobby2"}, {Name: "office1"}, {Name: "office.*"}}
+
+	enabled := printerNames(d.filter(printers))
+	if strings.Join(enabled, ",") != "lobby2,office1" {
+		t.Logf("expected lobby2,office1 to be enabled, got %v", enabled)
+		t.Fail()
+	}
+}
+
+func TestDisabledPrintersLogging(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetWriter(&buf)
+	defer log.SetWriter(os.Stderr)
+
+	d := newDisabledPrinters([]string{"lobby"})
+	printers := []lib.Printer{{Name: "lobby"}, {Name: "office"}}
+
+	d.filter(printers)
+	if strings.Count(buf.String(), "Disabled by disabled_printers") != 1 {
+		t.Logf("expected lobby to be logged as disabled, got %q", buf.String())
+		t.Fail()
+	}
+
+	buf.Reset()
+	d.filter(printers)
+	if buf.Len() != 0 {
+		t.Logf("expected a printer still disabled not to be logged again, got %q", buf.String())
+		t.Fail()
+	}
+
+	buf.Reset()
+	d.names = map[string]struct{}{}
+	d.filter(printers)
+	if !strings.Contains(buf.String(), "No longer in disabled_printers") {
+		t.Logf("expected lobby to be logged as enabled, got %q", buf.String())
+		t.Fail()
+	}
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package manager
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/cups-connector/log"
+)
+
+// The IDs of submitted jobs are kept in this file, in temp_dir.
+const submittedJobsFilename = "cups-connector-submitted-jobs.json"
+
+// jobDeduper remembers the IDs of the jobs submitted to CUPS for ttl, so that
+// a job that GCP delivers again, like after a network retry, isn't printed
+// twice. The IDs are saved to a file, so that they outlive a restart.
+type jobDeduper struct {
+	m        sync.Mutex
+	ttl      time.Duration
+	filename string
+	// Key is job ID, value is when the job was submitted.
+	submitted map[string]time.Time
+}
+
+// newJobDeduper loads the job IDs saved in dir that are younger than ttl. An
+// empty dir means the system temporary directory, like for the job files.
+func newJobDeduper(dir string, ttl time.Duration) *jobDeduper {
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	d := jobDeduper{
+		ttl:       ttl,
+		filename:  filepath.Join(dir, submittedJobsFilename),
+		submitted: make(map[string]time.Time),
+	}
+
+	b, err := ioutil.ReadFile(d.filename)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warningf("Failed to read submitted job IDs: %s", err)
+		}
+		return &d
+	}
+	if err = json.Unmarshal(b, &d.submitted); err != nil {
+		log.Warningf("Failed to read submitted job IDs from %s: %s", d.filename, err)
+		d.submitted = make(map[string]time.Time)
+	}
+	d.prune()
+	log.Debugf("Loaded %d submitted job IDs from %s", len(d.submitted), d.filename)
+
+	return &d
+}
+
+// wasSubmitted reports whether the job was submitted to CUPS within ttl.
+func (d *jobDeduper) wasSubmitted(jobID string) bool {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	t, exists := d.submitted[jobID]
+	return exists && time.Since(t) <= d.ttl
+}
+
+// add remembers that the job was submitted to CUPS, and saves the IDs.
+func (d *jobDeduper) add(jobID string) {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	d.submitted[jobID] = time.Now()
+	d.prune()
+
+	b, err := json.Marshal(d.submitted)
+	if err != nil {
+		log.Warningf("Failed to save submitted job IDs: %s", err)
+		return
+	}
+	// Write then rename, so that a crash doesn't leave half a file.
+	tmp := d.filename + ".tmp"
+	if err = ioutil.WriteFile(tmp, b, 0600); err == nil {
+		err = os.Rename(tmp, d.filename)
+	}
+	if err != nil {
+		log.Warningf("Failed to save submitted job IDs to %s: %s", d.filename, err)
+	}
+}
+
+// prune forgets the IDs older than ttl. The caller must hold d.m, except in
+// newJobDeduper.
+func (d *jobDeduper) prune() {
+	for jobID, t := range d.submitted {
+		if time.Since(t) > d.ttl {
+			delete(d.submitted, jobID)
+		}
+	}
+}
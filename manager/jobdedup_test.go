@@ -0,0 +1,102 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package manager
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestJobDeduper(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jobdedup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	d := newJobDeduper(dir, time.Hour)
+	if d.wasSubmitted("1") {
+		t.Log("expected job 1 to not be submitted yet")
+		t.Fail()
+	}
+	d.add("1")
+	if !d.wasSubmitted("1") {
+		t.Log("expected job 1 to be submitted")
+		t.Fail()
+	}
+
+	// Saved by writing a temporary file, then renaming it.
+	if _, err := os.Stat(filepath.Join(dir, submittedJobsFilename)); err != nil {
+		t.Logf("expected the submitted jobs file, got %s", err)
+		t.Fail()
+	}
+	if _, err := os.Stat(filepath.Join(dir, submittedJobsFilename+".tmp")); !os.IsNotExist(err) {
+		t.Logf("expected no temporary file to be left, got %v", err)
+		t.Fail()
+	}
+
+	// Loaded again after a restart.
+	d = newJobDeduper(dir, time.Hour)
+	if !d.wasSubmitted("1") {
+		t.Log("expected job 1 to be submitted after loading")
+		t.Fail()
+	}
+
+	// Expired after the TTL, and pruned by the next add.
+	d.submitted["1"] = time.Now().Add(-2 * time.Hour)
+	if d.wasSubmitted("1") {
+		t.Log("expected job 1 to expire after the TTL")
+		t.Fail()
+	}
+	d.add("2")
+	if _, exists := d.submitted["1"]; exists {
+		t.Log("expected job 1 to be pruned")
+		t.Fail()
+	}
+
+	// Not loaded once expired.
+	b, _ := json.Marshal(map[string]time.Time{"3": time.Now().Add(-2 * time.Hour), "4": time.Now()})
+	if err = ioutil.WriteFile(filepath.Join(dir, submittedJobsFilename), b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	d = newJobDeduper(dir, time.Hour)
+	if len(d.submitted) != 1 || !d.wasSubmitted("4") {
+		t.Logf("expected only job 4 to be loaded, got %v", d.submitted)
+		t.Fail()
+	}
+}
+
+func TestSkipCloudJob(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jobdedup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pm := PrinterManager{jobsInFlight: make(map[string]struct{})}
+	pm.addInFlightJob("1")
+	if !pm.skipCloudJob("1") {
+		t.Log("expected job 1 to be skipped while in flight")
+		t.Fail()
+	}
+	if pm.skipCloudJob("2") {
+		t.Log("expected job 2 to not be skipped without deduplication")
+		t.Fail()
+	}
+
+	pm.jobDeduper = newJobDeduper(dir, time.Hour)
+	if pm.skipCloudJob("2") {
+		t.Log("expected job 2 to not be skipped before it was submitted")
+		t.Fail()
+	}
+}
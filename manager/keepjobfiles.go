@@ -0,0 +1,107 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package manager
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/cups-connector/log"
+)
+
+// Kept job files are named with this prefix, then the job ID.
+const keptJobFilePrefix = "cups-connector-kept-job-"
+
+// jobFileKeeper keeps the files of printed jobs in the temp dir, instead of
+// removing them, so that jobs that print wrong can be looked into. Only the
+// newest files are kept, up to a quantity and age.
+type jobFileKeeper struct {
+	m      sync.Mutex
+	max    uint
+	maxAge time.Duration
+}
+
+// newJobFileKeeper removes the kept files in dir that are too many or too
+// old already, like after a restart with a stricter retention. An empty dir
+// means the system temporary directory, like for the job files themselves.
+func newJobFileKeeper(dir string, max uint, maxAge time.Duration) *jobFileKeeper {
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	k := jobFileKeeper{max: max, maxAge: maxAge}
+	k.prune(dir)
+	return &k
+}
+
+// keep renames the file of a finished job, then removes the kept files that
+// are past retention. The file is removed when it can't be kept.
+func (k *jobFileKeeper) keep(jobID, filename string) {
+	dir := filepath.Dir(filename)
+	kept := filepath.Join(dir, keptJobFilePrefix+strings.Replace(jobID, string(os.PathSeparator), "_", -1))
+
+	k.m.Lock()
+	defer k.m.Unlock()
+
+	if err := os.Rename(filename, kept); err != nil {
+		log.WarningJobf(jobID, "Failed to keep job file: %s", err)
+		os.Remove(filename)
+		return
+	}
+	// Age counts from when the job finished, not from the download.
+	now := time.Now()
+	os.Chtimes(kept, now, now)
+	log.InfoJobf(jobID, "Kept job file %s", kept)
+
+	k.prune(dir)
+}
+
+// prune removes the kept files in dir beyond the newest max, and those older
+// than maxAge. The caller must hold k.m, except in newJobFileKeeper.
+func (k *jobFileKeeper) prune(dir string) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		log.Warningf("Failed to list kept job files: %s", err)
+		return
+	}
+
+	var kept []os.FileInfo
+	for _, info := range infos {
+		if strings.HasPrefix(info.Name(), keptJobFilePrefix) && info.Mode().IsRegular() {
+			kept = append(kept, info)
+		}
+	}
+	sort.Slice(kept, func(i, j int) bool { return kept[i].ModTime().After(kept[j].ModTime()) })
+
+	for i, info := range kept {
+		if uint(i) < k.max && (k.maxAge <= 0 || time.Since(info.ModTime()) <= k.maxAge) {
+			continue
+		}
+		filename := filepath.Join(dir, info.Name())
+		if err := os.Remove(filename); err != nil {
+			log.Warningf("Failed to remove kept job file %s: %s", filename, err)
+		} else {
+			log.Debugf("Removed kept job file %s", filename)
+		}
+	}
+}
+
+// removeJobFile removes the file of a finished job, or keeps it when
+// keep_job_files is set.
+func (pm *PrinterManager) removeJobFile(jobID, filename string) {
+	if pm.jobFileKeeper == nil {
+		os.Remove(filename)
+		return
+	}
+	pm.jobFileKeeper.keep(jobID, filename)
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package manager
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeKeptJobFile writes a kept job file, modified age ago.
+func writeKeptJobFile(t *testing.T, dir, jobID string, age time.Duration) {
+	filename := filepath.Join(dir, keptJobFilePrefix+jobID)
+	if err := ioutil.WriteFile(filename, []byte(jobID), 0600); err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Now().Add(-age)
+	if err := os.Chtimes(filename, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// listDir lists the names of the files in dir, sorted.
+func listDir(t *testing.T, dir string) string {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, len(infos))
+	for i, info := range infos {
+		names[i] = strings.TrimPrefix(info.Name(), keptJobFilePrefix)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+func TestJobFileKeeperPrunesByCount(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keepjobfiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeKeptJobFile(t, dir, "a", 4*time.Minute)
+	writeKeptJobFile(t, dir, "b", 3*time.Minute)
+	writeKeptJobFile(t, dir, "c", 2*time.Minute)
+	if err = ioutil.WriteFile(filepath.Join(dir, "other"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Starting up prunes files kept before, beyond the newest two.
+	k := newJobFileKeeper(dir, 2, 0)
+	if files := listDir(t, dir); files != "b,c,other" {
+		t.Logf("expected b,c,other after starting, got %s", files)
+		t.Fail()
+	}
+
+	// Keeping a new file prunes the oldest.
+	filename := filepath.Join(dir, "download")
+	if err = ioutil.WriteFile(filename, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	k.keep("d", filename)
+	if files := listDir(t, dir); files != "c,d,other" {
+		t.Logf("expected c,d,other after keeping d, got %s", files)
+		t.Fail()
+	}
+}
+
+func TestJobFileKeeperPrunesByAge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keepjobfiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeKeptJobFile(t, dir, "old", 2*time.Hour)
+	writeKeptJobFile(t, dir, "new", time.Minute)
+
+	newJobFileKeeper(dir, 10, time.Hour)
+	if files := listDir(t, dir); files != "new" {
+		t.Logf("expected only new to be kept, got %s", files)
+		t.Fail()
+	}
+}
+
+func TestJobFileKeeperKeepsByJobID(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keepjobfiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "download")
+	if err = ioutil.WriteFile(filename, []byte("job"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// The download was long ago, but the age counts from when it's kept.
+	long := time.Now().Add(-2 * time.Hour)
+	os.Chtimes(filename, long, long)
+
+	k := newJobFileKeeper(dir, 10, time.Hour)
+	k.keep("../job", filename)
+	if files := listDir(t, dir); files != ".._job" {
+		t.Logf("expected the file to be kept as .._job, got %s", files)
+		t.Fail()
+	}
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package manager
+
+import (
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/google/cups-connector/lib"
+	"github.com/google/cups-connector/log"
+)
+
+// capRegistrations drops the registrations that would put more than
+// max_registered_printers printers in GCP, keeping the first ones by name so
+// that the same printers are registered from one sync to the next. The
+// skipped printers are logged once, each time they change.
+func (pm *PrinterManager) capRegistrations(diffs []lib.PrinterDiff) []lib.PrinterDiff {
+	if pm.maxRegisteredPrinters == 0 || pm.gcp == nil {
+		return diffs
+	}
+
+	var registered uint
+	var registrations []lib.PrinterDiff
+	capped := make([]lib.PrinterDiff, 0, len(diffs))
+	for i := range diffs {
+		switch diffs[i].Operation {
+		case lib.RegisterPrinter:
+			registrations = append(registrations, diffs[i])
+			continue
+		case lib.UpdatePrinter, lib.NoChangeToPrinter:
+			registered++
+		}
+		capped = append(capped, diffs[i])
+	}
+	sort.Slice(registrations, func(i, j int) bool { return registrations[i].Printer.Name < registrations[j].Printer.Name })
+
+	var skipped []string
+	for i := range registrations {
+		if registered < pm.maxRegisteredPrinters {
+			capped = append(capped, registrations[i])
+			registered++
+		} else {
+			skipped = append(skipped, registrations[i].Printer.Name)
+		}
+	}
+
+	atomic.StoreUint32(&pm.registrationsSkipped, uint32(len(skipped)))
+	if names := strings.Join(skipped, ", "); names != pm.lastSkippedRegistrations {
+		pm.lastSkippedRegistrations = names
+		if len(skipped) > 0 {
+			log.Warningf("Not registering %d printers, since max_registered_printers %d are registered: %s",
+				len(skipped), pm.maxRegisteredPrinters, names)
+		}
+	}
+
+	return capped
+}
+
+// RegistrationsSkipped gets the quantity of printers that the last sync
+// didn't register, because of max_registered_printers.
+func (pm *PrinterManager) RegistrationsSkipped() uint32 {
+	return atomic.LoadUint32(&pm.registrationsSkipped)
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package manager
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/cups-connector/gcp"
+	"github.com/google/cups-connector/lib"
+	"github.com/google/cups-connector/log"
+)
+
+func diffNames(diffs []lib.PrinterDiff, operation lib.PrinterDiffOperation) []string {
+	var names []string
+	for _, diff := range diffs {
+		if diff.Operation == operation {
+			names = append(names, diff.Printer.Name)
+		}
+	}
+	return names
+}
+
+func TestCapRegistrations(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetWriter(&buf)
+	defer log.SetWriter(os.Stderr)
+
+	pm, _, err := newPrinterManager(nil, nil, nil, nil, Options{
+		RawPrinterPolicy:      lib.RawPrinterPolicyRegister,
+		MaxRegisteredPrinters: 3,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Registrations are only capped with GCP, which isn't called.
+	pm.gcp = &gcp.GoogleCloudPrint{}
+
+	// Deleted printers don't count; updated and unchanged ones do.
+	diffs := []lib.PrinterDiff{
+		{Operation: lib.RegisterPrinter, Printer: lib.Printer{Name: "d"}},
+		{Operation: lib.UpdatePrinter, Printer: lib.Printer{Name: "x"}},
+		{Operation: lib.RegisterPrinter, Printer: lib.Printer{Name: "b"}},
+		{Operation: lib.DeletePrinter, Printer: lib.Printer{Name: "y"}},
+		{Operation: lib.NoChangeToPrinter, Printer: lib.Printer{Name: "z"}},
+		{Operation: lib.RegisterPrinter, Printer: lib.Printer{Name: "c"}},
+	}
+	capped := pm.capRegistrations(diffs)
+	if names := diffNames(capped, lib.RegisterPrinter); !reflect.DeepEqual(names, []string{"b"}) {
+		t.Logf("expected to register b, the first by name, got %v", names)
+		t.Fail()
+	}
+	if len(capped) != 4 {
+		t.Logf("expected the other diffs to be kept, got %d diffs", len(capped))
+		t.Fail()
+	}
+	if skipped := pm.RegistrationsSkipped(); skipped != 2 {
+		t.Logf("expected 2 registrations skipped, got %d", skipped)
+		t.Fail()
+	}
+	if warnings := strings.Count(buf.String(), "Not registering"); warnings != 1 {
+		t.Logf("expected one warning, got %d: %s", warnings, buf.String())
+		t.Fail()
+	}
+
+	// The same skipped printers aren't warned about again.
+	pm.capRegistrations(diffs)
+	if warnings := strings.Count(buf.String(), "Not registering"); warnings != 1 {
+		t.Logf("expected no warning for the same skipped printers, got %d", warnings)
+		t.Fail()
+	}
+
+	// A change is.
+	pm.capRegistrations(diffs[1:])
+	if warnings := strings.Count(buf.String(), "Not registering"); warnings != 2 {
+		t.Logf("expected a warning for other skipped printers, got %d", warnings)
+		t.Fail()
+	}
+	if !strings.Contains(buf.String(), "Not registering 1 printers, since max_registered_printers 3 are registered: c\n") {
+		t.Logf("expected only c to be skipped, got %s", buf.String())
+		t.Fail()
+	}
+}
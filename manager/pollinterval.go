@@ -0,0 +1,98 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package manager
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// pollIntervals resolves the poll interval of each printer.
+type pollIntervals struct {
+	defaultInterval time.Duration
+	byName          map[string]time.Duration
+	byPattern       []pollIntervalPattern
+}
+
+type pollIntervalPattern struct {
+	re       *regexp.Regexp
+	interval time.Duration
+}
+
+// newPollIntervals parses overrides, which are keyed by printer name or by
+// regular expression matching the whole printer name.
+func newPollIntervals(defaultInterval time.Duration, overrides map[string]string) (*pollIntervals, error) {
+	pi := pollIntervals{
+		defaultInterval: defaultInterval,
+		byName:          make(map[string]time.Duration, len(overrides)),
+	}
+
+	// Sort keys so that the first matching regular expression is stable.
+	keys := make([]string, 0, len(overrides))
+	for key := range overrides {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := overrides[key]
+		interval, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse poll interval for %s: %s", key, err)
+		}
+		if interval <= 0 {
+			return nil, fmt.Errorf("Poll interval for %s must be positive", key)
+		}
+		pi.byName[key] = interval
+
+		re, err := regexp.Compile("^(?:" + key + ")$")
+		if err != nil {
+			// Not a regular expression; only matches by name.
+			continue
+		}
+		pi.byPattern = append(pi.byPattern, pollIntervalPattern{re, interval})
+	}
+
+	return &pi, nil
+}
+
+// forPrinter returns the poll interval of the named printer. Exact names win
+// over regular expressions.
+func (pi *pollIntervals) forPrinter(name string) time.Duration {
+	if interval, exists := pi.byName[name]; exists {
+		return interval
+	}
+	for _, p := range pi.byPattern {
+		if p.re.MatchString(name) {
+			return p.interval
+		}
+	}
+	return pi.defaultInterval
+}
+
+// nextPollIn returns the wait until the next printer is due for a poll, by
+// its own interval since it was last polled, as of now. A printer that is
+// overdue, since the last sync didn't poll it, is due one interval from now.
+// Without polled printers, the wait is the default interval.
+func (pi *pollIntervals) nextPollIn(lastPolled map[string]time.Time, now time.Time) time.Duration {
+	wait := pi.defaultInterval
+	for name, last := range lastPolled {
+		interval := pi.forPrinter(name)
+		due := last.Add(interval).Sub(now)
+		if due < 0 {
+			due = interval
+		}
+		if due < wait {
+			wait = due
+		}
+	}
+	return wait
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package manager
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPollIntervalsForPrinter(t *testing.T) {
+	pi, err := newPollIntervals(time.Minute, map[string]string{
+		"lobby":    "10s",
+		"floor-.*": "5m",
+		"floor-1":  "30s",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, expected := range map[string]time.Duration{
+		"lobby":   10 * time.Second,
+		"floor-1": 30 * time.Second,
+		"floor-2": 5 * time.Minute,
+		"office":  time.Minute,
+	} {
+		if interval := pi.forPrinter(name); interval != expected {
+			t.Logf("expected %s to be polled every %s, got %s", name, expected, interval)
+			t.Fail()
+		}
+	}
+
+	if _, err = newPollIntervals(time.Minute, map[string]string{"lobby": "0s"}); err == nil {
+		t.Log("expected error for a zero interval")
+		t.Fail()
+	}
+}
+
+func TestPollIntervalsNextPollIn(t *testing.T) {
+	pi, err := newPollIntervals(time.Minute, map[string]string{"lobby": "10s", "floor-.*": "25s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+
+	if wait := pi.nextPollIn(map[string]time.Time{}, now); wait != time.Minute {
+		t.Logf("expected the default interval without printers, got %s", wait)
+		t.Fail()
+	}
+
+	// Each printer is due by its own interval, not a multiple of the shortest.
+	lastPolled := map[string]time.Time{
+		"office":  now.Add(-50 * time.Second),
+		"floor-1": now.Add(-5 * time.Second),
+	}
+	if wait := pi.nextPollIn(lastPolled, now); wait != 10*time.Second {
+		t.Logf("expected office to be due in 10s, got %s", wait)
+		t.Fail()
+	}
+	lastPolled["office"] = now
+	if wait := pi.nextPollIn(lastPolled, now); wait != 20*time.Second {
+		t.Logf("expected floor-1 to be due in 20s, got %s", wait)
+		t.Fail()
+	}
+
+	// An override for a printer that isn't polled doesn't shorten the wait.
+	if wait := pi.nextPollIn(map[string]time.Time{"office": now}, now); wait != time.Minute {
+		t.Logf("expected the lobby override to not apply without lobby, got %s", wait)
+		t.Fail()
+	}
+
+	// Overdue printers are due one interval from now.
+	lastPolled = map[string]time.Time{"lobby": now.Add(-time.Hour), "office": now}
+	if wait := pi.nextPollIn(lastPolled, now); wait != 10*time.Second {
+		t.Logf("expected overdue lobby to be due in 10s, got %s", wait)
+		t.Fail()
+	}
+}
@@ -9,12 +9,17 @@ https://developers.google.com/open-source/licenses/bsd
 package manager
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"hash/adler32"
 	"os"
 	"reflect"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"text/template"
 	"time"
 
 	"github.com/google/cups-connector/cdd"
@@ -27,6 +32,47 @@ import (
 	"github.com/google/cups-connector/xmpp"
 )
 
+const (
+	// Username templates equivalent to the cups_job_full_username option.
+	fullUsernameTemplate  = "{{.Full}}"
+	localUsernameTemplate = "{{.Local}}"
+
+	// Time to wait before the first CUPS job submission retry.
+	// Doubles after every failed retry.
+	cupsJobRetryBackoff = 2 * time.Second
+)
+
+// Statuses of finished jobs, as counted by GetJobCounts.
+const (
+	jobStatusDone      = "done"
+	jobStatusError     = "error"
+	jobStatusCancelled = "cancelled"
+)
+
+// jobCountKey keys the quantity of finished jobs.
+type jobCountKey struct {
+	printerName string
+	status      string
+}
+
+// jobStatus returns the status of a job that finished in state.
+func jobStatus(state *cdd.JobState) string {
+	switch {
+	case state.Type == cdd.JobStateDone:
+		return jobStatusDone
+	case state.UserActionCause != nil && state.UserActionCause.ActionCode == cdd.UserActionCauseCanceled:
+		return jobStatusCancelled
+	}
+	return jobStatusError
+}
+
+// usernameTemplateFuncs are available to the CUPS job username template.
+var usernameTemplateFuncs = template.FuncMap{
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"split": strings.Split,
+}
+
 // Manages all interactions between CUPS and Google Cloud Print.
 type PrinterManager struct {
 	cups   *cups.CUPS
@@ -37,73 +83,165 @@ type PrinterManager struct {
 
 	printers *lib.ConcurrentPrinterMap
 
+	// Last time each CUPS printer was polled; only changed by syncPrinters,
+	// which holds lastPolledMutex to do so.
+	pollIntervals   *pollIntervals
+	lastPolledMutex sync.Mutex
+	lastPolled      map[string]time.Time
+
+	// Last time each printer was updated in GCP; only used by syncPrinters.
+	minUpdateInterval time.Duration
+	lastUpdated       map[string]time.Time
+
+	// Limits the printers that are shared; nil means no limit.
+	allowlist *printerAllowlist
+
+	// Printers kept out of GCP while they remain in CUPS.
+	disabled *disabledPrinters
+
+	// Device URI schemes of printers to keep out of GCP.
+	skippedSchemes *skippedSchemes
+
+	// Names of printers to delete from GCP and register again, to why; only
+	// used by syncPrinters.
+	reregister             map[string]string
+	reregistered           *reregistered
+	reregisterOnUUIDChange bool
+
+	// Removes or pins capabilities of printers, and rejects jobs that ask
+	// for them.
+	capabilityOverrides capabilityOverrides
+
+	// Manufacturer and model of printers, by printer name, to use instead of
+	// those in their PPDs.
+	makeModelOverrides map[string]map[string]string
+
+	// Custom tags added to printers.
+	customTags customTags
+
+	// Keeps the files of printed jobs; nil means they are removed.
+	jobFileKeeper *jobFileKeeper
+
+	// Remembers the jobs submitted to CUPS; nil means jobs aren't deduplicated.
+	jobDeduper *jobDeduper
+
 	// Job stats are numbers reported to monitoring.
 	jobStatsMutex sync.Mutex
 	jobsDone      uint
 	jobsError     uint
+	jobCounts     map[jobCountKey]uint
 
 	// Jobs in flight are jobs that have been received, and are not
 	// finished printing yet. Key is Job ID.
 	jobsInFlightMutex sync.Mutex
 	jobsInFlight      map[string]struct{}
 
-	cupsQueueSize     uint
-	jobFullUsername   bool
-	ignoreRawPrinters bool
-	shareScope        string
+	// Printers whose GCP jobs are being polled; see pollCloudJobs.
+	cloudJobPolls cloudJobPolls
 
-	quit chan struct{}
-}
+	// Serializes syncs, so that a GCP printer list refresh doesn't race with
+	// the regular poll.
+	syncMutex sync.Mutex
 
-func NewPrinterManager(cups *cups.CUPS, gcp *gcp.GoogleCloudPrint, privet *privet.Privet, snmp *snmp.SNMPManager, printerPollInterval time.Duration, cupsQueueSize uint, jobFullUsername, ignoreRawPrinters bool, shareScope string, jobs <-chan *lib.Job, xmppNotifications <-chan xmpp.PrinterNotification) (*PrinterManager, error) {
-	var printers *lib.ConcurrentPrinterMap
-	var queuedJobsCount map[string]uint
+	// Time of the last successful printer sync.
+	lastSyncMutex sync.Mutex
+	lastSync      time.Time
 
-	var err error
-	if gcp != nil {
-		// Get all GCP printers.
-		var gcpPrinters []lib.Printer
-		gcpPrinters, queuedJobsCount, err = gcp.ListPrinters()
-		if err != nil {
-			return nil, err
-		}
-		// Organize the GCP printers into a map.
-		for i := range gcpPrinters {
-			gcpPrinters[i].CUPSJobSemaphore = lib.NewSemaphore(cupsQueueSize)
-		}
-		printers = lib.NewConcurrentPrinterMap(gcpPrinters)
-	} else {
-		printers = lib.NewConcurrentPrinterMap(nil)
-	}
+	// Quantity of failed register, update, share and delete operations,
+	// counted once retries are exhausted.
+	syncFailures uint32
 
-	// Construct.
-	pm := PrinterManager{
-		cups:   cups,
-		gcp:    gcp,
-		privet: privet,
-		snmp:   snmp,
+	// Quantity of retries of those operations, and the printers to retry
+	// them for; see syncRetries.
+	syncRetryCount uint32
+	syncRetries    *syncRetries
 
-		printers: printers,
+	// Most printers to register in GCP, and the quantity and names of the
+	// printers that the last sync didn't register; see capRegistrations.
+	maxRegisteredPrinters    uint
+	registrationsSkipped     uint32
+	lastSkippedRegistrations string
 
-		jobStatsMutex: sync.Mutex{},
-		jobsDone:      0,
-		jobsError:     0,
+	// 1 while cloud operations are paused; see PauseCloud.
+	cloudPaused uint32
 
-		jobsInFlightMutex: sync.Mutex{},
-		jobsInFlight:      make(map[string]struct{}),
+	cupsQueueSize     uint
+	cupsJobRetries    uint
+	cupsJobMaxStopped time.Duration
+	usernameTemplate  *template.Template
+	rawPrinterPolicy  string
+	strictNames       bool
+	strictJobOptions  bool
+	allowEmptySync    bool
+	shadowMode        bool
+	logJobTitles      bool
+	shareScope        string
+	stateWebhook      *stateWebhook
 
-		cupsQueueSize:     cupsQueueSize,
-		jobFullUsername:   jobFullUsername,
-		ignoreRawPrinters: ignoreRawPrinters,
-		shareScope:        shareScope,
+	// ctx is cancelled by Quit, to abort syncs and GCP calls in flight.
+	ctx    context.Context
+	cancel context.CancelFunc
 
-		quit: make(chan struct{}),
+	quit chan struct{}
+}
+
+// Options are the settings of a PrinterManager, shared by NewPrinterManager,
+// SyncPrinters and TestPrint. The intervals of periodic work that SyncPrinters
+// and TestPrint don't start are ignored by them.
+type Options struct {
+	PrinterPollInterval           time.Duration
+	PrinterPollIntervalOverrides  map[string]string
+	MinUpdateInterval             time.Duration
+	GCPPrinterListRefreshInterval time.Duration
+	CloudJobPollInterval          time.Duration
+	SelfHealInterval              time.Duration
+	SyncRetryBackoff              time.Duration
+	SyncMaxRetries                uint
+	MaxRegisteredPrinters         uint
+	AllowlistFile                 string
+	AllowlistInterval             time.Duration
+	DisabledPrinters              []string
+	ForceReregister               []string
+	SkipDeviceURISchemes          []string
+	ReregisterOnUUIDChange        bool
+	CUPSQueueSize                 uint
+	CUPSJobRetries                uint
+	CUPSJobMaxStopped             time.Duration
+	JobFullUsername               bool
+	JobUsernameTemplate           string
+	RawPrinterPolicy              string
+	StrictNames                   bool
+	StrictJobOptions              bool
+	AllowEmptyCUPSSync            bool
+	ShadowMode                    bool
+	LogJobTitles                  bool
+	KeepJobFiles                  bool
+	KeepJobFilesCount             uint
+	KeepJobFilesMaxAge            time.Duration
+	JobDedupTTL                   time.Duration
+	TempDir                       string
+	CapabilityOverrides           map[string]map[string]string
+	MakeModelOverrides            map[string]map[string]string
+	PrinterTags                   map[string]map[string]string
+	ShareScope                    string
+	StateChangeWebhookURL         string
+}
+
+func NewPrinterManager(cups *cups.CUPS, gcp *gcp.GoogleCloudPrint, privet *privet.Privet, snmp *snmp.SNMPManager, options Options, jobs <-chan *lib.Job, xmppNotifications <-chan xmpp.PrinterNotification) (*PrinterManager, error) {
+	pm, queuedJobsCount, err := newPrinterManager(cups, gcp, privet, snmp, options)
+	if err != nil {
+		return nil, err
+	}
+
+	if gcp != nil && options.ShadowMode {
+		log.Info("Shadow mode is on, so changes to GCP printers are logged but not made")
 	}
 
 	// Sync once before returning, to make sure things are working.
 	// Ignore privet updates this first time because Privet always starts
 	// with zero printers.
 	if err = pm.syncPrinters(true); err != nil {
+		pm.cancel()
 		return nil, err
 	}
 
@@ -119,26 +257,223 @@ func NewPrinterManager(cups *cups.CUPS, gcp *gcp.GoogleCloudPrint, privet *prive
 		}
 	}
 
-	pm.syncPrintersPeriodically(printerPollInterval)
+	if pm.allowlist != nil {
+		pm.allowlist.reloadPeriodically(options.AllowlistInterval, pm.quit)
+	}
+	pm.syncPrintersPeriodically()
+	if gcp != nil {
+		if options.GCPPrinterListRefreshInterval > 0 {
+			pm.refreshGCPPrintersPeriodically(options.GCPPrinterListRefreshInterval)
+		}
+		if options.CloudJobPollInterval > 0 {
+			pm.pollCloudJobsPeriodically(options.CloudJobPollInterval)
+		}
+		if options.SelfHealInterval > 0 {
+			pm.selfHealPeriodically(options.SelfHealInterval)
+		}
+		pm.handleCloudPauseSignals()
+	}
 	pm.listenNotifications(jobs, xmppNotifications)
 
 	if gcp != nil {
 		for gcpPrinterID := range queuedJobsCount {
-			p, _ := printers.GetByGCPID(gcpPrinterID)
-			go gcp.HandleJobs(&p, func() { pm.incrementJobsProcessed(false) })
+			p, _ := pm.printers.GetByGCPID(gcpPrinterID)
+			go pm.handleJobs(p)
 		}
 	}
 
-	return &pm, nil
+	return pm, nil
+}
+
+// SyncPrinters performs one CUPS to GCP printer sync, without starting any of
+// the background work of a PrinterManager. Returns an error if the sync
+// failed, or if any printer failed to register, update or delete.
+func SyncPrinters(cups *cups.CUPS, gcp *gcp.GoogleCloudPrint, snmp *snmp.SNMPManager, options Options) error {
+	pm, _, err := newPrinterManager(cups, gcp, nil, snmp, options)
+	if err != nil {
+		return err
+	}
+	defer pm.cancel()
+
+	if err = pm.syncPrinters(true); err != nil {
+		return err
+	}
+	for pm.syncRetries.pending() {
+		time.Sleep(pm.syncRetries.nextBackoff())
+		if err = pm.syncPrinters(true); err != nil {
+			return err
+		}
+	}
+	if pm.LastSync().IsZero() {
+		return errors.New("Sync was skipped")
+	}
+	if failures := atomic.LoadUint32(&pm.syncFailures); failures > 0 {
+		return fmt.Errorf("%d printer operations failed", failures)
+	}
+	return nil
+}
+
+// newPrinterManager checks options and gets the GCP printers, to construct a
+// PrinterManager that hasn't synced nor started any background work. Returns
+// the quantity of queued jobs by GCP printer ID too.
+func newPrinterManager(cups *cups.CUPS, gcp *gcp.GoogleCloudPrint, privet *privet.Privet, snmp *snmp.SNMPManager, options Options) (*PrinterManager, map[string]uint, error) {
+	jobUsernameTemplate := options.JobUsernameTemplate
+	if jobUsernameTemplate == "" {
+		if options.JobFullUsername {
+			jobUsernameTemplate = fullUsernameTemplate
+		} else {
+			jobUsernameTemplate = localUsernameTemplate
+		}
+	}
+	usernameTemplate, err := template.New("username").Funcs(usernameTemplateFuncs).Parse(jobUsernameTemplate)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to parse CUPS job username template: %s", err)
+	}
+
+	pollIntervals, err := newPollIntervals(options.PrinterPollInterval, options.PrinterPollIntervalOverrides)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var allowlist *printerAllowlist
+	if options.AllowlistFile != "" {
+		if allowlist, err = newPrinterAllowlist(options.AllowlistFile); err != nil {
+			return nil, nil, fmt.Errorf("Failed to read printer allowlist: %s", err)
+		}
+	}
+
+	cos, err := newCapabilityOverrides(options.CapabilityOverrides)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = lib.CheckMakeModelOverrides(options.MakeModelOverrides); err != nil {
+		return nil, nil, err
+	}
+	tags, err := newCustomTags(options.PrinterTags)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = lib.CheckRawPrinterPolicy(options.RawPrinterPolicy); err != nil {
+		return nil, nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	printers, queuedJobsCount, err := getGCPPrinters(ctx, gcp, options.CUPSQueueSize)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+
+	var webhook *stateWebhook
+	if options.StateChangeWebhookURL != "" {
+		webhook = newStateWebhook(options.StateChangeWebhookURL)
+	}
+
+	var keeper *jobFileKeeper
+	if options.KeepJobFiles {
+		keeper = newJobFileKeeper(options.TempDir, options.KeepJobFilesCount, options.KeepJobFilesMaxAge)
+	}
+
+	reregistered := newReregistered(options.TempDir, options.ForceReregister)
+
+	var deduper *jobDeduper
+	if options.JobDedupTTL > 0 {
+		deduper = newJobDeduper(options.TempDir, options.JobDedupTTL)
+	}
+
+	// Construct.
+	pm := PrinterManager{
+		cups:   cups,
+		gcp:    gcp,
+		privet: privet,
+		snmp:   snmp,
+
+		printers: printers,
+
+		pollIntervals: pollIntervals,
+		lastPolled:    make(map[string]time.Time),
+
+		minUpdateInterval: options.MinUpdateInterval,
+		lastUpdated:       make(map[string]time.Time),
+
+		allowlist:      allowlist,
+		disabled:       newDisabledPrinters(options.DisabledPrinters),
+		skippedSchemes: newSkippedSchemes(options.SkipDeviceURISchemes),
+		reregister:     newReregister(options.ForceReregister, reregistered),
+		reregistered:   reregistered,
+
+		reregisterOnUUIDChange: options.ReregisterOnUUIDChange,
+
+		syncRetries: newSyncRetries(options.SyncMaxRetries, options.SyncRetryBackoff),
+
+		maxRegisteredPrinters: options.MaxRegisteredPrinters,
+
+		capabilityOverrides: cos,
+		makeModelOverrides:  options.MakeModelOverrides,
+
+		customTags: tags,
+
+		jobFileKeeper: keeper,
+		jobDeduper:    deduper,
+
+		jobStatsMutex: sync.Mutex{},
+		jobsDone:      0,
+		jobsError:     0,
+		jobCounts:     make(map[jobCountKey]uint),
+
+		jobsInFlightMutex: sync.Mutex{},
+		jobsInFlight:      make(map[string]struct{}),
+
+		cupsQueueSize:     options.CUPSQueueSize,
+		cupsJobRetries:    options.CUPSJobRetries,
+		cupsJobMaxStopped: options.CUPSJobMaxStopped,
+		usernameTemplate:  usernameTemplate,
+		rawPrinterPolicy:  options.RawPrinterPolicy,
+		strictNames:       options.StrictNames,
+		strictJobOptions:  options.StrictJobOptions,
+		allowEmptySync:    options.AllowEmptyCUPSSync,
+		shadowMode:        options.ShadowMode,
+		logJobTitles:      options.LogJobTitles,
+		shareScope:        options.ShareScope,
+		stateWebhook:      webhook,
+
+		ctx:    ctx,
+		cancel: cancel,
+
+		quit: make(chan struct{}),
+	}
+
+	return &pm, queuedJobsCount, nil
+}
+
+// getGCPPrinters gets all GCP printers of this connector, and the quantity of
+// queued jobs by GCP printer ID. Without GCP, there are no printers.
+func getGCPPrinters(ctx context.Context, gcp *gcp.GoogleCloudPrint, cupsQueueSize uint) (*lib.ConcurrentPrinterMap, map[string]uint, error) {
+	if gcp == nil {
+		return lib.NewConcurrentPrinterMap(nil), nil, nil
+	}
+
+	gcpPrinters, queuedJobsCount, err := gcp.ListPrinters(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Organize the GCP printers into a map.
+	for i := range gcpPrinters {
+		gcpPrinters[i].CUPSJobSemaphore = lib.NewSemaphore(cupsQueueSize)
+	}
+	return lib.NewConcurrentPrinterMap(gcpPrinters), queuedJobsCount, nil
 }
 
 func (pm *PrinterManager) Quit() {
+	pm.cancel()
 	close(pm.quit)
 }
 
-func (pm *PrinterManager) syncPrintersPeriodically(interval time.Duration) {
+// syncPrintersPeriodically syncs each time a printer is due for a poll, by
+// its own poll interval.
+func (pm *PrinterManager) syncPrintersPeriodically() {
 	go func() {
-		t := time.NewTimer(interval)
+		t := time.NewTimer(pm.nextSyncIn(pm.nextPollIn()))
 		defer t.Stop()
 
 		for {
@@ -147,6 +482,31 @@ func (pm *PrinterManager) syncPrintersPeriodically(interval time.Duration) {
 				if err := pm.syncPrinters(false); err != nil {
 					log.Error(err)
 				}
+				t.Reset(pm.nextSyncIn(pm.nextPollIn()))
+
+			case <-pm.quit:
+				return
+			}
+		}
+	}()
+}
+
+// refreshGCPPrintersPeriodically replaces what we know about GCP printers
+// with a fresh GCP printer list every interval, then syncs, to reconcile
+// printers changed outside the connector, eg deleted in the web UI.
+func (pm *PrinterManager) refreshGCPPrintersPeriodically(interval time.Duration) {
+	go func() {
+		t := time.NewTimer(interval)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-t.C:
+				if pm.CloudPaused() {
+					log.Info("Cloud operations are paused; not refreshing the GCP printer list")
+				} else if _, err := pm.refreshGCPPrinters(); err != nil {
+					log.Error(err)
+				}
 				t.Reset(interval)
 
 			case <-pm.quit:
@@ -156,17 +516,90 @@ func (pm *PrinterManager) syncPrintersPeriodically(interval time.Duration) {
 	}()
 }
 
+// refreshGCPPrinters returns the quantity of queued jobs by GCP printer ID.
+func (pm *PrinterManager) refreshGCPPrinters() (map[string]uint, error) {
+	pm.syncMutex.Lock()
+	defer pm.syncMutex.Unlock()
+
+	// Apply pending CUPS changes first, locally too, so that the sync after
+	// the refresh only has to fix what changed in GCP.
+	if err := pm.syncPrintersLocked(false); err != nil {
+		return nil, err
+	}
+
+	log.Info("Refreshing the GCP printer list")
+	gcpPrinters, queuedJobsCount, err := pm.gcp.ListPrinters(pm.ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to refresh the GCP printer list: %s", err)
+	}
+	for i := range gcpPrinters {
+		// Keep the semaphores of known printers, which jobs in flight hold.
+		if known, exists := pm.printers.GetByGCPID(gcpPrinters[i].GCPID); exists {
+			gcpPrinters[i].CUPSJobSemaphore = known.CUPSJobSemaphore
+		} else {
+			gcpPrinters[i].CUPSJobSemaphore = lib.NewSemaphore(pm.cupsQueueSize)
+		}
+	}
+	pm.printers.Refresh(gcpPrinters)
+	// Poll every CUPS printer, instead of trusting what was known.
+	pm.lastPolledMutex.Lock()
+	pm.lastPolled = make(map[string]time.Time)
+	pm.lastPolledMutex.Unlock()
+
+	return queuedJobsCount, pm.syncPrintersLocked(true)
+}
+
 func (pm *PrinterManager) syncPrinters(ignorePrivet bool) error {
+	pm.syncMutex.Lock()
+	defer pm.syncMutex.Unlock()
+
+	return pm.syncPrintersLocked(ignorePrivet)
+}
+
+// nextSyncIn is the wait before the next periodic sync: interval, or sooner
+// when failed operations are to be retried.
+func (pm *PrinterManager) nextSyncIn(interval time.Duration) time.Duration {
+	if pm.syncRetries.pending() {
+		if backoff := pm.syncRetries.nextBackoff(); backoff < interval {
+			return backoff
+		}
+	}
+	return interval
+}
+
+// syncPrintersLocked syncs CUPS printers to GCP; pm.syncMutex must be held.
+func (pm *PrinterManager) syncPrintersLocked(ignorePrivet bool) error {
 	log.Info("Synchronizing printers, stand by")
 
+	if pm.applyToCloud() {
+		pm.retryShares()
+	}
+
 	// Get current snapshot of CUPS printers.
-	cupsPrinters, err := pm.cups.GetPrinters()
+	cupsPrinters, err := pm.cups.GetPrinters(pm.ctx)
 	if err != nil {
 		return fmt.Errorf("Sync failed while calling GetPrinters(): %s", err)
 	}
-	if pm.ignoreRawPrinters {
-		cupsPrinters, _ = lib.FilterRawPrinters(cupsPrinters)
+	if !pm.allowEmptySync {
+		if err = lib.CheckEmptyCUPSPrinters(cupsPrinters, pm.printers.GetAll()); err != nil {
+			log.Warning(err)
+			return nil
+		}
+	}
+	cupsPrinters, gcpPrinters := lib.ApplyRawPrinterPolicy(pm.rawPrinterPolicy, cupsPrinters, pm.printers.GetAll())
+	if pm.allowlist != nil {
+		cupsPrinters = pm.allowlist.filter(cupsPrinters)
 	}
+	cupsPrinters = pm.disabled.filter(cupsPrinters)
+	cupsPrinters = pm.skippedSchemes.filter(cupsPrinters)
+	if pm.strictNames {
+		if duplicates := lib.DuplicatePrinterNames(cupsPrinters); len(duplicates) > 0 {
+			return fmt.Errorf("Sync failed because multiple CUPS printers share these names: %s",
+				strings.Join(duplicates, ", "))
+		}
+	}
+	pm.capabilityOverrides.apply(cupsPrinters)
+	lib.ApplyMakeModelOverrides(cupsPrinters, pm.makeModelOverrides)
 
 	// Augment CUPS printers with extra information from SNMP.
 	if pm.snmp != nil {
@@ -176,21 +609,48 @@ func (pm *PrinterManager) syncPrinters(ignorePrivet bool) error {
 		}
 	}
 
+	pm.customTags.apply(cupsPrinters)
+
 	// Set CapsHash on all printers.
 	for i := range cupsPrinters {
-		h := adler32.New()
-		lib.DeepHash(cupsPrinters[i].Tags, h)
-		cupsPrinters[i].Tags["tagshash"] = fmt.Sprintf("%x", h.Sum(nil))
+		cupsPrinters[i].Tags["tagshash"] = lib.TagsHash(cupsPrinters[i].Tags)
 
-		h = adler32.New()
+		h := adler32.New()
 		lib.DeepHash(cupsPrinters[i].Description, h)
 		cupsPrinters[i].CapsHash = fmt.Sprintf("%x", h.Sum(nil))
 	}
 
+	// Printers that aren't due for a poll keep their last known state.
+	now := time.Now()
+	lastPolled := make(map[string]time.Time, len(cupsPrinters))
+	for i := range cupsPrinters {
+		name := cupsPrinters[i].Name
+		if last, exists := pm.lastPolled[name]; exists && now.Sub(last) < pm.pollIntervals.forPrinter(name) {
+			if known, exists := pm.printers.GetByCUPSName(name); exists {
+				cupsPrinters[i] = known
+				lastPolled[name] = last
+				continue
+			}
+		}
+		lastPolled[name] = now
+	}
+	pm.lastPolledMutex.Lock()
+	pm.lastPolled = lastPolled
+	pm.lastPolledMutex.Unlock()
+
+	pm.checkUUIDs(cupsPrinters, gcpPrinters)
+	gcpPrinters = pm.forceReregister(cupsPrinters, gcpPrinters, ignorePrivet)
+
 	// Compare the snapshot to what we know currently.
-	diffs := lib.DiffPrinters(cupsPrinters, pm.printers.GetAll())
+	diffs, err := lib.DiffPrinters(cupsPrinters, gcpPrinters)
+	if err != nil {
+		return fmt.Errorf("Sync failed while comparing printers: %s", err)
+	}
+	diffs = pm.capRegistrations(diffs)
+	diffs = pm.debounceUpdates(diffs)
 	if diffs == nil {
 		log.Infof("Printers are already in sync; there are %d", len(cupsPrinters))
+		pm.setLastSync()
 		return nil
 	}
 
@@ -210,26 +670,124 @@ func (pm *PrinterManager) syncPrinters(ignorePrivet bool) error {
 	// Update what we know.
 	pm.printers.Refresh(currentPrinters)
 	log.Infof("Finished synchronizing %d printers", len(currentPrinters))
+	pm.setLastSync()
 
 	return nil
 }
 
-func (pm *PrinterManager) applyDiff(diff *lib.PrinterDiff, ch chan<- lib.Printer, ignorePrivet bool) {
+// debounceUpdates holds back updates to printers that were updated less than
+// minUpdateInterval ago, so that they are coalesced into a later sync. Changes
+// into an error state are never held back. Returns nil if no changes remain.
+func (pm *PrinterManager) debounceUpdates(diffs []lib.PrinterDiff) []lib.PrinterDiff {
+	if diffs == nil || pm.minUpdateInterval <= 0 {
+		return diffs
+	}
+
+	now := time.Now()
+	dirty := false
+	for i := range diffs {
+		switch diffs[i].Operation {
+		case lib.NoChangeToPrinter:
+			continue
+		case lib.DeletePrinter:
+			delete(pm.lastUpdated, diffs[i].Printer.Name)
+			dirty = true
+			continue
+		case lib.RegisterPrinter:
+			dirty = true
+			continue
+		}
+
+		name := diffs[i].Printer.Name
+		known, _ := pm.printers.GetByCUPSName(name)
+		if last, exists := pm.lastUpdated[name]; exists && now.Sub(last) < pm.minUpdateInterval &&
+			!enteredErrorState(known.State, diffs[i].Printer.State) && !pm.syncRetries.retrying(name) {
+			log.DebugPrinterf(name, "Holding back update for %s", pm.minUpdateInterval-now.Sub(last))
+			diffs[i] = lib.PrinterDiff{Operation: lib.NoChangeToPrinter, Printer: known}
+			continue
+		}
+
+		pm.lastUpdated[name] = now
+		dirty = true
+	}
+
+	if !dirty {
+		return nil
+	}
+	return diffs
+}
+
+// enteredErrorState reports whether a printer state changed to stopped.
+func enteredErrorState(old, new *cdd.PrinterStateSection) bool {
+	if new == nil || new.State != cdd.CloudDeviceStateStopped {
+		return false
+	}
+	return old == nil || old.State != cdd.CloudDeviceStateStopped
+}
+
+func (pm *PrinterManager) setLastSync() {
+	pm.lastSyncMutex.Lock()
+	defer pm.lastSyncMutex.Unlock()
+
+	pm.lastSync = time.Now()
+}
+
+// GetPrinters returns the printers that the last sync shared.
+func (pm *PrinterManager) GetPrinters() []lib.Printer {
+	return pm.printers.GetAll()
+}
+
+// LastSync returns the time of the last successful printer sync.
+func (pm *PrinterManager) LastSync() time.Time {
+	pm.lastSyncMutex.Lock()
+	defer pm.lastSyncMutex.Unlock()
+
+	return pm.lastSync
+}
+
+// nextPollIn returns the wait until the next CUPS printer is due for a poll.
+func (pm *PrinterManager) nextPollIn() time.Duration {
+	pm.lastPolledMutex.Lock()
+	defer pm.lastPolledMutex.Unlock()
+
+	return pm.pollIntervals.nextPollIn(pm.lastPolled, time.Now())
+}
+
+// LastPolled returns the last time that the CUPS printer named name was
+// polled, and whether it has been.
+func (pm *PrinterManager) LastPolled(name string) (time.Time, bool) {
+	pm.lastPolledMutex.Lock()
+	defer pm.lastPolledMutex.Unlock()
+
+	last, exists := pm.lastPolled[name]
+	return last, exists
+}
+
+// applyToCloud checks whether diffs are applied to GCP printers.
+func (pm *PrinterManager) applyToCloud() bool {
+	return pm.gcp != nil && !pm.shadowMode && !pm.CloudPaused()
+}
+
+// applyDiff applies diff, then sends the printer to keep on ch. Returns false
+// when GCP was to be changed but wasn't, including when it's retried later.
+func (pm *PrinterManager) applyDiff(diff *lib.PrinterDiff, ch chan<- lib.Printer, ignorePrivet bool) bool {
+	if pm.gcp != nil && pm.shadowMode && diff.Operation != lib.NoChangeToPrinter {
+		log.InfoPrinterf(diff.Printer.Name, "Shadow mode; would %s", diff)
+	}
+
 	switch diff.Operation {
 	case lib.RegisterPrinter:
-		if pm.gcp != nil {
-			if err := pm.gcp.Register(&diff.Printer); err != nil {
+		if pm.applyToCloud() {
+			if err := pm.gcp.Register(pm.ctx, &diff.Printer); err != nil {
 				log.ErrorPrinterf(diff.Printer.Name, "Failed to register: %s", err)
-				break
+				atomic.AddUint32(&pm.syncFailures, 1)
+				ch <- lib.Printer{}
+				return false
 			}
 			log.InfoPrinterf(diff.Printer.Name+" "+diff.Printer.GCPID, "Registered in the cloud")
 
 			if pm.gcp.CanShare() {
-				if err := pm.gcp.Share(diff.Printer.GCPID, pm.shareScope); err != nil {
-					log.ErrorPrinterf(diff.Printer.Name, "Failed to share: %s", err)
-				} else {
-					log.InfoPrinterf(diff.Printer.Name, "Shared")
-				}
+				pm.sharePrinter(diff.Printer.Name, diff.Printer.GCPID)
 			}
 		}
 
@@ -245,17 +803,34 @@ func (pm *PrinterManager) applyDiff(diff *lib.PrinterDiff, ch chan<- lib.Printer
 		}
 
 		ch <- diff.Printer
-		return
+		return true
 
 	case lib.UpdatePrinter:
-		if pm.gcp != nil {
-			if err := pm.gcp.Update(diff); err != nil {
+		updated := true
+		if pm.applyToCloud() {
+			if err := pm.gcp.Update(pm.ctx, diff); err != nil {
+				if pm.retryLater(diff.Printer.Name, "update", err) {
+					// Keep what GCP has, so that the next sync updates again.
+					old, _ := pm.printers.GetByCUPSName(diff.Printer.Name)
+					ch <- old
+					return false
+				}
 				log.ErrorPrinterf(diff.Printer.Name+" "+diff.Printer.GCPID, "Failed to update: %s", err)
+				atomic.AddUint32(&pm.syncFailures, 1)
+				updated = false
 			} else {
+				pm.syncRetries.succeeded(diff.Printer.Name)
 				log.InfoPrinterf(diff.Printer.Name+" "+diff.Printer.GCPID, "Updated in the cloud")
 			}
 		}
 
+		// Only once GCP has the new state, so that the webhook never reports a
+		// state that GCP doesn't show.
+		if updated && pm.stateWebhook != nil && diff.StateChanged {
+			old, _ := pm.printers.GetByCUPSName(diff.Printer.Name)
+			pm.stateWebhook.notify(diff.Printer.Name, old.State, diff.Printer.State)
+		}
+
 		if pm.privet != nil && !ignorePrivet && diff.DefaultDisplayNameChanged {
 			err := pm.privet.UpdatePrinter(diff)
 			if err != nil {
@@ -266,16 +841,26 @@ func (pm *PrinterManager) applyDiff(diff *lib.PrinterDiff, ch chan<- lib.Printer
 		}
 
 		ch <- diff.Printer
-		return
+		return updated
 
 	case lib.DeletePrinter:
-		pm.cups.RemoveCachedPPD(diff.Printer.Name)
+		if pm.cups != nil {
+			pm.cups.RemoveCachedPPD(diff.Printer.Name)
+		}
 
-		if pm.gcp != nil {
-			if err := pm.gcp.Delete(diff.Printer.GCPID); err != nil {
+		if pm.applyToCloud() {
+			if err := pm.gcp.Delete(pm.ctx, diff.Printer.GCPID); err != nil {
+				if pm.retryLater(diff.Printer.Name, "delete from the cloud", err) {
+					// Keep the printer, so that the next sync deletes again.
+					ch <- diff.Printer
+					return false
+				}
 				log.ErrorPrinterf(diff.Printer.Name+" "+diff.Printer.GCPID, "Failed to delete from the cloud: %s", err)
-				break
+				atomic.AddUint32(&pm.syncFailures, 1)
+				ch <- lib.Printer{}
+				return false
 			}
+			pm.syncRetries.succeeded(diff.Printer.Name)
 			log.InfoPrinterf(diff.Printer.Name+" "+diff.Printer.GCPID, "Deleted from the cloud")
 		}
 
@@ -290,10 +875,11 @@ func (pm *PrinterManager) applyDiff(diff *lib.PrinterDiff, ch chan<- lib.Printer
 
 	case lib.NoChangeToPrinter:
 		ch <- diff.Printer
-		return
+		return true
 	}
 
 	ch <- lib.Printer{}
+	return true
 }
 
 // listenNotifications handles the messages found on the channels.
@@ -305,12 +891,14 @@ func (pm *PrinterManager) listenNotifications(jobs <-chan *lib.Job, xmppMessages
 				return
 
 			case job := <-jobs:
-				go pm.printJob(job.CUPSPrinterName, job.Filename, job.Title, job.User, job.JobID, job.Ticket, job.UpdateJob)
+				go pm.printJob(job.CUPSPrinterName, job.Filename, job.Title, job.User, job.JobID, job.Ticket, job.UpdateJob, job.UpdateJobWithMessage)
 
 			case notification := <-xmppMessages:
-				if notification.Type == xmpp.PrinterNewJobs {
+				if notification.Type == xmpp.PrinterNewJobs && pm.CloudPaused() {
+					log.Info("Cloud operations are paused; jobs will be fetched when resumed")
+				} else if notification.Type == xmpp.PrinterNewJobs {
 					if p, exists := pm.printers.GetByGCPID(notification.GCPID); exists {
-						go pm.gcp.HandleJobs(&p, func() { pm.incrementJobsProcessed(false) })
+						go pm.handleJobs(p)
 					}
 				}
 			}
@@ -318,15 +906,16 @@ func (pm *PrinterManager) listenNotifications(jobs <-chan *lib.Job, xmppMessages
 	}()
 }
 
-func (pm *PrinterManager) incrementJobsProcessed(success bool) {
+func (pm *PrinterManager) incrementJobsProcessed(printerName, status string) {
 	pm.jobStatsMutex.Lock()
 	defer pm.jobStatsMutex.Unlock()
 
-	if success {
+	if status == jobStatusDone {
 		pm.jobsDone += 1
 	} else {
 		pm.jobsError += 1
 	}
+	pm.jobCounts[jobCountKey{printerName, status}] += 1
 }
 
 // addInFlightJob adds a job ID to the in flight set.
@@ -345,6 +934,15 @@ func (pm *PrinterManager) addInFlightJob(jobID string) bool {
 	return true
 }
 
+// jobInFlight checks whether a job ID is in the in flight set.
+func (pm *PrinterManager) jobInFlight(jobID string) bool {
+	pm.jobsInFlightMutex.Lock()
+	defer pm.jobsInFlightMutex.Unlock()
+
+	_, exists := pm.jobsInFlight[jobID]
+	return exists
+}
+
 // deleteInFlightJob deletes a job from the in flight set.
 func (pm *PrinterManager) deleteInFlightJob(jobID string) {
 	pm.jobsInFlightMutex.Lock()
@@ -353,62 +951,97 @@ func (pm *PrinterManager) deleteInFlightJob(jobID string) {
 	delete(pm.jobsInFlight, jobID)
 }
 
+// cupsUsername transforms a GCP/Privet username (joe@example.com) into the
+// CUPS job owner, as described by the username template.
+func (pm *PrinterManager) cupsUsername(user string) string {
+	parts := strings.SplitN(user, "@", 2)
+	data := struct{ Local, Domain, Full string }{Local: parts[0], Full: user}
+	if len(parts) > 1 {
+		data.Domain = parts[1]
+	}
+
+	var b bytes.Buffer
+	if err := pm.usernameTemplate.Execute(&b, data); err != nil {
+		log.Warningf("Failed to render CUPS job username for %s; using %s: %s", user, data.Local, err)
+		return data.Local
+	}
+	return b.String()
+}
+
 // printJob prints a new job to a CUPS printer, then polls the CUPS job state
 // and updates the GCP/Privet job state. then returns when the job state is DONE
 // or ABORTED.
 //
 // All errors are reported and logged from inside this function.
-func (pm *PrinterManager) printJob(cupsPrinterName, filename, title, user, jobID string, ticket *cdd.CloudJobTicket, updateJob func(string, cdd.PrintJobStateDiff) error) {
-	defer os.Remove(filename)
+func (pm *PrinterManager) printJob(cupsPrinterName, filename, title, user, jobID string, ticket *cdd.CloudJobTicket, updateJob func(string, cdd.PrintJobStateDiff) error, updateJobWithMessage func(string, cdd.PrintJobStateDiff, string) error) {
 	if !pm.addInFlightJob(jobID) {
 		// This print job was already received. We probably received it
 		// again because the first instance is still QUEUED (ie not
 		// IN_PROGRESS). That's OK, just throw away the second instance.
+		os.Remove(filename)
 		return
 	}
 	defer pm.deleteInFlightJob(jobID)
+	defer pm.removeJobFile(jobID, filename)
 
-	if !pm.jobFullUsername {
-		user = strings.Split(user, "@")[0]
-	}
+	user = pm.cupsUsername(user)
 
 	printer, exists := pm.printers.GetByCUPSName(cupsPrinterName)
 	if !exists {
-		pm.incrementJobsProcessed(false)
+		jobErr := lib.NewJobError(lib.JobErrorPrinter, "Printer %s is no longer in CUPS", cupsPrinterName)
 		state := cdd.PrintJobStateDiff{
 			State: &cdd.JobState{
 				Type:               cdd.JobStateAborted,
 				ServiceActionCause: &cdd.ServiceActionCause{ErrorCode: cdd.ServiceActionCausePrinterDeleted},
 			},
 		}
-		if err := updateJob(jobID, state); err != nil {
-			log.ErrorJob(jobID, err)
-		}
+		pm.failJob(cupsPrinterName, jobID, jobErr, state, updateJob, updateJobWithMessage)
 		return
 	}
 
+	if err := pm.capabilityOverrides.checkTicket(printer.Name, ticket); err != nil {
+		jobErr := lib.NewJobError(lib.JobErrorUnsupportedOption, "Rejected: %s", err)
+		pm.failJob(printer.Name, jobID, jobErr, jobErr.State(), updateJob, updateJobWithMessage)
+		return
+	}
+
+	// Options that can't be honored are reported with the first job state,
+	// and reject the job in strict mode. Otherwise they are left in the
+	// ticket, for CUPS to honor if it can.
+	var message string
+	unsupported := unsupportedOptions(printer.Description, ticket)
+	unsupported = append(unsupported, cups.UntranslatableTicketOptions(ticket)...)
+	if len(unsupported) > 0 {
+		message = fmt.Sprintf("The printer may not honor these options: %s", strings.Join(unsupported, ", "))
+		if pm.strictJobOptions {
+			jobErr := lib.NewJobError(lib.JobErrorUnsupportedOption, "Rejected: %s", message)
+			pm.failJob(printer.Name, jobID, jobErr, jobErr.State(), updateJob, updateJobWithMessage)
+			return
+		}
+		log.WarningJobf(jobID, "Printing anyway: %s", message)
+	}
+
 	printer.CUPSJobSemaphore.Acquire()
 	defer printer.CUPSJobSemaphore.Release()
 
-	cupsJobID, err := pm.cups.Print(printer.Name, filename, title, user, jobID, ticket)
+	cupsJobID, err := pm.submitJob(&printer, filename, title, user, jobID, ticket)
 	if err != nil {
-		pm.incrementJobsProcessed(false)
-		log.ErrorJobf(jobID, "Failed to submit to CUPS: %s", err)
-		state := cdd.PrintJobStateDiff{
-			State: &cdd.JobState{
-				Type:              cdd.JobStateAborted,
-				DeviceActionCause: &cdd.DeviceActionCause{ErrorCode: cdd.DeviceActionCausePrintFailure},
-			},
-		}
-		if err := updateJob(jobID, state); err != nil {
-			log.ErrorJob(jobID, err)
-		}
+		jobErr := lib.NewJobError(lib.JobErrorCUPSSubmit, "Failed to submit to CUPS: %s", err)
+		pm.failJob(printer.Name, jobID, jobErr, jobErr.State(), updateJob, updateJobWithMessage)
 		return
 	}
+	if pm.jobDeduper != nil {
+		pm.jobDeduper.add(jobID)
+	}
 
-	log.InfoJobf(jobID, "Submitted as CUPS job %d", cupsJobID)
+	if pm.logJobTitles {
+		log.InfoJobf(jobID, "Submitted %q as CUPS job %d", title, cupsJobID)
+	} else {
+		log.InfoJobf(jobID, "Submitted as CUPS job %d", cupsJobID)
+	}
 
 	var state cdd.PrintJobStateDiff
+	var stoppedSince time.Time
 
 	ticker := time.NewTicker(time.Second)
 	defer ticker.Stop()
@@ -416,39 +1049,117 @@ func (pm *PrinterManager) printJob(cupsPrinterName, filename, title, user, jobID
 	for _ = range ticker.C {
 		cupsState, err := pm.cups.GetJobState(cupsJobID)
 		if err != nil {
-			log.WarningJobf(jobID, "Failed to get state of CUPS job %d: %s", cupsJobID, err)
-
-			state = cdd.PrintJobStateDiff{
-				State: &cdd.JobState{
-					Type:              cdd.JobStateAborted,
-					DeviceActionCause: &cdd.DeviceActionCause{ErrorCode: cdd.DeviceActionCauseOther},
-				},
-				PagesPrinted: state.PagesPrinted,
-			}
-			if err := updateJob(jobID, state); err != nil {
-				log.ErrorJob(jobID, err)
-			}
-			pm.incrementJobsProcessed(false)
+			jobErr := lib.NewJobError(lib.JobErrorPrinter, "Failed to get state of CUPS job %d: %s", cupsJobID, err)
+			pagesPrinted := state.PagesPrinted
+			state = jobErr.State()
+			state.PagesPrinted = pagesPrinted
+			pm.failJob(printer.Name, jobID, jobErr, state, updateJob, updateJobWithMessage)
 			return
 		}
 
 		if !reflect.DeepEqual(cupsState, state) {
 			state = cupsState
-			if err = updateJob(jobID, state); err != nil {
+			if state.State.Type == cdd.JobStateAborted && jobStatus(state.State) == jobStatusError {
+				jobErr := lib.NewJobError(lib.JobErrorPrinter, "CUPS aborted job %d", cupsJobID)
+				log.ErrorJob(jobID, jobErr)
+				if message == "" {
+					message = jobErr.Error()
+				} else {
+					message = fmt.Sprintf("%s; %s", message, jobErr)
+				}
+			}
+			if message != "" && updateJobWithMessage != nil {
+				err = updateJobWithMessage(jobID, state, message)
+				message = ""
+			} else {
+				err = updateJob(jobID, state)
+			}
+			if err != nil {
 				log.ErrorJob(jobID, err)
 			}
 			log.InfoJobf(jobID, "State: %s", state.State.Type)
 		}
 
-		if state.State.Type != cdd.JobStateInProgress {
-			if state.State.Type == cdd.JobStateDone {
-				pm.incrementJobsProcessed(true)
-			} else {
-				pm.incrementJobsProcessed(false)
-			}
+		// A stopped CUPS job can still be resumed or cancelled, so follow it
+		// until CUPS finishes with it one way or the other, or until it has
+		// been stopped for too long to hold its place in the CUPS job queue.
+		if state.State.Type == cdd.JobStateDone || state.State.Type == cdd.JobStateAborted {
+			pm.incrementJobsProcessed(printer.Name, jobStatus(state.State))
 			return
 		}
+		if state.State.Type != cdd.JobStateStopped {
+			stoppedSince = time.Time{}
+		} else if stoppedSince.IsZero() {
+			stoppedSince = time.Now()
+		} else if pm.cupsJobMaxStopped > 0 && time.Since(stoppedSince) >= pm.cupsJobMaxStopped {
+			jobErr := lib.NewJobError(lib.JobErrorPrinter, "CUPS job %d was stopped for more than %s", cupsJobID, pm.cupsJobMaxStopped)
+			pagesPrinted := state.PagesPrinted
+			state = jobErr.State()
+			state.PagesPrinted = pagesPrinted
+			pm.failJob(printer.Name, jobID, jobErr, state, updateJob, updateJobWithMessage)
+			return
+		}
+	}
+}
+
+// failJob aborts a job for jobErr, with state, and logs jobErr. GCP shows
+// jobErr to the job's owner; Privet jobs get the state alone.
+func (pm *PrinterManager) failJob(printerName, jobID string, jobErr *lib.JobError, state cdd.PrintJobStateDiff, updateJob func(string, cdd.PrintJobStateDiff) error, updateJobWithMessage func(string, cdd.PrintJobStateDiff, string) error) {
+	pm.incrementJobsProcessed(printerName, jobStatusError)
+	log.ErrorJob(jobID, jobErr)
+
+	var err error
+	if updateJobWithMessage != nil {
+		err = updateJobWithMessage(jobID, state, jobErr.Error())
+	} else {
+		err = updateJob(jobID, state)
+	}
+	if err != nil {
+		log.ErrorJob(jobID, err)
+	}
+}
+
+// submitJob submits a job to CUPS, retrying with exponential backoff when
+// the submission fails. The caller must hold the printer's CUPSJobSemaphore;
+// it is released while waiting between attempts so that other jobs may use it.
+func (pm *PrinterManager) submitJob(printer *lib.Printer, filename, title, user, jobID string, ticket *cdd.CloudJobTicket) (uint32, error) {
+	backoff := cupsJobRetryBackoff
+
+	for attempt := uint(0); ; attempt++ {
+		cupsJobID, err := pm.cups.Print(printer.Name, filename, title, user, jobID, ticket)
+		if err == nil || attempt >= pm.cupsJobRetries || !cups.SubmitRetryable(err) {
+			return cupsJobID, err
+		}
+
+		log.WarningJobf(jobID, "Failed to submit to CUPS, will retry in %s: %s", backoff.String(), err)
+
+		printer.CUPSJobSemaphore.Release()
+		select {
+		case <-time.After(backoff):
+		case <-pm.quit:
+			printer.CUPSJobSemaphore.Acquire()
+			return 0, err
+		}
+		printer.CUPSJobSemaphore.Acquire()
+
+		backoff *= 2
+	}
+}
+
+// GetJobCounts returns the quantity of finished jobs since the connector
+// started, by printer name, then by status: done, error or cancelled.
+func (pm *PrinterManager) GetJobCounts() map[string]map[string]uint {
+	pm.jobStatsMutex.Lock()
+	defer pm.jobStatsMutex.Unlock()
+
+	counts := make(map[string]map[string]uint)
+	for key, count := range pm.jobCounts {
+		if counts[key.printerName] == nil {
+			counts[key.printerName] = make(map[string]uint)
+		}
+		counts[key.printerName][key.status] = count
 	}
+	return counts
 }
 
 // GetJobStats returns information that is useful for monitoring
@@ -0,0 +1,192 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package manager
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/google/cups-connector/lib"
+	"github.com/google/cups-connector/log"
+)
+
+// The names of the printers that force_reregister re-registered are kept in
+// this file, in temp_dir.
+const reregisteredFilename = "cups-connector-reregistered.json"
+
+// Why printers in force_reregister are re-registered.
+const forceReregisterReason = "as set by force_reregister"
+
+// reregistered remembers the printers that force_reregister re-registered, so
+// that they aren't re-registered again at each restart. The names are saved
+// to a file.
+type reregistered struct {
+	filename string
+	names    map[string]struct{}
+}
+
+// newReregistered loads the names saved in dir, forgetting those no longer in
+// forceReregister, so that a printer added back to it is re-registered again.
+// An empty dir means the system temporary directory, like for the job files.
+func newReregistered(dir string, forceReregister []string) *reregistered {
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	r := reregistered{
+		filename: filepath.Join(dir, reregisteredFilename),
+		names:    make(map[string]struct{}),
+	}
+
+	b, err := ioutil.ReadFile(r.filename)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warningf("Failed to read re-registered printers: %s", err)
+		}
+		return &r
+	}
+	var names []string
+	if err = json.Unmarshal(b, &names); err != nil {
+		log.Warningf("Failed to read re-registered printers from %s: %s", r.filename, err)
+		return &r
+	}
+
+	wanted := make(map[string]struct{}, len(forceReregister))
+	for _, name := range forceReregister {
+		wanted[name] = struct{}{}
+	}
+	for _, name := range names {
+		if _, exists := wanted[name]; exists {
+			r.names[name] = struct{}{}
+		}
+	}
+	if len(r.names) != len(names) {
+		r.save()
+	}
+
+	return &r
+}
+
+// done answers whether the printer was re-registered.
+func (r *reregistered) done(name string) bool {
+	_, exists := r.names[name]
+	return exists
+}
+
+// add remembers that the printer was re-registered, and saves the names.
+func (r *reregistered) add(name string) {
+	r.names[name] = struct{}{}
+	r.save()
+}
+
+func (r *reregistered) save() {
+	names := make([]string, 0, len(r.names))
+	for name := range r.names {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	b, err := json.Marshal(names)
+	if err != nil {
+		log.Warningf("Failed to save re-registered printers: %s", err)
+		return
+	}
+	// Write then rename, so that a crash doesn't leave half a file.
+	tmp := r.filename + ".tmp"
+	if err = ioutil.WriteFile(tmp, b, 0600); err == nil {
+		err = os.Rename(tmp, r.filename)
+	}
+	if err != nil {
+		log.Warningf("Failed to save re-registered printers to %s: %s", r.filename, err)
+	}
+}
+
+// newReregister returns the printers of forceReregister to re-register, to
+// why, leaving out those that were re-registered already.
+func newReregister(forceReregister []string, done *reregistered) map[string]string {
+	reregister := make(map[string]string, len(forceReregister))
+	for _, name := range forceReregister {
+		if done.done(name) {
+			log.DebugPrinterf(name, "Not re-registering again, though in force_reregister")
+			continue
+		}
+		reregister[name] = forceReregisterReason
+	}
+	return reregister
+}
+
+// forceReregister deletes from GCP the printers that are to be registered
+// again, so that the sync registers them as new printers. Each printer is
+// re-registered once, and only while it is in CUPS; those in force_reregister
+// once across restarts too. Nothing is re-registered while changes aren't
+// applied to GCP, like in shadow mode, so that they aren't duplicated.
+// Returns the GCP printers that remain.
+func (pm *PrinterManager) forceReregister(cupsPrinters, gcpPrinters []lib.Printer, ignorePrivet bool) []lib.Printer {
+	if len(pm.reregister) == 0 || !pm.applyToCloud() {
+		return gcpPrinters
+	}
+
+	inCUPS := make(map[string]struct{}, len(cupsPrinters))
+	for i := range cupsPrinters {
+		inCUPS[cupsPrinters[i].Name] = struct{}{}
+	}
+
+	remaining := make([]lib.Printer, 0, len(gcpPrinters))
+	ch := make(chan lib.Printer, 1)
+	for i := range gcpPrinters {
+		name := gcpPrinters[i].Name
+		why, pending := pm.reregister[name]
+		_, exists := inCUPS[name]
+		if !pending || !exists {
+			remaining = append(remaining, gcpPrinters[i])
+			continue
+		}
+
+		log.InfoPrinterf(name+" "+gcpPrinters[i].GCPID, "Re-registering, %s", why)
+		deleted := pm.applyDiff(&lib.PrinterDiff{Operation: lib.DeletePrinter, Printer: gcpPrinters[i]}, ch, ignorePrivet)
+		<-ch
+		if !deleted {
+			// Still in GCP; registering it again would duplicate it.
+			remaining = append(remaining, gcpPrinters[i])
+			continue
+		}
+		if why == forceReregisterReason {
+			pm.reregistered.add(name)
+		}
+		delete(pm.reregister, name)
+	}
+
+	return remaining
+}
+
+// checkUUIDs warns about the CUPS printers whose UUID is not the one GCP
+// knows, like after a driver reinstall. When reregister_on_uuid_change is
+// set, they are queued to be re-registered by forceReregister; otherwise the
+// sync updates the GCP UUID.
+func (pm *PrinterManager) checkUUIDs(cupsPrinters, gcpPrinters []lib.Printer) {
+	gcpUUIDs := make(map[string]string, len(gcpPrinters))
+	for i := range gcpPrinters {
+		gcpUUIDs[gcpPrinters[i].Name] = gcpPrinters[i].UUID
+	}
+
+	for i := range cupsPrinters {
+		name := cupsPrinters[i].Name
+		gcpUUID, exists := gcpUUIDs[name]
+		if !exists || gcpUUID == "" || gcpUUID == cupsPrinters[i].UUID {
+			continue
+		}
+
+		log.WarningPrinterf(name, "UUID changed from %s in GCP to %s in CUPS", gcpUUID, cupsPrinters[i].UUID)
+		if pm.reregisterOnUUIDChange {
+			pm.reregister[name] = "as the UUID changed"
+		}
+	}
+}
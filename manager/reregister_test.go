@@ -0,0 +1,176 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package manager
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/cups-connector/gcp"
+	"github.com/google/cups-connector/lib"
+)
+
+// SyncPrinters and NewPrinterManager both construct with newPrinterManager,
+// so sync-once re-registers printers whose UUID changed too.
+func TestCheckUUIDsReregisterOnUUIDChange(t *testing.T) {
+	cupsPrinters := []lib.Printer{{Name: "lobby", UUID: "new"}, {Name: "office", UUID: "same"}}
+	gcpPrinters := []lib.Printer{{Name: "lobby", UUID: "old"}, {Name: "office", UUID: "same"}}
+
+	for _, reregisterOnUUIDChange := range []bool{true, false} {
+		pm, _, err := newPrinterManager(nil, nil, nil, nil, Options{
+			RawPrinterPolicy:       lib.RawPrinterPolicyRegister,
+			ReregisterOnUUIDChange: reregisterOnUUIDChange,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		pm.checkUUIDs(cupsPrinters, gcpPrinters)
+
+		if _, exists := pm.reregister["lobby"]; exists != reregisterOnUUIDChange {
+			t.Logf("expected lobby to be re-registered %t, got %v", reregisterOnUUIDChange, pm.reregister)
+			t.Fail()
+		}
+		if _, exists := pm.reregister["office"]; exists {
+			t.Logf("expected office to not be re-registered, got %v", pm.reregister)
+			t.Fail()
+		}
+	}
+}
+
+func TestReregistered(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reregister")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := newReregistered(dir, []string{"lobby", "office"})
+	reregister := newReregister([]string{"lobby", "office"}, r)
+	if len(reregister) != 2 {
+		t.Logf("expected both printers to be re-registered, got %v", reregister)
+		t.Fail()
+	}
+	r.add("lobby")
+
+	// After a restart, lobby isn't re-registered again.
+	r = newReregistered(dir, []string{"lobby", "office"})
+	reregister = newReregister([]string{"lobby", "office"}, r)
+	if _, exists := reregister["lobby"]; exists || len(reregister) != 1 {
+		t.Logf("expected only office to be re-registered, got %v", reregister)
+		t.Fail()
+	}
+
+	// Removed from force_reregister, lobby is forgotten, so adding it back
+	// re-registers it again.
+	newReregistered(dir, []string{"office"})
+	r = newReregistered(dir, []string{"lobby", "office"})
+	if reregister = newReregister([]string{"lobby"}, r); len(reregister) != 1 {
+		t.Logf("expected lobby to be re-registered again, got %v", reregister)
+		t.Fail()
+	}
+	if _, err := os.Stat(filepath.Join(dir, reregisteredFilename+".tmp")); !os.IsNotExist(err) {
+		t.Logf("expected no temporary file to be left, got %v", err)
+		t.Fail()
+	}
+}
+
+// Without applying changes to GCP, like in shadow mode, deleting a printer
+// to register it again would only duplicate it.
+func TestForceReregisterShadowMode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reregister")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pm, _, err := newPrinterManager(nil, nil, nil, nil, Options{
+		RawPrinterPolicy: lib.RawPrinterPolicyRegister,
+		ForceReregister:  []string{"lobby"},
+		ShadowMode:       true,
+		TempDir:          dir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pm.gcp = &gcp.GoogleCloudPrint{}
+
+	printers := []lib.Printer{{Name: "lobby", GCPID: "1"}}
+	if remaining := pm.forceReregister(printers, printers, false); len(remaining) != 1 {
+		t.Logf("expected lobby to remain in GCP, got %v", remaining)
+		t.Fail()
+	}
+	if _, exists := pm.reregister["lobby"]; !exists {
+		t.Log("expected lobby to still be re-registered later")
+		t.Fail()
+	}
+	if pm.reregistered.done("lobby") {
+		t.Log("expected lobby to not be recorded as re-registered")
+		t.Fail()
+	}
+}
+
+// A delete that is retried later leaves the printer in GCP, so it must not be
+// registered again yet.
+func TestForceReregisterRetryableDelete(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reregister")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"access_token":"token","token_type":"Bearer","expires_in":3600}`)
+			return
+		}
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer s.Close()
+
+	pm, _, err := newPrinterManager(nil, nil, nil, nil, Options{
+		RawPrinterPolicy: lib.RawPrinterPolicyRegister,
+		ForceReregister:  []string{"lobby"},
+		SyncMaxRetries:   3,
+		SyncRetryBackoff: time.Second,
+		TempDir:          dir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pm.gcp, err = gcp.NewGoogleCloudPrint(s.URL+"/", "refresh", "", "proxy", "id", "secret",
+		s.URL+"/auth", s.URL+"/token", time.Second, time.Second, 1, dir, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	printers := []lib.Printer{{Name: "lobby", GCPID: "1"}}
+	if remaining := pm.forceReregister(printers, printers, false); len(remaining) != 1 {
+		t.Logf("expected lobby to remain in GCP, got %v", remaining)
+		t.Fail()
+	}
+	if _, exists := pm.reregister["lobby"]; !exists {
+		t.Log("expected lobby to still be re-registered later")
+		t.Fail()
+	}
+	if pm.reregistered.done("lobby") {
+		t.Log("expected lobby to not be recorded as re-registered")
+		t.Fail()
+	}
+	if !pm.syncRetries.retrying("lobby") {
+		t.Log("expected the delete of lobby to be retried")
+		t.Fail()
+	}
+}
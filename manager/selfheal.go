@@ -0,0 +1,83 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package manager
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/cups-connector/lib"
+	"github.com/google/cups-connector/log"
+)
+
+// selfHealPeriodically checks every interval that the registered printers
+// are still in GCP, to register again the ones deleted from GCP sooner than
+// the next GCP printer list refresh would.
+func (pm *PrinterManager) selfHealPeriodically(interval time.Duration) {
+	go func() {
+		t := time.NewTimer(interval)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-t.C:
+				if pm.CloudPaused() {
+					log.Debug("Cloud operations are paused; not checking for printers missing from GCP")
+				} else if err := pm.selfHeal(); err != nil {
+					log.Error(err)
+				}
+				t.Reset(interval)
+
+			case <-pm.quit:
+				return
+			}
+		}
+	}()
+}
+
+// selfHeal forgets the registered printers that are no longer in GCP, then
+// syncs, which registers them again while they are in CUPS. Unlike
+// refreshGCPPrinters, it only lists GCP printer IDs, which is cheap.
+func (pm *PrinterManager) selfHeal() error {
+	pm.syncMutex.Lock()
+	defer pm.syncMutex.Unlock()
+
+	gcpPrinters, err := pm.gcp.List(pm.ctx)
+	if err != nil {
+		return fmt.Errorf("Failed to check for printers missing from GCP: %s", err)
+	}
+
+	known := pm.printers.GetAll()
+	remaining, missing := forgetMissingPrinters(known, gcpPrinters)
+	if missing == 0 {
+		log.Debugf("All %d registered printers are in GCP", len(known))
+		return nil
+	}
+
+	pm.printers.Refresh(remaining)
+	// Privet still has the missing printers; only GCP lost them.
+	return pm.syncPrintersLocked(true)
+}
+
+// forgetMissingPrinters leaves out of known the registered printers whose
+// GCP ID isn't among gcpPrinters, a GCP printer list by GCP ID, and counts
+// them. Printers that aren't registered yet are kept.
+func forgetMissingPrinters(known []lib.Printer, gcpPrinters map[string]string) ([]lib.Printer, int) {
+	remaining := make([]lib.Printer, 0, len(known))
+	var missing int
+	for i := range known {
+		if _, exists := gcpPrinters[known[i].GCPID]; known[i].GCPID == "" || exists {
+			remaining = append(remaining, known[i])
+			continue
+		}
+		log.WarningPrinterf(known[i].Name+" "+known[i].GCPID, "Missing from GCP, so registering again")
+		missing++
+	}
+	return remaining, missing
+}
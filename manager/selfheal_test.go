@@ -0,0 +1,45 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package manager
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/cups-connector/lib"
+)
+
+func TestForgetMissingPrinters(t *testing.T) {
+	known := []lib.Printer{
+		{Name: "lobby", GCPID: "1"},
+		{Name: "office", GCPID: "2"},
+		{Name: "new"},
+		{Name: "lab", GCPID: "3"},
+	}
+	gcpPrinters := map[string]string{"1": "lobby", "3": "lab"}
+
+	remaining, missing := forgetMissingPrinters(known, gcpPrinters)
+	if missing != 1 {
+		t.Logf("expected 1 printer missing from GCP, got %d", missing)
+		t.Fail()
+	}
+	var names []string
+	for _, p := range remaining {
+		names = append(names, p.Name)
+	}
+	if expected := []string{"lobby", "new", "lab"}; !reflect.DeepEqual(names, expected) {
+		t.Logf("expected %v to remain, got %v", expected, names)
+		t.Fail()
+	}
+
+	if remaining, missing = forgetMissingPrinters(known[:1], gcpPrinters); missing != 0 || len(remaining) != 1 {
+		t.Logf("expected nothing missing, got %d missing and %d remaining", missing, len(remaining))
+		t.Fail()
+	}
+}
@@ -0,0 +1,62 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package manager
+
+import (
+	"strings"
+
+	"github.com/google/cups-connector/lib"
+	"github.com/google/cups-connector/log"
+)
+
+// skippedSchemes keeps CUPS printers with some device URI schemes, like
+// virtual cups-pdf queues, out of GCP.
+type skippedSchemes struct {
+	schemes map[string]struct{}
+
+	// Printers that were skipped during the last sync, so that each is
+	// logged once.
+	skipped map[string]struct{}
+}
+
+func newSkippedSchemes(schemes []string) *skippedSchemes {
+	s := skippedSchemes{
+		schemes: make(map[string]struct{}, len(schemes)),
+		skipped: make(map[string]struct{}),
+	}
+	for _, scheme := range schemes {
+		s.schemes[strings.ToLower(strings.TrimSpace(scheme))] = struct{}{}
+	}
+	return &s
+}
+
+// filter returns the printers whose device URI scheme isn't skipped. Printers
+// without a device URI are kept.
+func (s *skippedSchemes) filter(printers []lib.Printer) []lib.Printer {
+	if len(s.schemes) == 0 {
+		return printers
+	}
+
+	kept := make([]lib.Printer, 0, len(printers))
+	skipped := make(map[string]struct{})
+	for i := range printers {
+		name := printers[i].Name
+		scheme, ok := printers[i].GetDeviceURIScheme()
+		if _, skip := s.schemes[scheme]; !ok || !skip {
+			kept = append(kept, printers[i])
+			continue
+		}
+		skipped[name] = struct{}{}
+		if _, exists := s.skipped[name]; !exists {
+			log.InfoPrinterf(name, "Skipped, because skip_device_uri_schemes includes %s", scheme)
+		}
+	}
+	s.skipped = skipped
+	return kept
+}
@@ -0,0 +1,67 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package manager
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/cups-connector/lib"
+	"github.com/google/cups-connector/log"
+)
+
+func TestSkippedSchemesFilter(t *testing.T) {
+	s := newSkippedSchemes([]string{" CUPS-PDF ", "file"})
+	printers := []lib.Printer{
+		{Name: "pdf", Tags: map[string]string{"device-uri": "cups-pdf:/"}},
+		{Name: "upper", Tags: map[string]string{"device-uri": "FILE:/dev/null"}},
+		{Name: "ipp", Tags: map[string]string{"device-uri": "ipp://printer.example.com/ipp/print"}},
+		{Name: "prefix", Tags: map[string]string{"device-uri": "cups-pdfx:/"}},
+		{Name: "bad", Tags: map[string]string{"device-uri": "not a uri"}},
+		{Name: "none"},
+	}
+
+	kept := printerNames(s.filter(printers))
+	if strings.Join(kept, ",") != "ipp,prefix,bad,none" {
+		t.Logf("expected ipp,prefix,bad,none to be kept, got %v", kept)
+		t.Fail()
+	}
+}
+
+func TestSkippedSchemesEmpty(t *testing.T) {
+	printers := []lib.Printer{{Name: "pdf", Tags: map[string]string{"device-uri": "cups-pdf:/"}}}
+	if kept := newSkippedSchemes(nil).filter(printers); len(kept) != 1 {
+		t.Logf("expected every printer to be kept without schemes, got %v", printerNames(kept))
+		t.Fail()
+	}
+}
+
+func TestSkippedSchemesLogging(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetWriter(&buf)
+	defer log.SetWriter(os.Stderr)
+
+	s := newSkippedSchemes([]string{"cups-pdf"})
+	printers := []lib.Printer{{Name: "pdf", Tags: map[string]string{"device-uri": "cups-pdf:/"}}}
+
+	s.filter(printers)
+	if strings.Count(buf.String(), "Skipped") != 1 {
+		t.Logf("expected pdf to be logged as skipped, got %q", buf.String())
+		t.Fail()
+	}
+
+	buf.Reset()
+	s.filter(printers)
+	if buf.Len() != 0 {
+		t.Logf("expected a printer still skipped not to be logged again, got %q", buf.String())
+		t.Fail()
+	}
+}
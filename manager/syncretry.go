@@ -0,0 +1,184 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package manager
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/cups-connector/gcp"
+	"github.com/google/cups-connector/log"
+)
+
+// syncRetries tracks the printers whose GCP update, share or delete failed
+// with an error worth retrying, like a timeout or a 503. Instead of waiting
+// while the sync holds syncMutex, the failed operation is left undone, so
+// that the next sync, which syncPrintersPeriodically brings forward by the
+// backoff, does it again. Registrations aren't retried, since a register that
+// timed out may have created the printer in GCP.
+type syncRetries struct {
+	m          sync.Mutex
+	maxRetries uint
+	backoff    time.Duration
+	// Retries so far, by printer name.
+	retries map[string]uint
+	// GCP IDs of registered printers to share again, by printer name.
+	shares map[string]string
+}
+
+func newSyncRetries(maxRetries uint, backoff time.Duration) *syncRetries {
+	return &syncRetries{
+		maxRetries: maxRetries,
+		backoff:    backoff,
+		retries:    make(map[string]uint),
+		shares:     make(map[string]string),
+	}
+}
+
+// retry counts a retry of the printer's failed operation. Returns false when
+// the retries are exhausted, then forgets the printer, so that its next
+// failure is retried again.
+func (r *syncRetries) retry(printerName string) bool {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if r.retries[printerName] >= r.maxRetries {
+		delete(r.retries, printerName)
+		delete(r.shares, printerName)
+		return false
+	}
+	r.retries[printerName]++
+	return true
+}
+
+// succeeded forgets the retries of the printer.
+func (r *syncRetries) succeeded(printerName string) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	delete(r.retries, printerName)
+}
+
+// retrying checks whether the printer has a failed operation to retry.
+func (r *syncRetries) retrying(printerName string) bool {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	_, exists := r.retries[printerName]
+	return exists
+}
+
+// pending checks whether any printer has a failed operation to retry.
+func (r *syncRetries) pending() bool {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	return len(r.retries) > 0
+}
+
+// nextBackoff is the wait before the next sync retries: backoff, doubled for
+// each retry of the printer retried most.
+func (r *syncRetries) nextBackoff() time.Duration {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	var most uint
+	for _, retries := range r.retries {
+		if retries > most {
+			most = retries
+		}
+	}
+	if most == 0 {
+		return r.backoff
+	}
+	return r.backoff << (most - 1)
+}
+
+// addShare remembers to share the registered printer in the next sync.
+func (r *syncRetries) addShare(printerName, gcpID string) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	r.shares[printerName] = gcpID
+}
+
+// takeShares returns the printers to share again, by printer name, and
+// forgets them; failed shares are added again.
+func (r *syncRetries) takeShares() map[string]string {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	shares := r.shares
+	r.shares = make(map[string]string)
+	return shares
+}
+
+// SyncRetries returns the quantity of printer sync operations that were
+// retried after failing.
+func (pm *PrinterManager) SyncRetries() uint32 {
+	return atomic.LoadUint32(&pm.syncRetryCount)
+}
+
+// retryLater checks whether the failed operation of a printer sync is to be
+// retried by the next sync, and logs it when it is. Only errors that may go
+// away are retried, up to sync_max_retries times.
+func (pm *PrinterManager) retryLater(printerName, operation string, err error) bool {
+	if !gcp.Retryable(err) || !pm.syncRetries.retry(printerName) {
+		return false
+	}
+	log.WarningPrinterf(printerName, "Failed to %s, retrying in %s: %s", operation, pm.syncRetries.nextBackoff(), err)
+	atomic.AddUint32(&pm.syncRetryCount, 1)
+	return true
+}
+
+// sharePrinter shares a registered printer with the share scope. A share
+// that fails with an error that may go away is retried by the next sync.
+func (pm *PrinterManager) sharePrinter(printerName, gcpID string) {
+	err := pm.share(gcpID)
+	if err == nil {
+		pm.syncRetries.succeeded(printerName)
+		log.InfoPrinterf(printerName, "Shared")
+		return
+	}
+	if pm.retryLater(printerName, "share", err) {
+		pm.syncRetries.addShare(printerName, gcpID)
+		return
+	}
+	log.ErrorPrinterf(printerName, "Failed to share: %s", err)
+	atomic.AddUint32(&pm.syncFailures, 1)
+}
+
+// share shares a GCP printer with every scope of the share scope. A share
+// scope pattern is resolved each time, to share with the groups created
+// since.
+func (pm *PrinterManager) share(gcpID string) error {
+	scopes, err := pm.gcp.ResolveShareScope(pm.ctx, pm.shareScope)
+	if err != nil {
+		return err
+	}
+	for _, scope := range scopes {
+		if err := pm.gcp.Share(pm.ctx, gcpID, scope); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// retryShares shares again the printers whose share failed in an earlier
+// sync, while they are still registered.
+func (pm *PrinterManager) retryShares() {
+	for printerName, gcpID := range pm.syncRetries.takeShares() {
+		if p, exists := pm.printers.GetByGCPID(gcpID); exists && p.Name == printerName {
+			pm.sharePrinter(printerName, gcpID)
+		} else {
+			pm.syncRetries.succeeded(printerName)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package manager
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSyncRetries(t *testing.T) {
+	r := newSyncRetries(2, time.Second)
+	if r.pending() {
+		t.Log("expected no retries pending")
+		t.Fail()
+	}
+
+	if !r.retry("lobby") || !r.retrying("lobby") || !r.pending() {
+		t.Log("expected first retry of lobby to be pending")
+		t.Fail()
+	}
+	if backoff := r.nextBackoff(); backoff != time.Second {
+		t.Logf("expected backoff of 1s after one retry, got %s", backoff)
+		t.Fail()
+	}
+	if !r.retry("lobby") {
+		t.Log("expected second retry of lobby")
+		t.Fail()
+	}
+	if backoff := r.nextBackoff(); backoff != 2*time.Second {
+		t.Logf("expected backoff of 2s after two retries, got %s", backoff)
+		t.Fail()
+	}
+	if r.retry("lobby") {
+		t.Log("expected third retry of lobby to be refused")
+		t.Fail()
+	}
+	if r.retrying("lobby") || r.pending() {
+		t.Log("expected exhausted lobby to be forgotten")
+		t.Fail()
+	}
+	if !r.retry("lobby") {
+		t.Log("expected a later failure of lobby to be retried again")
+		t.Fail()
+	}
+
+	r.retry("office")
+	r.succeeded("lobby")
+	if r.retrying("lobby") || !r.retrying("office") {
+		t.Log("expected lobby to be forgotten and office retried")
+		t.Fail()
+	}
+}
+
+func TestSyncRetriesShares(t *testing.T) {
+	r := newSyncRetries(1, time.Second)
+	r.retry("lobby")
+	r.addShare("lobby", "1")
+	r.retry("office")
+	r.addShare("office", "2")
+
+	if shares := r.takeShares(); !reflect.DeepEqual(shares, map[string]string{"lobby": "1", "office": "2"}) {
+		t.Logf("expected shares of lobby and office, got %v", shares)
+		t.Fail()
+	}
+	if shares := r.takeShares(); len(shares) != 0 {
+		t.Logf("expected taken shares to be forgotten, got %v", shares)
+		t.Fail()
+	}
+
+	r.addShare("lobby", "1")
+	r.retry("lobby")
+	if shares := r.takeShares(); len(shares) != 0 {
+		t.Logf("expected exhausted share to be forgotten, got %v", shares)
+		t.Fail()
+	}
+}
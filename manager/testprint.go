@@ -0,0 +1,82 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package manager
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/google/cups-connector/cdd"
+	"github.com/google/cups-connector/cups"
+	"github.com/google/cups-connector/lib"
+)
+
+// TestPrint prints filename to the CUPS printer printerName the way a cloud
+// job is printed, with a ticket of the printer's default options, without
+// starting any of the background work of a PrinterManager. The test page is
+// submitted as user, a local user. Returns once CUPS is done with the job,
+// with an error unless it printed, naming why. filename is removed.
+func TestPrint(cups *cups.CUPS, printerName, filename, user string, options Options) error {
+	options.JobUsernameTemplate = localUsernameTemplate
+	pm, _, err := newPrinterManager(cups, nil, nil, nil, options)
+	if err != nil {
+		os.Remove(filename)
+		return err
+	}
+	defer pm.cancel()
+
+	cupsPrinters, err := cups.GetPrinters(pm.ctx)
+	if err != nil {
+		os.Remove(filename)
+		return fmt.Errorf("Failed to get CUPS printers: %s", err)
+	}
+	var printers []lib.Printer
+	for i := range cupsPrinters {
+		if cupsPrinters[i].Name == printerName {
+			printers = cupsPrinters[i : i+1]
+			break
+		}
+	}
+	if printers == nil {
+		os.Remove(filename)
+		return fmt.Errorf("CUPS has no printer named %s", printerName)
+	}
+	pm.capabilityOverrides.apply(printers)
+	lib.ApplyMakeModelOverrides(printers, options.MakeModelOverrides)
+	printer := &printers[0]
+	printer.CUPSJobSemaphore = lib.NewSemaphore(1)
+	pm.printers = lib.NewConcurrentPrinterMap(printers)
+
+	var state *cdd.JobState
+	var message string
+	updateJob := func(jobID string, diff cdd.PrintJobStateDiff) error {
+		if diff.State != nil {
+			state = diff.State
+		}
+		return nil
+	}
+	updateJobWithMessage := func(jobID string, diff cdd.PrintJobStateDiff, m string) error {
+		message = m
+		return updateJob(jobID, diff)
+	}
+	jobID := fmt.Sprintf("testprint-%d", time.Now().Unix())
+	pm.printJob(printer.Name, filename, "Test page", user, jobID, defaultTicket(printer.Description), updateJob, updateJobWithMessage)
+
+	if state == nil {
+		return fmt.Errorf("Test page to %s finished without a state", printerName)
+	}
+	if status := jobStatus(state); status != jobStatusDone {
+		if message != "" {
+			return fmt.Errorf("Test page to %s finished as %s: %s", printerName, status, message)
+		}
+		return fmt.Errorf("Test page to %s finished as %s", printerName, status)
+	}
+	return nil
+}
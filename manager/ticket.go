@@ -0,0 +1,190 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package manager
+
+import "github.com/google/cups-connector/cdd"
+
+// unsupportedOptions returns the names of the ticket items that description
+// doesn't support. The ticket is left as it is; CUPS may still honor them.
+func unsupportedOptions(description *cdd.PrinterDescriptionSection, ticket *cdd.CloudJobTicket) []string {
+	if ticket == nil || description == nil {
+		return nil
+	}
+
+	p := &ticket.Print
+	var unsupported []string
+
+	if p.Color != nil && !supportsColor(description.Color, p.Color) {
+		unsupported = append(unsupported, "color")
+	}
+	if p.Duplex != nil && !supportsDuplex(description.Duplex, p.Duplex.Type) {
+		unsupported = append(unsupported, "duplex")
+	}
+	if p.PageOrientation != nil && !supportsPageOrientation(description.PageOrientation, p.PageOrientation.Type) {
+		unsupported = append(unsupported, "page_orientation")
+	}
+	if p.Copies != nil && !supportsCopies(description.Copies, p.Copies.Copies) {
+		unsupported = append(unsupported, "copies")
+	}
+	if p.Margins != nil && description.Margins == nil {
+		unsupported = append(unsupported, "margins")
+	}
+	if p.DPI != nil && description.DPI == nil {
+		unsupported = append(unsupported, "dpi")
+	}
+	if p.FitToPage != nil && !supportsFitToPage(description.FitToPage, p.FitToPage.Type) {
+		unsupported = append(unsupported, "fit_to_page")
+	}
+	if p.MediaSize != nil && description.MediaSize == nil {
+		unsupported = append(unsupported, "media_size")
+	}
+	if p.Collate != nil && description.Collate == nil {
+		unsupported = append(unsupported, "collate")
+	}
+	if p.ReverseOrder != nil && description.ReverseOrder == nil {
+		unsupported = append(unsupported, "reverse_order")
+	}
+
+	return unsupported
+}
+
+func supportsColor(color *cdd.Color, item *cdd.ColorTicketItem) bool {
+	if color == nil {
+		return false
+	}
+	for _, option := range color.Option {
+		if option.Type == item.Type && (item.VendorID == "" || option.VendorID == item.VendorID) {
+			return true
+		}
+	}
+	return false
+}
+
+func supportsDuplex(duplex *cdd.Duplex, t cdd.DuplexType) bool {
+	if duplex == nil {
+		// Every printer can print on one side.
+		return t == cdd.DuplexNoDuplex
+	}
+	for _, option := range duplex.Option {
+		if option.Type == t {
+			return true
+		}
+	}
+	return false
+}
+
+// supportsCopies checks copies against the maximum, when it is known.
+func supportsCopies(c *cdd.Copies, copies int32) bool {
+	if c == nil {
+		return copies <= 1
+	}
+	return c.Max <= 0 || copies <= c.Max
+}
+
+func supportsPageOrientation(orientation *cdd.PageOrientation, t cdd.PageOrientationType) bool {
+	if orientation == nil {
+		return false
+	}
+	for _, option := range orientation.Option {
+		if option.Type == t {
+			return true
+		}
+	}
+	return false
+}
+
+func supportsFitToPage(fitToPage *cdd.FitToPage, t cdd.FitToPageType) bool {
+	if fitToPage == nil {
+		return false
+	}
+	for _, option := range fitToPage.Option {
+		if option.Type == t {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultTicket returns a ticket with the default of each option that
+// description has, as a cloud client would print without changing anything.
+func defaultTicket(description *cdd.PrinterDescriptionSection) *cdd.CloudJobTicket {
+	t := cdd.CloudJobTicket{Version: "1.0"}
+	if description == nil {
+		return &t
+	}
+	p := &t.Print
+
+	if description.VendorCapability != nil {
+		for _, vc := range *description.VendorCapability {
+			if vc.SelectCap == nil {
+				continue
+			}
+			for _, option := range vc.SelectCap.Option {
+				if option.IsDefault {
+					p.VendorTicketItem = append(p.VendorTicketItem, cdd.VendorTicketItem{ID: vc.ID, Value: option.Value})
+					break
+				}
+			}
+		}
+	}
+	if description.Color != nil {
+		for _, option := range description.Color.Option {
+			if option.IsDefault {
+				p.Color = &cdd.ColorTicketItem{VendorID: option.VendorID, Type: option.Type}
+				break
+			}
+		}
+	}
+	if description.Duplex != nil {
+		for _, option := range description.Duplex.Option {
+			if option.IsDefault {
+				p.Duplex = &cdd.DuplexTicketItem{Type: option.Type}
+				break
+			}
+		}
+	}
+	if description.PageOrientation != nil {
+		for _, option := range description.PageOrientation.Option {
+			if option.IsDefault {
+				p.PageOrientation = &cdd.PageOrientationTicketItem{Type: option.Type}
+				break
+			}
+		}
+	}
+	if description.Copies != nil {
+		p.Copies = &cdd.CopiesTicketItem{Copies: 1}
+	}
+	if description.DPI != nil {
+		for _, option := range description.DPI.Option {
+			if option.IsDefault {
+				p.DPI = &cdd.DPITicketItem{
+					HorizontalDPI: option.HorizontalDPI,
+					VerticalDPI:   option.VerticalDPI,
+					VendorID:      option.VendorID,
+				}
+				break
+			}
+		}
+	}
+	if description.MediaSize != nil {
+		for _, option := range description.MediaSize.Option {
+			if option.IsDefault {
+				p.MediaSize = &cdd.MediaSizeTicketItem{
+					WidthMicrons:     option.WidthMicrons,
+					HeightMicrons:    option.HeightMicrons,
+					IsContinuousFeed: option.IsContinuousFeed,
+					VendorID:         option.VendorID,
+				}
+				break
+			}
+		}
+	}
+
+	return &t
+}
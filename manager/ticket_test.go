@@ -0,0 +1,45 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package manager
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/cups-connector/cdd"
+)
+
+func TestUnsupportedOptions(t *testing.T) {
+	description := cdd.PrinterDescriptionSection{
+		Duplex: &cdd.Duplex{Option: []cdd.DuplexOption{{Type: cdd.DuplexNoDuplex}}},
+	}
+	ticket := cdd.CloudJobTicket{
+		Print: cdd.PrintTicketSection{
+			Duplex:  &cdd.DuplexTicketItem{Type: cdd.DuplexLongEdge},
+			Collate: &cdd.CollateTicketItem{Collate: true},
+		},
+	}
+
+	unsupported := unsupportedOptions(&description, &ticket)
+	if !reflect.DeepEqual(unsupported, []string{"duplex", "collate"}) {
+		t.Logf("expected duplex and collate to be unsupported, got %v", unsupported)
+		t.Fail()
+	}
+	if ticket.Print.Duplex == nil || ticket.Print.Collate == nil {
+		t.Log("expected the ticket to keep the unsupported options")
+		t.Fail()
+	}
+
+	ticket.Print.Duplex.Type = cdd.DuplexNoDuplex
+	ticket.Print.Collate = nil
+	if unsupported = unsupportedOptions(&description, &ticket); len(unsupported) != 0 {
+		t.Logf("expected no unsupported options, got %v", unsupported)
+		t.Fail()
+	}
+}
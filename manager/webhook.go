@@ -0,0 +1,74 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package manager
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/cups-connector/cdd"
+	"github.com/google/cups-connector/log"
+)
+
+const (
+	stateWebhookTimeout    = 5 * time.Second
+	stateWebhookRetryDelay = 2 * time.Second
+)
+
+// stateChange is the JSON payload POSTed to the state change webhook.
+type stateChange struct {
+	Printer   string                   `json:"printer"`
+	OldState  *cdd.PrinterStateSection `json:"old_state"`
+	NewState  *cdd.PrinterStateSection `json:"new_state"`
+	Timestamp time.Time                `json:"timestamp"`
+}
+
+// stateWebhook delivers printer state changes to a URL, best-effort.
+type stateWebhook struct {
+	url    string
+	client *http.Client
+}
+
+func newStateWebhook(url string) *stateWebhook {
+	return &stateWebhook{url, &http.Client{Timeout: stateWebhookTimeout}}
+}
+
+// notify POSTs a state change in the background, retrying once.
+func (w *stateWebhook) notify(printerName string, oldState, newState *cdd.PrinterStateSection) {
+	body, err := json.Marshal(stateChange{printerName, oldState, newState, time.Now().UTC()})
+	if err != nil {
+		log.ErrorPrinterf(printerName, "Failed to encode state change notification: %s", err)
+		return
+	}
+
+	go func() {
+		if err := w.post(body); err != nil {
+			time.Sleep(stateWebhookRetryDelay)
+			if err = w.post(body); err != nil {
+				log.WarningPrinterf(printerName, "Failed to deliver state change notification: %s", err)
+			}
+		}
+	}()
+}
+
+func (w *stateWebhook) post(body []byte) error {
+	response, err := w.client.Post(w.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("Webhook responded with %s", response.Status)
+	}
+	return nil
+}
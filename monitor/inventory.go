@@ -0,0 +1,72 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package monitor
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/google/cups-connector/lib"
+)
+
+// inventoryPrinter is one printer in the inventory, as known to the connector.
+type inventoryPrinter struct {
+	GCPID          string            `json:"gcp_id,omitempty"`
+	Name           string            `json:"name"`
+	DisplayName    string            `json:"display_name"`
+	State          string            `json:"state"`
+	Manufacturer   string            `json:"manufacturer"`
+	Model          string            `json:"model"`
+	ConnectionType string            `json:"connection_type"`
+	Tags           map[string]string `json:"tags"`
+	Labels         map[string]string `json:"labels,omitempty"`
+}
+
+// getInventory returns the printers known to the printer manager, as a JSON
+// array sorted by name, each with the connector's labels. URIs in tags are
+// redacted.
+func (m *Monitor) getInventory() (string, error) {
+	printers := m.pm.GetPrinters()
+	sort.Slice(printers, func(i, j int) bool { return printers[i].Name < printers[j].Name })
+
+	inventory := make([]inventoryPrinter, len(printers))
+	for i := range printers {
+		inventory[i] = newInventoryPrinter(&printers[i])
+		inventory[i].Labels = m.labels
+	}
+
+	b, err := json.MarshalIndent(inventory, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(b) + "\n", nil
+}
+
+func newInventoryPrinter(p *lib.Printer) inventoryPrinter {
+	ip := inventoryPrinter{
+		GCPID:          p.GCPID,
+		Name:           p.Name,
+		DisplayName:    p.DefaultDisplayName,
+		Manufacturer:   p.Manufacturer,
+		Model:          p.Model,
+		ConnectionType: p.Tags[lib.ConnectionTypeTag],
+		Tags:           make(map[string]string, len(p.Tags)),
+	}
+	if p.State != nil {
+		ip.State = string(p.State.State)
+	}
+	for tag, value := range p.Tags {
+		if strings.Contains(tag, "uri") {
+			value = lib.RedactDeviceURI(value)
+		}
+		ip.Tags[tag] = value
+	}
+	return ip
+}
@@ -9,8 +9,18 @@ https://developers.google.com/open-source/licenses/bsd
 package monitor
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
 	"fmt"
 	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/google/cups-connector/cups"
 	"github.com/google/cups-connector/gcp"
@@ -18,33 +28,80 @@ import (
 	"github.com/google/cups-connector/log"
 	"github.com/google/cups-connector/manager"
 	"github.com/google/cups-connector/privet"
+	"github.com/google/cups-connector/xmpp"
 )
 
-const monitorFormat = `cups-printers=%d
+const monitorFormat = `connector-version=%s
+connector-display-name=%s
+build-date=%s
+git-commit=%s
+go-version=%s
+start-time=%s
+uptime-seconds=%d
+cups-printers=%d
 cups-raw-printers=%d
+cups-connection-types=%s
 gcp-printers=%d
+gcp-auth-degraded=%t
+gcp-robot-scopes=%s
+gcp-cloud-paused=%t
+xmpp-server=%s
 local-printers=%d
 cups-conn-qty=%d
 cups-conn-max-qty=%d
+cups-conn-idle-qty=%d
+cups-conn-created=%d
+cups-conn-reused=%d
+cups-conn-expired=%d
+ppd-cache-entries=%d
+ppd-cache-hits=%d
+ppd-cache-misses=%d
+ppd-cache-refresh-failures=%d
+ppd-cache-evictions=%d
 jobs-done=%d
 jobs-error=%d
 jobs-in-progress=%d
+last-sync=%s
+last-sync-age-seconds=%s
+sync-retries=%d
+registrations-skipped=%d
+connector-labels=%s
 `
 
+// How long to wait for a client to send its request.
+const monitorRequestTimeout = 100 * time.Millisecond
+
+// How often to try to create the monitor socket again, after it failed.
+const monitorRetryInterval = time.Minute
+
+// startTime is roughly when the connector process started.
+var startTime = time.Now()
+
 type Monitor struct {
 	cups         *cups.CUPS
 	gcp          *gcp.GoogleCloudPrint
+	xmpp         *xmpp.XMPP
 	p            *privet.Privet
 	pm           *manager.PrinterManager
+	displayName  string
+	labels       map[string]string
 	listenerQuit chan bool
 }
 
-func NewMonitor(cups *cups.CUPS, gcp *gcp.GoogleCloudPrint, p *privet.Privet, pm *manager.PrinterManager, socketFilename string) (*Monitor, error) {
-	m := Monitor{cups, gcp, p, pm, make(chan bool)}
+// NewMonitor listens to socketFilename for monitor requests. When the socket
+// can't be created, returns an error if required, or else logs a warning and
+// tries again every monitorRetryInterval.
+func NewMonitor(cups *cups.CUPS, gcp *gcp.GoogleCloudPrint, xmpp *xmpp.XMPP, p *privet.Privet, pm *manager.PrinterManager, displayName string, labels map[string]string, socketFilename string, required bool) (*Monitor, error) {
+	m := Monitor{cups, gcp, xmpp, p, pm, displayName, labels, make(chan bool)}
 
-	listener, err := net.ListenUnix("unix", &net.UnixAddr{socketFilename, "unix"})
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: socketFilename, Net: "unix"})
 	if err != nil {
-		return nil, err
+		if required {
+			return nil, err
+		}
+		log.Warningf("Failed to create monitor socket, so running without it for now: %s", err)
+		go m.retryListen(socketFilename)
+		return &m, nil
 	}
 
 	go m.listen(listener)
@@ -52,6 +109,50 @@ func NewMonitor(cups *cups.CUPS, gcp *gcp.GoogleCloudPrint, p *privet.Privet, pm
 	return &m, nil
 }
 
+// CreateSocketDir creates the directory that holds socketFilename, if it
+// doesn't exist.
+func CreateSocketDir(socketFilename string) error {
+	dir := filepath.Dir(socketFilename)
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	log.Infof("Created monitor socket directory %s", dir)
+	return nil
+}
+
+// retryListen tries to create the monitor socket, and its directory, which
+// may be removed meanwhile, like /run on tmpfs, until it succeeds, then
+// listens to it, or until Quit is called.
+func (m *Monitor) retryListen(socketFilename string) {
+	t := time.NewTicker(monitorRetryInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			if err := CreateSocketDir(socketFilename); err != nil {
+				log.Warningf("Failed to create monitor socket directory again: %s", err)
+				continue
+			}
+			listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: socketFilename, Net: "unix"})
+			if err != nil {
+				log.Warningf("Failed to create monitor socket again: %s", err)
+				continue
+			}
+			log.Infof("Created monitor socket %s", socketFilename)
+			m.listen(listener)
+			return
+
+		case <-m.listenerQuit:
+			m.listenerQuit <- true
+			return
+		}
+	}
+}
+
 func (m *Monitor) listen(listener net.Listener) {
 	ch := make(chan net.Conn)
 	quitReq := make(chan bool, 1)
@@ -77,12 +178,12 @@ func (m *Monitor) listen(listener net.Listener) {
 		select {
 		case conn := <-ch:
 			log.Info("Received monitor request")
-			stats, err := m.getStats()
+			response, err := m.respond(readRequest(conn))
 			if err != nil {
 				log.Warningf("Monitor request failed: %s", err)
 				conn.Write([]byte("error"))
 			} else {
-				conn.Write([]byte(stats))
+				conn.Write([]byte(response))
 			}
 			conn.Close()
 
@@ -96,6 +197,41 @@ func (m *Monitor) listen(listener net.Listener) {
 	}
 }
 
+// readRequest reads the one-line request that a client may send before
+// reading the response. Clients that send nothing get the stats.
+func readRequest(conn net.Conn) string {
+	conn.SetReadDeadline(time.Now().Add(monitorRequestTimeout))
+	request, _ := bufio.NewReader(conn).ReadString('\n')
+	return strings.TrimSpace(request)
+}
+
+func (m *Monitor) respond(request string) (string, error) {
+	switch request {
+	case "", lib.MonitorRequestStats:
+		return m.getStats()
+	case lib.MonitorRequestInventory:
+		return m.getInventory()
+	case lib.MonitorRequestSupplies:
+		return m.getSupplies()
+	}
+	if fields := strings.Fields(request); fields[0] == lib.MonitorRequestRefreshPPD {
+		return m.refreshPPD(fields[1:])
+	}
+	return "", fmt.Errorf("Request %q is not recognized", request)
+}
+
+// refreshPPD removes the cached PPD of the named printer, so that the next
+// poll fetches and translates it again, like after a driver update.
+func (m *Monitor) refreshPPD(args []string) (string, error) {
+	if len(args) != 1 {
+		return "", fmt.Errorf("Request %s needs one printer name", lib.MonitorRequestRefreshPPD)
+	}
+	printerName := args[0]
+	removed := m.cups.RemoveCachedPPD(printerName)
+	log.InfoPrinterf(printerName, "Monitor request to refresh the PPD; it was cached: %t", removed)
+	return fmt.Sprintf("ppd-removed=%t\n", removed), nil
+}
+
 func (m *Monitor) Quit() {
 	m.listenerQuit <- true
 	<-m.listenerQuit
@@ -103,24 +239,38 @@ func (m *Monitor) Quit() {
 
 func (m *Monitor) getStats() (string, error) {
 	var cupsPrinterQuantity, rawPrinterQuantity, gcpPrinterQuantity, privetPrinterQuantity int
+	var gcpAuthDegraded bool
+	var connectionTypes, gcpRobotScopes, xmppServer string
 
-	if cupsPrinters, err := m.cups.GetPrinters(); err != nil {
+	if cupsPrinters, err := m.cups.GetPrinters(context.Background()); err != nil {
 		return "", err
 	} else {
 		cupsPrinterQuantity = len(cupsPrinters)
-		_, rawPrinters := lib.FilterRawPrinters(cupsPrinters)
+		_, rawPrinters := m.cups.FilterRawPrinters(cupsPrinters)
 		rawPrinterQuantity = len(rawPrinters)
+		connectionTypes = countConnectionTypes(cupsPrinters)
 	}
 
 	cupsConnOpen := m.cups.ConnQtyOpen()
 	cupsConnMax := m.cups.ConnQtyMax()
+	cupsConnPoolStats := m.cups.ConnPoolStats()
+	ppdCacheStats := m.cups.PPDCacheStats()
 
-	if m.gcp != nil {
-		if gcpPrinters, err := m.gcp.List(); err != nil {
+	if m.gcp != nil && m.gcp.AuthDegraded() {
+		gcpAuthDegraded = true
+	} else if m.gcp != nil && !m.pm.CloudPaused() {
+		if gcpPrinters, err := m.gcp.List(context.Background()); err != nil {
 			return "", err
 		} else {
 			gcpPrinterQuantity = len(gcpPrinters)
 		}
+		if scopes, ok, err := m.gcp.GetRobotScopes(); err == nil && ok {
+			gcpRobotScopes = strings.Join(scopes, " ")
+		}
+	}
+
+	if m.xmpp != nil {
+		xmppServer = m.xmpp.Server()
 	}
 
 	if m.p != nil {
@@ -132,11 +282,115 @@ func (m *Monitor) getStats() (string, error) {
 		return "", err
 	}
 
+	// Before the first sync, there is no age to report.
+	lastSync, lastSyncAge := "never", "never"
+	if t := m.pm.LastSync(); !t.IsZero() {
+		lastSync = t.UTC().Format(time.RFC3339)
+		lastSyncAge = strconv.FormatInt(int64(time.Since(t).Seconds()), 10)
+	}
+
 	stats := fmt.Sprintf(
 		monitorFormat,
-		cupsPrinterQuantity, rawPrinterQuantity, gcpPrinterQuantity, privetPrinterQuantity,
-		cupsConnOpen, cupsConnMax,
-		jobsDone, jobsError, jobsProcessing)
+		lib.BuildVersion, m.displayName, lib.BuildDate, lib.GitCommit, runtime.Version(),
+		startTime.UTC().Format(time.RFC3339), int64(time.Since(startTime).Seconds()),
+		cupsPrinterQuantity, rawPrinterQuantity, connectionTypes, gcpPrinterQuantity, gcpAuthDegraded,
+		gcpRobotScopes, m.pm.CloudPaused(), xmppServer, privetPrinterQuantity,
+		cupsConnOpen, cupsConnMax, cupsConnPoolStats.Idle, cupsConnPoolStats.Created,
+		cupsConnPoolStats.Reused, cupsConnPoolStats.Expired,
+		ppdCacheStats.Entries, ppdCacheStats.Hits, ppdCacheStats.Misses,
+		ppdCacheStats.RefreshFailures, ppdCacheStats.Evictions,
+		jobsDone, jobsError, jobsProcessing,
+		lastSync, lastSyncAge,
+		m.pm.SyncRetries(), m.pm.RegistrationsSkipped(), formatLabels(m.labels))
+
+	stats += formatJobCounts(m.pm.GetJobCounts())
+	if m.gcp != nil {
+		stats += formatCallStats(gcp.GetCallStats())
+	}
+	return stats + formatSupplies(m.collectSupplies()), nil
+}
 
-	return stats, nil
+// formatLabels formats the labels of the labels config as a JSON object, like
+// {"env":"prod","site":"nyc"}, for the connector-labels stat.
+func formatLabels(labels map[string]string) string {
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	b, err := json.Marshal(labels)
+	if err != nil {
+		return "{}"
+	}
+	return string(b)
+}
+
+// formatCallStats formats the GCP HTTP call counts and latencies by operation,
+// like gcp-call-latency-ms{operation="register",quantile="0.9"}=120.
+func formatCallStats(stats map[string]gcp.CallStats) string {
+	operations := make([]string, 0, len(stats))
+	for operation := range stats {
+		operations = append(operations, operation)
+	}
+	sort.Strings(operations)
+
+	var lines []string
+	for _, operation := range operations {
+		s := stats[operation]
+		lines = append(lines,
+			fmt.Sprintf("gcp-calls{operation=%q}=%d\n", operation, s.Calls),
+			fmt.Sprintf("gcp-call-errors{operation=%q}=%d\n", operation, s.Errors))
+		for _, q := range []struct {
+			quantile string
+			latency  time.Duration
+		}{{"0.5", s.P50}, {"0.9", s.P90}, {"0.99", s.P99}} {
+			lines = append(lines, fmt.Sprintf("gcp-call-latency-ms{operation=%q,quantile=%q}=%d\n",
+				operation, q.quantile, int64(q.latency/time.Millisecond)))
+		}
+	}
+	return strings.Join(lines, "")
+}
+
+// formatJobCounts formats the quantity of finished jobs by printer and status,
+// one line per pair, like job-count{printer="lobby",status="done"}=42.
+func formatJobCounts(counts map[string]map[string]uint) string {
+	printerNames := make([]string, 0, len(counts))
+	for printerName := range counts {
+		printerNames = append(printerNames, printerName)
+	}
+	sort.Strings(printerNames)
+
+	var lines []string
+	for _, printerName := range printerNames {
+		statuses := make([]string, 0, len(counts[printerName]))
+		for status := range counts[printerName] {
+			statuses = append(statuses, status)
+		}
+		sort.Strings(statuses)
+
+		for _, status := range statuses {
+			lines = append(lines, fmt.Sprintf("job-count{printer=%q,status=%q}=%d\n",
+				printerName, status, counts[printerName][status]))
+		}
+	}
+	return strings.Join(lines, "")
+}
+
+// countConnectionTypes summarizes how printers are connected, like
+// "42 ipp, 3 usb".
+func countConnectionTypes(printers []lib.Printer) string {
+	counts := make(map[string]int)
+	for i := range printers {
+		counts[printers[i].Tags[lib.ConnectionTypeTag]]++
+	}
+
+	connectionTypes := make([]string, 0, len(counts))
+	for connectionType := range counts {
+		connectionTypes = append(connectionTypes, connectionType)
+	}
+	sort.Strings(connectionTypes)
+
+	summary := make([]string, len(connectionTypes))
+	for i, connectionType := range connectionTypes {
+		summary[i] = fmt.Sprintf("%d %s", counts[connectionType], connectionType)
+	}
+	return strings.Join(summary, ", ")
 }
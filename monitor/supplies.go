@@ -0,0 +1,137 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package monitor
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/cups-connector/cdd"
+	"github.com/google/cups-connector/lib"
+)
+
+// supply is one marker supply of a printer, like a toner cartridge.
+type supply struct {
+	VendorID     string `json:"vendor_id"`
+	Type         string `json:"type"`
+	Color        string `json:"color"`
+	State        string `json:"state"`
+	LevelPercent *int32 `json:"level_percent,omitempty"`
+	LevelPages   *int32 `json:"level_pages,omitempty"`
+	Capacity     int64  `json:"capacity,omitempty"`
+}
+
+// printerSupplies are the supplies of one printer, as of the last time that
+// the printer was polled.
+type printerSupplies struct {
+	Name     string            `json:"name"`
+	Updated  string            `json:"updated,omitempty"`
+	Supplies []supply          `json:"supplies"`
+	Labels   map[string]string `json:"labels,omitempty"`
+
+	updated time.Time
+}
+
+// getSupplies returns the supplies of the printers that report them, as a
+// JSON array sorted by printer name, each with the connector's labels.
+func (m *Monitor) getSupplies() (string, error) {
+	b, err := json.MarshalIndent(m.collectSupplies(), "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(b) + "\n", nil
+}
+
+func (m *Monitor) collectSupplies() []printerSupplies {
+	printers := m.pm.GetPrinters()
+	sort.Slice(printers, func(i, j int) bool { return printers[i].Name < printers[j].Name })
+
+	all := make([]printerSupplies, 0, len(printers))
+	for i := range printers {
+		supplies := newSupplies(&printers[i])
+		if len(supplies) == 0 {
+			continue
+		}
+		ps := printerSupplies{Name: printers[i].Name, Supplies: supplies, Labels: m.labels}
+		if updated, ok := m.pm.LastPolled(printers[i].Name); ok {
+			ps.updated = updated
+			ps.Updated = updated.UTC().Format(time.RFC3339)
+		}
+		all = append(all, ps)
+	}
+	return all
+}
+
+// newSupplies matches the markers of p to their states.
+func newSupplies(p *lib.Printer) []supply {
+	if p.Description == nil || p.Description.Marker == nil || p.State == nil || p.State.MarkerState == nil {
+		return nil
+	}
+
+	states := make(map[string]cdd.MarkerStateItem, len(p.State.MarkerState.Item))
+	for _, item := range p.State.MarkerState.Item {
+		states[item.VendorID] = item
+	}
+	capacities := lib.ParseMarkerCapacities(p.Tags[lib.MarkerCapacitiesTag])
+
+	var supplies []supply
+	for _, marker := range *p.Description.Marker {
+		state, exists := states[marker.VendorID]
+		if !exists {
+			continue
+		}
+		supplies = append(supplies, supply{
+			VendorID:     marker.VendorID,
+			Type:         string(marker.Type),
+			Color:        markerColor(marker.Color),
+			State:        string(state.State),
+			LevelPercent: state.LevelPercent,
+			LevelPages:   state.LevelPages,
+			Capacity:     capacities[marker.VendorID],
+		})
+	}
+	return supplies
+}
+
+// markerColor names a marker color, using the custom name when there is one.
+func markerColor(color *cdd.MarkerColor) string {
+	if color == nil {
+		return ""
+	}
+	if color.Type == cdd.MarkerColorCustom && color.CustomDisplayNameLocalized != nil &&
+		len(*color.CustomDisplayNameLocalized) > 0 {
+		return (*color.CustomDisplayNameLocalized)[0].Value
+	}
+	return string(color.Type)
+}
+
+// formatSupplies formats supply levels, capacities and ages, one line per
+// value, like supply-level-percent{printer="lobby",supply="1",color="BLACK"}=42.
+func formatSupplies(all []printerSupplies) string {
+	var lines []string
+	for _, ps := range all {
+		if !ps.updated.IsZero() {
+			lines = append(lines, fmt.Sprintf("supply-age-seconds{printer=%q}=%d\n",
+				ps.Name, int64(time.Since(ps.updated).Seconds())))
+		}
+		for _, s := range ps.Supplies {
+			labels := fmt.Sprintf("printer=%q,supply=%q,color=%q", ps.Name, s.VendorID, s.Color)
+			if s.LevelPercent != nil {
+				lines = append(lines, fmt.Sprintf("supply-level-percent{%s}=%d\n", labels, *s.LevelPercent))
+			}
+			if s.Capacity > 0 {
+				lines = append(lines, fmt.Sprintf("supply-capacity{%s}=%d\n", labels, s.Capacity))
+			}
+		}
+	}
+	return strings.Join(lines, "")
+}
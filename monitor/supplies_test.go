@@ -0,0 +1,90 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package monitor
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/cups-connector/cdd"
+	"github.com/google/cups-connector/lib"
+)
+
+func int32Ptr(i int32) *int32 {
+	return &i
+}
+
+func TestNewSupplies(t *testing.T) {
+	p := lib.Printer{
+		Name: "lobby",
+		Description: &cdd.PrinterDescriptionSection{
+			Marker: &[]cdd.Marker{
+				{VendorID: "1", Type: cdd.MarkerToner, Color: &cdd.MarkerColor{Type: cdd.MarkerColorBlack}},
+				{VendorID: "2", Type: cdd.MarkerInk, Color: &cdd.MarkerColor{
+					Type: cdd.MarkerColorCustom, CustomDisplayNameLocalized: cdd.NewLocalizedString("Teal")}},
+				{VendorID: "3", Type: cdd.MarkerToner},
+			},
+		},
+		State: &cdd.PrinterStateSection{
+			MarkerState: &cdd.MarkerState{Item: []cdd.MarkerStateItem{
+				{VendorID: "1", State: cdd.MarkerStateOK, LevelPercent: int32Ptr(42)},
+				{VendorID: "2", State: cdd.MarkerStateExhausted, LevelPercent: int32Ptr(0)},
+			}},
+		},
+		Tags: map[string]string{lib.MarkerCapacitiesTag: "1=3000,2=bogus"},
+	}
+
+	expected := []supply{
+		{VendorID: "1", Type: "TONER", Color: "BLACK", State: "OK", LevelPercent: int32Ptr(42), Capacity: 3000},
+		{VendorID: "2", Type: "INK", Color: "Teal", State: "EXHAUSTED", LevelPercent: int32Ptr(0)},
+	}
+	if supplies := newSupplies(&p); !reflect.DeepEqual(supplies, expected) {
+		t.Logf("expected %+v, got %+v", expected, supplies)
+		t.Fail()
+	}
+
+	p.State = nil
+	if supplies := newSupplies(&p); supplies != nil {
+		t.Logf("expected no supplies without state, got %+v", supplies)
+		t.Fail()
+	}
+}
+
+func TestFormatSupplies(t *testing.T) {
+	all := []printerSupplies{
+		{
+			Name: "lobby",
+			Supplies: []supply{
+				{VendorID: "1", Color: "BLACK", LevelPercent: int32Ptr(42), Capacity: 3000},
+				{VendorID: "2", Color: "CYAN"},
+			},
+			updated: time.Now().Add(-90 * time.Second),
+		},
+		{
+			Name:     "office",
+			Supplies: []supply{{VendorID: "1", Color: "BLACK", LevelPercent: int32Ptr(7)}},
+		},
+	}
+
+	expected := `supply-age-seconds{printer="lobby"}=90
+supply-level-percent{printer="lobby",supply="1",color="BLACK"}=42
+supply-capacity{printer="lobby",supply="1",color="BLACK"}=3000
+supply-level-percent{printer="office",supply="1",color="BLACK"}=7
+`
+	if formatted := formatSupplies(all); formatted != expected {
+		t.Logf("expected\n%s\ngot\n%s", expected, formatted)
+		t.Fail()
+	}
+
+	if formatted := formatSupplies(nil); formatted != "" {
+		t.Logf("expected nothing without supplies, got %q", formatted)
+		t.Fail()
+	}
+}
@@ -73,6 +73,7 @@ type privetAPI struct {
 	name  string
 
 	gcpBaseURL string
+	tempDir    string
 	xsrf       xsrfSecret
 	online     bool
 	jc         *jobCache
@@ -85,7 +86,7 @@ type privetAPI struct {
 	startTime time.Time
 }
 
-func newPrivetAPI(gcpID, name, gcpBaseURL string, xsrf xsrfSecret, online bool, jc *jobCache, jobs chan<- *lib.Job, getPrinter func(string) (lib.Printer, bool), getProximityToken func(string, string) ([]byte, int, error)) (*privetAPI, error) {
+func newPrivetAPI(gcpID, name, gcpBaseURL, tempDir string, xsrf xsrfSecret, online bool, jc *jobCache, jobs chan<- *lib.Job, getPrinter func(string) (lib.Printer, bool), getProximityToken func(string, string) ([]byte, int, error)) (*privetAPI, error) {
 	l, err := newQuittableListener()
 	if err != nil {
 		return nil, err
@@ -94,6 +95,7 @@ func newPrivetAPI(gcpID, name, gcpBaseURL string, xsrf xsrfSecret, online bool,
 		gcpID:      gcpID,
 		name:       name,
 		gcpBaseURL: gcpBaseURL,
+		tempDir:    tempDir,
 		xsrf:       xsrf,
 		online:     online,
 		jc:         jc,
@@ -386,7 +388,7 @@ func (api *privetAPI) submitdoc(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	file, err := ioutil.TempFile("", "cups-connector-privet-")
+	file, err := ioutil.TempFile(api.tempDir, "cups-connector-privet-")
 	if err != nil {
 		log.Errorf("Failed to create file for new Privet job: %s", err)
 		w.WriteHeader(http.StatusInternalServerError)
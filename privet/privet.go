@@ -26,13 +26,14 @@ type Privet struct {
 	jc   jobCache
 
 	gcpBaseURL        string
+	tempDir           string
 	getProximityToken func(string, string) ([]byte, int, error)
 }
 
 // NewPrivet constructs a new Privet object.
 //
 // getProximityToken should be GoogleCloudPrint.ProximityToken()
-func NewPrivet(jobs chan<- *lib.Job, gcpBaseURL string, getProximityToken func(string, string) ([]byte, int, error)) (*Privet, error) {
+func NewPrivet(jobs chan<- *lib.Job, gcpBaseURL, tempDir string, getProximityToken func(string, string) ([]byte, int, error)) (*Privet, error) {
 	zc, err := newZeroconf()
 	if err != nil {
 		return nil, err
@@ -47,6 +48,7 @@ func NewPrivet(jobs chan<- *lib.Job, gcpBaseURL string, getProximityToken func(s
 		jc:   *newJobCache(),
 
 		gcpBaseURL:        gcpBaseURL,
+		tempDir:           tempDir,
 		getProximityToken: getProximityToken,
 	}
 
@@ -60,7 +62,7 @@ func (p *Privet) AddPrinter(printer lib.Printer, getPrinter func(string) (lib.Pr
 		online = true
 	}
 
-	api, err := newPrivetAPI(printer.GCPID, printer.Name, p.gcpBaseURL, p.xsrf, online, &p.jc, p.jobs, getPrinter, p.getProximityToken)
+	api, err := newPrivetAPI(printer.GCPID, printer.Name, p.gcpBaseURL, p.tempDir, p.xsrf, online, &p.jc, p.jobs, getPrinter, p.getProximityToken)
 	if err != nil {
 		return err
 	}
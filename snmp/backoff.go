@@ -0,0 +1,70 @@
+/*
+Copyright 2015 Google Inc. All rights reserved.
+
+Use of this source code is governed by a BSD-style
+license that can be found in the LICENSE file or at
+https://developers.google.com/open-source/licenses/bsd
+*/
+
+package snmp
+
+import (
+	"sync"
+	"time"
+)
+
+// How long an agent that didn't answer is left alone. The wait doubles with
+// each consecutive failure, up to the maximum.
+const (
+	agentMinBackoff = time.Minute
+	agentMaxBackoff = time.Hour
+)
+
+// agentBackoff remembers which SNMP agents failed to answer, so that they are
+// retried with exponential backoff instead of on every poll.
+type agentBackoff struct {
+	m      sync.Mutex
+	agents map[string]agentFailure
+}
+
+type agentFailure struct {
+	failures uint
+	retry    time.Time
+}
+
+func newAgentBackoff() *agentBackoff {
+	return &agentBackoff{agents: make(map[string]agentFailure)}
+}
+
+// ready returns true when hostname may be queried at now.
+func (b *agentBackoff) ready(hostname string, now time.Time) bool {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	f, exists := b.agents[hostname]
+	return !exists || !now.Before(f.retry)
+}
+
+// failed records that hostname didn't answer at now.
+func (b *agentBackoff) failed(hostname string, now time.Time) {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	f := b.agents[hostname]
+	wait := agentMinBackoff << f.failures
+	if wait > agentMaxBackoff || wait <= 0 {
+		wait = agentMaxBackoff
+	} else {
+		f.failures++
+	}
+	f.retry = now.Add(wait)
+	b.agents[hostname] = f
+}
+
+// succeeded forgets any failures of hostname.
+func (b *agentBackoff) succeeded(hostname string) {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	delete(b.agents, hostname)
+}
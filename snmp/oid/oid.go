@@ -10,14 +10,35 @@ https://developers.google.com/open-source/licenses/bsd
 package oid
 
 import (
+	"errors"
 	"fmt"
 	"sort"
+	"strconv"
 	"strings"
 )
 
 // OID reprents a numeric object ID.
 type OID []uint
 
+// ParseOID parses a dotted numeric OID, like 1.3.6.1.2.1.43, with or
+// without a leading dot.
+func ParseOID(s string) (OID, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), ".")
+	if s == "" {
+		return nil, errors.New("Empty OID")
+	}
+	parts := strings.Split(s, ".")
+	o := make(OID, len(parts))
+	for i, part := range parts {
+		n, err := strconv.ParseUint(part, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid OID %s", s)
+		}
+		o[i] = uint(n)
+	}
+	return o, nil
+}
+
 // AsString formats the OID as a string.
 func (o OID) AsString() string {
 	q := make([]string, len(o))
@@ -108,12 +129,25 @@ func (vs *VariableSet) Variables() []Variable {
 	return vs.vars
 }
 
-// AddVariable adds a variable to this set.
+// AddVariable adds a variable to this set, keeping it ordered. A variable
+// that is already in the set gets the new value.
 func (vs *VariableSet) AddVariable(name OID, value string) {
 	if strings.HasPrefix(value, "\"") && strings.HasSuffix(value, "\"") {
 		value = value[1 : len(value)-1]
 	}
-	vs.vars = append(vs.vars, Variable{name, value})
+	if len(vs.vars) == 0 || vs.vars[len(vs.vars)-1].Name.ComesBefore(name) {
+		// Walks add variables in order.
+		vs.vars = append(vs.vars, Variable{name, value})
+		return
+	}
+	i := sort.Search(len(vs.vars), func(i int) bool { return !vs.vars[i].Name.ComesBefore(name) })
+	if vs.vars[i].Name.IsEqualTo(name) {
+		vs.vars[i].Value = value
+		return
+	}
+	vs.vars = append(vs.vars, Variable{})
+	copy(vs.vars[i+1:], vs.vars[i:])
+	vs.vars[i] = Variable{name, value}
 }
 
 // GetSubtree gets the subtree of variables whose OID name has prefix.
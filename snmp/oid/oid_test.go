@@ -121,6 +121,42 @@ func TestAddVariable(t *testing.T) {
 	}
 }
 
+func TestAddVariableOrder(t *testing.T) {
+	var o VariableSet
+	o.AddVariable(OID{1, 3}, "a")
+	o.AddVariable(OID{1, 5}, "b")
+	o.AddVariable(OID{1, 4}, "c")
+	o.AddVariable(OID{1, 2}, "d")
+	o.AddVariable(OID{1, 5}, "e")
+
+	expected := []Variable{
+		Variable{OID{1, 2}, "d"},
+		Variable{OID{1, 3}, "a"},
+		Variable{OID{1, 4}, "c"},
+		Variable{OID{1, 5}, "e"},
+	}
+	if !reflect.DeepEqual(o.Variables(), expected) {
+		t.Errorf("expected %v, got %v", expected, o.Variables())
+	}
+}
+
+func TestParseOID(t *testing.T) {
+	for s, expected := range map[string]OID{
+		"1.3.6.1.2.1.43": OID{1, 3, 6, 1, 2, 1, 43},
+		".1.3.6.1.4.1.9": OID{1, 3, 6, 1, 4, 1, 9},
+	} {
+		o, err := ParseOID(s)
+		if err != nil || !o.IsEqualTo(expected) {
+			t.Errorf("called ParseOID(%q)\n expected %v\n got %v, %v", s, expected, o, err)
+		}
+	}
+	for _, s := range []string{"", ".", "1..3", "1.3.x", "1.-3"} {
+		if _, err := ParseOID(s); err == nil {
+			t.Errorf("called ParseOID(%q)\n expected error", s)
+		}
+	}
+}
+
 func TestGetSubtree(t *testing.T) {
 	e := "called o.GetSubtree(\"%v\")\n expected %v\n got %v"
 
@@ -555,3 +555,24 @@ func (vs *VariableSet) GetMarkers() (*[]cdd.Marker, *cdd.MarkerState, *cdd.Vendo
 
 	return &markers, &markerState, &vendorState, true
 }
+
+// GetMarkerCapacities gets the maximum capacity of each marker supply, in the
+// supply's units, by the vendor ID of its marker. Supplies of unknown
+// capacity are left out.
+func (vs *VariableSet) GetMarkerCapacities() (map[string]int64, bool) {
+	levelsMax := vs.GetSubtree(PrinterMarkerSuppliesMaxCapacity).Variables()
+	if len(levelsMax) < 1 {
+		return nil, false
+	}
+
+	capacities := make(map[string]int64, len(levelsMax))
+	for _, v := range levelsMax {
+		levelMax, err := strconv.ParseInt(v.Value, 10, 32)
+		if err != nil || levelMax <= 0 {
+			continue
+		}
+		index := int64(v.Name[len(v.Name)-1])
+		capacities[strconv.FormatInt(index, 10)] = levelMax
+	}
+	return capacities, len(capacities) > 0
+}
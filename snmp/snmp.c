@@ -108,13 +108,11 @@ void add_error(struct bulkwalk_response *response, char *error) {
 	response->errors[response->errors_len-1] = error;
 }
 
-// bulkwalk executes the SNMP GETBULK operation.
-// Caller frees returned response.
-struct bulkwalk_response *bulkwalk(char *peername, char *community) {
-	struct bulkwalk_response *response = calloc(1, sizeof(struct bulkwalk_response));
-
+// open_session opens an SNMP v2c session to peername, or adds
+// an error to response and returns NULL.
+void *open_session(char *peername, char *community, struct bulkwalk_response *response) {
 	void *sessp;
-	struct snmp_session session, *sptr;
+	struct snmp_session session;
 
 	snmp_sess_init(&session);
 	session.version = SNMP_VERSION_2c;
@@ -132,6 +130,18 @@ struct bulkwalk_response *bulkwalk(char *peername, char *community) {
 			free(errstr);
 		}
 		add_error(response, err);
+	}
+
+	return sessp;
+}
+
+// bulkwalk executes the SNMP GETBULK operation.
+// Caller frees returned response.
+struct bulkwalk_response *bulkwalk(char *peername, char *community) {
+	struct bulkwalk_response *response = calloc(1, sizeof(struct bulkwalk_response));
+
+	void *sessp = open_session(peername, community, response);
+	if (sessp == NULL) {
 		return response;
 	}
 
@@ -188,3 +198,68 @@ struct bulkwalk_response *bulkwalk(char *peername, char *community) {
 
 	return response;
 }
+
+// get executes one SNMP GET operation for each of names, so that an OID
+// that the agent doesn't have doesn't fail the others. Such OIDs are
+// left out of the response.
+// Caller frees returned response.
+struct bulkwalk_response *get(char *peername, char *community, oid **names, size_t *name_lengths, size_t names_len) {
+	struct bulkwalk_response *response = calloc(1, sizeof(struct bulkwalk_response));
+
+	void *sessp = open_session(peername, community, response);
+	if (sessp == NULL) {
+		return response;
+	}
+
+	struct oid_value **next_ov = &response->ov_root;
+
+	for (size_t i = 0; i < names_len; i++) {
+		struct snmp_pdu *request = snmp_pdu_create(SNMP_MSG_GET);
+		snmp_add_null_var(request, names[i], name_lengths[i]);
+
+		struct snmp_pdu *pdu = NULL;
+		int status = snmp_sess_synch_response(sessp, request, &pdu);
+		if (status != STAT_SUCCESS) {
+			char *errstr = session_error(sessp);
+			char *err = NULL;
+			int failure = asprintf(&err, "SNMP request error: %s", errstr);
+			if (failure == -1) {
+				err = errstr;
+			} else {
+				free(errstr);
+			}
+			add_error(response, err);
+			if (pdu != NULL) {
+				snmp_free_pdu(pdu);
+			}
+			if (status == STAT_TIMEOUT) {
+				// The agent isn't answering; don't wait for each OID.
+				break;
+			}
+			continue;
+		}
+
+		struct variable_list *var = pdu->variables;
+		if (pdu->errstat == SNMP_ERR_NOERROR && var != NULL &&
+				var->type != SNMP_NOSUCHOBJECT && var->type != SNMP_NOSUCHINSTANCE &&
+				var->type != SNMP_ENDOFMIBVIEW) {
+			oid *objid = malloc(var->name_length * sizeof(oid));
+			memmove(objid, var->name, var->name_length * sizeof(oid));
+
+			size_t value_length = 1, out_length = 0;
+			char *value = malloc(value_length);
+			sprint_realloc_value((unsigned char **)&value, &value_length, &out_length, 1, var->name, var->name_length, var);
+
+			*next_ov = calloc(1, sizeof(struct oid_value));
+			(*next_ov)->name = objid;
+			(*next_ov)->name_length = var->name_length;
+			(*next_ov)->value = value;
+			next_ov = &(*next_ov)->next;
+		}
+		snmp_free_pdu(pdu);
+	}
+
+	snmp_sess_close(sessp);
+
+	return response;
+}
@@ -18,11 +18,15 @@ package snmp
 import "C"
 import (
 	"errors"
+	"fmt"
 	"reflect"
+	"strings"
 	"sync"
+	"time"
 	"unsafe"
 
 	"github.com/google/cups-connector/lib"
+	"github.com/google/cups-connector/log"
 	"github.com/google/cups-connector/snmp/oid"
 )
 
@@ -30,29 +34,166 @@ type SNMPManager struct {
 	inUse          *lib.Semaphore
 	community      *C.char
 	maxConnections uint
+	pollInterval   time.Duration
+	backoff        *agentBackoff
+	extraOIDs      []extraOID
+
+	// The last answer from each agent, reused while the agent backs off, and
+	// by AugmentPrinters between background polls.
+	lastMutex sync.Mutex
+	last      map[string]*oid.VariableSet
+
+	// Hostnames of the printers of the last AugmentPrinters, which the
+	// background polls query; see pollPeriodically.
+	hostnamesMutex sync.Mutex
+	hostnames      map[string]struct{}
+	pollNow        chan struct{}
+	quit           chan struct{}
+}
+
+// MAX_OID_LEN of Net-SNMP.
+const maxOIDLength = 128
+
+// extraOID is a vendor-specific OID, and the printer tag to put its value in.
+type extraOID struct {
+	oid oid.OID
+	tag string
 }
 
-// NewSNMPManager creates a new SNMP manager.
-func NewSNMPManager(community string, maxConnections uint) (*SNMPManager, error) {
+// NewSNMPManager creates a new SNMP manager. With a pollInterval, agents are
+// polled in the background every pollInterval, with each poll's queries
+// spread over half of it; zero polls during AugmentPrinters instead, as fast
+// as maxConnections allows. extraOIDs maps vendor-specific OIDs to the
+// printer tags to put them in.
+func NewSNMPManager(community string, maxConnections uint, pollInterval time.Duration, extraOIDs map[string]string) (*SNMPManager, error) {
 	if community == "" || maxConnections == 0 {
 		return nil, errors.New(
 			"SNMP values not set in config file; run connector-util -update-config-file")
 	}
 
+	extras := make([]extraOID, 0, len(extraOIDs))
+	for o, tag := range extraOIDs {
+		parsed, err := oid.ParseOID(o)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse SNMP extra OID: %s", err)
+		}
+		if len(parsed) > maxOIDLength {
+			return nil, fmt.Errorf("SNMP extra OID %s is too long", o)
+		}
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" {
+			return nil, fmt.Errorf("SNMP extra OID %s has no tag", o)
+		}
+		extras = append(extras, extraOID{parsed, tag})
+	}
+
 	C.initialize()
 	s := SNMPManager{
 		inUse:          lib.NewSemaphore(1),
 		community:      C.CString(community),
 		maxConnections: maxConnections,
+		pollInterval:   pollInterval,
+		backoff:        newAgentBackoff(),
+		extraOIDs:      extras,
+		last:           make(map[string]*oid.VariableSet),
+		hostnames:      make(map[string]struct{}),
+		pollNow:        make(chan struct{}, 1),
+		quit:           make(chan struct{}),
+	}
+	if pollInterval > 0 {
+		s.pollPeriodically()
 	}
 	return &s, nil
 }
 
 func (s *SNMPManager) Quit() {
+	close(s.quit)
 	s.inUse.Acquire()
 	C.free(unsafe.Pointer(s.community))
 }
 
+// pollPeriodically queries the agents of the printers of the last
+// AugmentPrinters every pollInterval, and as soon as new printers show up,
+// so that the paced queries don't hold up the printer sync.
+func (s *SNMPManager) pollPeriodically() {
+	go func() {
+		t := time.NewTimer(s.pollInterval)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-t.C:
+			case <-s.pollNow:
+				// Don't wait for a tick that may never come; since Go 1.23,
+				// Stop discards the tick of a timer that already fired.
+				if !t.Stop() {
+					select {
+					case <-t.C:
+					default:
+					}
+				}
+			case <-s.quit:
+				return
+			}
+
+			if _, err := s.getPrinters(s.polledHostnames()); err != nil {
+				log.Warningf("Failed to poll printers via SNMP: %s", err)
+			}
+			t.Reset(s.pollInterval)
+		}
+	}()
+}
+
+// setPolledHostnames replaces the hostnames that the background polls query,
+// and starts a poll when any is new.
+func (s *SNMPManager) setPolledHostnames(hostnames []string) {
+	s.hostnamesMutex.Lock()
+	defer s.hostnamesMutex.Unlock()
+
+	polled := make(map[string]struct{}, len(hostnames))
+	isNew := false
+	for _, hostname := range hostnames {
+		polled[hostname] = struct{}{}
+		if _, exists := s.hostnames[hostname]; !exists {
+			isNew = true
+		}
+	}
+	s.hostnames = polled
+
+	if isNew {
+		select {
+		case s.pollNow <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (s *SNMPManager) polledHostnames() []string {
+	s.hostnamesMutex.Lock()
+	defer s.hostnamesMutex.Unlock()
+
+	hostnames := make([]string, 0, len(s.hostnames))
+	for hostname := range s.hostnames {
+		hostnames = append(hostnames, hostname)
+	}
+	return hostnames
+}
+
+// lastAnswers returns the last answer from the agent at each hostname that
+// answered.
+func (s *SNMPManager) lastAnswers(hostnames []string) map[string]*oid.VariableSet {
+	s.lastMutex.Lock()
+	defer s.lastMutex.Unlock()
+
+	answers := make(map[string]*oid.VariableSet, len(hostnames))
+	for _, hostname := range hostnames {
+		if last, exists := s.last[hostname]; exists {
+			answers[hostname] = last
+		}
+	}
+	return answers
+}
+
 func intArrayToOID(cOID *C.oid, cLength C.size_t) oid.OID {
 	length := int(cLength)
 	hdr := reflect.SliceHeader{
@@ -69,6 +210,16 @@ func intArrayToOID(cOID *C.oid, cLength C.size_t) oid.OID {
 	return o
 }
 
+// oidToIntArray copies o to C memory. Caller frees the returned array.
+func oidToIntArray(o oid.OID) *C.oid {
+	cOID := (*C.oid)(C.malloc(C.size_t(len(o)) * C.size_t(unsafe.Sizeof(C.oid(0)))))
+	digits := (*[maxOIDLength]C.oid)(unsafe.Pointer(cOID))[:len(o):len(o)]
+	for i, digit := range o {
+		digits[i] = C.oid(digit)
+	}
+	return cOID
+}
+
 func charArrayToSlice(cArr **C.char, cLength C.size_t) []*C.char {
 	length := int(cLength)
 	hdr := reflect.SliceHeader{
@@ -79,57 +230,155 @@ func charArrayToSlice(cArr **C.char, cLength C.size_t) []*C.char {
 	return *(*[]*C.char)(unsafe.Pointer(&hdr))
 }
 
-// getPrinters gets all printer SNMP information for each hostname, concurrently.
+// getPrinters gets all printer SNMP information for each hostname, with up to
+// maxConnections queries at a time. Agents that are backing off after a
+// failure aren't queried; their last answer is returned instead, if any.
 func (s *SNMPManager) getPrinters(hostnames []string) (map[string]*oid.VariableSet, error) {
 	if !s.inUse.TryAcquire() {
 		return nil, errors.New("Tried to query printers via SNMP twice")
 	}
 	defer s.inUse.Release()
 
-	wg := sync.WaitGroup{}
-	wg.Add(len(hostnames))
-
 	results := make(map[string]*oid.VariableSet, len(hostnames))
+	var queue []string
+	now := time.Now()
+	s.lastMutex.Lock()
 	for _, hostname := range hostnames {
-		results[hostname] = &oid.VariableSet{}
+		if _, exists := results[hostname]; exists {
+			continue
+		}
+		if s.backoff.ready(hostname, now) {
+			results[hostname] = &oid.VariableSet{}
+			queue = append(queue, hostname)
+		} else if last, exists := s.last[hostname]; exists {
+			results[hostname] = last
+		}
+	}
+	s.lastMutex.Unlock()
+	if len(queue) == 0 {
+		return results, nil
 	}
 
-	semaphore := lib.NewSemaphore(s.maxConnections)
-
-	for _, hostname := range hostnames {
-		go func(hostname string) {
-			r := results[hostname]
-			h := C.CString(hostname)
-			defer C.free(unsafe.Pointer(h))
-
-			semaphore.Acquire()
-			defer semaphore.Release()
-
-			response := C.bulkwalk(h, s.community)
-			for o := response.ov_root; o != nil; o = o.next {
-				r.AddVariable(intArrayToOID((*o).name, (*o).name_length), C.GoString((*o).value))
-				defer C.free(unsafe.Pointer((*o).name))
-				defer C.free(unsafe.Pointer((*o).value))
-				defer C.free(unsafe.Pointer(o))
-			}
-			if response.errors_len > 0 {
-				for _, err := range charArrayToSlice(response.errors, response.errors_len) {
-					// Ignore errors. Not all printers support SNMP, so this is best effort.
-					C.free(unsafe.Pointer(err))
+	workers := int(s.maxConnections)
+	if workers > len(queue) {
+		workers = len(queue)
+	}
+	work := make(chan string)
+	wg := sync.WaitGroup{}
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for hostname := range work {
+				if s.query(hostname, results[hostname]) {
+					s.backoff.succeeded(hostname)
+				} else {
+					s.backoff.failed(hostname, time.Now())
 				}
-				C.free(unsafe.Pointer(response.errors))
 			}
-			wg.Done()
-		}(hostname)
+		}()
+	}
+
+	// Pace the queries, so that a large fleet doesn't open every session at once.
+	var pace <-chan time.Time
+	if s.pollInterval > 0 && len(queue) > 1 {
+		ticker := time.NewTicker(paceInterval(s.pollInterval, len(queue)))
+		defer ticker.Stop()
+		pace = ticker.C
+	}
+	for i, hostname := range queue {
+		if i > 0 && pace != nil {
+			select {
+			case <-pace:
+			case <-s.quit:
+				// Finish the queries in flight, and skip the rest.
+				close(work)
+				wg.Wait()
+				return nil, errors.New("SNMP poll stopped")
+			}
+		}
+		work <- hostname
 	}
+	close(work)
 
 	wg.Wait()
 
+	last := make(map[string]*oid.VariableSet, len(results))
+	for hostname, r := range results {
+		if r.Size() > 0 {
+			last[hostname] = r
+		}
+	}
+	s.lastMutex.Lock()
+	s.last = last
+	s.lastMutex.Unlock()
+
 	return results, nil
 }
 
-// AugmentPrinters queries every printer's SNMP agent, adds anything it
-// finds back to the printer object.
+// Shortest wait between two paced queries.
+const minPaceInterval = time.Millisecond
+
+// paceInterval spreads queries over half of pollInterval, at most one every
+// minPaceInterval.
+func paceInterval(pollInterval time.Duration, queries int) time.Duration {
+	interval := pollInterval / 2 / time.Duration(queries)
+	if interval < minPaceInterval {
+		return minPaceInterval
+	}
+	return interval
+}
+
+// query walks the printer MIB of the agent at hostname into r, then gets the
+// extra OIDs, returning false when the agent didn't answer.
+func (s *SNMPManager) query(hostname string, r *oid.VariableSet) bool {
+	h := C.CString(hostname)
+	defer C.free(unsafe.Pointer(h))
+
+	if !addResponse(C.bulkwalk(h, s.community), r) && r.Size() == 0 {
+		return false
+	}
+	if len(s.extraOIDs) == 0 {
+		return true
+	}
+
+	// Extra OIDs that the agent doesn't have are simply missing from r.
+	names := make([]*C.oid, len(s.extraOIDs))
+	nameLengths := make([]C.size_t, len(s.extraOIDs))
+	for i := range s.extraOIDs {
+		names[i] = oidToIntArray(s.extraOIDs[i].oid)
+		defer C.free(unsafe.Pointer(names[i]))
+		nameLengths[i] = C.size_t(len(s.extraOIDs[i].oid))
+	}
+	addResponse(C.get(h, s.community, &names[0], &nameLengths[0], C.size_t(len(names))), r)
+	return true
+}
+
+// addResponse adds the variables of response to r, then frees response.
+// Returns false when there were errors.
+func addResponse(response *C.struct_bulkwalk_response, r *oid.VariableSet) bool {
+	defer C.free(unsafe.Pointer(response))
+	for o := response.ov_root; o != nil; o = o.next {
+		r.AddVariable(intArrayToOID((*o).name, (*o).name_length), C.GoString((*o).value))
+		defer C.free(unsafe.Pointer((*o).name))
+		defer C.free(unsafe.Pointer((*o).value))
+		defer C.free(unsafe.Pointer(o))
+	}
+	if response.errors_len > 0 {
+		for _, err := range charArrayToSlice(response.errors, response.errors_len) {
+			// Ignore errors. Not all printers support SNMP, so this is best effort.
+			C.free(unsafe.Pointer(err))
+		}
+		C.free(unsafe.Pointer(response.errors))
+		return false
+	}
+	return true
+}
+
+// AugmentPrinters adds what every printer's SNMP agent answered back to the
+// printer object. With a poll interval, that's the answer of the last
+// background poll, and the printers are polled from now on; a new printer's
+// answer is added by a later call. Otherwise the agents are queried now.
 func (s *SNMPManager) AugmentPrinters(printers []lib.Printer) error {
 	hostnames := make([]string, 0, len(printers))
 	for _, printer := range printers {
@@ -138,9 +387,15 @@ func (s *SNMPManager) AugmentPrinters(printers []lib.Printer) error {
 		}
 	}
 
-	varsByHostname, err := s.getPrinters(hostnames)
-	if err != nil {
-		return err
+	var varsByHostname map[string]*oid.VariableSet
+	if s.pollInterval > 0 {
+		s.setPolledHostnames(hostnames)
+		varsByHostname = s.lastAnswers(hostnames)
+	} else {
+		var err error
+		if varsByHostname, err = s.getPrinters(hostnames); err != nil {
+			return err
+		}
 	}
 
 	for i := range printers {
@@ -155,6 +410,14 @@ func (s *SNMPManager) AugmentPrinters(printers []lib.Printer) error {
 		if serialNumber, ok := vars.GetSerialNumber(); ok {
 			printers[i].Tags["snmp-serial-number"] = serialNumber
 		}
+		for _, extra := range s.extraOIDs {
+			if value, ok := vars.GetValue(extra.oid); ok {
+				printers[i].Tags[extra.tag] = value
+			}
+		}
+		if capacities, ok := vars.GetMarkerCapacities(); ok {
+			printers[i].Tags[lib.MarkerCapacitiesTag] = lib.FormatMarkerCapacities(capacities)
+		}
 		if covers, coverState, exists := vars.GetCovers(); exists {
 			printers[i].State.CoverState = coverState
 			printers[i].Description.Cover = covers
@@ -29,3 +29,4 @@ struct bulkwalk_response {
 
 void initialize();
 struct bulkwalk_response *bulkwalk(char *peername, char *community);
+struct bulkwalk_response *get(char *peername, char *community, oid **names, size_t *name_lengths, size_t names_len);
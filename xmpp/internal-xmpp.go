@@ -56,7 +56,7 @@ type internalXMPP struct {
 // Updates to the ping interval are received on pingIntervalUpdates.
 //
 // If the connection dies unexpectedly, a message is sent on dead.
-func newInternalXMPP(jid, accessToken, proxyName, server string, port uint16, pingTimeout, pingInterval time.Duration, notifications chan<- PrinterNotification, pingIntervalUpdates <-chan time.Duration, dead chan<- struct{}) (*internalXMPP, error) {
+func newInternalXMPP(jid, accessToken, proxyName, server string, port, minTLSVersion uint16, pingTimeout, pingInterval time.Duration, notifications chan<- PrinterNotification, pingIntervalUpdates <-chan time.Duration, dead chan<- struct{}) (*internalXMPP, error) {
 	var user, domain string
 	if parts := strings.SplitN(jid, "@", 2); len(parts) != 2 {
 		return nil, fmt.Errorf("Tried to use invalid XMPP JID: %s", jid)
@@ -65,12 +65,12 @@ func newInternalXMPP(jid, accessToken, proxyName, server string, port uint16, pi
 		domain = parts[1]
 	}
 
-	conn, err := dialViaHTTPProxy(server, port)
+	conn, err := dialViaHTTPProxy(server, port, minTLSVersion)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to dial XMPP server via proxy: %s", err)
 	}
 	if conn == nil {
-		conn, err = dial(server, port)
+		conn, err = dial(server, port, minTLSVersion)
 		if err != nil {
 			return nil, fmt.Errorf("Failed to dial XMPP service: %s", err)
 		}
@@ -271,7 +271,7 @@ func (x *internalXMPP) ping(timeout time.Duration) (bool, error) {
 	panic("unreachable")
 }
 
-func dialViaHTTPProxy(server string, port uint16) (*tls.Conn, error) {
+func dialViaHTTPProxy(server string, port, minTLSVersion uint16) (*tls.Conn, error) {
 	xmppHost := fmt.Sprintf("%s:%d", server, port)
 	fakeRequest := http.Request{
 		URL: &url.URL{
@@ -306,10 +306,10 @@ func dialViaHTTPProxy(server string, port uint16) (*tls.Conn, error) {
 		return nil, fmt.Errorf("Failed to connect to proxy: %s", response.Status)
 	}
 
-	return addTLS(server, conn)
+	return addTLS(server, conn, minTLSVersion)
 }
 
-func dial(server string, port uint16) (*tls.Conn, error) {
+func dial(server string, port, minTLSVersion uint16) (*tls.Conn, error) {
 	dialer := net.Dialer{
 		KeepAlive: netKeepAlive,
 		Timeout:   netTimeout,
@@ -319,12 +319,13 @@ func dial(server string, port uint16) (*tls.Conn, error) {
 		return nil, fmt.Errorf("Failed to connect to XMPP server: %s", err)
 	}
 
-	return addTLS(server, conn)
+	return addTLS(server, conn, minTLSVersion)
 }
 
-func addTLS(server string, conn net.Conn) (*tls.Conn, error) {
+func addTLS(server string, conn net.Conn, minTLSVersion uint16) (*tls.Conn, error) {
 	tlsConfig := tls.Config{
 		ServerName: server,
+		MinVersion: minTLSVersion,
 	}
 	tlsClient := tls.Client(conn, &tlsConfig)
 
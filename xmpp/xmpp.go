@@ -9,7 +9,9 @@ https://developers.google.com/open-source/licenses/bsd
 package xmpp
 
 import (
+	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/google/cups-connector/log"
@@ -30,8 +32,9 @@ type PrinterNotification struct {
 type XMPP struct {
 	jid            string
 	proxyName      string
-	server         string
+	servers        []string
 	port           uint16
+	minTLSVersion  uint16
 	pingTimeout    time.Duration
 	pingInterval   time.Duration
 	getAccessToken func() (string, error)
@@ -43,14 +46,22 @@ type XMPP struct {
 	quit chan struct{}
 
 	ix *internalXMPP
+
+	// The server of the current conversation.
+	serverMutex sync.Mutex
+	server      string
 }
 
-func NewXMPP(jid, proxyName, server string, port uint16, pingTimeout, pingInterval time.Duration, getAccessToken func() (string, error), notifications chan<- PrinterNotification) (*XMPP, error) {
+// NewXMPP starts an XMPP conversation with the first of servers that can be
+// reached. Each restart tries them in order again. Servers that can't speak
+// at least TLS minTLSVersion are refused; 0 means the crypto/tls default.
+func NewXMPP(jid, proxyName string, servers []string, port, minTLSVersion uint16, pingTimeout, pingInterval time.Duration, getAccessToken func() (string, error), notifications chan<- PrinterNotification) (*XMPP, error) {
 	x := XMPP{
 		jid:                 jid,
 		proxyName:           proxyName,
-		server:              server,
+		servers:             servers,
 		port:                port,
+		minTLSVersion:       minTLSVersion,
 		pingTimeout:         pingTimeout,
 		pingInterval:        pingInterval,
 		getAccessToken:      getAccessToken,
@@ -96,14 +107,40 @@ func (x *XMPP) startXMPP() error {
 		return fmt.Errorf("While starting XMPP, failed to get access token (password): %s", err)
 	}
 
-	// The current access token is the XMPP password.
-	ix, err := newInternalXMPP(x.jid, password, x.proxyName, x.server, x.port, x.pingTimeout, x.pingInterval, x.notifications, x.pingIntervalUpdates, x.dead)
-	if err != nil {
-		return fmt.Errorf("Failed to start XMPP conversation: %s", err)
+	if len(x.servers) == 0 {
+		return errors.New("Failed to start XMPP conversation: no XMPP server is set")
 	}
 
-	x.ix = ix
-	return nil
+	for i, server := range x.servers {
+		// The current access token is the XMPP password.
+		var ix *internalXMPP
+		ix, err = newInternalXMPP(x.jid, password, x.proxyName, server, x.port, x.minTLSVersion, x.pingTimeout, x.pingInterval, x.notifications, x.pingIntervalUpdates, x.dead)
+		if err != nil {
+			if i+1 < len(x.servers) {
+				log.Warningf("Failed to start XMPP conversation with %s, trying %s: %s", server, x.servers[i+1], err)
+			}
+			continue
+		}
+
+		if i > 0 {
+			log.Warningf("Started XMPP conversation with fallback server %s", server)
+		}
+		x.serverMutex.Lock()
+		x.server = server
+		x.serverMutex.Unlock()
+		x.ix = ix
+		return nil
+	}
+
+	return fmt.Errorf("Failed to start XMPP conversation: %s", err)
+}
+
+// Server returns the XMPP server of the current conversation.
+func (x *XMPP) Server() string {
+	x.serverMutex.Lock()
+	defer x.serverMutex.Unlock()
+
+	return x.server
 }
 
 // keepXMPPAlive restarts XMPP when it fails.